@@ -23,6 +23,18 @@ var (
 	token string
 )
 
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
 	// Load .env file if it exists
 	_ = godotenv.Load()
@@ -37,8 +49,23 @@ func main() {
 	instanceName := createInstanceCmd.String("name", "", "Instance name")
 	flavorID := createInstanceCmd.String("flavor", "", "Flavor ID")
 	imageID := createInstanceCmd.String("image", "", "Image ID")
-	networkID := createInstanceCmd.String("network", "", "Network ID")
+	var networkIDs, portIDs, securityGroups, metadata, tags stringList
+	createInstanceCmd.Var(&networkIDs, "network", "Network ID; repeat for more NICs")
+	createInstanceCmd.Var(&portIDs, "port", "Existing port ID to attach; repeatable")
 	keyName := createInstanceCmd.String("key", "", "Key name (optional)")
+	createInstanceCmd.Var(&securityGroups, "security-group", "Security group name; repeatable")
+	userDataFile := createInstanceCmd.String("user-data", "", "File with cloud-config or a script to run on first boot")
+	bootVolumeSize := createInstanceCmd.Int("boot-volume-size", 0, "Boot from a new volume of this size in GB created from the image")
+	bootVolumeID := createInstanceCmd.String("boot-volume", "", "Boot from this existing volume instead of an image")
+	volumeType := createInstanceCmd.String("volume-type", "", "Volume type of the boot volume")
+	deleteOnTermination := createInstanceCmd.Bool("delete-volume-on-termination", false, "Delete the boot volume with the instance")
+	availabilityZone := createInstanceCmd.String("availability-zone", "", "Availability zone")
+	serverGroupID := createInstanceCmd.String("server-group", "", "Server group ID")
+	createInstanceCmd.Var(&metadata, "metadata", "Metadata as key=value; repeatable")
+	createInstanceCmd.Var(&tags, "tag", "Tag; repeatable")
+	count := createInstanceCmd.Int("count", 0, "Number of instances to create")
+	minCount := createInstanceCmd.Int("min-count", 0, "Minimum number of instances to create")
+	maxCount := createInstanceCmd.Int("max-count", 0, "Maximum number of instances to create")
 
 	listImagesCmd := flag.NewFlagSet("list-images", flag.ExitOnError)
 	listFlavorsCmd := flag.NewFlagSet("list-flavors", flag.ExitOnError)
@@ -84,11 +111,84 @@ func main() {
 			fmt.Println("Not authenticated. Run 'login' command first or set LINESERVE_TOKEN environment variable")
 			os.Exit(1)
 		}
-		if *instanceName == "" || *flavorID == "" || *imageID == "" || *networkID == "" {
-			fmt.Println("Name, flavor, image, and network are required")
+		if *instanceName == "" || *flavorID == "" || (*imageID == "" && *bootVolumeID == "") || (len(networkIDs) == 0 && len(portIDs) == 0) {
+			fmt.Println("Name, flavor, image (or boot volume), and network (or port) are required")
 			os.Exit(1)
 		}
-		createInstance(*instanceName, *flavorID, *imageID, *networkID, *keyName)
+
+		// Build request body
+		reqBody := map[string]interface{}{
+			"name":      *instanceName,
+			"flavor_id": *flavorID,
+			"image_id":  *imageID,
+		}
+		networks := []map[string]string{}
+		for _, id := range networkIDs {
+			networks = append(networks, map[string]string{"network_id": id})
+		}
+		for _, id := range portIDs {
+			networks = append(networks, map[string]string{"port_id": id})
+		}
+		reqBody["networks"] = networks
+		if *keyName != "" {
+			reqBody["key_name"] = *keyName
+		}
+		if len(securityGroups) > 0 {
+			reqBody["security_groups"] = securityGroups
+		}
+		if *userDataFile != "" {
+			userData, err := os.ReadFile(*userDataFile)
+			if err != nil {
+				fmt.Printf("Error reading user data: %v\n", err)
+				os.Exit(1)
+			}
+			reqBody["user_data"] = string(userData)
+		}
+		if *bootVolumeID != "" {
+			reqBody["boot_volume"] = map[string]interface{}{
+				"source_type":           "volume",
+				"source_id":             *bootVolumeID,
+				"delete_on_termination": *deleteOnTermination,
+			}
+		} else if *bootVolumeSize > 0 {
+			reqBody["boot_volume"] = map[string]interface{}{
+				"source_type":           "image",
+				"size":                  *bootVolumeSize,
+				"volume_type":           *volumeType,
+				"delete_on_termination": *deleteOnTermination,
+			}
+		}
+		if *availabilityZone != "" {
+			reqBody["availability_zone"] = *availabilityZone
+		}
+		if *serverGroupID != "" {
+			reqBody["server_group_id"] = *serverGroupID
+		}
+		if len(metadata) > 0 {
+			metadataMap := map[string]string{}
+			for _, item := range metadata {
+				key, value, ok := strings.Cut(item, "=")
+				if !ok {
+					fmt.Printf("Invalid metadata %q, expected key=value\n", item)
+					os.Exit(1)
+				}
+				metadataMap[key] = value
+			}
+			reqBody["metadata"] = metadataMap
+		}
+		if len(tags) > 0 {
+			reqBody["tags"] = tags
+		}
+		if *count > 0 {
+			reqBody["count"] = *count
+		}
+		if *minCount > 0 {
+			reqBody["min_count"] = *minCount
+		}
+		if *maxCount > 0 {
+			reqBody["max_count"] = *maxCount
+		}
+		createInstance(reqBody)
 
 	case "list-images":
 		listImagesCmd.Parse(os.Args[2:])
@@ -210,16 +310,16 @@ func listInstances() {
 	fmt.Println(prettyJSON.String())
 }
 
-func createInstance(name, flavorID, imageID, networkID, keyName string) {
-	// Create request body
-	reqBody := fmt.Sprintf(`{"name":"%s","flavor_id":"%s","image_id":"%s","network_id":"%s"`, name, flavorID, imageID, networkID)
-	if keyName != "" {
-		reqBody += fmt.Sprintf(`,"key_name":"%s"`, keyName)
+func createInstance(reqBody map[string]interface{}) {
+	// Encode request body
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		fmt.Printf("Error encoding request: %v\n", err)
+		os.Exit(1)
 	}
-	reqBody += "}"
 
 	// Create request
-	req, err := http.NewRequest("POST", baseURL+"/api/instances", strings.NewReader(reqBody))
+	req, err := http.NewRequest("POST", baseURL+"/api/instances", bytes.NewReader(jsonBody))
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		os.Exit(1)
@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 
+	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumetypes"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/volumeattach"
@@ -12,6 +15,14 @@ import (
 	"github.com/lineserve/lineserve-api/pkg/models"
 )
 
+// onlineExtendMicroversion is the first block storage microversion that
+// extends attached volumes
+const onlineExtendMicroversion = "3.42"
+
+// ErrOnlineExtendUnsupported is returned when an attached volume's backend
+// cannot extend it
+var ErrOnlineExtendUnsupported = errors.New("the volume's storage backend cannot extend it while attached; detach it first")
+
 // VolumeService handles operations related to volume resources
 type VolumeService struct {
 	Client *client.OpenStackClient
@@ -44,32 +55,8 @@ func (s *VolumeService) ListVolumes() ([]models.Volume, error) {
 			return false, err
 		}
 
-		for _, volume := range volumeList {
-			// Convert attachments
-			var modelAttachments []models.VolumeAttachment
-			for _, attachment := range volume.Attachments {
-				modelAttachment := models.VolumeAttachment{
-					ID:         attachment.AttachmentID,
-					VolumeID:   volume.ID,
-					InstanceID: attachment.ServerID,
-					Device:     attachment.Device,
-					Status:     "attached", // OpenStack doesn't provide status in attachment, so we assume it's attached
-				}
-				modelAttachments = append(modelAttachments, modelAttachment)
-			}
-
-			modelVolume := models.Volume{
-				ID:               volume.ID,
-				Name:             volume.Name,
-				Status:           volume.Status,
-				Size:             volume.Size,
-				VolumeType:       volume.VolumeType,
-				AvailabilityZone: volume.AvailabilityZone,
-				CreatedAt:        volume.CreatedAt,
-				Attachments:      modelAttachments,
-			}
-
-			modelVolumes = append(modelVolumes, modelVolume)
+		for i := range volumeList {
+			modelVolumes = append(modelVolumes, volumeFromOpenStack(&volumeList[i]))
 		}
 
 		return true, nil
@@ -94,9 +81,13 @@ func (s *VolumeService) CreateVolume(req models.CreateVolumeRequest) (*models.Vo
 	// Define volume create options
 	createOpts := volumes.CreateOpts{
 		Name:             req.Name,
+		Description:      req.Description,
 		Size:             req.Size,
 		VolumeType:       req.VolumeType,
 		AvailabilityZone: req.AvailabilityZone,
+		ImageID:          req.ImageID,
+		SnapshotID:       req.SnapshotID,
+		SourceVolID:      req.SourceVolumeID,
 	}
 
 	// Create the volume
@@ -106,18 +97,8 @@ func (s *VolumeService) CreateVolume(req models.CreateVolumeRequest) (*models.Vo
 	}
 
 	// Return the volume
-	modelVolume := &models.Volume{
-		ID:               volume.ID,
-		Name:             volume.Name,
-		Status:           volume.Status,
-		Size:             volume.Size,
-		VolumeType:       volume.VolumeType,
-		AvailabilityZone: volume.AvailabilityZone,
-		CreatedAt:        volume.CreatedAt,
-		Attachments:      []models.VolumeAttachment{},
-	}
-
-	return modelVolume, nil
+	modelVolume := volumeFromOpenStack(volume)
+	return &modelVolume, nil
 }
 
 // GetVolume gets a volume by ID
@@ -135,32 +116,35 @@ func (s *VolumeService) GetVolume(id string) (*models.Volume, error) {
 		return nil, err
 	}
 
-	// Convert attachments
-	var modelAttachments []models.VolumeAttachment
-	for _, attachment := range volume.Attachments {
-		modelAttachment := models.VolumeAttachment{
-			ID:         attachment.AttachmentID,
-			VolumeID:   volume.ID,
-			InstanceID: attachment.ServerID,
-			Device:     attachment.Device,
-			Status:     "attached", // OpenStack doesn't provide status in attachment, so we assume it's attached
-		}
-		modelAttachments = append(modelAttachments, modelAttachment)
+	// Return the volume
+	modelVolume := volumeFromOpenStack(volume)
+	return &modelVolume, nil
+}
+
+// UpdateVolume changes a volume's name or description
+func (s *VolumeService) UpdateVolume(id string, req models.UpdateVolumeRequest) (*models.Volume, error) {
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return nil, fmt.Errorf("volume client is nil")
 	}
 
-	// Return the volume
-	modelVolume := &models.Volume{
-		ID:               volume.ID,
-		Name:             volume.Name,
-		Status:           volume.Status,
-		Size:             volume.Size,
-		VolumeType:       volume.VolumeType,
-		AvailabilityZone: volume.AvailabilityZone,
-		CreatedAt:        volume.CreatedAt,
-		Attachments:      modelAttachments,
+	// Define volume update options
+	updateOpts := volumes.UpdateOpts{
+		Name:        req.Name,
+		Description: req.Description,
 	}
 
-	return modelVolume, nil
+	// Update the volume
+	volume, err := volumes.Update(ctx, s.Client.Volume, id, updateOpts).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the volume
+	modelVolume := volumeFromOpenStack(volume)
+	return &modelVolume, nil
 }
 
 // DeleteVolume deletes a volume by ID
@@ -290,8 +274,10 @@ func (s *VolumeService) DetachVolume(volumeID string, req models.VolumeDetachReq
 	return volumeattach.Delete(ctx, s.Client.Compute, serverID, attachmentID).ExtractErr()
 }
 
-// ResizeVolume resizes a volume to a new size
-func (s *VolumeService) ResizeVolume(volumeID string, req models.VolumeResizeRequest) error {
+// ResizeVolume resizes a volume to a new size. An attached volume is
+// extended online, which returns ErrOnlineExtendUnsupported when the volume's
+// backend cannot do it.
+func (s *VolumeService) ResizeVolume(volumeID string, req models.VolumeResizeRequest, attached bool) error {
 	ctx := context.Background()
 
 	// Check if Volume client is nil
@@ -304,6 +290,51 @@ func (s *VolumeService) ResizeVolume(volumeID string, req models.VolumeResizeReq
 		NewSize: req.NewSize,
 	}
 
-	// Resize the volume
-	return volumes.ExtendSize(ctx, s.Client.Volume, volumeID, resizeOpts).ExtractErr()
+	if !attached {
+		// Resize the volume
+		return volumes.ExtendSize(ctx, s.Client.Volume, volumeID, resizeOpts).ExtractErr()
+	}
+
+	// Extending an attached volume needs a later microversion; copy the shared
+	// client so that other requests keep the default one
+	volumeClient := *s.Client.Volume
+	volumeClient.Microversion = onlineExtendMicroversion
+
+	err := volumes.ExtendSize(ctx, &volumeClient, volumeID, resizeOpts).ExtractErr()
+	if gophercloud.ResponseCodeIs(err, http.StatusBadRequest) || gophercloud.ResponseCodeIs(err, http.StatusNotAcceptable) {
+		return ErrOnlineExtendUnsupported
+	}
+	return err
+}
+
+// volumeFromOpenStack converts an OpenStack volume to our model
+func volumeFromOpenStack(volume *volumes.Volume) models.Volume {
+	// Convert attachments
+	modelAttachments := []models.VolumeAttachment{}
+	for _, attachment := range volume.Attachments {
+		modelAttachment := models.VolumeAttachment{
+			ID:         attachment.AttachmentID,
+			VolumeID:   volume.ID,
+			InstanceID: attachment.ServerID,
+			Device:     attachment.Device,
+			Status:     "attached", // OpenStack doesn't provide status in attachment, so we assume it's attached
+		}
+		modelAttachments = append(modelAttachments, modelAttachment)
+	}
+
+	return models.Volume{
+		ID:               volume.ID,
+		Name:             volume.Name,
+		Description:      volume.Description,
+		Status:           volume.Status,
+		Size:             volume.Size,
+		VolumeType:       volume.VolumeType,
+		AvailabilityZone: volume.AvailabilityZone,
+		Bootable:         volume.Bootable == "true",
+		SnapshotID:       volume.SnapshotID,
+		SourceVolumeID:   volume.SourceVolID,
+		ImageID:          volume.VolumeImageMetadata["image_id"],
+		CreatedAt:        volume.CreatedAt,
+		Attachments:      modelAttachments,
+	}
 }
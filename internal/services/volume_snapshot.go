@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/v2/pagination"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// VolumeSnapshotService handles operations related to volume snapshots
+type VolumeSnapshotService struct {
+	Client *client.OpenStackClient
+}
+
+// NewVolumeSnapshotService creates a new volume snapshot service
+func NewVolumeSnapshotService(client *client.OpenStackClient) *VolumeSnapshotService {
+	return &VolumeSnapshotService{
+		Client: client,
+	}
+}
+
+// ListVolumeSnapshots lists volume snapshots, optionally only those of one
+// volume
+func (s *VolumeSnapshotService) ListVolumeSnapshots(volumeID string) ([]models.VolumeSnapshot, error) {
+	modelSnapshots := []models.VolumeSnapshot{}
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return modelSnapshots, fmt.Errorf("volume client is nil")
+	}
+
+	// Create a pager
+	pager := snapshots.ListDetail(s.Client.Volume, snapshots.ListOpts{VolumeID: volumeID})
+
+	// Extract snapshots from pages
+	err := pager.EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
+		snapshotList, err := snapshots.ExtractSnapshots(page)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range snapshotList {
+			modelSnapshots = append(modelSnapshots, volumeSnapshotFromOpenStack(&snapshotList[i]))
+		}
+
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return modelSnapshots, nil
+}
+
+// CreateVolumeSnapshot snapshots a volume
+func (s *VolumeSnapshotService) CreateVolumeSnapshot(req models.CreateVolumeSnapshotRequest) (*models.VolumeSnapshot, error) {
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return nil, fmt.Errorf("volume client is nil")
+	}
+
+	// Define snapshot create options
+	createOpts := snapshots.CreateOpts{
+		VolumeID:    req.VolumeID,
+		Name:        req.Name,
+		Description: req.Description,
+		Force:       req.Force,
+	}
+
+	// Create the snapshot
+	snapshot, err := snapshots.Create(ctx, s.Client.Volume, createOpts).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the snapshot
+	modelSnapshot := volumeSnapshotFromOpenStack(snapshot)
+	return &modelSnapshot, nil
+}
+
+// GetVolumeSnapshot gets a volume snapshot by ID
+func (s *VolumeSnapshotService) GetVolumeSnapshot(id string) (*models.VolumeSnapshot, error) {
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return nil, fmt.Errorf("volume client is nil")
+	}
+
+	// Get the snapshot
+	snapshot, err := snapshots.Get(ctx, s.Client.Volume, id).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the snapshot
+	modelSnapshot := volumeSnapshotFromOpenStack(snapshot)
+	return &modelSnapshot, nil
+}
+
+// UpdateVolumeSnapshot changes a volume snapshot's name or description
+func (s *VolumeSnapshotService) UpdateVolumeSnapshot(id string, req models.UpdateVolumeSnapshotRequest) (*models.VolumeSnapshot, error) {
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return nil, fmt.Errorf("volume client is nil")
+	}
+
+	// Define snapshot update options
+	updateOpts := snapshots.UpdateOpts{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	// Update the snapshot
+	snapshot, err := snapshots.Update(ctx, s.Client.Volume, id, updateOpts).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the snapshot
+	modelSnapshot := volumeSnapshotFromOpenStack(snapshot)
+	return &modelSnapshot, nil
+}
+
+// DeleteVolumeSnapshot deletes a volume snapshot by ID
+func (s *VolumeSnapshotService) DeleteVolumeSnapshot(id string) error {
+	ctx := context.Background()
+
+	// Check if Volume client is nil
+	if s.Client == nil || s.Client.Volume == nil {
+		return fmt.Errorf("volume client is nil")
+	}
+
+	// Delete the snapshot
+	return snapshots.Delete(ctx, s.Client.Volume, id).ExtractErr()
+}
+
+// volumeSnapshotFromOpenStack converts an OpenStack volume snapshot to our
+// model
+func volumeSnapshotFromOpenStack(snapshot *snapshots.Snapshot) models.VolumeSnapshot {
+	return models.VolumeSnapshot{
+		ID:          snapshot.ID,
+		Name:        snapshot.Name,
+		Description: snapshot.Description,
+		VolumeID:    snapshot.VolumeID,
+		Status:      snapshot.Status,
+		Size:        snapshot.Size,
+		CreatedAt:   snapshot.CreatedAt,
+		UpdatedAt:   snapshot.UpdatedAt,
+	}
+}
@@ -2,18 +2,23 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
+	"github.com/lineserve/lineserve-api/pkg/backup"
 	"github.com/lineserve/lineserve-api/pkg/client"
 	"github.com/lineserve/lineserve-api/pkg/config"
 	"github.com/lineserve/lineserve-api/pkg/cron"
+	"github.com/lineserve/lineserve-api/pkg/events"
 	"github.com/lineserve/lineserve-api/pkg/handlers"
+	"github.com/lineserve/lineserve-api/pkg/mailer"
 	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+	"github.com/lineserve/lineserve-api/pkg/signing"
 )
 
 func main() {
@@ -28,6 +33,13 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Load JWT signing keys; there is no fallback key
+	jwtKeys, err := signing.LoadKeySet(cfg.JWTKeyFiles, cfg.JWTKeys)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	log.Printf("Signing JWTs with %s key %s", jwtKeys.SigningKey().Algorithm, jwtKeys.SigningKey().ID)
+
 	// Create PostgreSQL client
 	postgresClient, err := client.NewPostgresClient(cfg.GetPostgresConnectionString())
 	if err != nil {
@@ -40,6 +52,13 @@ func main() {
 		log.Fatalf("Failed to create tables: %v", err)
 	}
 
+	// Grant the admin role to bootstrap administrators
+	if promoted, err := postgresClient.PromoteAdmins(context.Background(), cfg.AdminEmails); err != nil {
+		log.Printf("Warning: Failed to promote admin accounts: %v", err)
+	} else if promoted > 0 {
+		log.Printf("Granted admin role to %d account(s) from ADMIN_EMAILS", promoted)
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -53,16 +72,22 @@ func main() {
 		},
 	})
 
+	// Resolve client IPs through the proxy header of trusted load balancers
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse TRUSTED_PROXIES: %v", err)
+	}
+	app.Use(middleware.ResolveClientIP(cfg.ProxyHeader, trustedProxies))
+
 	// Add middleware
 	app.Use(cors.New())
 	app.Use(logger.New())
 
-	// Get JWT secret
-	jwtSecret := cfg.JWTSecret
-	if jwtSecret == "" {
-		jwtSecret = "lineserve-secret-key" // Default secret
-	}
-	fmt.Printf("Main: Using JWT secret: %s\n", jwtSecret)
+	// Publish the public keys so other services can verify our tokens
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(jwtKeys.JWKS())
+	})
 
 	// Serve Swagger documentation
 	app.Get("/docs/swagger.json", func(c *fiber.Ctx) error {
@@ -77,42 +102,225 @@ func main() {
 	// Create API group with version
 	v1 := app.Group("/v1")
 
+	// Default quotas for new projects
+	projectQuotas := openstack.ProjectQuotas{
+		Instances:      cfg.DefaultQuotaInstances,
+		Cores:          cfg.DefaultQuotaCores,
+		RAM:            cfg.DefaultQuotaRAM,
+		Volumes:        cfg.DefaultQuotaVolumes,
+		Gigabytes:      cfg.DefaultQuotaGigabytes,
+		Snapshots:      cfg.DefaultQuotaSnapshots,
+		FloatingIPs:    cfg.DefaultQuotaFloatingIPs,
+		Networks:       cfg.DefaultQuotaNetworks,
+		Routers:        cfg.DefaultQuotaRouters,
+		SecurityGroups: cfg.DefaultQuotaSecurityGroups,
+	}
+
+	// Create the mailer for verification, password reset and invitation emails
+	appMailer, err := mailer.New(mailer.Config{
+		Driver:       cfg.MailDriver,
+		From:         cfg.MailFrom,
+		SMTPHost:     cfg.SMTPHost,
+		SMTPPort:     cfg.SMTPPort,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+		Dir:          cfg.MailDir,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
 	// Create auth handler
 	authHandler := &handlers.AuthHandler{
 		PostgresClient: postgresClient,
-		JWTSecret:      jwtSecret,
-		MemberRoleID:   "c76575246ae343ddb80d0f0f1f2d958b", // Updated member role ID
+		Keys:           jwtKeys,
+		MemberRoleID:   cfg.OSMemberRoleID,
+		ReaderRoleID:   cfg.OSReaderRoleID,
+		Quotas:         projectQuotas,
+		TOTPIssuer:     cfg.TOTPIssuer,
+		Mailer:         appMailer,
+		AppBaseURL:     cfg.AppBaseURL,
+
+		RequireEmailVerification: cfg.RequireEmailVerification,
+		EmailVerificationTTL:     cfg.EmailVerificationTTL,
+		PasswordResetTTL:         cfg.PasswordResetTTL,
+
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		SessionLifetime: cfg.SessionLifetime,
+	}
+
+	// Choose the rate limit store; the Postgres store shares counts between replicas
+	var rateLimitStore middleware.RateLimitStore = middleware.NewMemoryRateLimitStore()
+	if cfg.RateLimitBackend == "postgres" {
+		rateLimitStore = postgresClient
+		cron.StartRateLimitPruneCron(postgresClient)
+	}
+
+	// Lock accounts after repeated failed sign-ins. Passwords and second
+	// factors are counted apart, so that a correct password, which only
+	// answers with a challenge, does not clear wrong codes.
+	lockoutPolicy := middleware.LoginLockoutPolicy{
+		Name:            "password",
+		MaxFailures:     cfg.LoginMaxFailures,
+		FailureWindow:   cfg.LoginFailureWindow,
+		LockoutDuration: cfg.LoginLockoutDuration,
+		Account:         middleware.KeyByBodyField("username"),
 	}
+	passwordLockout := middleware.LoginLockout(rateLimitStore, lockoutPolicy)
+	lockoutPolicy.Name = "2fa"
+	lockoutPolicy.Account = authHandler.TwoFactorLoginAccount
+	secondFactorLockout := middleware.LoginLockout(rateLimitStore, lockoutPolicy)
 
 	// Public routes
-	v1.Post("/login", authHandler.Login)
-	v1.Post("/register", authHandler.Register)
-	v1.Post("/project-token", authHandler.GetProjectToken)
+	v1.Post("/login", middleware.RateLimit(rateLimitStore, ratePolicy("login", cfg.RateLimitLogin, middleware.KeyByIP)), passwordLockout, authHandler.Login)
+	v1.Post("/register", middleware.RateLimit(rateLimitStore, ratePolicy("register", cfg.RateLimitRegister, middleware.KeyByIP)), authHandler.Register)
+	v1.Post("/project-token", middleware.RateLimit(rateLimitStore, ratePolicy("project-token", cfg.RateLimitLogin, middleware.KeyByIP)), passwordLockout, authHandler.GetProjectToken)
+	v1.Post("/token/refresh", authHandler.RefreshToken)
+	v1.Post("/login/2fa", middleware.RateLimit(rateLimitStore, ratePolicy("login-2fa", cfg.RateLimitLogin, middleware.KeyByIP)), secondFactorLockout, authHandler.CompleteTwoFactorLogin)
+	v1.Post("/verify-email", middleware.RateLimit(rateLimitStore, ratePolicy("verify-email", cfg.RateLimitLogin, middleware.KeyByIP)), authHandler.VerifyEmail)
+	v1.Post("/verify-email/resend", middleware.RateLimit(rateLimitStore,
+		ratePolicy("verify-email-resend", cfg.RateLimitAccountMail, middleware.KeyByIP),
+		ratePolicy("verify-email-resend-account", cfg.RateLimitAccountMail, middleware.KeyByBodyField("email")),
+	), authHandler.ResendVerificationEmail)
+	v1.Post("/password/forgot", middleware.RateLimit(rateLimitStore,
+		ratePolicy("password-forgot", cfg.RateLimitAccountMail, middleware.KeyByIP),
+		ratePolicy("password-forgot-account", cfg.RateLimitAccountMail, middleware.KeyByBodyField("email")),
+	), authHandler.ForgotPassword)
+	v1.Post("/password/reset", middleware.RateLimit(rateLimitStore, ratePolicy("password-reset", cfg.RateLimitLogin, middleware.KeyByIP)), authHandler.ResetPassword)
 
 	// Protected routes
-	protected := v1.Group("/")
-	protected.Use(middleware.JWTMiddleware(jwtSecret))
+	protected := middleware.NewRouteGroup(v1, middleware.JWTMiddleware(jwtKeys, postgresClient))
 
 	// User routes (require authentication but not project scope)
 	protected.Get("/projects", func(c *fiber.Ctx) error {
 		return authHandler.ListProjects(c)
 	})
 
+	// Account routes manage the signed-in user and cannot be used with API keys
+	account := protected.Group("", middleware.APIKeysForbidden())
+
+	// Session routes
+	account.Post("/logout", authHandler.Logout)
+	account.Get("/sessions", authHandler.ListSessions)
+	account.Delete("/sessions", authHandler.RevokeOtherSessions)
+	account.Delete("/sessions/:id", authHandler.RevokeSession)
+
+	// Two-factor authentication routes
+	account.Get("/2fa", authHandler.GetTwoFactorStatus)
+	account.Post("/2fa/enroll", authHandler.EnrollTwoFactor)
+	account.Post("/2fa/verify", authHandler.VerifyTwoFactor)
+	account.Post("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+	account.Post("/2fa/disable", authHandler.DisableTwoFactor)
+
+	// Personal API key routes
+	apiKeyHandler := handlers.NewAPIKeyHandler(postgresClient)
+	account.Get("/api-keys", apiKeyHandler.ListAPIKeys)
+	account.Post("/api-keys", apiKeyHandler.CreateAPIKey)
+	account.Delete("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
+
+	// Project lifecycle routes (owners only, checked in the handler). A key
+	// belongs to one project, so it can change that project but not create
+	// others.
+	userProjectHandler := handlers.NewUserProjectHandler(postgresClient, authHandler.RoleMapping(), projectQuotas, cfg.ExternalNetworkID, cfg.BootstrapSubnetCIDR, cfg.MaxProjectsPerUser)
+	manageProject := middleware.APIKeyProjectPermission("id", models.PermissionProjectManage)
+	account.Post("/projects", userProjectHandler.CreateProject)
+	protected.Patch("/projects/:id", manageProject, userProjectHandler.UpdateProject)
+	protected.Delete("/projects/:id", manageProject, userProjectHandler.DeleteProject)
+
+	// Project membership routes (require a token scoped to the project in the URL)
+	memberHandler := handlers.NewMemberHandler(postgresClient, jwtKeys, authHandler.RoleMapping())
+	memberHandler.Mailer = appMailer
+	memberHandler.AppBaseURL = cfg.AppBaseURL
+	manageMembers := middleware.RequireProjectPermission(postgresClient, models.PermissionMembersManage)
+	projectMembers := protected.Group("/projects/:id", middleware.ProjectScopeRequired(), middleware.ProjectParamMatches("id"))
+	projectMembers.Get("/members", middleware.ProjectMemberRequired(postgresClient), memberHandler.ListMembers)
+	projectMembers.Put("/members/:user_id", manageMembers, memberHandler.UpdateMemberRole)
+	projectMembers.Delete("/members/:user_id", middleware.ProjectMemberRequired(postgresClient), memberHandler.RemoveMember)
+	projectMembers.Post("/transfer-ownership", middleware.RequireProjectPermission(postgresClient, models.PermissionProjectManage), memberHandler.TransferOwnership)
+	projectMembers.Get("/invitations", manageMembers, memberHandler.ListInvitations)
+	projectMembers.Post("/invitations", manageMembers, memberHandler.CreateInvitation)
+	projectMembers.Delete("/invitations/:invitation_id", manageMembers, memberHandler.RevokeInvitation)
+	account.Post("/invitations/accept", memberHandler.AcceptInvitation)
+
 	// Project-scoped routes
 	projectScoped := protected.Group("/")
 	projectScoped.Use(middleware.ProjectScopeRequired())
+	projectScoped.Use(middleware.ProjectPermission(postgresClient, models.PermissionResourcesRead, models.PermissionResourcesWrite))
+
+	// Billing routes are personal with an unscoped token; with a project token
+	// they follow the caller's project role
+	billingPermission := middleware.ProjectPermission(postgresClient, models.PermissionBillingRead, models.PermissionBillingWrite)
 
 	// Instance routes
-	instanceHandler := handlers.NewComputeHandler(jwtSecret)
+	instanceHandler := handlers.NewComputeHandler()
 	projectScoped.Get("/instances", instanceHandler.ListInstances)
 	projectScoped.Post("/instances", instanceHandler.CreateInstance)
 	projectScoped.Get("/instances/:id", instanceHandler.GetInstance)
 	projectScoped.Delete("/instances/:id", instanceHandler.DeleteInstance)
 	projectScoped.Put("/instances/:id", instanceHandler.UpdateInstance)
 	projectScoped.Post("/instances/:id/action", instanceHandler.PerformInstanceAction)
+	projectScoped.Post("/instances/:id/resize", instanceHandler.ResizeInstance)
+	projectScoped.Post("/instances/:id/resize/confirm", instanceHandler.ConfirmResizeInstance)
+	projectScoped.Post("/instances/:id/resize/revert", instanceHandler.RevertResizeInstance)
+	projectScoped.Post("/instances/:id/rebuild", instanceHandler.RebuildInstance)
+	projectScoped.Post("/instances/:id/rescue", instanceHandler.RescueInstance)
+	projectScoped.Post("/instances/:id/unrescue", instanceHandler.UnrescueInstance)
+	projectScoped.Post("/instances/:id/shelve", instanceHandler.ShelveInstance)
+	projectScoped.Post("/instances/:id/unshelve", instanceHandler.UnshelveInstance)
+	projectScoped.Post("/instances/:id/pause", instanceHandler.PauseInstance)
+	projectScoped.Post("/instances/:id/unpause", instanceHandler.UnpauseInstance)
+	projectScoped.Post("/instances/:id/suspend", instanceHandler.SuspendInstance)
+	projectScoped.Post("/instances/:id/resume", instanceHandler.ResumeInstance)
+	projectScoped.Post("/instances/:id/lock", instanceHandler.LockInstance)
+	projectScoped.Post("/instances/:id/unlock", instanceHandler.UnlockInstance)
+	projectScoped.Post("/instances/:id/reset-password", instanceHandler.ResetInstancePassword)
+	projectScoped.Post("/instances/:id/console", instanceHandler.CreateInstanceConsole)
+	projectScoped.Get("/instances/:id/console-log", instanceHandler.GetInstanceConsoleLog)
+	projectScoped.Post("/instances/:id/snapshot", instanceHandler.CreateInstanceSnapshot)
+	projectScoped.Get("/instances/:id/snapshots", instanceHandler.ListInstanceSnapshots)
+	projectScoped.Get("/instances/:id/interfaces", instanceHandler.ListInstanceInterfaces)
+	projectScoped.Post("/instances/:id/interfaces", instanceHandler.AttachInstanceInterface)
+	projectScoped.Delete("/instances/:id/interfaces/:port_id", instanceHandler.DetachInstanceInterface)
+	projectScoped.Get("/instances/:id/security-groups", instanceHandler.ListInstanceSecurityGroups)
+	projectScoped.Post("/instances/:id/security-groups", instanceHandler.AddInstanceSecurityGroup)
+	projectScoped.Delete("/instances/:id/security-groups/:group", instanceHandler.RemoveInstanceSecurityGroup)
+
+	// Snapshot routes
+	projectScoped.Get("/snapshots", instanceHandler.ListSnapshots)
+	projectScoped.Get("/snapshots/usage", instanceHandler.GetSnapshotUsage)
+	projectScoped.Get("/snapshots/:id", instanceHandler.GetSnapshot)
+
+	// Server group and availability zone routes
+	projectScoped.Get("/server-groups", instanceHandler.ListServerGroups)
+	projectScoped.Post("/server-groups", instanceHandler.CreateServerGroup)
+	projectScoped.Get("/server-groups/:id", instanceHandler.GetServerGroup)
+	projectScoped.Delete("/server-groups/:id", instanceHandler.DeleteServerGroup)
+	projectScoped.Get("/availability-zones", instanceHandler.ListAvailabilityZones)
+
+	// Port routes
+	portHandler := handlers.NewPortHandler()
+	projectScoped.Get("/ports", portHandler.ListPorts)
+	projectScoped.Post("/ports", portHandler.CreatePort)
+	projectScoped.Get("/ports/:id", portHandler.GetPort)
+	projectScoped.Put("/ports/:id", portHandler.UpdatePort)
+	projectScoped.Delete("/ports/:id", portHandler.DeletePort)
+
+	// Backup policy routes
+	backupHandler := handlers.NewBackupHandler(postgresClient)
+	projectScoped.Get("/backup-policies", backupHandler.ListBackupPolicies)
+	projectScoped.Post("/backup-policies", backupHandler.CreateBackupPolicy)
+	projectScoped.Get("/backup-policies/:id", backupHandler.GetBackupPolicy)
+	projectScoped.Put("/backup-policies/:id", backupHandler.UpdateBackupPolicy)
+	projectScoped.Delete("/backup-policies/:id", backupHandler.DeleteBackupPolicy)
+	projectScoped.Post("/backup-policies/:id/run", backupHandler.RunBackupPolicy)
+	projectScoped.Get("/backup-policies/:id/backups", backupHandler.ListPolicyBackups)
+	projectScoped.Delete("/backup-policies/:id/backups/:backup_id", backupHandler.DeletePolicyBackup)
+	projectScoped.Post("/backup-policies/:id/backups/:backup_id/restore", backupHandler.RestorePolicyBackup)
+	cron.StartBackupCron(backup.NewRunner(postgresClient), cfg.BackupScheduleInterval)
 
 	// Image routes
-	imageHandler := handlers.NewImageHandler(jwtSecret)
+	imageHandler := handlers.NewImageHandler()
 	projectScoped.Get("/images", imageHandler.ListImages)
 	projectScoped.Get("/images/:id", imageHandler.GetImage)
 	projectScoped.Post("/images", imageHandler.CreateImage)
@@ -204,6 +412,8 @@ func main() {
 
 	// Initialize Stripe handler
 	stripeHandler := handlers.NewStripeHandler(supabaseClient, stripeClient)
+	vpsHandler.StripeClient = stripeClient
+	vpsHandler.PostgresClient = postgresClient
 
 	// Initialize M-Pesa client
 	mpesaClient, err := client.GetMPesaClientFromEnv()
@@ -212,7 +422,10 @@ func main() {
 	}
 
 	// Initialize M-Pesa handler
-	mpesaHandler := handlers.NewMPesaHandler(supabaseClient, mpesaClient)
+	mpesaHandler := handlers.NewMPesaHandler(supabaseClient, postgresClient, mpesaClient)
+	mpesaHandler.STKQueryDelay = cfg.MPesaSTKQueryDelay
+	mpesaHandler.STKMaxQueries = cfg.MPesaSTKMaxQueries
+	mpesaHandler.STKAttemptTTL = cfg.MPesaSTKAttemptTTL
 
 	// Network routes
 	projectScoped.Get("/networks", func(c *fiber.Ctx) error {
@@ -298,6 +511,14 @@ func main() {
 		return volumeHandler.DetachVolume(c)
 	})
 	projectScoped.Put("/volumes/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.UpdateVolume(c)
+	})
+	projectScoped.Post("/volumes/:id/extend", func(c *fiber.Ctx) error {
 		if openStackClient == nil {
 			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
 				"error": "OpenStack service unavailable",
@@ -314,6 +535,90 @@ func main() {
 		return volumeHandler.ListVolumeTypes(c)
 	})
 
+	// Volume snapshot routes
+	projectScoped.Get("/volume-snapshots", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.ListVolumeSnapshots(c)
+	})
+	projectScoped.Post("/volume-snapshots", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.CreateVolumeSnapshot(c)
+	})
+	projectScoped.Get("/volume-snapshots/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.GetVolumeSnapshot(c)
+	})
+	projectScoped.Put("/volume-snapshots/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.UpdateVolumeSnapshot(c)
+	})
+	projectScoped.Delete("/volume-snapshots/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.DeleteVolumeSnapshot(c)
+	})
+
+	// Volume backup routes
+	projectScoped.Get("/volume-backups", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.ListVolumeBackups(c)
+	})
+	projectScoped.Post("/volume-backups", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.CreateVolumeBackup(c)
+	})
+	projectScoped.Get("/volume-backups/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.GetVolumeBackup(c)
+	})
+	projectScoped.Delete("/volume-backups/:id", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.DeleteVolumeBackup(c)
+	})
+	projectScoped.Post("/volume-backups/:id/restore", func(c *fiber.Ctx) error {
+		if openStackClient == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "OpenStack service unavailable",
+			})
+		}
+		return volumeHandler.RestoreVolumeBackup(c)
+	})
+
 	// Project routes
 	projectScoped.Get("/projects", func(c *fiber.Ctx) error {
 		if openStackClient == nil {
@@ -407,6 +712,10 @@ func main() {
 		}
 		return floatingIPHandler.DeleteFloatingIP(c)
 	})
+	projectScoped.Post("/floating-ips/:id/associate", floatingIPHandler.AssociateFloatingIP)
+	projectScoped.Post("/floating-ips/:id/disassociate", floatingIPHandler.DisassociateFloatingIP)
+	projectScoped.Post("/instances/:id/public-ip", floatingIPHandler.CreateInstancePublicIP)
+	projectScoped.Delete("/instances/:id/public-ip", floatingIPHandler.DeleteInstancePublicIP)
 
 	// Security Group routes
 	projectScoped.Get("/security-groups", func(c *fiber.Ctx) error {
@@ -545,21 +854,35 @@ func main() {
 	})
 
 	// VPS routes (require authentication but not project scope)
-	vpsRoutes := protected.Group("/vps")
+	vpsRoutes := protected.Group("/vps", billingPermission)
 	vpsRoutes.Get("/plans", vpsHandler.ListPlans)
 	vpsRoutes.Post("/subscribe", vpsHandler.Subscribe)
 	vpsRoutes.Get("/subscriptions", vpsHandler.ListSubscriptions)
 	vpsRoutes.Post("/subscriptions/:id/cancel", vpsHandler.CancelSubscription)
 
+	// VPS server routes act on the instance, so they follow the resource
+	// permissions rather than the billing ones
+	vpsServerRoutes := protected.Group("/vps/subscriptions/:id")
+	vpsServerRoutes.Post("/console", middleware.RequireProjectPermission(postgresClient, models.PermissionResourcesWrite), vpsHandler.CreateSubscriptionConsole)
+	vpsServerRoutes.Get("/console-log", middleware.RequireProjectPermission(postgresClient, models.PermissionResourcesRead), vpsHandler.GetSubscriptionConsoleLog)
+	vpsServerRoutes.Get("/snapshots", middleware.RequireProjectPermission(postgresClient, models.PermissionResourcesRead), vpsHandler.ListSubscriptionSnapshots)
+	vpsServerRoutes.Post("/reinstall", middleware.RequireProjectPermission(postgresClient, models.PermissionResourcesWrite), vpsHandler.ReinstallSubscription)
+
 	// New VPS order and invoice routes
 	vpsRoutes.Post("/order", vpsHandler.CreateOrder)
 	vpsRoutes.Get("/invoice/:id", vpsHandler.GetInvoice)
 	vpsRoutes.Post("/invoice/:id/pay", vpsHandler.PayInvoice)
 	vpsRoutes.Get("/invoices", vpsHandler.ListInvoices)
 
+	// Event stream of the caller's project, fed by OpenStack polling and by
+	// events raised here
+	eventsHandler := handlers.NewEventsHandler(supabaseClient, postgresClient)
+	projectScoped.Get("/events", eventsHandler.StreamEvents)
+	cron.StartEventPollCron(events.NewPoller(events.Default()), cfg.EventPollInterval)
+
 	// PayPal routes (for VPS payments, require authentication but not project scope)
 	if paypalClient != nil {
-		paypalRoutes := protected.Group("/paypal")
+		paypalRoutes := protected.Group("/paypal", billingPermission)
 		paypalRoutes.Post("/create-order", paypalHandler.CreateOrder)
 		paypalRoutes.Post("/capture-order", paypalHandler.CaptureOrder)
 		paypalRoutes.Get("/order/:id", paypalHandler.GetOrderStatus)
@@ -570,31 +893,87 @@ func main() {
 
 	// Stripe routes (for VPS payments, require authentication but not project scope)
 	if stripeClient != nil {
-		stripeRoutes := protected.Group("/stripe")
+		stripeRoutes := protected.Group("/stripe", billingPermission)
 		stripeRoutes.Post("/checkout", stripeHandler.CreateCheckoutSession)
 		stripeRoutes.Post("/subscription", stripeHandler.CreateSubscription)
 		stripeRoutes.Post("/subscription/:id/cancel", stripeHandler.CancelSubscription)
-		stripeRoutes.Post("/webhook", stripeHandler.HandleWebhook)
+		stripeRoutes.Get("/payment-methods", stripeHandler.ListPaymentMethods)
+		stripeRoutes.Post("/payment-methods", stripeHandler.AddPaymentMethod)
+		stripeRoutes.Post("/payment-methods/setup-intent", stripeHandler.CreateSetupIntent)
+		stripeRoutes.Post("/payment-methods/:id/default", stripeHandler.SetDefaultPaymentMethod)
+		stripeRoutes.Delete("/payment-methods/:id", stripeHandler.DeletePaymentMethod)
+		stripeRoutes.Post("/billing-portal", stripeHandler.CreateBillingPortalSession)
+
+		// Webhook endpoint (no authentication required, verified by signature)
+		v1.Post("/stripe/webhook", stripeHandler.HandleWebhook)
 	}
 
-	// Flutterwave routes
-	v1.Post("/flutterwave/create-payment", flutterwaveHandler.CreatePayment)
-	v1.Post("/flutterwave/webhook", flutterwaveHandler.HandleWebhook)
-	v1.Get("/flutterwave/verify/:id", flutterwaveHandler.VerifyPayment)
-	v1.Get("/flutterwave/status/:tx_ref", flutterwaveHandler.GetPaymentStatus)
+	// Flutterwave routes (for VPS payments, require authentication but not project scope)
+	if flutterwaveClient != nil {
+		flutterwaveRoutes := protected.Group("/flutterwave", billingPermission)
+		flutterwaveRoutes.Post("/create-payment", flutterwaveHandler.CreatePayment)
+		flutterwaveRoutes.Get("/status/:tx_ref", flutterwaveHandler.GetPaymentStatus)
 
-	// M-Pesa routes
+		// Webhook (verified by FLUTTERWAVE_SECRET_HASH) and redirect verification,
+		// which confirms the charge with Flutterwave before marking an invoice paid
+		v1.Post("/flutterwave/webhook", flutterwaveHandler.HandleWebhook)
+		v1.Get("/flutterwave/verify/:id", flutterwaveHandler.VerifyPayment)
+	}
+
+	// M-Pesa routes (for VPS payments, require authentication but not project scope)
 	if mpesaClient != nil {
-		v1.Post("/mpesa/stk-push", mpesaHandler.InitiateSTKPush)
-		v1.Post("/mpesa/callback", mpesaHandler.HandleSTKPushCallback)
-		v1.Post("/mpesa/check-status", mpesaHandler.CheckSTKPushStatus)
+		mpesaRoutes := protected.Group("/mpesa", billingPermission)
+		mpesaRoutes.Post("/stk-push", middleware.RateLimit(rateLimitStore,
+			ratePolicy("stk-push", cfg.RateLimitSTKPush, middleware.KeyByIP),
+			ratePolicy("stk-push-account", cfg.RateLimitSTKPush, middleware.KeyByUser),
+			ratePolicy("stk-push-phone", cfg.RateLimitSTKPhone, handlers.MPesaPhoneKey),
+		), mpesaHandler.InitiateSTKPush)
+		mpesaRoutes.Post("/check-status", mpesaHandler.CheckSTKPushStatus)
+		mpesaRoutes.Get("/invoices/:id/attempts", mpesaHandler.ListSTKAttempts)
+		mpesaRoutes.Get("/paybill", mpesaHandler.GetPaybillDetails)
+
+		// Callback endpoints (no authentication required, restricted to Safaricom
+		// addresses and the secret MPESA_CALLBACK_TOKEN path segment). Daraja will
+		// not register C2B URLs that mention M-Pesa, hence /paybill.
+		v1.Post("/mpesa/callback/:token", mpesaHandler.VerifyCallback, mpesaHandler.HandleSTKPushCallback)
+		v1.Post("/mpesa/transaction-status/result/:token", mpesaHandler.VerifyCallback, mpesaHandler.HandleTransactionStatusResult)
+		v1.Post("/mpesa/transaction-status/timeout/:token", mpesaHandler.VerifyCallback, mpesaHandler.HandleTransactionStatusTimeout)
+		v1.Post("/paybill/validation/:token", mpesaHandler.VerifyCallback, mpesaHandler.HandleC2BValidation)
+		v1.Post("/paybill/confirmation/:token", mpesaHandler.VerifyCallback, mpesaHandler.HandleC2BConfirmation)
+
+		// Settle STK pushes whose callbacks never arrive
+		if supabaseClient != nil {
+			cron.StartSTKPushReconcileCron(mpesaHandler, cfg.MPesaSTKReconcileInterval)
+		}
 	}
 
 	// Admin routes
+	adminHandler := handlers.NewAdminHandler(postgresClient, supabaseClient)
 	adminRoutes := protected.Group("/admin")
-	adminRoutes.Use(middleware.AdminRequired())
+	adminRoutes.Use(middleware.AdminRequired(postgresClient))
+	adminRoutes.Use(middleware.AdminAudit(postgresClient))
+	adminRoutes.Get("/users", adminHandler.ListUsers)
+	adminRoutes.Get("/users/:id", adminHandler.GetUser)
+	adminRoutes.Get("/users/:id/subscriptions", adminHandler.ListUserSubscriptions)
+	adminRoutes.Get("/users/:id/invoices", adminHandler.ListUserInvoices)
+	adminRoutes.Post("/users/:id/suspend", adminHandler.SuspendUser)
+	adminRoutes.Post("/users/:id/unsuspend", adminHandler.UnsuspendUser)
+	adminRoutes.Put("/users/:id/role", adminHandler.UpdateUserRole)
+	adminRoutes.Delete("/users/:id/2fa", adminHandler.ResetUserTwoFactor)
+	adminRoutes.Get("/security-settings", adminHandler.GetSecuritySettings)
+	adminRoutes.Put("/security-settings", adminHandler.UpdateSecuritySettings)
+	adminRoutes.Get("/audit-logs", adminHandler.ListAuditLogs)
 	adminRoutes.Post("/vps/billing/run", vpsHandler.RunRenewalBilling)
 
+	// M-Pesa Paybill review queue and setup
+	if mpesaClient != nil {
+		adminRoutes.Get("/mpesa/c2b", mpesaHandler.ListC2BPayments)
+		adminRoutes.Get("/mpesa/c2b/:id", mpesaHandler.GetC2BPayment)
+		adminRoutes.Post("/mpesa/c2b/:id/resolve", mpesaHandler.ResolveC2BPayment)
+		adminRoutes.Post("/mpesa/c2b/register-urls", mpesaHandler.RegisterC2BURLs)
+		adminRoutes.Post("/mpesa/c2b/simulate", mpesaHandler.SimulateC2BPayment)
+	}
+
 	// Add a root endpoint that shows API info
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
@@ -629,3 +1008,13 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// ratePolicy builds a rate limit policy from a configured rate
+func ratePolicy(name string, rate config.Rate, key middleware.RateLimitKeyFunc) middleware.RateLimitPolicy {
+	return middleware.RateLimitPolicy{
+		Name:   name,
+		Limit:  rate.Limit,
+		Window: rate.Window,
+		Key:    key,
+	}
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// dueBatchSize is the most policies one scheduler run backs up
+const dueBatchSize = 50
+
+// busyStatuses are the image and Cinder backup statuses of backups still
+// being taken or restored, which pruning leaves alone
+var busyStatuses = map[string]bool{
+	"queued":    true,
+	"saving":    true,
+	"uploading": true,
+	"importing": true,
+	"creating":  true,
+	"restoring": true,
+	"deleting":  true,
+}
+
+// Store keeps backup policies and their run history
+type Store interface {
+	ListDueBackupPolicies(ctx context.Context, now time.Time, limit int) ([]models.BackupPolicy, error)
+	ClaimBackupPolicyRun(ctx context.Context, id string, scheduledAt, nextRunAt time.Time) (bool, error)
+	RecordBackupPolicyRun(ctx context.Context, id, status, runErr string, disable bool) error
+}
+
+// Runner takes the backups of due backup policies
+type Runner struct {
+	store Store
+}
+
+// NewRunner creates a runner for the policies in a store
+func NewRunner(store Store) *Runner {
+	return &Runner{
+		store: store,
+	}
+}
+
+// RunBackups backs up the resources of due policies and prunes their old
+// backups. A run whose window has closed is recorded as missed. It returns
+// the number of backups taken.
+func (r *Runner) RunBackups(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	policies, err := r.store.ListDueBackupPolicies(ctx, now, dueBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(policies) == 0 {
+		return 0, nil
+	}
+
+	// Backups are taken and held with admin credentials, after checking each
+	// instance or volume still belongs to its policy's project
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get admin provider: %v", err)
+	}
+
+	taken := 0
+	for i := range policies {
+		policy := &policies[i]
+
+		// Move the policy on to its next window first so that no other
+		// scheduler takes the same backup
+		claimed, err := r.store.ClaimBackupPolicyRun(ctx, policy.ID, policy.NextRunAt, NextRun(policy, now))
+		if err != nil {
+			log.Printf("Error claiming backup policy %s: %v", policy.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if windowEnd := WindowEnd(policy, policy.NextRunAt); now.After(windowEnd) {
+			runErr := fmt.Sprintf("backup window closed at %s", windowEnd.Format(time.RFC3339))
+			if err := r.store.RecordBackupPolicyRun(ctx, policy.ID, models.BackupRunMissed, runErr, false); err != nil {
+				log.Printf("Error recording backup policy %s run: %v", policy.ID, err)
+			}
+			continue
+		}
+
+		status, runErr, disable := models.BackupRunSucceeded, "", false
+		backupID, err := RunPolicy(ctx, adminProvider, policy)
+		if err != nil {
+			log.Printf("Error running backup policy %s: %v", policy.ID, err)
+			status, runErr = models.BackupRunFailed, err.Error()
+
+			// Stop backing up an instance or volume that has been deleted
+			// or has left the policy's project
+			disable = backupID == "" && (gophercloud.ResponseCodeIs(err, 404) || errors.Is(err, openstack.ErrBackupResourceNotInProject))
+		}
+		if backupID != "" {
+			taken++
+		}
+		if err := r.store.RecordBackupPolicyRun(ctx, policy.ID, status, runErr, disable); err != nil {
+			log.Printf("Error recording backup policy %s run: %v", policy.ID, err)
+		}
+		publishBackupProgress(policy, backupID, err)
+	}
+
+	return taken, nil
+}
+
+// RunPolicy backs up a policy's instance or volume now and prunes the backups
+// beyond its keep count. It returns the new backup's ID, which is set even
+// when pruning fails.
+func RunPolicy(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) (string, error) {
+	backupID, err := openstack.CreatePolicyBackup(ctx, provider, policy)
+	if err != nil {
+		return "", err
+	}
+
+	if err := Prune(ctx, provider, policy); err != nil {
+		return backupID, err
+	}
+
+	return backupID, nil
+}
+
+// Prune deletes a policy's backups beyond the newest Keep. Backups still
+// being taken count towards Keep but are never deleted.
+func Prune(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) error {
+	allBackups, err := openstack.ListPolicyBackups(ctx, provider, policy)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, backup := range allBackups {
+		if i < policy.Keep || busyStatuses[backup.Status] {
+			continue
+		}
+		err := openstack.DeletePolicyBackup(ctx, provider, policy, backup.ID)
+		if err != nil && !gophercloud.ResponseCodeIs(err, 404) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// publishBackupProgress tells the project's event streams how a scheduled
+// backup went
+func publishBackupProgress(policy *models.BackupPolicy, backupID string, runErr error) {
+	data := map[string]interface{}{
+		"job":       "backup",
+		"stage":     "completed",
+		"policy_id": policy.ID,
+	}
+	if backupID != "" {
+		data["backup_id"] = backupID
+	}
+	if runErr != nil {
+		data["stage"] = "failed"
+		data["error"] = runErr.Error()
+	}
+
+	events.Publish(events.Event{
+		Type:         events.TypeJobProgress,
+		ProjectID:    policy.ProjectID,
+		ResourceType: policy.ResourceType,
+		ResourceID:   policy.ResourceID,
+		Data:         data,
+	})
+}
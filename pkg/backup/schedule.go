@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+const (
+	// DefaultWindowStart and DefaultWindowHours are the backup window of a
+	// policy that does not choose one
+	DefaultWindowStart = "00:00"
+	DefaultWindowHours = 4
+
+	// MaxKeep is the most backups a policy may keep
+	MaxKeep = 30
+)
+
+// parseWindowStart parses an HH:MM window start into hours and minutes
+func parseWindowStart(windowStart string) (int, int, error) {
+	start, err := time.Parse("15:04", windowStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("window_start must be HH:MM in UTC")
+	}
+	return start.Hour(), start.Minute(), nil
+}
+
+// ValidatePolicy checks a backup policy's schedule and retention and fills in
+// the default window
+func ValidatePolicy(policy *models.BackupPolicy) error {
+	switch policy.ResourceType {
+	case models.BackupResourceInstance, models.BackupResourceVolume:
+	default:
+		return fmt.Errorf("resource_type must be instance or volume")
+	}
+	if policy.ResourceID == "" {
+		return fmt.Errorf("resource_id is required")
+	}
+
+	switch policy.Frequency {
+	case models.BackupFrequencyDaily:
+		policy.Weekday = 0
+	case models.BackupFrequencyWeekly:
+		if policy.Weekday < 0 || policy.Weekday > 6 {
+			return fmt.Errorf("weekday must be 0 (Sunday) to 6 (Saturday)")
+		}
+	default:
+		return fmt.Errorf("frequency must be daily or weekly")
+	}
+
+	if policy.WindowStart == "" {
+		policy.WindowStart = DefaultWindowStart
+	}
+	if _, _, err := parseWindowStart(policy.WindowStart); err != nil {
+		return err
+	}
+	if policy.WindowHours == 0 {
+		policy.WindowHours = DefaultWindowHours
+	}
+	if policy.WindowHours < 1 || policy.WindowHours > 23 {
+		return fmt.Errorf("window_hours must be 1 to 23")
+	}
+
+	if policy.Keep < 1 || policy.Keep > MaxKeep {
+		return fmt.Errorf("keep must be 1 to %d", MaxKeep)
+	}
+
+	return nil
+}
+
+// NextRun returns the start of a validated policy's first backup window
+// after a time
+func NextRun(policy *models.BackupPolicy, after time.Time) time.Time {
+	hour, minute, _ := parseWindowStart(policy.WindowStart)
+
+	after = after.UTC()
+	next := time.Date(after.Year(), after.Month(), after.Day(), hour, minute, 0, 0, time.UTC)
+	if policy.Frequency == models.BackupFrequencyWeekly {
+		next = next.AddDate(0, 0, (policy.Weekday-int(next.Weekday())+7)%7)
+	}
+
+	step := 1
+	if policy.Frequency == models.BackupFrequencyWeekly {
+		step = 7
+	}
+	for !next.After(after) {
+		next = next.AddDate(0, 0, step)
+	}
+
+	return next
+}
+
+// WindowEnd returns when the backup window starting at a time closes
+func WindowEnd(policy *models.BackupPolicy, start time.Time) time.Time {
+	return start.Add(time.Duration(policy.WindowHours) * time.Hour)
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,6 +26,10 @@ type FlutterwaveClient struct {
 	BaseURL   string
 	IsSandbox bool
 	Client    *http.Client
+
+	// SecretHash is the secret hash set on the Flutterwave dashboard, which
+	// Flutterwave sends back in the verif-hash header of every webhook
+	SecretHash string
 }
 
 // NewFlutterwaveClient creates a new Flutterwave client
@@ -208,9 +213,11 @@ func (c *FlutterwaveClient) VerifyTransaction(transactionID string) (*Flutterwav
 
 // VerifyWebhookSignature verifies the signature of a webhook event
 func (c *FlutterwaveClient) VerifyWebhookSignature(signature string, payload []byte) bool {
-	// In a real implementation, you would verify the signature using the Flutterwave webhook secret
-	// For now, we'll just return true for testing purposes
-	return true
+	// Without a secret hash no webhook can be trusted
+	if c.SecretHash == "" || signature == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(c.SecretHash)) == 1
 }
 
 // ListBanks gets a list of banks from Flutterwave
@@ -262,5 +269,11 @@ func GetFlutterwaveClientFromEnv() (*FlutterwaveClient, error) {
 		return nil, errors.New("FLUTTERWAVE_SECRET_KEY and FLUTTERWAVE_PUBLIC_KEY environment variables must be set")
 	}
 
-	return NewFlutterwaveClient(secretKey, publicKey, isSandbox), nil
+	flutterwaveClient := NewFlutterwaveClient(secretKey, publicKey, isSandbox)
+	flutterwaveClient.SecretHash = os.Getenv("FLUTTERWAVE_SECRET_HASH")
+	if flutterwaveClient.SecretHash == "" {
+		fmt.Println("Warning: FLUTTERWAVE_SECRET_HASH is not set; Flutterwave webhooks will be rejected")
+	}
+
+	return flutterwaveClient, nil
 }
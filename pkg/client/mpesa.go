@@ -2,13 +2,16 @@ package client
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,40 @@ type MPesaClient struct {
 	TokenExpiry       time.Time
 	BusinessShortCode string
 	PassKey           string
+
+	// CallbackToken is the secret path segment of callback URLs, and
+	// CallbackAllowedNets the networks Safaricom calls back from; nil allows any
+	CallbackToken       string
+	CallbackAllowedNets []*net.IPNet
+
+	// C2BShortCode is the Paybill customers pay into, and C2BRejectUnmatched
+	// rejects Paybill payments whose account number matches nothing at
+	// validation instead of queueing them for review
+	C2BShortCode       string
+	C2BRejectUnmatched bool
+
+	// Initiator and SecurityCredential (the initiator password encrypted with
+	// Safaricom's certificate) authorise transaction status queries, whose
+	// results are posted to URLs under CallbackBaseURL
+	Initiator          string
+	SecurityCredential string
+	CallbackBaseURL    string
+}
+
+// MPesaCallbackIPs are the addresses Safaricom publishes for Daraja callbacks
+var MPesaCallbackIPs = []string{
+	"196.201.214.200",
+	"196.201.214.206",
+	"196.201.213.114",
+	"196.201.214.207",
+	"196.201.214.208",
+	"196.201.213.44",
+	"196.201.212.127",
+	"196.201.212.138",
+	"196.201.212.129",
+	"196.201.212.136",
+	"196.201.212.74",
+	"196.201.212.69",
 }
 
 // MPesaResponse represents a generic M-Pesa API response
@@ -39,8 +76,27 @@ type MPesaResponse struct {
 	MerchantRequestID   string `json:"MerchantRequestID,omitempty"`
 	CheckoutRequestID   string `json:"CheckoutRequestID,omitempty"`
 	CustomerMessage     string `json:"CustomerMessage,omitempty"`
+
+	// ResultCode and ResultDesc are the outcome of a queried STK push
+	ResultCode string `json:"ResultCode,omitempty"`
+	ResultDesc string `json:"ResultDesc,omitempty"`
+}
+
+// MPesaErrorResponse represents an error returned by the M-Pesa API
+type MPesaErrorResponse struct {
+	RequestID    string `json:"requestId"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
 }
 
+// ErrSTKPushProcessing is returned when an STK push is queried before the
+// customer has responded to it
+var ErrSTKPushProcessing = errors.New("STK push is still being processed")
+
+// mpesaErrorProcessing is the error code of a query for an STK push that is
+// still in progress
+const mpesaErrorProcessing = "500.001.1001"
+
 // STKPushRequest represents an STK push request
 type STKPushRequest struct {
 	BusinessShortCode string `json:"BusinessShortCode"`
@@ -88,13 +144,17 @@ type TransactionStatusRequest struct {
 	Initiator          string `json:"Initiator"`
 	SecurityCredential string `json:"SecurityCredential"`
 	CommandID          string `json:"CommandID"`
-	TransactionID      string `json:"TransactionID"`
+	TransactionID      string `json:"TransactionID,omitempty"`
 	PartyA             string `json:"PartyA"`
 	IdentifierType     string `json:"IdentifierType"`
 	ResultURL          string `json:"ResultURL"`
 	QueueTimeOutURL    string `json:"QueueTimeOutURL"`
 	Remarks            string `json:"Remarks"`
 	Occasion           string `json:"Occasion"`
+
+	// OriginalConversationID identifies the transaction when its receipt
+	// number is not known
+	OriginalConversationID string `json:"OriginalConversationID,omitempty"`
 }
 
 // TransactionStatusResponse represents a transaction status response
@@ -105,6 +165,35 @@ type TransactionStatusResponse struct {
 	ResponseDescription      string `json:"ResponseDescription"`
 }
 
+// TransactionStatusResult represents the transaction status M-Pesa posts to
+// the result URL of a transaction status request
+type TransactionStatusResult struct {
+	Result struct {
+		ResultType               int    `json:"ResultType"`
+		ResultCode               int    `json:"ResultCode"`
+		ResultDesc               string `json:"ResultDesc"`
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ConversationID           string `json:"ConversationID"`
+		TransactionID            string `json:"TransactionID"`
+		ResultParameters         struct {
+			ResultParameter []struct {
+				Key   string      `json:"Key"`
+				Value interface{} `json:"Value,omitempty"`
+			} `json:"ResultParameter"`
+		} `json:"ResultParameters"`
+	} `json:"Result"`
+}
+
+// Parameter returns a result parameter as a string, or "" when it is absent
+func (r *TransactionStatusResult) Parameter(key string) string {
+	for _, param := range r.Result.ResultParameters.ResultParameter {
+		if param.Key == key && param.Value != nil {
+			return fmt.Sprintf("%v", param.Value)
+		}
+	}
+	return ""
+}
+
 // C2BRegisterURLRequest represents a C2B register URL request
 type C2BRegisterURLRequest struct {
 	ShortCode       string `json:"ShortCode"`
@@ -136,6 +225,31 @@ type C2BSimulateResponse struct {
 	ResponseDescription      string `json:"ResponseDescription"`
 }
 
+// C2BCallback represents the payment details M-Pesa posts to the C2B
+// validation and confirmation URLs
+type C2BCallback struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// C2B validation result codes
+const (
+	C2BResultAccepted             = "0"
+	C2BResultInvalidAccountNumber = "C2B00012"
+	C2BResultInvalidAmount        = "C2B00013"
+)
+
 // NewMPesaClient creates a new M-Pesa client
 func NewMPesaClient(consumerKey, consumerSecret, businessShortCode, passKey string, isSandbox bool) *MPesaClient {
 	baseURL := MPesaBaseURLLive
@@ -162,12 +276,106 @@ func GetMPesaClientFromEnv() (*MPesaClient, error) {
 	passKey := os.Getenv("MPESA_PASS_KEY")
 	sandboxStr := os.Getenv("MPESA_SANDBOX")
 
-	if consumerKey == "" || consumerSecret == "" || businessShortCode == "" || passKey == "" {
-		return nil, errors.New("MPESA_CONSUMER_KEY, MPESA_CONSUMER_SECRET, MPESA_BUSINESS_SHORTCODE, and MPESA_PASS_KEY must be set")
+	callbackToken := os.Getenv("MPESA_CALLBACK_TOKEN")
+
+	if consumerKey == "" || consumerSecret == "" || businessShortCode == "" || passKey == "" || callbackToken == "" {
+		return nil, errors.New("MPESA_CONSUMER_KEY, MPESA_CONSUMER_SECRET, MPESA_BUSINESS_SHORTCODE, MPESA_PASS_KEY, and MPESA_CALLBACK_TOKEN must be set")
+	}
+
+	// Only accept callbacks from Safaricom unless told otherwise
+	allowedIPs := MPesaCallbackIPs
+	if value := strings.TrimSpace(os.Getenv("MPESA_CALLBACK_ALLOWED_IPS")); value != "" {
+		allowedIPs = strings.Split(value, ",")
+	}
+	allowedNets, err := parseIPNets(allowedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MPESA_CALLBACK_ALLOWED_IPS: %v", err)
 	}
 
 	isSandbox := sandboxStr == "true"
-	return NewMPesaClient(consumerKey, consumerSecret, businessShortCode, passKey, isSandbox), nil
+	mpesaClient := NewMPesaClient(consumerKey, consumerSecret, businessShortCode, passKey, isSandbox)
+	mpesaClient.CallbackToken = callbackToken
+	mpesaClient.CallbackAllowedNets = allowedNets
+
+	// Paybill payments go to the STK shortcode unless a separate one is set
+	mpesaClient.C2BShortCode = businessShortCode
+	if shortCode := os.Getenv("MPESA_C2B_SHORTCODE"); shortCode != "" {
+		mpesaClient.C2BShortCode = shortCode
+	}
+	mpesaClient.C2BRejectUnmatched = os.Getenv("MPESA_C2B_REJECT_UNMATCHED") == "true"
+
+	// Transaction status queries are optional
+	mpesaClient.Initiator = os.Getenv("MPESA_INITIATOR_NAME")
+	mpesaClient.SecurityCredential = os.Getenv("MPESA_SECURITY_CREDENTIAL")
+	mpesaClient.CallbackBaseURL = os.Getenv("MPESA_CALLBACK_BASE_URL")
+
+	return mpesaClient, nil
+}
+
+// parseIPNets parses IP addresses and CIDR ranges; a "*" entry allows any address
+func parseIPNets(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			continue
+		case value == "*":
+			return nil, nil
+		case strings.Contains(value, "/"):
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, ipNet)
+		default:
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return nets, nil
+}
+
+// CallbackURL returns the URL M-Pesa should call back for a path under
+// /v1/mpesa, including the secret callback token
+func (c *MPesaClient) CallbackURL(baseURL, path string) string {
+	return fmt.Sprintf("%s/v1/mpesa/%s/%s", strings.TrimRight(baseURL, "/"), path, c.CallbackToken)
+}
+
+// PaybillURL returns a C2B URL under /v1/paybill, including the secret
+// callback token. Daraja refuses to register C2B URLs that mention M-Pesa,
+// so these cannot live under /v1/mpesa.
+func (c *MPesaClient) PaybillURL(baseURL, path string) string {
+	return fmt.Sprintf("%s/v1/paybill/%s/%s", strings.TrimRight(baseURL, "/"), path, c.CallbackToken)
+}
+
+// VerifyCallback checks that a callback came from an allowed address and
+// carries the secret callback token
+func (c *MPesaClient) VerifyCallback(remoteIP, token string) bool {
+	if c.CallbackToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.CallbackToken)) != 1 {
+		return false
+	}
+	if c.CallbackAllowedNets == nil {
+		return true
+	}
+
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range c.CallbackAllowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // Authenticate authenticates with the M-Pesa API and gets an access token
@@ -297,6 +505,10 @@ func (c *MPesaClient) QuerySTKPushStatus(businessShortCode, password, timestamp,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		var errResp MPesaErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.ErrorCode == mpesaErrorProcessing {
+			return nil, ErrSTKPushProcessing
+		}
 		return nil, fmt.Errorf("STK push query failed: %s", string(body))
 	}
 
@@ -429,6 +641,11 @@ func (c *MPesaClient) TransactionStatus(req TransactionStatusRequest) (*Transact
 	return &statusResp, nil
 }
 
+// CanQueryTransactionStatus reports whether transaction status queries are configured
+func (c *MPesaClient) CanQueryTransactionStatus() bool {
+	return c.Initiator != "" && c.SecurityCredential != "" && c.CallbackBaseURL != ""
+}
+
 // GetBusinessShortCode returns the business short code
 func (c *MPesaClient) GetBusinessShortCode() string {
 	return c.BusinessShortCode
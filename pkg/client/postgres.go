@@ -100,6 +100,362 @@ func (c *PostgresClient) CreateTablesIfNotExist(ctx context.Context) error {
 		return fmt.Errorf("failed to create email verifications table: %v", err)
 	}
 
+	// Add platform role and suspension columns to users table
+	_, err = c.DB.ExecContext(ctx, `
+		ALTER TABLE lineserve_cloud_users
+			ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user',
+			ADD COLUMN IF NOT EXISTS suspended BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS suspended_reason TEXT
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add role columns to users table: %v", err)
+	}
+
+	// Add project role column to user_projects table. Existing rows were
+	// created for the registering user's own project, so they become owners.
+	_, err = c.DB.ExecContext(ctx, `
+		ALTER TABLE lineserve_cloud_user_projects
+			ADD COLUMN IF NOT EXISTS project_role TEXT NOT NULL DEFAULT 'owner';
+		ALTER TABLE lineserve_cloud_user_projects
+			ALTER COLUMN project_role SET DEFAULT 'member'
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add project role column to user_projects table: %v", err)
+	}
+
+	// Remove duplicate memberships and enforce one membership per user and project
+	_, err = c.DB.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_user_projects a
+		USING lineserve_cloud_user_projects b
+		WHERE a.user_id = b.user_id AND a.project_id = b.project_id
+			AND (a.created_at, a.id) > (b.created_at, b.id);
+		CREATE UNIQUE INDEX IF NOT EXISTS lineserve_cloud_user_projects_user_project_idx
+			ON lineserve_cloud_user_projects (user_id, project_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add user_projects unique index: %v", err)
+	}
+
+	// Create project invitations table
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_project_invitations (
+			id UUID PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			email TEXT NOT NULL,
+			project_role TEXT NOT NULL,
+			invited_by UUID NOT NULL REFERENCES lineserve_cloud_users(id),
+			status TEXT NOT NULL DEFAULT 'pending',
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			accepted_at TIMESTAMP,
+			accepted_by UUID REFERENCES lineserve_cloud_users(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create project invitations table: %v", err)
+	}
+
+	// Create admin audit log table
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_admin_audit_logs (
+			id UUID PRIMARY KEY,
+			admin_user_id TEXT NOT NULL,
+			admin_username TEXT,
+			action TEXT NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target_type TEXT,
+			target_id TEXT,
+			status_code INTEGER NOT NULL,
+			details JSONB,
+			ip_address TEXT,
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create admin audit logs table: %v", err)
+	}
+
+	// Create sessions table. Each session is one refresh token family.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_sessions (
+			id UUID PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			domain_name TEXT,
+			project_id TEXT,
+			openstack_token TEXT,
+			user_agent TEXT,
+			ip_address TEXT,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			revoked_reason TEXT
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_sessions_user_idx
+			ON lineserve_cloud_sessions (user_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %v", err)
+	}
+
+	// Create refresh tokens table
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_refresh_tokens (
+			id UUID PRIMARY KEY,
+			session_id UUID NOT NULL REFERENCES lineserve_cloud_sessions(id) ON DELETE CASCADE,
+			token_hash TEXT UNIQUE NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh tokens table: %v", err)
+	}
+
+	// Create personal API keys table. Only a hash of each key is stored.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_api_keys (
+			id UUID PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			domain_name TEXT,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL,
+			project_id TEXT,
+			permissions TEXT[],
+			application_credential_id TEXT,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			last_used_ip TEXT,
+			created_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_api_keys_user_idx
+			ON lineserve_cloud_api_keys (user_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create API keys table: %v", err)
+	}
+
+	// Add two-factor authentication columns to users table. totp_last_step is
+	// the last accepted TOTP time step, so codes cannot be replayed.
+	_, err = c.DB.ExecContext(ctx, `
+		ALTER TABLE lineserve_cloud_users
+			ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+			ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE lineserve_cloud_sessions
+			ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add two-factor columns: %v", err)
+	}
+
+	// Create recovery codes table. Codes are stored as SHA-256 hashes.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_recovery_codes (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES lineserve_cloud_users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_recovery_codes_user_idx
+			ON lineserve_cloud_recovery_codes (user_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create recovery codes table: %v", err)
+	}
+
+	// Create two-factor login challenges table. A challenge holds the result
+	// of the password step until the second factor is presented.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_mfa_challenges (
+			id UUID PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			domain_name TEXT,
+			project_id TEXT,
+			openstack_token TEXT,
+			projects JSONB,
+			session_expires_at TIMESTAMP NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create MFA challenges table: %v", err)
+	}
+
+	// Create platform settings table
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_by TEXT,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create settings table: %v", err)
+	}
+
+	// Index email verifications by token hash
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_email_verifications_token_idx
+			ON lineserve_cloud_email_verifications (token)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to index email verifications: %v", err)
+	}
+
+	// Create password resets table
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_password_resets (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES lineserve_cloud_users(id),
+			token_hash TEXT NOT NULL UNIQUE,
+			requested_ip TEXT,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create password resets table: %v", err)
+	}
+
+	// Create rate limit counters table, shared by every replica
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_rate_limits (
+			key TEXT PRIMARY KEY,
+			count INTEGER NOT NULL,
+			reset_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create rate limits table: %v", err)
+	}
+
+	// Create M-Pesa C2B payments table. Payments are unique by M-Pesa
+	// transaction ID so repeated confirmations are recorded once.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_mpesa_c2b_payments (
+			id UUID PRIMARY KEY,
+			trans_id TEXT NOT NULL UNIQUE,
+			trans_type TEXT,
+			trans_time TEXT,
+			amount NUMERIC(12, 2) NOT NULL,
+			business_short_code TEXT,
+			bill_ref_number TEXT,
+			msisdn TEXT,
+			payer_name TEXT,
+			status TEXT NOT NULL,
+			invoice_id TEXT,
+			user_id TEXT,
+			note TEXT,
+			resolved_by TEXT,
+			resolved_at TIMESTAMP,
+			payload JSONB,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_mpesa_c2b_payments_status_idx
+			ON lineserve_cloud_mpesa_c2b_payments (status, created_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create M-Pesa C2B payments table: %v", err)
+	}
+
+	// Create account credit ledger, keyed by the billing (Supabase) user ID.
+	// References are unique so a payment or settlement is booked once.
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_credit_transactions (
+			id UUID PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			currency TEXT NOT NULL,
+			amount NUMERIC(12, 2) NOT NULL,
+			kind TEXT NOT NULL,
+			reference TEXT NOT NULL UNIQUE,
+			description TEXT,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_credit_transactions_user_idx
+			ON lineserve_cloud_credit_transactions (user_id, currency)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create credit transactions table: %v", err)
+	}
+
+	// Create M-Pesa STK push attempts table, the history of pushes sent for
+	// each invoice
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_mpesa_stk_attempts (
+			id UUID PRIMARY KEY,
+			invoice_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			amount NUMERIC(12, 2) NOT NULL,
+			merchant_request_id TEXT,
+			checkout_request_id TEXT NOT NULL UNIQUE,
+			status TEXT NOT NULL,
+			result_code TEXT,
+			result_desc TEXT,
+			receipt_no TEXT,
+			queries INTEGER NOT NULL DEFAULT 0,
+			last_queried_at TIMESTAMP,
+			status_conversation_id TEXT,
+			created_at TIMESTAMP NOT NULL,
+			completed_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_mpesa_stk_attempts_invoice_idx
+			ON lineserve_cloud_mpesa_stk_attempts (invoice_id, created_at);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_mpesa_stk_attempts_pending_idx
+			ON lineserve_cloud_mpesa_stk_attempts (created_at) WHERE status = 'pending'
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create M-Pesa STK attempts table: %v", err)
+	}
+
+	// Create backup policies table; each instance or volume has at most one
+	_, err = c.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS lineserve_cloud_backup_policies (
+			id UUID PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			resource_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL,
+			frequency TEXT NOT NULL,
+			weekday INTEGER NOT NULL DEFAULT 0,
+			window_start TEXT NOT NULL,
+			window_hours INTEGER NOT NULL,
+			keep INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			next_run_at TIMESTAMP NOT NULL,
+			last_run_at TIMESTAMP,
+			last_status TEXT,
+			last_error TEXT,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			UNIQUE (resource_type, resource_id)
+		);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_backup_policies_project_idx
+			ON lineserve_cloud_backup_policies (project_id);
+		CREATE INDEX IF NOT EXISTS lineserve_cloud_backup_policies_due_idx
+			ON lineserve_cloud_backup_policies (next_run_at) WHERE enabled
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create backup policies table: %v", err)
+	}
+
 	return nil
 }
 
@@ -136,15 +492,17 @@ func (c *PostgresClient) InsertUser(ctx context.Context, user *struct {
 	return userID, nil
 }
 
-// AssociateUserWithProject associates a user with a project
-func (c *PostgresClient) AssociateUserWithProject(ctx context.Context, userID, projectID, roleID string) (string, error) {
+// AssociateUserWithProject associates a user with a project. An existing
+// membership is left unchanged.
+func (c *PostgresClient) AssociateUserWithProject(ctx context.Context, userID, projectID, roleID, projectRole string) (string, error) {
 	id := uuid.New().String()
 	createdAt := time.Now()
 
 	_, err := c.DB.ExecContext(ctx, `
-		INSERT INTO lineserve_cloud_user_projects (id, user_id, project_id, role_id, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, id, userID, projectID, roleID, createdAt)
+		INSERT INTO lineserve_cloud_user_projects (id, user_id, project_id, role_id, project_role, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, project_id) DO NOTHING
+	`, id, userID, projectID, roleID, projectRole, createdAt)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to associate user with project: %v", err)
@@ -158,9 +516,10 @@ func (c *PostgresClient) GetUserProjects(ctx context.Context, userID string) ([]
 	ID       string
 	Name     string
 	DomainID string
+	Role     string
 }, error) {
 	rows, err := c.DB.QueryContext(ctx, `
-		SELECT up.project_id, COALESCE(p.name, 'Unknown Project'), COALESCE(p.domain_id, '')
+		SELECT up.project_id, COALESCE(p.name, 'Unknown Project'), COALESCE(p.domain_id, ''), up.project_role
 		FROM lineserve_cloud_user_projects up
 		LEFT JOIN lineserve_cloud_projects p ON up.project_id = p.id
 		WHERE up.user_id = $1
@@ -174,6 +533,7 @@ func (c *PostgresClient) GetUserProjects(ctx context.Context, userID string) ([]
 		ID       string
 		Name     string
 		DomainID string
+		Role     string
 	}
 
 	for rows.Next() {
@@ -181,8 +541,9 @@ func (c *PostgresClient) GetUserProjects(ctx context.Context, userID string) ([]
 			ID       string
 			Name     string
 			DomainID string
+			Role     string
 		}
-		if err := rows.Scan(&project.ID, &project.Name, &project.DomainID); err != nil {
+		if err := rows.Scan(&project.ID, &project.Name, &project.DomainID, &project.Role); err != nil {
 			return nil, fmt.Errorf("failed to scan user project: %v", err)
 		}
 		projects = append(projects, project)
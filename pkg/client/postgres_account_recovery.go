@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTokenInvalid is returned when an email verification or password reset
+// token is unknown, already used or expired
+var ErrTokenInvalid = errors.New("token is invalid or has expired")
+
+// ConsumeEmailVerification marks the account an email verification belongs to
+// as verified and discards every outstanding verification of that account.
+// It returns the account ID.
+func (c *PostgresClient) ConsumeEmailVerification(ctx context.Context, tokenHash string) (string, error) {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM lineserve_cloud_email_verifications
+		WHERE token = $1 AND expires_at > $2
+		RETURNING user_id
+	`, tokenHash, time.Now()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenInvalid
+	} else if err != nil {
+		return "", fmt.Errorf("failed to consume email verification: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_email_verifications WHERE user_id = $1
+	`, userID); err != nil {
+		return "", fmt.Errorf("failed to delete email verifications: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET verified = true WHERE id = $1
+	`, userID); err != nil {
+		return "", fmt.Errorf("failed to verify user: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit email verification: %v", err)
+	}
+
+	return userID, nil
+}
+
+// CreatePasswordReset records a password reset request
+func (c *PostgresClient) CreatePasswordReset(ctx context.Context, userID, tokenHash, requestedIP string, expiresAt time.Time) error {
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_password_resets (id, user_id, token_hash, requested_ip, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, uuid.New().String(), userID, tokenHash, requestedIP, expiresAt, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create password reset: %v", err)
+	}
+
+	return nil
+}
+
+// ConsumePasswordReset marks a password reset as used and returns the account
+// it belongs to. Each reset can be consumed once.
+func (c *PostgresClient) ConsumePasswordReset(ctx context.Context, tokenHash string) (string, error) {
+	now := time.Now()
+
+	var userID string
+	err := c.DB.QueryRowContext(ctx, `
+		UPDATE lineserve_cloud_password_resets SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id
+	`, tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenInvalid
+	} else if err != nil {
+		return "", fmt.Errorf("failed to consume password reset: %v", err)
+	}
+
+	return userID, nil
+}
+
+// ReleasePasswordReset makes a consumed password reset usable again, for
+// when the reset could not be completed
+func (c *PostgresClient) ReleasePasswordReset(ctx context.Context, tokenHash string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_password_resets SET used_at = NULL WHERE token_hash = $1
+	`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to release password reset: %v", err)
+	}
+
+	return nil
+}
+
+// CompletePasswordReset stores an account's new password hash, marks its email
+// as verified, since the reset link proved ownership, and invalidates its
+// other outstanding resets
+func (c *PostgresClient) CompletePasswordReset(ctx context.Context, userID, passwordHash string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET password_hash = $2, verified = true WHERE id = $1
+	`, userID, passwordHash); err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_password_resets SET used_at = $2
+		WHERE user_id = $1 AND used_at IS NULL
+	`, userID, now); err != nil {
+		return fmt.Errorf("failed to invalidate password resets: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password reset: %v", err)
+	}
+
+	return nil
+}
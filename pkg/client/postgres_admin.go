@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrUserNotFound is returned when no account matches a lookup
+var ErrUserNotFound = errors.New("user not found")
+
+// userAccountColumns is the column list scanned by scanUserAccount
+const userAccountColumns = `id, name, email, COALESCE(phone, ''), COALESCE(openstack_user_id, ''), role,
+	verified, suspended, suspended_at, COALESCE(suspended_reason, ''), totp_enabled, created_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUserAccount scans a row selected with userAccountColumns
+func scanUserAccount(row rowScanner) (*models.UserAccount, error) {
+	var account models.UserAccount
+	var suspendedAt sql.NullTime
+
+	err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.Email,
+		&account.Phone,
+		&account.OpenstackUserID,
+		&account.Role,
+		&account.Verified,
+		&account.Suspended,
+		&suspendedAt,
+		&account.SuspendedReason,
+		&account.TwoFactorEnabled,
+		&account.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if suspendedAt.Valid {
+		account.SuspendedAt = &suspendedAt.Time
+	}
+
+	return &account, nil
+}
+
+// GetUserAccount gets a user account by ID
+func (c *PostgresClient) GetUserAccount(ctx context.Context, id string) (*models.UserAccount, error) {
+	row := c.DB.QueryRowContext(ctx, `SELECT `+userAccountColumns+` FROM lineserve_cloud_users WHERE id = $1`, id)
+
+	account, err := scanUserAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	return account, nil
+}
+
+// GetUserAccountByOpenStackID gets a user account by its OpenStack user ID
+func (c *PostgresClient) GetUserAccountByOpenStackID(ctx context.Context, openstackUserID string) (*models.UserAccount, error) {
+	row := c.DB.QueryRowContext(ctx, `SELECT `+userAccountColumns+` FROM lineserve_cloud_users WHERE openstack_user_id = $1`, openstackUserID)
+
+	account, err := scanUserAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	return account, nil
+}
+
+// ListUserAccounts lists user accounts, optionally filtered by a search term
+// matched against name, email and phone
+func (c *PostgresClient) ListUserAccounts(ctx context.Context, search string, limit, offset int) ([]models.UserAccount, int, error) {
+	pattern := "%" + strings.TrimSpace(search) + "%"
+
+	var total int
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM lineserve_cloud_users
+		WHERE name ILIKE $1 OR email ILIKE $1 OR COALESCE(phone, '') ILIKE $1
+	`, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %v", err)
+	}
+
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+userAccountColumns+` FROM lineserve_cloud_users
+		WHERE name ILIKE $1 OR email ILIKE $1 OR COALESCE(phone, '') ILIKE $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %v", err)
+	}
+	defer rows.Close()
+
+	accounts := []models.UserAccount{}
+	for rows.Next() {
+		account, err := scanUserAccount(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %v", err)
+		}
+		accounts = append(accounts, *account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating users: %v", err)
+	}
+
+	return accounts, total, nil
+}
+
+// UpdateUserRole sets the platform role of a user
+func (c *PostgresClient) UpdateUserRole(ctx context.Context, userID, role string) error {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET role = $2 WHERE id = $1
+	`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %v", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SetUserSuspended suspends or reinstates a user
+func (c *PostgresClient) SetUserSuspended(ctx context.Context, userID string, suspended bool, reason string) error {
+	var suspendedAt interface{}
+	if suspended {
+		suspendedAt = time.Now()
+	} else {
+		reason = ""
+	}
+
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users
+		SET suspended = $2, suspended_at = $3, suspended_reason = NULLIF($4, '')
+		WHERE id = $1
+	`, userID, suspended, suspendedAt, reason)
+	if err != nil {
+		return fmt.Errorf("failed to update user suspension: %v", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PromoteAdmins grants the admin role to the accounts with the given emails
+func (c *PostgresClient) PromoteAdmins(ctx context.Context, emails []string) (int64, error) {
+	if len(emails) == 0 {
+		return 0, nil
+	}
+
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET role = $1
+		WHERE LOWER(email) = ANY(string_to_array(LOWER($2), ',')) AND role <> $1
+	`, models.UserRoleAdmin, strings.Join(emails, ","))
+	if err != nil {
+		return 0, fmt.Errorf("failed to promote admins: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// InsertAdminAuditLog records an administrator action
+func (c *PostgresClient) InsertAdminAuditLog(ctx context.Context, entry *models.AdminAuditLog) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	var details []byte
+	if len(entry.Details) > 0 {
+		var err error
+		details, err = json.Marshal(entry.Details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit details: %v", err)
+		}
+	}
+
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_admin_audit_logs
+			(id, admin_user_id, admin_username, action, method, path, target_type, target_id, status_code, details, ip_address, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, $12)
+	`, entry.ID, entry.AdminUserID, entry.AdminUsername, entry.Action, entry.Method, entry.Path,
+		entry.TargetType, entry.TargetID, entry.StatusCode, details, entry.IPAddress, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert admin audit log: %v", err)
+	}
+
+	return nil
+}
+
+// ListAdminAuditLogs lists audit log entries, newest first, optionally
+// filtered by target ID
+func (c *PostgresClient) ListAdminAuditLogs(ctx context.Context, targetID string, limit, offset int) ([]models.AdminAuditLog, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT id, admin_user_id, COALESCE(admin_username, ''), action, method, path,
+			COALESCE(target_type, ''), COALESCE(target_id, ''), status_code, details, COALESCE(ip_address, ''), created_at
+		FROM lineserve_cloud_admin_audit_logs
+		WHERE $1 = '' OR target_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, targetID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin audit logs: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AdminAuditLog{}
+	for rows.Next() {
+		var entry models.AdminAuditLog
+		var details []byte
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.AdminUserID,
+			&entry.AdminUsername,
+			&entry.Action,
+			&entry.Method,
+			&entry.Path,
+			&entry.TargetType,
+			&entry.TargetID,
+			&entry.StatusCode,
+			&details,
+			&entry.IPAddress,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan admin audit log: %v", err)
+		}
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &entry.Details); err != nil {
+				return nil, fmt.Errorf("failed to decode audit details: %v", err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating admin audit logs: %v", err)
+	}
+
+	return entries, nil
+}
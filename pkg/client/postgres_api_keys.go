@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches a lookup
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// apiKeyTouchInterval limits how often last-used tracking writes to the database
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyColumns is the column list scanned by scanAPIKey
+const apiKeyColumns = `k.id, k.user_id, k.username, COALESCE(k.domain_name, ''), k.name, k.prefix,
+	COALESCE(k.project_id, ''), k.permissions, COALESCE(k.application_credential_id, ''),
+	k.expires_at, k.last_used_at, COALESCE(k.last_used_ip, ''), k.created_at, k.revoked_at`
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var permissions pq.StringArray
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Username,
+		&key.DomainName,
+		&key.Name,
+		&key.Prefix,
+		&key.ProjectID,
+		&permissions,
+		&key.ApplicationCredentialID,
+		&expiresAt,
+		&lastUsedAt,
+		&key.LastUsedIP,
+		&key.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Permissions = []string(permissions)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
+
+// CreateAPIKey stores a new API key under the hash of its secret value
+func (c *PostgresClient) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) error {
+	key.ID = uuid.New().String()
+	key.CreatedAt = time.Now()
+
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_api_keys (id, user_id, username, domain_name, name, prefix, key_hash,
+			project_id, permissions, application_credential_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, NULLIF($10, ''), $11, $12)
+	`, key.ID, key.UserID, key.Username, key.DomainName, key.Name, key.Prefix, keyHash,
+		key.ProjectID, pq.Array(key.Permissions), key.ApplicationCredentialID, key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %v", err)
+	}
+
+	return nil
+}
+
+// GetAPIKey gets an API key by ID, including revoked keys
+func (c *PostgresClient) GetAPIKey(ctx context.Context, id string) (*models.APIKey, error) {
+	key, err := scanAPIKey(c.DB.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+` FROM lineserve_cloud_api_keys k WHERE k.id = $1
+	`, id))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %v", err)
+	}
+
+	return key, nil
+}
+
+// GetActiveAPIKeyByHash gets an unrevoked, unexpired API key by the hash of its
+// value, together with the platform role and suspension state of its owner.
+// It returns a nil key when no active key matches.
+func (c *PostgresClient) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, string, bool, error) {
+	var role string
+	var suspended bool
+
+	row := c.DB.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+`, COALESCE(u.role, $3), COALESCE(u.suspended, false)
+		FROM lineserve_cloud_api_keys k
+		LEFT JOIN lineserve_cloud_users u ON u.openstack_user_id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > $2)
+	`, keyHash, time.Now(), models.UserRoleUser)
+
+	key, err := scanAPIKey(scannerFunc(func(dest ...interface{}) error {
+		return row.Scan(append(dest, &role, &suspended)...)
+	}))
+	if err == sql.ErrNoRows {
+		return nil, "", false, nil
+	} else if err != nil {
+		return nil, "", false, fmt.Errorf("failed to get API key: %v", err)
+	}
+
+	return key, role, suspended, nil
+}
+
+// scannerFunc adapts a function to the rowScanner interface
+type scannerFunc func(dest ...interface{}) error
+
+// Scan calls f
+func (f scannerFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
+
+// TouchAPIKey records that an API key was used. Writes are throttled so busy
+// keys do not update their row on every request.
+func (c *PostgresClient) TouchAPIKey(ctx context.Context, id, ipAddress string) error {
+	now := time.Now()
+
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_api_keys
+		SET last_used_at = $2, last_used_ip = $3
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $4)
+	`, id, now, ipAddress, now.Add(-apiKeyTouchInterval))
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %v", err)
+	}
+
+	return nil
+}
+
+// ListAPIKeys lists the unrevoked API keys of an OpenStack user, including expired ones
+func (c *PostgresClient) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM lineserve_cloud_api_keys k
+		WHERE k.user_id = $1 AND k.revoked_at IS NULL
+		ORDER BY k.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %v", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key
+func (c *PostgresClient) RevokeAPIKey(ctx context.Context, id string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL
+	`, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeUserAPIKeys revokes every unrevoked API key of an OpenStack user and
+// returns the keys it revoked, so that their application credentials can be
+// deleted
+func (c *PostgresClient) RevokeUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		UPDATE lineserve_cloud_api_keys k SET revoked_at = $2
+		WHERE k.user_id = $1 AND k.revoked_at IS NULL
+		RETURNING `+apiKeyColumns+`
+	`, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %v", err)
+	}
+
+	return keys, nil
+}
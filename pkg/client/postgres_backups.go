@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrBackupPolicyNotFound is returned when a backup policy does not exist
+var ErrBackupPolicyNotFound = errors.New("backup policy not found")
+
+// ErrBackupPolicyExists is returned when a resource already has a backup policy
+var ErrBackupPolicyExists = errors.New("resource already has a backup policy")
+
+// backupPolicyColumns are the columns scanned by scanBackupPolicy
+const backupPolicyColumns = `
+	id, project_id, resource_type, resource_id, frequency, weekday, window_start,
+	window_hours, keep, enabled, next_run_at, last_run_at, COALESCE(last_status, ''),
+	COALESCE(last_error, ''), created_by, created_at, updated_at`
+
+// scanBackupPolicy scans a row selected with backupPolicyColumns
+func scanBackupPolicy(row interface{ Scan(...interface{}) error }) (*models.BackupPolicy, error) {
+	var policy models.BackupPolicy
+	var lastRunAt sql.NullTime
+	if err := row.Scan(
+		&policy.ID,
+		&policy.ProjectID,
+		&policy.ResourceType,
+		&policy.ResourceID,
+		&policy.Frequency,
+		&policy.Weekday,
+		&policy.WindowStart,
+		&policy.WindowHours,
+		&policy.Keep,
+		&policy.Enabled,
+		&policy.NextRunAt,
+		&lastRunAt,
+		&policy.LastStatus,
+		&policy.LastError,
+		&policy.CreatedBy,
+		&policy.CreatedAt,
+		&policy.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if lastRunAt.Valid {
+		policy.LastRunAt = &lastRunAt.Time
+	}
+
+	return &policy, nil
+}
+
+// CreateBackupPolicy records a new backup policy
+func (c *PostgresClient) CreateBackupPolicy(ctx context.Context, policy *models.BackupPolicy) error {
+	// Run times are stored in UTC so they compare correctly
+	policy.ID = uuid.New().String()
+	policy.NextRunAt = policy.NextRunAt.UTC()
+	policy.CreatedAt = time.Now().UTC()
+	policy.UpdatedAt = policy.CreatedAt
+
+	result, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_backup_policies (
+			id, project_id, resource_type, resource_id, frequency, weekday, window_start,
+			window_hours, keep, enabled, next_run_at, created_by, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (resource_type, resource_id) DO NOTHING
+	`, policy.ID, policy.ProjectID, policy.ResourceType, policy.ResourceID, policy.Frequency,
+		policy.Weekday, policy.WindowStart, policy.WindowHours, policy.Keep, policy.Enabled,
+		policy.NextRunAt, policy.CreatedBy, policy.CreatedAt, policy.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create backup policy: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to create backup policy: %v", err)
+	}
+	if rows == 0 {
+		return ErrBackupPolicyExists
+	}
+
+	return nil
+}
+
+// GetBackupPolicy gets a project's backup policy by ID
+func (c *PostgresClient) GetBackupPolicy(ctx context.Context, projectID, id string) (*models.BackupPolicy, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrBackupPolicyNotFound
+	}
+
+	policy, err := scanBackupPolicy(c.DB.QueryRowContext(ctx, `
+		SELECT `+backupPolicyColumns+` FROM lineserve_cloud_backup_policies
+		WHERE id = $1 AND project_id = $2
+	`, id, projectID))
+	if err == sql.ErrNoRows {
+		return nil, ErrBackupPolicyNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get backup policy: %v", err)
+	}
+
+	return policy, nil
+}
+
+// listBackupPolicies lists backup policies matching a condition
+func (c *PostgresClient) listBackupPolicies(ctx context.Context, query string, args ...interface{}) ([]models.BackupPolicy, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+backupPolicyColumns+` FROM lineserve_cloud_backup_policies
+	`+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup policies: %v", err)
+	}
+	defer rows.Close()
+
+	policies := []models.BackupPolicy{}
+	for rows.Next() {
+		policy, err := scanBackupPolicy(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan backup policy: %v", err)
+		}
+		policies = append(policies, *policy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating backup policies: %v", err)
+	}
+
+	return policies, nil
+}
+
+// ListBackupPolicies lists a project's backup policies
+func (c *PostgresClient) ListBackupPolicies(ctx context.Context, projectID string) ([]models.BackupPolicy, error) {
+	return c.listBackupPolicies(ctx, `
+		WHERE project_id = $1
+		ORDER BY created_at
+	`, projectID)
+}
+
+// ListDueBackupPolicies lists the enabled backup policies whose next run is
+// due, oldest first
+func (c *PostgresClient) ListDueBackupPolicies(ctx context.Context, now time.Time, limit int) ([]models.BackupPolicy, error) {
+	return c.listBackupPolicies(ctx, `
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2
+	`, now.UTC(), limit)
+}
+
+// UpdateBackupPolicy saves a backup policy's schedule, retention and enabled flag
+func (c *PostgresClient) UpdateBackupPolicy(ctx context.Context, policy *models.BackupPolicy) error {
+	policy.NextRunAt = policy.NextRunAt.UTC()
+	policy.UpdatedAt = time.Now().UTC()
+
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_backup_policies SET
+			frequency = $2,
+			weekday = $3,
+			window_start = $4,
+			window_hours = $5,
+			keep = $6,
+			enabled = $7,
+			next_run_at = $8,
+			updated_at = $9
+		WHERE id = $1
+	`, policy.ID, policy.Frequency, policy.Weekday, policy.WindowStart, policy.WindowHours,
+		policy.Keep, policy.Enabled, policy.NextRunAt, policy.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update backup policy: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update backup policy: %v", err)
+	}
+	if rows == 0 {
+		return ErrBackupPolicyNotFound
+	}
+
+	return nil
+}
+
+// ClaimBackupPolicyRun moves a due policy's next run from scheduledAt to
+// nextRunAt. It returns false when another scheduler claimed the run first.
+func (c *PostgresClient) ClaimBackupPolicyRun(ctx context.Context, id string, scheduledAt, nextRunAt time.Time) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_backup_policies SET next_run_at = $3
+		WHERE id = $1 AND next_run_at = $2 AND enabled
+	`, id, scheduledAt.UTC(), nextRunAt.UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to claim backup policy run: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim backup policy run: %v", err)
+	}
+
+	return rows > 0, nil
+}
+
+// RecordBackupPolicyRun records the outcome of a backup policy run. A policy
+// whose resource is gone is disabled.
+func (c *PostgresClient) RecordBackupPolicyRun(ctx context.Context, id, status, runErr string, disable bool) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_backup_policies SET
+			last_run_at = $2,
+			last_status = $3,
+			last_error = NULLIF($4, ''),
+			enabled = enabled AND NOT $5
+		WHERE id = $1
+	`, id, time.Now().UTC(), status, runErr, disable)
+	if err != nil {
+		return fmt.Errorf("failed to record backup policy run: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteBackupPolicy deletes a project's backup policy
+func (c *PostgresClient) DeleteBackupPolicy(ctx context.Context, projectID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrBackupPolicyNotFound
+	}
+
+	result, err := c.DB.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_backup_policies WHERE id = $1 AND project_id = $2
+	`, id, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete backup policy: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete backup policy: %v", err)
+	}
+	if rows == 0 {
+		return ErrBackupPolicyNotFound
+	}
+
+	return nil
+}
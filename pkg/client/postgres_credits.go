@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrInsufficientCredit is returned when an account's credit balance does not
+// cover a debit
+var ErrInsufficientCredit = errors.New("insufficient credit balance")
+
+// CreditAccount adds an entry to an account's credit ledger. Each reference
+// is booked once; booked is false when the reference was already used.
+func (c *PostgresClient) CreditAccount(ctx context.Context, entry *models.CreditTransaction) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_credit_transactions (id, user_id, currency, amount, kind, reference, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (reference) DO NOTHING
+	`, uuid.New().String(), entry.UserID, entry.Currency, entry.Amount, entry.Kind, entry.Reference, entry.Description, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to credit account: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to credit account: %v", err)
+	}
+
+	return rows > 0, nil
+}
+
+// DebitAccount takes an amount from an account's credit balance, failing with
+// ErrInsufficientCredit when the balance does not cover it. Like CreditAccount
+// each reference is booked once, and booked is false when it already was.
+func (c *PostgresClient) DebitAccount(ctx context.Context, entry *models.CreditTransaction) (bool, error) {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Serialise debits of the account so two cannot spend the same credit
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "credit:"+entry.UserID); err != nil {
+		return false, fmt.Errorf("failed to lock credit balance: %v", err)
+	}
+
+	var booked bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM lineserve_cloud_credit_transactions WHERE reference = $1)
+	`, entry.Reference).Scan(&booked); err != nil {
+		return false, fmt.Errorf("failed to check credit reference: %v", err)
+	}
+	if booked {
+		return false, nil
+	}
+
+	var balance float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM lineserve_cloud_credit_transactions
+		WHERE user_id = $1 AND currency = $2
+	`, entry.UserID, entry.Currency).Scan(&balance); err != nil {
+		return false, fmt.Errorf("failed to get credit balance: %v", err)
+	}
+	if balance < entry.Amount {
+		return false, ErrInsufficientCredit
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_credit_transactions (id, user_id, currency, amount, kind, reference, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, uuid.New().String(), entry.UserID, entry.Currency, -entry.Amount, entry.Kind, entry.Reference, entry.Description, time.Now()); err != nil {
+		return false, fmt.Errorf("failed to debit account: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit debit: %v", err)
+	}
+
+	return true, nil
+}
+
+// GetCreditBalance returns an account's credit balance in a currency
+func (c *PostgresClient) GetCreditBalance(ctx context.Context, userID, currency string) (float64, error) {
+	var balance float64
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM lineserve_cloud_credit_transactions
+		WHERE user_id = $1 AND currency = $2
+	`, userID, currency).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get credit balance: %v", err)
+	}
+
+	return balance, nil
+}
+
+// ListCreditTransactions lists an account's credit ledger, newest first
+func (c *PostgresClient) ListCreditTransactions(ctx context.Context, userID string, limit, offset int) ([]models.CreditTransaction, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT id, user_id, currency, amount, kind, reference, COALESCE(description, ''), created_at
+		FROM lineserve_cloud_credit_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list credit transactions: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.CreditTransaction{}
+	for rows.Next() {
+		var entry models.CreditTransaction
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&entry.Currency,
+			&entry.Amount,
+			&entry.Kind,
+			&entry.Reference,
+			&entry.Description,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan credit transaction: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating credit transactions: %v", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrC2BPaymentNotFound is returned when a C2B payment does not exist
+var ErrC2BPaymentNotFound = errors.New("C2B payment not found")
+
+// c2bPaymentColumns are the columns scanned by scanC2BPayment
+const c2bPaymentColumns = `
+	id, trans_id, COALESCE(trans_type, ''), COALESCE(trans_time, ''), amount,
+	COALESCE(business_short_code, ''), COALESCE(bill_ref_number, ''), COALESCE(msisdn, ''),
+	COALESCE(payer_name, ''), status, COALESCE(invoice_id, ''), COALESCE(user_id, ''),
+	COALESCE(note, ''), COALESCE(resolved_by, ''), resolved_at, created_at, updated_at`
+
+// scanC2BPayment scans a row selected with c2bPaymentColumns
+func scanC2BPayment(row interface{ Scan(...interface{}) error }) (*models.MPesaC2BPayment, error) {
+	var payment models.MPesaC2BPayment
+	var resolvedAt sql.NullTime
+	if err := row.Scan(
+		&payment.ID,
+		&payment.TransID,
+		&payment.TransType,
+		&payment.TransTime,
+		&payment.Amount,
+		&payment.BusinessShortCode,
+		&payment.BillRefNumber,
+		&payment.MSISDN,
+		&payment.PayerName,
+		&payment.Status,
+		&payment.InvoiceID,
+		&payment.UserID,
+		&payment.Note,
+		&payment.ResolvedBy,
+		&resolvedAt,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if resolvedAt.Valid {
+		payment.ResolvedAt = &resolvedAt.Time
+	}
+
+	return &payment, nil
+}
+
+// RecordMPesaC2BPayment stores a confirmed C2B payment with its raw payload.
+// Confirmations may be repeated, so a payment already recorded under the same
+// transaction ID is returned instead, with created set to false.
+func (c *PostgresClient) RecordMPesaC2BPayment(ctx context.Context, payment *models.MPesaC2BPayment, payload []byte) (*models.MPesaC2BPayment, bool, error) {
+	now := time.Now()
+
+	row := c.DB.QueryRowContext(ctx, `
+		INSERT INTO lineserve_cloud_mpesa_c2b_payments (
+			id, trans_id, trans_type, trans_time, amount, business_short_code,
+			bill_ref_number, msisdn, payer_name, status, payload, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12)
+		ON CONFLICT (trans_id) DO NOTHING
+		RETURNING `+c2bPaymentColumns,
+		uuid.New().String(), payment.TransID, payment.TransType, payment.TransTime, payment.Amount,
+		payment.BusinessShortCode, payment.BillRefNumber, payment.MSISDN, payment.PayerName,
+		models.MPesaC2BStatusReceived, payload, now,
+	)
+	recorded, err := scanC2BPayment(row)
+	if err == nil {
+		return recorded, true, nil
+	} else if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("failed to record C2B payment: %v", err)
+	}
+
+	// Already recorded
+	existing, err := scanC2BPayment(c.DB.QueryRowContext(ctx, `
+		SELECT `+c2bPaymentColumns+` FROM lineserve_cloud_mpesa_c2b_payments WHERE trans_id = $1
+	`, payment.TransID))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get C2B payment: %v", err)
+	}
+
+	return existing, false, nil
+}
+
+// GetMPesaC2BPayment gets a C2B payment by ID
+func (c *PostgresClient) GetMPesaC2BPayment(ctx context.Context, id string) (*models.MPesaC2BPayment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrC2BPaymentNotFound
+	}
+
+	payment, err := scanC2BPayment(c.DB.QueryRowContext(ctx, `
+		SELECT `+c2bPaymentColumns+` FROM lineserve_cloud_mpesa_c2b_payments WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrC2BPaymentNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get C2B payment: %v", err)
+	}
+
+	return payment, nil
+}
+
+// ListMPesaC2BPayments lists C2B payments, newest first, optionally only
+// those with a status
+func (c *PostgresClient) ListMPesaC2BPayments(ctx context.Context, status string, limit, offset int) ([]models.MPesaC2BPayment, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+c2bPaymentColumns+` FROM lineserve_cloud_mpesa_c2b_payments
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list C2B payments: %v", err)
+	}
+	defer rows.Close()
+
+	payments := []models.MPesaC2BPayment{}
+	for rows.Next() {
+		payment, err := scanC2BPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan C2B payment: %v", err)
+		}
+		payments = append(payments, *payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating C2B payments: %v", err)
+	}
+
+	return payments, nil
+}
+
+// UpdateMPesaC2BPayment records how a C2B payment was reconciled. A non-empty
+// resolvedBy marks an admin decision.
+func (c *PostgresClient) UpdateMPesaC2BPayment(ctx context.Context, id, status, invoiceID, userID, note, resolvedBy string) error {
+	now := time.Now()
+
+	var resolvedAt interface{}
+	if resolvedBy != "" {
+		resolvedAt = now
+	}
+
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_mpesa_c2b_payments SET
+			status = $2,
+			invoice_id = NULLIF($3, ''),
+			user_id = NULLIF($4, ''),
+			note = NULLIF($5, ''),
+			resolved_by = COALESCE(NULLIF($6, ''), resolved_by),
+			resolved_at = COALESCE($7::timestamp, resolved_at),
+			updated_at = $8
+		WHERE id = $1
+	`, id, status, invoiceID, userID, note, resolvedBy, resolvedAt, now)
+	if err != nil {
+		return fmt.Errorf("failed to update C2B payment: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrSTKAttemptNotFound is returned when an STK push attempt does not exist
+var ErrSTKAttemptNotFound = errors.New("STK push attempt not found")
+
+// stkAttemptColumns are the columns scanned by scanSTKAttempt
+const stkAttemptColumns = `
+	id, invoice_id, user_id, phone_number, amount, COALESCE(merchant_request_id, ''),
+	checkout_request_id, status, COALESCE(result_code, ''), COALESCE(result_desc, ''),
+	COALESCE(receipt_no, ''), queries, last_queried_at, COALESCE(status_conversation_id, ''),
+	created_at, completed_at`
+
+// scanSTKAttempt scans a row selected with stkAttemptColumns
+func scanSTKAttempt(row interface{ Scan(...interface{}) error }) (*models.MPesaSTKAttempt, error) {
+	var attempt models.MPesaSTKAttempt
+	var lastQueriedAt, completedAt sql.NullTime
+	if err := row.Scan(
+		&attempt.ID,
+		&attempt.InvoiceID,
+		&attempt.UserID,
+		&attempt.PhoneNumber,
+		&attempt.Amount,
+		&attempt.MerchantRequestID,
+		&attempt.CheckoutRequestID,
+		&attempt.Status,
+		&attempt.ResultCode,
+		&attempt.ResultDesc,
+		&attempt.ReceiptNo,
+		&attempt.Queries,
+		&lastQueriedAt,
+		&attempt.StatusConversationID,
+		&attempt.CreatedAt,
+		&completedAt,
+	); err != nil {
+		return nil, err
+	}
+	if lastQueriedAt.Valid {
+		attempt.LastQueriedAt = &lastQueriedAt.Time
+	}
+	if completedAt.Valid {
+		attempt.CompletedAt = &completedAt.Time
+	}
+
+	return &attempt, nil
+}
+
+// CreateSTKAttempt records an STK push sent for an invoice
+func (c *PostgresClient) CreateSTKAttempt(ctx context.Context, attempt *models.MPesaSTKAttempt) error {
+	attempt.ID = uuid.New().String()
+	attempt.Status = models.MPesaSTKStatusPending
+	attempt.CreatedAt = time.Now()
+
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_mpesa_stk_attempts (
+			id, invoice_id, user_id, phone_number, amount, merchant_request_id,
+			checkout_request_id, status, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, attempt.ID, attempt.InvoiceID, attempt.UserID, attempt.PhoneNumber, attempt.Amount,
+		attempt.MerchantRequestID, attempt.CheckoutRequestID, attempt.Status, attempt.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create STK attempt: %v", err)
+	}
+
+	return nil
+}
+
+// getSTKAttempt gets the first STK push attempt matching a condition
+func (c *PostgresClient) getSTKAttempt(ctx context.Context, where string, args ...interface{}) (*models.MPesaSTKAttempt, error) {
+	attempt, err := scanSTKAttempt(c.DB.QueryRowContext(ctx, `
+		SELECT `+stkAttemptColumns+` FROM lineserve_cloud_mpesa_stk_attempts
+		WHERE `+where+`
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrSTKAttemptNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get STK attempt: %v", err)
+	}
+
+	return attempt, nil
+}
+
+// GetSTKAttemptByCheckoutRequestID gets an STK push attempt by its checkout request ID
+func (c *PostgresClient) GetSTKAttemptByCheckoutRequestID(ctx context.Context, checkoutRequestID string) (*models.MPesaSTKAttempt, error) {
+	return c.getSTKAttempt(ctx, "checkout_request_id = $1", checkoutRequestID)
+}
+
+// GetSTKAttemptByStatusConversationID gets the STK push attempt a transaction
+// status query was sent for
+func (c *PostgresClient) GetSTKAttemptByStatusConversationID(ctx context.Context, conversationID string) (*models.MPesaSTKAttempt, error) {
+	return c.getSTKAttempt(ctx, "status_conversation_id = $1", conversationID)
+}
+
+// GetLatestSTKAttempt gets the most recent STK push attempt for an invoice
+func (c *PostgresClient) GetLatestSTKAttempt(ctx context.Context, invoiceID string) (*models.MPesaSTKAttempt, error) {
+	return c.getSTKAttempt(ctx, "invoice_id = $1", invoiceID)
+}
+
+// listSTKAttempts lists STK push attempts matching a condition
+func (c *PostgresClient) listSTKAttempts(ctx context.Context, query string, args ...interface{}) ([]models.MPesaSTKAttempt, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+stkAttemptColumns+` FROM lineserve_cloud_mpesa_stk_attempts
+	`+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list STK attempts: %v", err)
+	}
+	defer rows.Close()
+
+	attempts := []models.MPesaSTKAttempt{}
+	for rows.Next() {
+		attempt, err := scanSTKAttempt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan STK attempt: %v", err)
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating STK attempts: %v", err)
+	}
+
+	return attempts, nil
+}
+
+// ListSTKAttempts lists the STK push attempts for an invoice, newest first
+func (c *PostgresClient) ListSTKAttempts(ctx context.Context, invoiceID string) ([]models.MPesaSTKAttempt, error) {
+	return c.listSTKAttempts(ctx, `
+		WHERE invoice_id = $1
+		ORDER BY created_at DESC
+	`, invoiceID)
+}
+
+// ListPendingSTKAttempts lists STK push attempts sent before a time whose
+// outcome is not yet known, oldest first
+func (c *PostgresClient) ListPendingSTKAttempts(ctx context.Context, sentBefore time.Time, limit int) ([]models.MPesaSTKAttempt, error) {
+	return c.listSTKAttempts(ctx, `
+		WHERE status = $1 AND created_at < $2
+		ORDER BY created_at
+		LIMIT $3
+	`, models.MPesaSTKStatusPending, sentBefore, limit)
+}
+
+// RecordSTKAttemptQuery counts a status query of an STK push attempt. A
+// non-empty conversationID is the ID of a transaction status query whose
+// result will be posted back.
+func (c *PostgresClient) RecordSTKAttemptQuery(ctx context.Context, id, conversationID string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_mpesa_stk_attempts SET
+			queries = queries + 1,
+			last_queried_at = $2,
+			status_conversation_id = COALESCE(NULLIF($3, ''), status_conversation_id)
+		WHERE id = $1
+	`, id, time.Now(), conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to record STK attempt query: %v", err)
+	}
+
+	return nil
+}
+
+// CompleteSTKAttempt records the outcome of a pending STK push attempt. It
+// returns false when the attempt was already complete.
+func (c *PostgresClient) CompleteSTKAttempt(ctx context.Context, id, status, resultCode, resultDesc, receiptNo string) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_mpesa_stk_attempts SET
+			status = $2,
+			result_code = NULLIF($3, ''),
+			result_desc = NULLIF($4, ''),
+			receipt_no = NULLIF($5, ''),
+			completed_at = $6
+		WHERE id = $1 AND status = $7
+	`, id, status, resultCode, resultDesc, receiptNo, time.Now(), models.MPesaSTKStatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to complete STK attempt: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to complete STK attempt: %v", err)
+	}
+
+	return rows > 0, nil
+}
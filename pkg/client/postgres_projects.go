@@ -0,0 +1,375 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// GetProjectRole gets the project role of an OpenStack user in a project.
+// It returns an empty role when the user is not a member of the project.
+func (c *PostgresClient) GetProjectRole(ctx context.Context, openstackUserID, projectID string) (string, error) {
+	var role string
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT up.project_role
+		FROM lineserve_cloud_user_projects up
+		JOIN lineserve_cloud_users u ON u.id = up.user_id
+		WHERE u.openstack_user_id = $1 AND up.project_id = $2
+	`, openstackUserID, projectID).Scan(&role)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get project role: %v", err)
+	}
+
+	return role, nil
+}
+
+// ErrMemberNotFound is returned when a user is not a member of a project
+var ErrMemberNotFound = errors.New("project member not found")
+
+// ErrInvitationNotFound is returned when no invitation matches a lookup
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// ErrProjectNotFound is returned when a project has no database record
+var ErrProjectNotFound = errors.New("project not found")
+
+// GetProjectRecord gets the stored name, description and state of a project
+func (c *PostgresClient) GetProjectRecord(ctx context.Context, projectID string) (*models.Project, error) {
+	var project models.Project
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT id, name, COALESCE(description, ''), COALESCE(domain_id, ''), enabled
+		FROM lineserve_cloud_projects
+		WHERE id = $1
+	`, projectID).Scan(&project.ID, &project.Name, &project.Description, &project.DomainID, &project.Enabled)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get project: %v", err)
+	}
+
+	return &project, nil
+}
+
+// SetProjectEnabled enables or disables a project record. Projects are
+// disabled while they are being torn down.
+func (c *PostgresClient) SetProjectEnabled(ctx context.Context, projectID string, enabled bool) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_projects SET enabled = $2 WHERE id = $1
+	`, projectID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update project state: %v", err)
+	}
+
+	return nil
+}
+
+// CountOwnedProjects counts the projects a user owns
+func (c *PostgresClient) CountOwnedProjects(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM lineserve_cloud_user_projects
+		WHERE user_id = $1 AND project_role = $2
+	`, userID, models.ProjectRoleOwner).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count owned projects: %v", err)
+	}
+
+	return count, nil
+}
+
+// DeleteProjectRecords removes a project with its memberships and invitations
+func (c *PostgresClient) DeleteProjectRecords(ctx context.Context, projectID string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM lineserve_cloud_project_invitations WHERE project_id = $1`,
+		`DELETE FROM lineserve_cloud_user_projects WHERE project_id = $1`,
+		`DELETE FROM lineserve_cloud_projects WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, projectID); err != nil {
+			return fmt.Errorf("failed to delete project records: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// ListProjectMembers lists the members of a project
+func (c *PostgresClient) ListProjectMembers(ctx context.Context, projectID string) ([]models.ProjectMember, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT u.id, u.name, u.email, up.project_role, COALESCE(u.openstack_user_id, ''), up.created_at
+		FROM lineserve_cloud_user_projects up
+		JOIN lineserve_cloud_users u ON u.id = up.user_id
+		WHERE up.project_id = $1
+		ORDER BY up.created_at
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []models.ProjectMember{}
+	for rows.Next() {
+		var member models.ProjectMember
+		if err := rows.Scan(&member.UserID, &member.Name, &member.Email, &member.Role, &member.OpenstackUserID, &member.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project member: %v", err)
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating project members: %v", err)
+	}
+
+	return members, nil
+}
+
+// GetProjectMember gets a single member of a project by platform user ID
+func (c *PostgresClient) GetProjectMember(ctx context.Context, projectID, userID string) (*models.ProjectMember, error) {
+	var member models.ProjectMember
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT u.id, u.name, u.email, up.project_role, COALESCE(u.openstack_user_id, ''), up.created_at
+		FROM lineserve_cloud_user_projects up
+		JOIN lineserve_cloud_users u ON u.id = up.user_id
+		WHERE up.project_id = $1 AND up.user_id = $2
+	`, projectID, userID).Scan(&member.UserID, &member.Name, &member.Email, &member.Role, &member.OpenstackUserID, &member.JoinedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrMemberNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get project member: %v", err)
+	}
+
+	return &member, nil
+}
+
+// UpdateProjectMemberRole changes a member's project role and the Keystone role backing it
+func (c *PostgresClient) UpdateProjectMemberRole(ctx context.Context, projectID, userID, roleID, projectRole string) error {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_user_projects
+		SET role_id = $3, project_role = $4
+		WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID, roleID, projectRole)
+	if err != nil {
+		return fmt.Errorf("failed to update project member role: %v", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+// RemoveProjectMember removes a user from a project
+func (c *PostgresClient) RemoveProjectMember(ctx context.Context, projectID, userID string) error {
+	result, err := c.DB.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_user_projects WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove project member: %v", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+// TransferProjectOwnership makes newOwnerID the owner of a project and demotes
+// the current owner to admin in a single transaction
+func (c *PostgresClient) TransferProjectOwnership(ctx context.Context, projectID, currentOwnerID, newOwnerID, currentOwnerRoleID, newOwnerRoleID string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_user_projects SET project_role = $3, role_id = $4
+		WHERE project_id = $1 AND user_id = $2
+	`, projectID, currentOwnerID, models.ProjectRoleAdmin, currentOwnerRoleID)
+	if err != nil {
+		return fmt.Errorf("failed to demote current owner: %v", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_user_projects SET project_role = $3, role_id = $4
+		WHERE project_id = $1 AND user_id = $2
+	`, projectID, newOwnerID, models.ProjectRoleOwner, newOwnerRoleID)
+	if err != nil {
+		return fmt.Errorf("failed to promote new owner: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit ownership transfer: %v", err)
+	}
+
+	return nil
+}
+
+// invitationColumns is the column list scanned by scanInvitation
+const invitationColumns = `id, project_id, email, project_role, invited_by, status, expires_at, created_at,
+	accepted_at, COALESCE(accepted_by::text, '')`
+
+// scanInvitation scans a row selected with invitationColumns
+func scanInvitation(row rowScanner) (*models.ProjectInvitation, error) {
+	var invitation models.ProjectInvitation
+	var acceptedAt sql.NullTime
+
+	err := row.Scan(
+		&invitation.ID,
+		&invitation.ProjectID,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.Status,
+		&invitation.ExpiresAt,
+		&invitation.CreatedAt,
+		&acceptedAt,
+		&invitation.AcceptedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if acceptedAt.Valid {
+		invitation.AcceptedAt = &acceptedAt.Time
+	}
+
+	return &invitation, nil
+}
+
+// CreateProjectInvitation inserts a new pending invitation
+func (c *PostgresClient) CreateProjectInvitation(ctx context.Context, invitation *models.ProjectInvitation) error {
+	invitation.ID = uuid.New().String()
+	invitation.Status = "pending"
+	invitation.CreatedAt = time.Now()
+
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_project_invitations (id, project_id, email, project_role, invited_by, status, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, invitation.ID, invitation.ProjectID, invitation.Email, invitation.Role, invitation.InvitedBy,
+		invitation.Status, invitation.ExpiresAt, invitation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create invitation: %v", err)
+	}
+
+	return nil
+}
+
+// GetProjectInvitation gets an invitation by ID
+func (c *PostgresClient) GetProjectInvitation(ctx context.Context, id string) (*models.ProjectInvitation, error) {
+	row := c.DB.QueryRowContext(ctx, `SELECT `+invitationColumns+` FROM lineserve_cloud_project_invitations WHERE id = $1`, id)
+
+	invitation, err := scanInvitation(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %v", err)
+	}
+
+	return invitation, nil
+}
+
+// ListProjectInvitations lists the pending invitations of a project
+func (c *PostgresClient) ListProjectInvitations(ctx context.Context, projectID string) ([]models.ProjectInvitation, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+invitationColumns+` FROM lineserve_cloud_project_invitations
+		WHERE project_id = $1 AND status = 'pending'
+		ORDER BY created_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %v", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.ProjectInvitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %v", err)
+		}
+		invitations = append(invitations, *invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invitations: %v", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeProjectInvitation revokes a pending invitation
+func (c *PostgresClient) RevokeProjectInvitation(ctx context.Context, projectID, id string) error {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_project_invitations SET status = 'revoked'
+		WHERE id = $1 AND project_id = $2 AND status = 'pending'
+	`, id, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke invitation: %v", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// AcceptProjectInvitation marks a pending invitation as accepted and adds the
+// user to the project in a single transaction
+func (c *PostgresClient) AcceptProjectInvitation(ctx context.Context, invitation *models.ProjectInvitation, userID, roleID string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Claim the invitation so it cannot be accepted twice
+	result, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_project_invitations
+		SET status = 'accepted', accepted_at = $2, accepted_by = $3
+		WHERE id = $1 AND status = 'pending'
+	`, invitation.ID, time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to accept invitation: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_user_projects (id, user_id, project_id, role_id, project_role, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, uuid.New().String(), userID, invitation.ProjectID, roleID, invitation.Role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to associate user with project: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit invitation acceptance: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IncrementRateLimit counts a hit against a key, opening a new window when
+// none is open, and returns the count in the window and when it ends
+func (c *PostgresClient) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	// Windows are stored in UTC so reset times read back correctly
+	now := time.Now().UTC()
+
+	var count int
+	var resetAt time.Time
+	err := c.DB.QueryRowContext(ctx, `
+		INSERT INTO lineserve_cloud_rate_limits (key, count, reset_at)
+		VALUES ($1, 1, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN lineserve_cloud_rate_limits.reset_at <= $2 THEN 1 ELSE lineserve_cloud_rate_limits.count + 1 END,
+			reset_at = CASE WHEN lineserve_cloud_rate_limits.reset_at <= $2 THEN $3 ELSE lineserve_cloud_rate_limits.reset_at END
+		RETURNING count, reset_at
+	`, key, now, now.Add(window)).Scan(&count, &resetAt)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to increment rate limit: %v", err)
+	}
+
+	return count, resetAt, nil
+}
+
+// GetRateLimit returns the count in a key's open window without counting a
+// hit; the count is zero when no window is open
+func (c *PostgresClient) GetRateLimit(ctx context.Context, key string) (int, time.Time, error) {
+	var count int
+	var resetAt time.Time
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT count, reset_at FROM lineserve_cloud_rate_limits
+		WHERE key = $1 AND reset_at > $2
+	`, key, time.Now().UTC()).Scan(&count, &resetAt)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to get rate limit: %v", err)
+	}
+
+	return count, resetAt, nil
+}
+
+// ResetRateLimit closes a key's window
+func (c *PostgresClient) ResetRateLimit(ctx context.Context, key string) error {
+	if _, err := c.DB.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_rate_limits WHERE key = $1
+	`, key); err != nil {
+		return fmt.Errorf("failed to reset rate limit: %v", err)
+	}
+
+	return nil
+}
+
+// PruneRateLimits deletes closed rate limit windows
+func (c *PostgresClient) PruneRateLimits(ctx context.Context) (int64, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		DELETE FROM lineserve_cloud_rate_limits WHERE reset_at <= $1
+	`, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune rate limits: %v", err)
+	}
+
+	return result.RowsAffected()
+}
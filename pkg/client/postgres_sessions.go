@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrSessionNotFound is returned when no session matches a lookup
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRefreshTokenNotFound is returned when a refresh token is unknown
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// ErrSessionExpired is returned when a refresh token belongs to a revoked or
+// expired session, or has itself expired
+var ErrSessionExpired = errors.New("session expired")
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again. The whole session is revoked when this happens.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// sessionColumns is the column list scanned by scanSession
+const sessionColumns = `s.id, s.user_id, s.username, COALESCE(s.domain_name, ''), COALESCE(s.project_id, ''),
+	COALESCE(s.openstack_token, ''), COALESCE(s.user_agent, ''), COALESCE(s.ip_address, ''),
+	s.mfa, s.created_at, s.last_used_at, s.expires_at, s.revoked_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Username,
+		&session.DomainName,
+		&session.ProjectID,
+		&session.OpenstackToken,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.MFA,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+// CreateSession creates a session together with its first refresh token
+func (c *PostgresClient) CreateSession(ctx context.Context, session *models.Session, refreshTokenHash string, refreshExpiresAt time.Time) error {
+	session.ID = uuid.New().String()
+	session.CreatedAt = time.Now()
+	session.LastUsedAt = session.CreatedAt
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_sessions (id, user_id, username, domain_name, project_id, openstack_token,
+			user_agent, ip_address, mfa, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10, $10, $11)
+	`, session.ID, session.UserID, session.Username, session.DomainName, session.ProjectID, session.OpenstackToken,
+		session.UserAgent, session.IPAddress, session.MFA, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_refresh_tokens (id, session_id, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New().String(), session.ID, refreshTokenHash, session.CreatedAt, refreshExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// session. Presenting a token that was already used revokes the session and
+// returns ErrRefreshTokenReused. The new token lives for refreshTTL, capped at
+// the session's expiry, which is returned with the session.
+func (c *PostgresClient) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, refreshTTL time.Duration) (*models.Session, time.Time, error) {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the presented token
+	var tokenID, sessionID string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, session_id, expires_at, used_at
+		FROM lineserve_cloud_refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, tokenHash).Scan(&tokenID, &sessionID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, ErrRefreshTokenNotFound
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get refresh token: %v", err)
+	}
+
+	// A rotated token was replayed, so the family is compromised
+	if usedAt.Valid {
+		tx.Rollback()
+		if err := c.RevokeSession(ctx, sessionID, "refresh token reuse"); err != nil {
+			return nil, time.Time{}, err
+		}
+		return nil, time.Time{}, ErrRefreshTokenReused
+	}
+
+	session, err := scanSession(tx.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+` FROM lineserve_cloud_sessions s WHERE s.id = $1
+	`, sessionID))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get session: %v", err)
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) || time.Now().After(expiresAt) {
+		return nil, time.Time{}, ErrSessionExpired
+	}
+
+	// Mark the presented token used and issue its replacement
+	now := time.Now()
+	newExpiresAt := now.Add(refreshTTL)
+	if newExpiresAt.After(session.ExpiresAt) {
+		newExpiresAt = session.ExpiresAt
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_refresh_tokens SET used_at = $2 WHERE id = $1
+	`, tokenID, now); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to mark refresh token used: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_refresh_tokens (id, session_id, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New().String(), sessionID, newTokenHash, now, newExpiresAt); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_sessions SET last_used_at = $2 WHERE id = $1
+	`, sessionID, now); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to update session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	session.LastUsedAt = now
+	return session, newExpiresAt, nil
+}
+
+// GetSession gets a session by ID
+func (c *PostgresClient) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	session, err := scanSession(c.DB.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+` FROM lineserve_cloud_sessions s WHERE s.id = $1
+	`, id))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get session: %v", err)
+	}
+
+	return session, nil
+}
+
+// IsSessionActive reports whether a session exists, is not revoked and has not expired
+func (c *PostgresClient) IsSessionActive(ctx context.Context, id string) (bool, error) {
+	var active bool
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM lineserve_cloud_sessions
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2
+		)
+	`, id, time.Now()).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %v", err)
+	}
+
+	return active, nil
+}
+
+// ListActiveSessions lists the active sessions of an OpenStack user
+func (c *PostgresClient) ListActiveSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	rows, err := c.DB.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM lineserve_cloud_sessions s
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL AND s.expires_at > $2
+		ORDER BY s.last_used_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a session and with it every refresh token in its family
+func (c *PostgresClient) RevokeSession(ctx context.Context, id, reason string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_sessions
+		SET revoked_at = $3, revoked_reason = $2, openstack_token = NULL
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id, reason, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of an OpenStack user except
+// exceptID, which may be empty. It returns the number of sessions revoked.
+func (c *PostgresClient) RevokeUserSessions(ctx context.Context, userID, exceptID, reason string) (int64, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_sessions
+		SET revoked_at = $4, revoked_reason = $3, openstack_token = NULL
+		WHERE user_id = $1 AND revoked_at IS NULL AND id::text <> $2
+	`, userID, exceptID, reason, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	return result.RowsAffected()
+}
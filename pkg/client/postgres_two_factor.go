@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrMFAChallengeNotFound is returned when a login challenge is unknown
+var ErrMFAChallengeNotFound = errors.New("login challenge not found")
+
+// settingRequireAdmin2FA is the settings key for SecuritySettings.RequireAdmin2FA
+const settingRequireAdmin2FA = "require_admin_2fa"
+
+// GetTwoFactorStatus gets the two-factor state of an account, including its
+// TOTP secret, which may be pending verification
+func (c *PostgresClient) GetTwoFactorStatus(ctx context.Context, userID string) (*models.TwoFactorStatus, error) {
+	var status models.TwoFactorStatus
+	var enabledAt sql.NullTime
+
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT u.totp_enabled, u.totp_enabled_at, COALESCE(u.totp_secret, ''), u.totp_last_step,
+			(SELECT COUNT(*) FROM lineserve_cloud_recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		FROM lineserve_cloud_users u
+		WHERE u.id = $1
+	`, userID).Scan(&status.Enabled, &enabledAt, &status.Secret, &status.LastStep, &status.RecoveryCodesRemaining)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get two-factor status: %v", err)
+	}
+
+	if enabledAt.Valid {
+		status.EnabledAt = &enabledAt.Time
+	}
+
+	return &status, nil
+}
+
+// SetPendingTOTPSecret stores a TOTP secret that takes effect once a code from
+// it is verified. Accounts that already have two-factor enabled are unchanged;
+// it reports whether the secret was stored.
+func (c *PostgresClient) SetPendingTOTPSecret(ctx context.Context, userID, secret string) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET totp_secret = $2, totp_last_step = 0
+		WHERE id = $1 AND NOT totp_enabled
+	`, userID, secret)
+	if err != nil {
+		return false, fmt.Errorf("failed to store TOTP secret: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store TOTP secret: %v", err)
+	}
+
+	return updated == 1, nil
+}
+
+// EnableTwoFactor turns on two-factor authentication for an account whose
+// pending secret was verified at step, replacing its recovery codes
+func (c *PostgresClient) EnableTwoFactor(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users
+		SET totp_enabled = TRUE, totp_enabled_at = $2, totp_last_step = $3
+		WHERE id = $1
+	`, userID, time.Now(), step)
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %v", err)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// DisableTwoFactor turns off two-factor authentication and removes the
+// account's TOTP secret and recovery codes
+func (c *PostgresClient) DisableTwoFactor(ctx context.Context, userID string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users
+		SET totp_enabled = FALSE, totp_enabled_at = NULL, totp_secret = NULL, totp_last_step = 0
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM lineserve_cloud_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// RecordTOTPStep records the time step of an accepted TOTP code. It reports
+// false when that step or a later one was already used, so each code works once.
+func (c *PostgresClient) RecordTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_users SET totp_last_step = $2
+		WHERE id = $1 AND totp_last_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP code: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP code: %v", err)
+	}
+
+	return updated == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of an account
+func (c *PostgresClient) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes an account's recovery codes and stores new ones
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM lineserve_cloud_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	now := time.Now()
+	for _, hash := range codeHashes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO lineserve_cloud_recovery_codes (id, user_id, code_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+		`, uuid.New().String(), userID, hash, now)
+		if err != nil {
+			return fmt.Errorf("failed to store recovery code: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false when
+// the account has no such unused code.
+func (c *PostgresClient) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	return updated > 0, nil
+}
+
+// CreateMFAChallenge stores the outcome of a login's password step
+func (c *PostgresClient) CreateMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	challenge.ID = uuid.New().String()
+
+	projects, err := json.Marshal(challenge.Projects)
+	if err != nil {
+		return fmt.Errorf("failed to encode projects: %v", err)
+	}
+
+	_, err = c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_mfa_challenges (id, user_id, username, domain_name, project_id,
+			openstack_token, projects, session_expires_at, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10)
+	`, challenge.ID, challenge.UserID, challenge.Username, challenge.DomainName, challenge.ProjectID,
+		challenge.OpenstackToken, projects, challenge.SessionExpiresAt, time.Now(), challenge.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create login challenge: %v", err)
+	}
+
+	return nil
+}
+
+// GetMFAChallenge gets a login challenge by ID
+func (c *PostgresClient) GetMFAChallenge(ctx context.Context, id string) (*models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	var projects []byte
+	var usedAt sql.NullTime
+
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT id, user_id, username, COALESCE(domain_name, ''), COALESCE(project_id, ''),
+			COALESCE(openstack_token, ''), projects, session_expires_at, attempts, expires_at, used_at
+		FROM lineserve_cloud_mfa_challenges
+		WHERE id = $1
+	`, id).Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.Username,
+		&challenge.DomainName,
+		&challenge.ProjectID,
+		&challenge.OpenstackToken,
+		&projects,
+		&challenge.SessionExpiresAt,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&usedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFAChallengeNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get login challenge: %v", err)
+	}
+
+	if len(projects) > 0 {
+		if err := json.Unmarshal(projects, &challenge.Projects); err != nil {
+			return nil, fmt.Errorf("failed to decode projects: %v", err)
+		}
+	}
+	if usedAt.Valid {
+		challenge.UsedAt = &usedAt.Time
+	}
+
+	return &challenge, nil
+}
+
+// RecordMFAChallengeFailure counts a wrong code against a login challenge and
+// returns the number of failed attempts so far
+func (c *PostgresClient) RecordMFAChallengeFailure(ctx context.Context, id string) (int, error) {
+	var attempts int
+	err := c.DB.QueryRowContext(ctx, `
+		UPDATE lineserve_cloud_mfa_challenges SET attempts = attempts + 1
+		WHERE id = $1
+		RETURNING attempts
+	`, id).Scan(&attempts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update login challenge: %v", err)
+	}
+
+	return attempts, nil
+}
+
+// CompleteMFAChallenge marks a login challenge used and clears the OpenStack
+// token it held. It reports false when the challenge was already used.
+func (c *PostgresClient) CompleteMFAChallenge(ctx context.Context, id string) (bool, error) {
+	result, err := c.DB.ExecContext(ctx, `
+		UPDATE lineserve_cloud_mfa_challenges SET used_at = $2, openstack_token = NULL
+		WHERE id = $1 AND used_at IS NULL
+	`, id, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to complete login challenge: %v", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to complete login challenge: %v", err)
+	}
+
+	return updated == 1, nil
+}
+
+// GetSecuritySettings gets the platform security settings. Unset settings
+// have their zero value.
+func (c *PostgresClient) GetSecuritySettings(ctx context.Context) (*models.SecuritySettings, error) {
+	var settings models.SecuritySettings
+	var value string
+
+	err := c.DB.QueryRowContext(ctx, `
+		SELECT value FROM lineserve_cloud_settings WHERE key = $1
+	`, settingRequireAdmin2FA).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get security settings: %v", err)
+	}
+	settings.RequireAdmin2FA, _ = strconv.ParseBool(value)
+
+	return &settings, nil
+}
+
+// UpdateSecuritySettings saves the platform security settings
+func (c *PostgresClient) UpdateSecuritySettings(ctx context.Context, settings *models.SecuritySettings, updatedBy string) error {
+	_, err := c.DB.ExecContext(ctx, `
+		INSERT INTO lineserve_cloud_settings (key, value, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET value = $2, updated_by = $3, updated_at = $4
+	`, settingRequireAdmin2FA, strconv.FormatBool(settings.RequireAdmin2FA), updatedBy, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update security settings: %v", err)
+	}
+
+	return nil
+}
@@ -4,21 +4,32 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/stripe/stripe-go/v72"
+	portalsession "github.com/stripe/stripe-go/v72/billingportal/session"
 	"github.com/stripe/stripe-go/v72/checkout/session"
 	"github.com/stripe/stripe-go/v72/customer"
 	"github.com/stripe/stripe-go/v72/paymentintent"
 	"github.com/stripe/stripe-go/v72/paymentmethod"
 	"github.com/stripe/stripe-go/v72/refund"
+	"github.com/stripe/stripe-go/v72/setupintent"
 	"github.com/stripe/stripe-go/v72/sub"
 	"github.com/stripe/stripe-go/v72/webhook"
 )
 
+// StripeVPSSubscriptionKey is the metadata key holding the VPS subscription a
+// Stripe subscription bills for
+const StripeVPSSubscriptionKey = "vps_subscription_id"
+
 // StripeClient represents a client for interacting with the Stripe API
 type StripeClient struct {
 	SecretKey string
 	PublicKey string
+	// PriceIDs maps a VPS plan code and commit period, as "plan_code:months",
+	// to the Stripe Price billing it
+	PriceIDs map[string]string
 }
 
 // GetStripeClientFromEnv creates a new Stripe client from environment variables
@@ -30,12 +41,55 @@ func GetStripeClientFromEnv() (*StripeClient, error) {
 		return nil, fmt.Errorf("STRIPE_SECRET_KEY and STRIPE_PUBLIC_KEY must be set")
 	}
 
+	priceIDs, err := parseStripePriceIDs(os.Getenv("STRIPE_PRICE_IDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STRIPE_PRICE_IDS: %v", err)
+	}
+
 	return &StripeClient{
 		SecretKey: secretKey,
 		PublicKey: publicKey,
+		PriceIDs:  priceIDs,
 	}, nil
 }
 
+// parseStripePriceIDs parses a comma-separated list of
+// plan_code:months=price_id entries
+func parseStripePriceIDs(value string) (map[string]string, error) {
+	priceIDs := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, priceID, ok := strings.Cut(entry, "=")
+		planCode, months, hasMonths := strings.Cut(key, ":")
+		if !ok || !hasMonths || planCode == "" || strings.TrimSpace(priceID) == "" {
+			return nil, fmt.Errorf("entry %q is not plan_code:months=price_id", entry)
+		}
+		period, err := strconv.Atoi(months)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("entry %q has an invalid commit period", entry)
+		}
+
+		priceIDs[stripePriceKey(strings.TrimSpace(planCode), period)] = strings.TrimSpace(priceID)
+	}
+
+	return priceIDs, nil
+}
+
+// stripePriceKey is the PriceIDs key of a plan and commit period
+func stripePriceKey(planCode string, commitPeriod int) string {
+	return fmt.Sprintf("%s:%d", planCode, commitPeriod)
+}
+
+// PriceID returns the Stripe Price billing a VPS plan for a commit period in
+// months, or "" when none is configured
+func (c *StripeClient) PriceID(planCode string, commitPeriod int) string {
+	return c.PriceIDs[stripePriceKey(planCode, commitPeriod)]
+}
+
 // NewStripeClient creates a new Stripe client
 func NewStripeClient(secretKey, publicKey string) *StripeClient {
 	return &StripeClient{
@@ -73,6 +127,126 @@ func (c *StripeClient) AttachPaymentMethod(ctx context.Context, paymentMethodID,
 	return err
 }
 
+// GetCustomer retrieves a customer by ID
+func (c *StripeClient) GetCustomer(ctx context.Context, customerID string) (*stripe.Customer, error) {
+	c.Initialize()
+	return customer.Get(customerID, nil)
+}
+
+// ListPaymentMethods lists the cards saved for a customer
+func (c *StripeClient) ListPaymentMethods(ctx context.Context, customerID string) ([]*stripe.PaymentMethod, error) {
+	c.Initialize()
+
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String(string(stripe.PaymentMethodTypeCard)),
+	}
+
+	var paymentMethods []*stripe.PaymentMethod
+	iter := paymentmethod.List(params)
+	for iter.Next() {
+		paymentMethods = append(paymentMethods, iter.PaymentMethod())
+	}
+
+	return paymentMethods, iter.Err()
+}
+
+// GetPaymentMethod retrieves a payment method by ID
+func (c *StripeClient) GetPaymentMethod(ctx context.Context, paymentMethodID string) (*stripe.PaymentMethod, error) {
+	c.Initialize()
+	return paymentmethod.Get(paymentMethodID, nil)
+}
+
+// DetachPaymentMethod removes a payment method from its customer
+func (c *StripeClient) DetachPaymentMethod(ctx context.Context, paymentMethodID string) error {
+	c.Initialize()
+
+	_, err := paymentmethod.Detach(paymentMethodID, nil)
+	return err
+}
+
+// SetDefaultPaymentMethod sets the payment method a customer's invoices and
+// subscriptions are charged to
+func (c *StripeClient) SetDefaultPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	c.Initialize()
+
+	params := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(paymentMethodID),
+		},
+	}
+
+	_, err := customer.Update(customerID, params)
+	return err
+}
+
+// SetSubscriptionPaymentMethod sets the payment method a subscription's
+// renewals are charged to
+func (c *StripeClient) SetSubscriptionPaymentMethod(ctx context.Context, subscriptionID, paymentMethodID string) error {
+	c.Initialize()
+
+	params := &stripe.SubscriptionParams{
+		DefaultPaymentMethod: stripe.String(paymentMethodID),
+	}
+
+	_, err := sub.Update(subscriptionID, params)
+	return err
+}
+
+// CreateSetupIntent creates a setup intent that saves a card to a customer for
+// later off-session charges
+func (c *StripeClient) CreateSetupIntent(ctx context.Context, customerID string) (*stripe.SetupIntent, error) {
+	c.Initialize()
+
+	params := &stripe.SetupIntentParams{
+		Customer: stripe.String(customerID),
+		PaymentMethodTypes: stripe.StringSlice([]string{
+			"card",
+		}),
+		Usage: stripe.String(string(stripe.SetupIntentUsageOffSession)),
+	}
+
+	return setupintent.New(params)
+}
+
+// CreateBillingPortalSession creates a Stripe customer portal session
+func (c *StripeClient) CreateBillingPortalSession(ctx context.Context, customerID, returnURL string) (*stripe.BillingPortalSession, error) {
+	c.Initialize()
+
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(customerID),
+		ReturnURL: stripe.String(returnURL),
+	}
+
+	return portalsession.New(params)
+}
+
+// ChargePaymentMethod charges a customer's saved payment method while the
+// customer is present. Requests with the same idempotency key are charged
+// once. The payment intent may need the customer to authenticate the payment
+// before it succeeds.
+func (c *StripeClient) ChargePaymentMethod(ctx context.Context, amount int64, currency, customerID, paymentMethodID, description, idempotencyKey string, metadata map[string]string) (*stripe.PaymentIntent, error) {
+	c.Initialize()
+
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(currency),
+		Customer:      stripe.String(customerID),
+		PaymentMethod: stripe.String(paymentMethodID),
+		Description:   stripe.String(description),
+		Confirm:       stripe.Bool(true),
+		PaymentMethodTypes: stripe.StringSlice([]string{
+			"card",
+		}),
+	}
+	for key, value := range metadata {
+		params.AddMetadata(key, value)
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	return paymentintent.New(params)
+}
+
 // CreatePaymentIntent creates a payment intent
 func (c *StripeClient) CreatePaymentIntent(ctx context.Context, amount int64, currency, customerID, paymentMethodID, description string) (*stripe.PaymentIntent, error) {
 	c.Initialize()
@@ -138,14 +312,17 @@ func (c *StripeClient) CreateCheckoutSession(ctx context.Context, customerID, su
 	return session.New(params)
 }
 
-// CreateSubscriptionCheckoutSession creates a checkout session for a subscription
-func (c *StripeClient) CreateSubscriptionCheckoutSession(ctx context.Context, customerID, priceID, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
+// CreateSubscriptionCheckoutSession creates a checkout session for a
+// subscription billing a VPS subscription. The VPS subscription ID is the
+// session's client reference and is kept in the Stripe subscription's metadata.
+func (c *StripeClient) CreateSubscriptionCheckoutSession(ctx context.Context, customerID, priceID, vpsSubscriptionID, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
 	c.Initialize()
 
 	params := &stripe.CheckoutSessionParams{
-		Customer:   stripe.String(customerID),
-		SuccessURL: stripe.String(successURL),
-		CancelURL:  stripe.String(cancelURL),
+		Customer:          stripe.String(customerID),
+		ClientReferenceID: stripe.String(vpsSubscriptionID),
+		SuccessURL:        stripe.String(successURL),
+		CancelURL:         stripe.String(cancelURL),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				Price:    stripe.String(priceID),
@@ -156,17 +333,27 @@ func (c *StripeClient) CreateSubscriptionCheckoutSession(ctx context.Context, cu
 		PaymentMethodTypes: stripe.StringSlice([]string{
 			"card",
 		}),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{
+				StripeVPSSubscriptionKey: vpsSubscriptionID,
+			},
+		},
 	}
 
 	return session.New(params)
 }
 
-// CancelSubscription cancels a subscription
+// CancelSubscription cancels a subscription, either at the end of the
+// current period or immediately
 func (c *StripeClient) CancelSubscription(ctx context.Context, subscriptionID string, cancelAtPeriodEnd bool) (*stripe.Subscription, error) {
 	c.Initialize()
 
+	if !cancelAtPeriodEnd {
+		return sub.Cancel(subscriptionID, nil)
+	}
+
 	params := &stripe.SubscriptionParams{
-		CancelAtPeriodEnd: stripe.Bool(cancelAtPeriodEnd),
+		CancelAtPeriodEnd: stripe.Bool(true),
 	}
 
 	return sub.Update(subscriptionID, params)
@@ -532,6 +532,35 @@ func (c *SupabaseClient) GetVPSInvoiceByStripeSessionID(sessionID string) (*mode
 	return invoices[0], nil
 }
 
+// GetVPSInvoicesByStripePaymentID gets the VPS invoices recorded for a Stripe
+// payment; it returns no invoices when none were
+func (c *SupabaseClient) GetVPSInvoicesByStripePaymentID(paymentID string) ([]models.VPSInvoice, error) {
+	req, err := http.NewRequest("GET", c.ProjectURL+"rest/v1/vps_invoices?stripe_payment_id=eq."+paymentID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("apikey", c.APIKey)
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var invoices []models.VPSInvoice
+	if err := json.NewDecoder(resp.Body).Decode(&invoices); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return invoices, nil
+}
+
 // GetVPSSubscriptionByStripeID gets a VPS subscription by Stripe subscription ID
 func (c *SupabaseClient) GetVPSSubscriptionByStripeID(stripeID string) (*models.VPSSubscription, error) {
 	url := fmt.Sprintf("%s/rest/v1/vps_subscriptions?stripe_subscription_id=eq.%s&select=*", c.ProjectURL, stripeID)
@@ -665,3 +694,68 @@ func (c *SupabaseClient) GetLineserveCloudUserByID(userID string) (*models.Lines
 
 	return &users[0], nil
 }
+
+// uuidPrefixRange returns PostgREST filters matching UUIDs that start with an
+// 8-character hex prefix, since UUID columns cannot be matched with like
+func uuidPrefixRange(column, prefix string) string {
+	prefix = strings.ToLower(prefix)
+	return fmt.Sprintf("%s=gte.%s-0000-0000-0000-000000000000&%s=lte.%s-ffff-ffff-ffff-ffffffffffff", column, prefix, column, prefix)
+}
+
+// GetVPSInvoicesByIDPrefix gets the VPS invoices whose IDs start with an
+// 8-character prefix, the invoice number customers quote
+func (c *SupabaseClient) GetVPSInvoicesByIDPrefix(prefix string) ([]models.VPSInvoice, error) {
+	req, err := http.NewRequest("GET", c.ProjectURL+"rest/v1/vps_invoices?"+uuidPrefixRange("id", prefix), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("apikey", c.APIKey)
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var invoices []models.VPSInvoice
+	if err := json.NewDecoder(resp.Body).Decode(&invoices); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return invoices, nil
+}
+
+// GetLineserveCloudUsersByIDPrefix gets the lineserve cloud users whose
+// Supabase user IDs start with an 8-character prefix
+func (c *SupabaseClient) GetLineserveCloudUsersByIDPrefix(prefix string) ([]models.LineserveCloudUser, error) {
+	req, err := http.NewRequest("GET", c.ProjectURL+"rest/v1/lineserve_cloud_users?"+uuidPrefixRange("id", prefix), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("apikey", c.APIKey)
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var users []models.LineserveCloudUser
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return users, nil
+}
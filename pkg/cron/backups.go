@@ -0,0 +1,29 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// BackupRunner takes the backups of due backup policies
+type BackupRunner interface {
+	RunBackups(ctx context.Context) (int, error)
+}
+
+// StartBackupCron periodically takes due scheduled backups and prunes old ones
+func StartBackupCron(runner BackupRunner, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			taken, err := runner.RunBackups(context.Background())
+			if err != nil {
+				log.Printf("Error running scheduled backups: %v", err)
+			} else if taken > 0 {
+				log.Printf("Took %d scheduled backups", taken)
+			}
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// EventPoller publishes events for changes in OpenStack state
+type EventPoller interface {
+	PollEvents(ctx context.Context) error
+}
+
+// StartEventPollCron periodically polls OpenStack for the event stream
+func StartEventPollCron(poller EventPoller, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := poller.PollEvents(context.Background()); err != nil {
+				log.Printf("Error polling events: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// STKPushReconciler settles M-Pesa STK pushes whose callbacks have not arrived
+type STKPushReconciler interface {
+	ReconcileSTKPushes(ctx context.Context) (int, error)
+}
+
+// StartSTKPushReconcileCron periodically reconciles pending STK pushes
+func StartSTKPushReconcileCron(reconciler STKPushReconciler, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			settled, err := reconciler.ReconcileSTKPushes(context.Background())
+			if err != nil {
+				log.Printf("Error reconciling STK pushes: %v", err)
+			} else if settled > 0 {
+				log.Printf("Settled %d STK pushes by status query", settled)
+			}
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lineserve/lineserve-api/pkg/client"
+)
+
+// rateLimitPruneInterval is how often closed rate limit windows are deleted
+const rateLimitPruneInterval = time.Hour
+
+// StartRateLimitPruneCron periodically deletes closed rate limit windows from Postgres
+func StartRateLimitPruneCron(postgresClient *client.PostgresClient) {
+	go func() {
+		ticker := time.NewTicker(rateLimitPruneInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			pruned, err := postgresClient.PruneRateLimits(context.Background())
+			if err != nil {
+				log.Printf("Error pruning rate limits: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d closed rate limit windows", pruned)
+			}
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TypeInstanceStatus  = "instance.status"
+	TypeInstanceDeleted = "instance.deleted"
+	TypeInstanceAction  = "instance.action"
+	TypeVolumeAttached  = "volume.attached"
+	TypeVolumeDetached  = "volume.detached"
+	TypeSnapshotStatus  = "snapshot.status"
+	TypeJobProgress     = "job.progress"
+	TypeInvoicePaid     = "invoice.paid"
+)
+
+// subscriptionBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriptionBuffer = 64
+
+// Event is a state change delivered to the subscribers of its project, or of
+// its user for account events such as paid invoices
+type Event struct {
+	ID           uint64                 `json:"id"`
+	Type         string                 `json:"type"`
+	ProjectID    string                 `json:"project_id,omitempty"`
+	UserID       string                 `json:"-"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	Time         time.Time              `json:"time"`
+}
+
+// Subscription receives the events of a project and user
+type Subscription struct {
+	ProjectID string
+	UserID    string
+	Events    chan Event
+
+	broker *Broker
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker fans events out to subscribers
+type Broker struct {
+	mu          sync.RWMutex
+	nextID      uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a new event broker
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// defaultBroker carries the events of this API instance
+var defaultBroker = NewBroker()
+
+// Default returns the broker handlers publish to
+func Default() *Broker {
+	return defaultBroker
+}
+
+// Publish delivers an event to the default broker's subscribers
+func Publish(event Event) {
+	defaultBroker.Publish(event)
+}
+
+// Subscribe subscribes to a project's and user's events on the default broker
+func Subscribe(projectID, userID string) *Subscription {
+	return defaultBroker.Subscribe(projectID, userID)
+}
+
+// Publish delivers an event to the subscribers of its project or user.
+// Subscribers that have fallen behind miss the event.
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	b.nextID++
+	event.ID = b.nextID
+	b.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscribers {
+		if (event.ProjectID == "" || event.ProjectID != s.ProjectID) && (event.UserID == "" || event.UserID != s.UserID) {
+			continue
+		}
+		select {
+		case s.Events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe subscribes to a project's and user's events
+func (b *Broker) Subscribe(projectID, userID string) *Subscription {
+	s := &Subscription{
+		ProjectID: projectID,
+		UserID:    userID,
+		Events:    make(chan Event, subscriptionBuffer),
+		broker:    b,
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subscribers, s)
+	b.mu.Unlock()
+}
+
+// Projects returns the projects that have subscribers
+func (b *Broker) Projects() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	projects := []string{}
+	for s := range b.subscribers {
+		if s.ProjectID != "" && !seen[s.ProjectID] {
+			seen[s.ProjectID] = true
+			projects = append(projects, s.ProjectID)
+		}
+	}
+	return projects
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// projectState is what the poller last saw of a project
+type projectState struct {
+	// Server status by server ID
+	servers map[string]string
+
+	// Attached server IDs by volume ID
+	volumes map[string]map[string]bool
+
+	// Snapshot status by image ID
+	snapshots map[string]string
+}
+
+// Poller turns changes in OpenStack state into events. Only projects with
+// subscribers are polled; the first poll of a project records its state
+// without publishing anything.
+type Poller struct {
+	broker   *Broker
+	projects map[string]*projectState
+}
+
+// NewPoller creates a poller publishing to a broker
+func NewPoller(broker *Broker) *Poller {
+	return &Poller{
+		broker:   broker,
+		projects: make(map[string]*projectState),
+	}
+}
+
+// PollEvents diffs the servers, volumes and snapshots of subscribed projects against the
+// previous poll and publishes the changes
+func (p *Poller) PollEvents(ctx context.Context) error {
+	projectIDs := p.broker.Projects()
+
+	// Forget projects nobody listens to any more
+	subscribed := make(map[string]bool)
+	for _, projectID := range projectIDs {
+		subscribed[projectID] = true
+	}
+	for projectID := range p.projects {
+		if !subscribed[projectID] {
+			delete(p.projects, projectID)
+		}
+	}
+	if len(projectIDs) == 0 {
+		return nil
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get admin provider: %v", err)
+	}
+	computeClient, err := openstack.NewComputeClient(adminProvider)
+	if err != nil {
+		return fmt.Errorf("failed to create compute client: %v", err)
+	}
+	blockStorageClient, err := openstack.NewBlockStorageClient(adminProvider)
+	if err != nil {
+		return fmt.Errorf("failed to create block storage client: %v", err)
+	}
+
+	for _, projectID := range projectIDs {
+		if err := p.pollProject(ctx, adminProvider, computeClient, blockStorageClient, projectID); err != nil {
+			log.Printf("Error polling events of project %s: %v", projectID, err)
+		}
+	}
+
+	return nil
+}
+
+// pollProject diffs one project's servers, volumes and snapshots
+func (p *Poller) pollProject(ctx context.Context, adminProvider *gophercloud.ProviderClient, computeClient, blockStorageClient *gophercloud.ServiceClient, projectID string) error {
+	allPages, err := servers.List(computeClient, servers.ListOpts{AllTenants: true, TenantID: projectID}).AllPages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list servers: %v", err)
+	}
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return fmt.Errorf("failed to extract servers: %v", err)
+	}
+
+	volumePages, err := volumes.List(blockStorageClient, volumes.ListOpts{AllTenants: true, TenantID: projectID}).AllPages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+	allVolumes, err := volumes.ExtractVolumes(volumePages)
+	if err != nil {
+		return fmt.Errorf("failed to extract volumes: %v", err)
+	}
+
+	snapshots, err := openstack.ListSnapshots(ctx, adminProvider, projectID, "")
+	if err != nil {
+		return err
+	}
+
+	state := &projectState{
+		servers:   make(map[string]string),
+		volumes:   make(map[string]map[string]bool),
+		snapshots: make(map[string]string),
+	}
+	for _, server := range allServers {
+		state.servers[server.ID] = server.Status
+	}
+	for _, volume := range allVolumes {
+		attached := make(map[string]bool)
+		for _, attachment := range volume.Attachments {
+			attached[attachment.ServerID] = true
+		}
+		state.volumes[volume.ID] = attached
+	}
+	for _, snapshot := range snapshots {
+		state.snapshots[snapshot.ID] = snapshot.Status
+	}
+
+	previous, ok := p.projects[projectID]
+	p.projects[projectID] = state
+	if !ok {
+		return nil
+	}
+
+	// Server status changes, including new servers
+	for serverID, status := range state.servers {
+		if oldStatus := previous.servers[serverID]; oldStatus != status {
+			p.broker.Publish(Event{
+				Type:         TypeInstanceStatus,
+				ProjectID:    projectID,
+				ResourceType: "instance",
+				ResourceID:   serverID,
+				Data: map[string]interface{}{
+					"status":          status,
+					"previous_status": oldStatus,
+				},
+			})
+		}
+	}
+	for serverID, oldStatus := range previous.servers {
+		if _, ok := state.servers[serverID]; !ok {
+			p.broker.Publish(Event{
+				Type:         TypeInstanceDeleted,
+				ProjectID:    projectID,
+				ResourceType: "instance",
+				ResourceID:   serverID,
+				Data: map[string]interface{}{
+					"previous_status": oldStatus,
+				},
+			})
+		}
+	}
+
+	// Volume attachments
+	for volumeID, attached := range state.volumes {
+		for serverID := range attached {
+			if !previous.volumes[volumeID][serverID] {
+				p.publishAttachment(TypeVolumeAttached, projectID, volumeID, serverID)
+			}
+		}
+		for serverID := range previous.volumes[volumeID] {
+			if !attached[serverID] {
+				p.publishAttachment(TypeVolumeDetached, projectID, volumeID, serverID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// publishAttachment publishes a volume attach or detach event
+func (p *Poller) publishAttachment(eventType, projectID, volumeID, serverID string) {
+	p.broker.Publish(Event{
+		Type:         eventType,
+		ProjectID:    projectID,
+		ResourceType: "volume",
+		ResourceID:   volumeID,
+		Data: map[string]interface{}{
+			"instance_id": serverID,
+		},
+	})
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/mailer"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// emailVerificationTokenType marks tokens in email verification links
+	emailVerificationTokenType = "email_verification"
+
+	// passwordResetTokenType marks tokens in password reset links
+	passwordResetTokenType = "password_reset"
+
+	defaultEmailVerificationTTL = 24 * time.Hour
+	defaultPasswordResetTTL     = time.Hour
+)
+
+// emailLinkSentMessage is returned whether or not the account exists, so the
+// endpoints cannot be used to discover registered addresses
+const emailLinkSentMessage = "If an account exists for that email address, a link has been sent to it"
+
+// emailVerificationTTL returns the configured verification link lifetime
+func (h *AuthHandler) emailVerificationTTL() time.Duration {
+	if h.EmailVerificationTTL > 0 {
+		return h.EmailVerificationTTL
+	}
+	return defaultEmailVerificationTTL
+}
+
+// passwordResetTTL returns the configured password reset link lifetime
+func (h *AuthHandler) passwordResetTTL() time.Duration {
+	if h.PasswordResetTTL > 0 {
+		return h.PasswordResetTTL
+	}
+	return defaultPasswordResetTTL
+}
+
+// mailer returns the configured mailer, printing messages when there is none
+func (h *AuthHandler) mailer() mailer.Mailer {
+	if h.Mailer != nil {
+		return h.Mailer
+	}
+	return mailer.NewLogMailer("")
+}
+
+// signEmailToken signs a single-purpose token for a link emailed to an account.
+// The jti makes every token, and so its stored hash, unique.
+func (h *AuthHandler) signEmailToken(tokenType, accountID, email string, expiresAt time.Time) (string, error) {
+	return h.Keys.Sign(jwt.MapClaims{
+		"typ":   tokenType,
+		"jti":   uuid.New().String(),
+		"sub":   accountID,
+		"email": email,
+		"exp":   expiresAt.Unix(),
+	})
+}
+
+// parseEmailToken verifies a token from an emailed link and returns the account it was issued to
+func (h *AuthHandler) parseEmailToken(tokenString, tokenType string) (string, error) {
+	claims, err := h.Keys.Parse(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	if claims["typ"] != tokenType {
+		return "", fmt.Errorf("wrong token type")
+	}
+
+	accountID, _ := claims["sub"].(string)
+	if accountID == "" {
+		return "", fmt.Errorf("missing account ID")
+	}
+
+	return accountID, nil
+}
+
+// hashEmailToken hashes an emailed token for storage and lookup
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendVerificationEmail records a new verification token for an account and
+// emails the link carrying it
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, accountID, name, email string) error {
+	expiresAt := time.Now().Add(h.emailVerificationTTL())
+	token, err := h.signEmailToken(emailVerificationTokenType, accountID, email, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to sign verification token: %v", err)
+	}
+
+	if _, err := h.PostgresClient.InsertEmailVerification(ctx, accountID, hashEmailToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	link := h.AppBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	mailer.SendAsync(h.mailer(), mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to start using your account:\n\n%s\n\nThis link expires in %s. If you did not create an account, you can ignore this email.\n",
+			name, link, formatTTL(h.emailVerificationTTL())),
+	})
+
+	return nil
+}
+
+// formatTTL renders a link lifetime for an email
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		if hours := int(ttl / time.Hour); hours != 1 {
+			return fmt.Sprintf("%d hours", hours)
+		}
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d minutes", int(ttl/time.Minute))
+}
+
+// VerifyEmail confirms an account's email address with the token from its verification link
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "A verification token is required",
+		})
+	}
+
+	// Check the signature and expiry before touching the database
+	accountID, err := h.parseEmailToken(req.Token, emailVerificationTokenType)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid or expired verification link",
+		})
+	}
+
+	// Consume the token and mark the account verified
+	userID, err := h.PostgresClient.ConsumeEmailVerification(ctx, hashEmailToken(req.Token))
+	if errors.Is(err, client.ErrTokenInvalid) || (err == nil && userID != accountID) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid or expired verification link",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Email address verified. You can now sign in.",
+	})
+}
+
+// ResendVerificationEmail emails a new verification link to an unverified account
+func (h *AuthHandler) ResendVerificationEmail(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.EmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid email format",
+		})
+	}
+
+	// Only unverified accounts get a link; the response is the same either way
+	if user, err := h.PostgresClient.GetUserByEmail(ctx, req.Email); err == nil && !user.Verified {
+		if err := h.sendVerificationEmail(ctx, user.ID, user.Name, user.Email); err != nil {
+			fmt.Printf("Warning: Failed to send verification email to %s: %v\n", user.Email, err)
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": emailLinkSentMessage,
+	})
+}
+
+// ForgotPassword emails a password reset link to an account
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.EmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid email format",
+		})
+	}
+
+	// Suspended and unknown accounts get no link; the response is the same either way
+	if account, err := h.resetAccount(ctx, req.Email); err == nil {
+		if err := h.sendPasswordResetEmail(ctx, account, middleware.ClientIP(c)); err != nil {
+			fmt.Printf("Warning: Failed to send password reset email to %s: %v\n", account.Email, err)
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": emailLinkSentMessage,
+	})
+}
+
+// resetAccount returns the account with an email address if it may reset its password
+func (h *AuthHandler) resetAccount(ctx context.Context, email string) (*models.UserAccount, error) {
+	user, err := h.PostgresClient.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := h.PostgresClient.GetUserAccount(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Suspended {
+		return nil, errAccountSuspended
+	}
+
+	return account, nil
+}
+
+// sendPasswordResetEmail records a new password reset for an account and
+// emails the link carrying its token
+func (h *AuthHandler) sendPasswordResetEmail(ctx context.Context, account *models.UserAccount, requestedIP string) error {
+	expiresAt := time.Now().Add(h.passwordResetTTL())
+	token, err := h.signEmailToken(passwordResetTokenType, account.ID, account.Email, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to sign reset token: %v", err)
+	}
+
+	if err := h.PostgresClient.CreatePasswordReset(ctx, account.ID, hashEmailToken(token), requestedIP, expiresAt); err != nil {
+		return err
+	}
+
+	link := h.AppBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	mailer.SendAsync(h.mailer(), mailer.Message{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Choose a new one here:\n\n%s\n\nThis link expires in %s and can be used once. If you did not ask to reset your password, you can ignore this email.\n",
+			account.Name, link, formatTTL(h.passwordResetTTL())),
+	})
+
+	return nil
+}
+
+// ResetPassword sets a new password with the token from a password reset link.
+// The Keystone password is changed too, and every session of the account is revoked.
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate required fields
+	if req.Token == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Token and password are required",
+		})
+	}
+
+	// Validate password strength
+	if err := validatePasswordStrength(req.Password); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Check the signature and expiry before touching the database
+	accountID, err := h.parseEmailToken(req.Token, passwordResetTokenType)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid or expired reset link",
+		})
+	}
+
+	// Claim the reset so it cannot be used concurrently
+	tokenHash := hashEmailToken(req.Token)
+	userID, err := h.PostgresClient.ConsumePasswordReset(ctx, tokenHash)
+	if errors.Is(err, client.ErrTokenInvalid) || (err == nil && userID != accountID) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid or expired reset link",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// From here on, hand the reset back if it cannot be completed
+	fail := func(status int, message string) error {
+		if err := h.PostgresClient.ReleasePasswordReset(ctx, tokenHash); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error: message,
+		})
+	}
+
+	// Get the account
+	account, err := h.PostgresClient.GetUserAccount(ctx, userID)
+	if err != nil {
+		return fail(fiber.StatusInternalServerError, err.Error())
+	}
+	if account.Suspended {
+		return fail(fiber.StatusForbidden, errAccountSuspended.Error())
+	}
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fail(fiber.StatusInternalServerError, "Failed to hash password")
+	}
+
+	// Change the Keystone password, which is what sign-in checks
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return fail(fiber.StatusInternalServerError, fmt.Sprintf("Failed to get admin provider: %v", err))
+	}
+	if err := openstack.SetUserPassword(ctx, adminProvider, account.OpenstackUserID, req.Password); err != nil {
+		return fail(fiber.StatusInternalServerError, fmt.Sprintf("Failed to update password: %v", err))
+	}
+
+	// Store the new hash; the Keystone password has already changed, so the
+	// reset is not handed back on failure
+	if err := h.PostgresClient.CompletePasswordReset(ctx, account.ID, string(hashedPassword)); err != nil {
+		fmt.Printf("Warning: Failed to complete password reset for %s: %v\n", account.Email, err)
+	}
+
+	// Sign out everywhere
+	if _, err := h.PostgresClient.RevokeUserSessions(ctx, account.OpenstackUserID, "", "password reset"); err != nil {
+		fmt.Printf("Warning: Failed to revoke sessions for %s: %v\n", account.Email, err)
+	}
+
+	// Revoke API keys too, deleting their application credentials so that
+	// OpenStack tokens issued for them stop working
+	revokedKeys, err := h.PostgresClient.RevokeUserAPIKeys(ctx, account.OpenstackUserID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to revoke API keys for %s: %v\n", account.Email, err)
+	}
+	for _, key := range revokedKeys {
+		if key.ApplicationCredentialID == "" {
+			continue
+		}
+		if err := openstack.DeleteApplicationCredential(ctx, adminProvider, key.UserID, key.ApplicationCredentialID); err != nil {
+			fmt.Printf("Warning: Failed to delete application credential %s of API key %s: %v\n", key.ApplicationCredentialID, key.ID, err)
+		}
+	}
+
+	mailer.SendAsync(h.mailer(), mailer.Message{
+		To:      account.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password for your account was just reset, you have been signed out of every session and your API keys have been revoked. If this was not you, contact support immediately.\n",
+			account.Name),
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Password reset. Sign in with your new password.",
+	})
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// AdminHandler handles platform administration endpoints
+type AdminHandler struct {
+	PostgresClient *client.PostgresClient
+	SupabaseClient *client.SupabaseClient
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(postgresClient *client.PostgresClient, supabaseClient *client.SupabaseClient) *AdminHandler {
+	return &AdminHandler{
+		PostgresClient: postgresClient,
+		SupabaseClient: supabaseClient,
+	}
+}
+
+// parsePagination reads limit and offset query parameters with sane bounds
+func parsePagination(c *fiber.Ctx) (int, int) {
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// ListUsers lists and searches platform accounts
+func (h *AdminHandler) ListUsers(c *fiber.Ctx) error {
+	limit, offset := parsePagination(c)
+	search := c.Query("search")
+
+	// Get accounts from database
+	accounts, total, err := h.PostgresClient.ListUserAccounts(c.Context(), search, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list users: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "user.list", "user", "", map[string]interface{}{"search": search})
+
+	return c.JSON(models.UserAccountListResponse{
+		Users:  accounts,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// GetUser gets a single platform account
+func (h *AdminHandler) GetUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	// Get account from database
+	account, err := h.PostgresClient.GetUserAccount(c.Context(), id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("User not found: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "user.view", "user", id, nil)
+
+	return c.JSON(account)
+}
+
+// ListUserSubscriptions lists the VPS subscriptions of any account
+func (h *AdminHandler) ListUserSubscriptions(c *fiber.Ctx) error {
+	if h.SupabaseClient == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "Billing service unavailable",
+		})
+	}
+
+	id := c.Params("id")
+	middleware.SetAuditAction(c, "user.subscriptions.view", "user", id, nil)
+
+	// Get subscriptions from Supabase
+	subscriptions, err := h.SupabaseClient.GetVPSSubscriptionsByUserID(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get subscriptions: %v", err),
+		})
+	}
+
+	return c.JSON(models.VPSSubscriptionsResponse{
+		Subscriptions: subscriptions,
+	})
+}
+
+// ListUserInvoices lists the VPS invoices of any account
+func (h *AdminHandler) ListUserInvoices(c *fiber.Ctx) error {
+	if h.SupabaseClient == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "Billing service unavailable",
+		})
+	}
+
+	id := c.Params("id")
+	middleware.SetAuditAction(c, "user.invoices.view", "user", id, nil)
+
+	// Get invoices from Supabase
+	invoices, err := h.SupabaseClient.GetVPSInvoicesByUserID(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get invoices: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"invoices": invoices,
+	})
+}
+
+// SuspendUser suspends an account and disables its OpenStack user
+func (h *AdminHandler) SuspendUser(c *fiber.Ctx) error {
+	return h.setSuspended(c, true)
+}
+
+// UnsuspendUser reinstates a suspended account
+func (h *AdminHandler) UnsuspendUser(c *fiber.Ctx) error {
+	return h.setSuspended(c, false)
+}
+
+// setSuspended applies a suspension change in Keystone and the database
+func (h *AdminHandler) setSuspended(c *fiber.Ctx, suspended bool) error {
+	ctx := c.Context()
+	id := c.Params("id")
+
+	action := "user.unsuspend"
+	var req models.AdminSuspendRequest
+	if suspended {
+		action = "user.suspend"
+		// The reason is optional, so an empty body is fine
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+					Error: "Invalid request body",
+				})
+			}
+		}
+	}
+	middleware.SetAuditAction(c, action, "user", id, map[string]interface{}{"reason": req.Reason})
+
+	// Get account from database
+	account, err := h.PostgresClient.GetUserAccount(ctx, id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("User not found: %v", err),
+		})
+	}
+
+	// Admins cannot lock themselves out
+	if currentUserID, _ := c.Locals("user_id").(string); suspended && account.OpenstackUserID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "You cannot suspend your own account",
+		})
+	}
+
+	// Disable the OpenStack user so its existing tokens and direct API access stop working
+	if account.OpenstackUserID != "" {
+		adminProvider, err := openstack.GetAdminProvider(ctx)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+			})
+		}
+
+		if err := openstack.SetUserEnabled(ctx, adminProvider, account.OpenstackUserID, !suspended); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to update OpenStack user: %v", err),
+			})
+		}
+	}
+
+	// Update account in database
+	if err := h.PostgresClient.SetUserSuspended(ctx, id, suspended, req.Reason); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update user: %v", err),
+		})
+	}
+
+	// Sign a suspended account out everywhere
+	if suspended && account.OpenstackUserID != "" {
+		if _, err := h.PostgresClient.RevokeUserSessions(ctx, account.OpenstackUserID, "", "account suspended"); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to revoke sessions: %v", err),
+			})
+		}
+	}
+
+	// Return updated account
+	account, err = h.PostgresClient.GetUserAccount(ctx, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get user: %v", err),
+		})
+	}
+
+	return c.JSON(account)
+}
+
+// UpdateUserRole changes the platform role of an account
+func (h *AdminHandler) UpdateUserRole(c *fiber.Ctx) error {
+	ctx := c.Context()
+	id := c.Params("id")
+
+	// Parse request body
+	var req models.AdminUpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate role
+	if req.Role != models.UserRoleUser && req.Role != models.UserRoleAdmin {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Role must be one of: %s, %s", models.UserRoleUser, models.UserRoleAdmin),
+		})
+	}
+
+	// Get account from database
+	account, err := h.PostgresClient.GetUserAccount(ctx, id)
+	if err != nil {
+		middleware.SetAuditAction(c, "user.role.update", "user", id, map[string]interface{}{"new_role": req.Role})
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("User not found: %v", err),
+		})
+	}
+	middleware.SetAuditAction(c, "user.role.update", "user", id, map[string]interface{}{
+		"old_role": account.Role,
+		"new_role": req.Role,
+	})
+
+	// Admins cannot demote themselves
+	if currentUserID, _ := c.Locals("user_id").(string); account.OpenstackUserID == currentUserID && req.Role != models.UserRoleAdmin {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "You cannot remove your own admin role",
+		})
+	}
+
+	// Update role in database
+	if err := h.PostgresClient.UpdateUserRole(ctx, id, req.Role); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update role: %v", err),
+		})
+	}
+
+	// Sign the user out everywhere so that tokens carrying the old role
+	// stop working at once
+	if account.Role != req.Role && account.OpenstackUserID != "" {
+		if _, err := h.PostgresClient.RevokeUserSessions(ctx, account.OpenstackUserID, "", "role changed"); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to revoke sessions: %v", err),
+			})
+		}
+	}
+
+	account.Role = req.Role
+	return c.JSON(account)
+}
+
+// ListAuditLogs lists the admin audit log
+func (h *AdminHandler) ListAuditLogs(c *fiber.Ctx) error {
+	limit, offset := parsePagination(c)
+
+	// Get entries from database
+	entries, err := h.PostgresClient.ListAdminAuditLogs(c.Context(), c.Query("target_id"), limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list audit logs: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "audit_log.list", "", "", nil)
+
+	return c.JSON(fiber.Map{
+		"audit_logs": entries,
+	})
+}
+
+// GetSecuritySettings returns the platform security settings
+func (h *AdminHandler) GetSecuritySettings(c *fiber.Ctx) error {
+	settings, err := h.PostgresClient.GetSecuritySettings(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	middleware.SetAuditAction(c, "security_settings.get", "", "", nil)
+
+	return c.JSON(settings)
+}
+
+// UpdateSecuritySettings changes the platform security settings
+func (h *AdminHandler) UpdateSecuritySettings(c *fiber.Ctx) error {
+	// Parse request body
+	var req models.SecuritySettings
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	middleware.SetAuditAction(c, "security_settings.update", "", "", map[string]interface{}{
+		"require_admin_2fa": req.RequireAdmin2FA,
+	})
+
+	// Requiring two-factor authentication from a session without it would
+	// lock the caller out of the admin API
+	if mfa, _ := c.Locals("mfa").(bool); req.RequireAdmin2FA && !mfa {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Enable two-factor authentication on your account and sign in with it first",
+		})
+	}
+
+	// Update settings in database
+	userID, _ := c.Locals("user_id").(string)
+	if err := h.PostgresClient.UpdateSecuritySettings(c.Context(), &req, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(req)
+}
+
+// ResetUserTwoFactor turns off two-factor authentication for an account that
+// lost its authenticator and recovery codes, and revokes its sessions
+func (h *AdminHandler) ResetUserTwoFactor(c *fiber.Ctx) error {
+	ctx := c.Context()
+	id := c.Params("id")
+	middleware.SetAuditAction(c, "user.2fa.reset", "user", id, nil)
+
+	// Get account from database
+	account, err := h.PostgresClient.GetUserAccount(ctx, id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("User not found: %v", err),
+		})
+	}
+
+	if err := h.PostgresClient.DisableTwoFactor(ctx, account.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if _, err := h.PostgresClient.RevokeUserSessions(ctx, account.OpenstackUserID, "", "two-factor reset"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	account.TwoFactorEnabled = false
+	return c.JSON(account)
+}
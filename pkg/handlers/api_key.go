@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// maxAPIKeyNameLength is the longest accepted API key name
+const maxAPIKeyNameLength = 100
+
+// APIKeyHandler handles personal API key endpoints
+type APIKeyHandler struct {
+	PostgresClient *client.PostgresClient
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(postgresClient *client.PostgresClient) *APIKeyHandler {
+	return &APIKeyHandler{
+		PostgresClient: postgresClient,
+	}
+}
+
+// generateAPIKey returns a new API key, its public prefix and its secret. The
+// secret doubles as the secret of the key's application credential.
+func generateAPIKey() (string, string, string, error) {
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	return middleware.APIKeyPrefix + prefix + "_" + secret, prefix, secret, nil
+}
+
+// ListAPIKeys lists the caller's API keys
+func (h *APIKeyHandler) ListAPIKeys(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	keys, err := h.PostgresClient.ListAPIKeys(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// CreateAPIKey creates a personal API key. Keys scoped to a project are backed
+// by a Keystone application credential created with the caller's project
+// token, so the caller must be signed in to that project. The key is returned
+// once and never again.
+func (h *APIKeyHandler) CreateAPIKey(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Keys cannot mint further keys
+	if middleware.IsAPIKeyRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "API keys cannot be created with an API key",
+		})
+	}
+
+	// Parse request body
+	var req models.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("name is required and must be at most %d characters", maxAPIKeyNameLength),
+		})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "expires_at must be in the future",
+		})
+	}
+
+	permissions := []string{}
+	seen := map[string]bool{}
+	for _, permission := range req.Permissions {
+		if !models.IsValidPermission(permission) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Unknown permission: %s", permission),
+			})
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+
+	claims, _ := c.Locals("user").(jwt.MapClaims)
+	userID, _ := c.Locals("user_id").(string)
+	username, _ := claims["username"].(string)
+	domainName, _ := claims["domain_name"].(string)
+	tokenProjectID, _ := c.Locals("project_id").(string)
+
+	// Keys default to the project of the caller's token
+	if req.ProjectID == "" {
+		req.ProjectID = tokenProjectID
+	}
+	if req.ProjectID != tokenProjectID {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Get a project token for the project before creating an API key scoped to it",
+		})
+	}
+
+	// Project keys cannot grant more than the caller's project role
+	if req.ProjectID != "" && !middleware.IsAdmin(c) {
+		role, err := h.PostgresClient.GetProjectRole(ctx, userID, req.ProjectID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		if role == "" {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: "You are not a member of this project",
+			})
+		}
+		for _, permission := range permissions {
+			if !models.ProjectRoleAllows(role, permission) {
+				return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+					Error: fmt.Sprintf("Your project role (%s) does not grant %s", role, permission),
+				})
+			}
+		}
+	}
+
+	key, prefix, secret, err := generateAPIKey()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	apiKey := &models.APIKey{
+		UserID:      userID,
+		Username:    username,
+		DomainName:  domainName,
+		Name:        req.Name,
+		Prefix:      prefix,
+		ProjectID:   req.ProjectID,
+		Permissions: permissions,
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	// Create the application credential with the caller's own project token
+	if req.ProjectID != "" {
+		openstackToken, _ := claims["openstack_token"].(string)
+		provider, err := openstack.AuthenticateWithToken(ctx, openstackToken, req.ProjectID)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Authentication error: %v", err),
+			})
+		}
+
+		credentialID, err := openstack.CreateApplicationCredential(ctx, provider, userID,
+			"lineserve-api-key-"+prefix, req.Name, secret, req.ExpiresAt)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		apiKey.ApplicationCredentialID = credentialID
+	}
+
+	// Save key
+	if err := h.PostgresClient.CreateAPIKey(ctx, apiKey, middleware.HashAPIKey(key)); err != nil {
+		h.deleteApplicationCredential(apiKey)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.CreateAPIKeyResponse{
+		APIKey: *apiKey,
+		Key:    key,
+	})
+}
+
+// RevokeAPIKey revokes one of the caller's API keys and deletes its
+// application credential
+func (h *APIKeyHandler) RevokeAPIKey(c *fiber.Ctx) error {
+	ctx := c.Context()
+	userID, _ := c.Locals("user_id").(string)
+
+	keyID := c.Params("id")
+	if _, err := uuid.Parse(keyID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "API key not found",
+		})
+	}
+
+	// Get the key, hiding keys of other users
+	apiKey, err := h.PostgresClient.GetAPIKey(ctx, keyID)
+	if errors.Is(err, client.ErrAPIKeyNotFound) || (err == nil && (apiKey.UserID != userID || apiKey.RevokedAt != nil)) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "API key not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if err := h.PostgresClient.RevokeAPIKey(ctx, apiKey.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Deleting the credential also invalidates OpenStack tokens issued for it
+	h.deleteApplicationCredential(apiKey)
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// deleteApplicationCredential deletes the application credential behind an
+// API key, if any. Failures are logged; the key itself is already unusable.
+func (h *APIKeyHandler) deleteApplicationCredential(apiKey *models.APIKey) {
+	if apiKey.ApplicationCredentialID == "" {
+		return
+	}
+
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err == nil {
+		err = openstack.DeleteApplicationCredential(ctx, adminProvider, apiKey.UserID, apiKey.ApplicationCredentialID)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to delete application credential %s of API key %s: %v\n",
+			apiKey.ApplicationCredentialID, apiKey.ID, err)
+	}
+}
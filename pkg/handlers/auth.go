@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/mail"
 	"regexp"
@@ -11,29 +13,57 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/mailer"
 	"github.com/lineserve/lineserve-api/pkg/models"
 	"github.com/lineserve/lineserve-api/pkg/openstack"
+	"github.com/lineserve/lineserve-api/pkg/signing"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthHandler handles authentication
 type AuthHandler struct {
-	JWTSecret      string
+	Keys           *signing.KeySet
 	PostgresClient *client.PostgresClient
 	MemberRoleID   string
+	ReaderRoleID   string
 	DomainName     string
+	Quotas         openstack.ProjectQuotas
+	TOTPIssuer     string
+
+	// Outgoing email and the frontend base URL for links in it
+	Mailer     mailer.Mailer
+	AppBaseURL string
+
+	// Email verification and password reset; zero TTLs fall back to the defaults
+	RequireEmailVerification bool
+	EmailVerificationTTL     time.Duration
+	PasswordResetTTL         time.Duration
+
+	// Token lifetimes; zero values fall back to the defaults
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	SessionLifetime time.Duration
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(jwtSecret string, postgresClient *client.PostgresClient, memberRoleID, domainName string) *AuthHandler {
+func NewAuthHandler(keys *signing.KeySet, postgresClient *client.PostgresClient, memberRoleID, readerRoleID, domainName string) *AuthHandler {
 	return &AuthHandler{
-		JWTSecret:      jwtSecret,
+		Keys:           keys,
 		PostgresClient: postgresClient,
 		MemberRoleID:   memberRoleID,
+		ReaderRoleID:   readerRoleID,
 		DomainName:     domainName,
 	}
 }
 
+// RoleMapping returns the Keystone roles backing each project role
+func (h *AuthHandler) RoleMapping() openstack.ProjectRoleMapping {
+	return openstack.ProjectRoleMapping{
+		MemberRoleID: h.MemberRoleID,
+		ReaderRoleID: h.ReaderRoleID,
+	}
+}
+
 // Login handles user login with unscoped authentication
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	// Parse request body
@@ -68,6 +98,18 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Resolve the platform account, rejecting suspended and unverified accounts
+	account, err := h.loginAccount(ctx, userID)
+	if loginRejected(err) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
 	// List projects for the user
 	openstackProjects, err := openstack.ListUserProjects(ctx, provider, userID)
 	if err != nil {
@@ -86,19 +128,20 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		}
 	}
 
-	// Create JWT token
-	jwtToken := jwt.New(jwt.SigningMethodHS256)
+	session := &models.Session{
+		UserID:     userID,
+		Username:   req.Username,
+		DomainName: domainName,
+		ExpiresAt:  time.Now().Add(h.sessionLifetime()),
+	}
 
-	// Set claims
-	expiresAt := time.Now().Add(time.Hour * 24)
-	claims := jwtToken.Claims.(jwt.MapClaims)
-	claims["user_id"] = userID
-	claims["username"] = req.Username
-	claims["domain_name"] = domainName
-	claims["exp"] = expiresAt.Unix()
+	// Accounts with two-factor authentication must present a code first
+	if account.TwoFactorEnabled {
+		return h.challengeSecondFactor(c, session, projects)
+	}
 
-	// Generate encoded token
-	encodedToken, err := jwtToken.SignedString([]byte(h.JWTSecret))
+	// Start a session and issue its tokens
+	tokens, err := h.startSession(c, session, account.Role)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error: "Failed to generate token",
@@ -107,10 +150,12 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 	// Return token and projects
 	return c.JSON(models.LoginResponse{
-		Token:     encodedToken,
-		UserID:    userID,
-		Projects:  projects,
-		ExpiresAt: expiresAt,
+		Token:            tokens.AccessToken,
+		RefreshToken:     tokens.RefreshToken,
+		UserID:           userID,
+		Projects:         projects,
+		ExpiresAt:        tokens.ExpiresAt,
+		RefreshExpiresAt: tokens.RefreshExpiresAt,
 	})
 }
 
@@ -172,24 +217,38 @@ func (h *AuthHandler) GetProjectToken(c *fiber.Ctx) error {
 		})
 	}
 
+	// Resolve the platform account, rejecting suspended and unverified accounts
+	account, err := h.loginAccount(ctx, user.ID)
+	if loginRejected(err) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
 	// Get the OpenStack token
 	openstackToken := provider.Token()
 
-	// Create JWT token
-	jwtToken := jwt.New(jwt.SigningMethodHS256)
-
-	// Set claims
-	expiresAt := tokenObj.ExpiresAt
-	claims := jwtToken.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["username"] = req.Username
-	claims["project_id"] = req.ProjectID
-	claims["domain_name"] = domainName
-	claims["exp"] = expiresAt.Unix()
-	claims["openstack_token"] = openstackToken
-
-	// Generate encoded token
-	encodedToken, err := jwtToken.SignedString([]byte(h.JWTSecret))
+	// The session cannot outlive the OpenStack token embedded in its access tokens
+	session := &models.Session{
+		UserID:         user.ID,
+		Username:       req.Username,
+		DomainName:     domainName,
+		ProjectID:      req.ProjectID,
+		OpenstackToken: openstackToken,
+		ExpiresAt:      minTime(time.Now().Add(h.sessionLifetime()), tokenObj.ExpiresAt),
+	}
+
+	// Accounts with two-factor authentication must present a code first
+	if account.TwoFactorEnabled {
+		return h.challengeSecondFactor(c, session, nil)
+	}
+
+	// Start a session and issue its tokens
+	tokens, err := h.startSession(c, session, account.Role)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error: "Failed to generate token",
@@ -198,12 +257,57 @@ func (h *AuthHandler) GetProjectToken(c *fiber.Ctx) error {
 
 	// Return token
 	return c.JSON(models.ProjectScopeResponse{
-		Token:     encodedToken,
-		ProjectID: req.ProjectID,
-		ExpiresAt: expiresAt,
+		Token:            tokens.AccessToken,
+		RefreshToken:     tokens.RefreshToken,
+		ProjectID:        req.ProjectID,
+		ExpiresAt:        tokens.ExpiresAt,
+		RefreshExpiresAt: tokens.RefreshExpiresAt,
 	})
 }
 
+// errAccountSuspended is returned when a suspended account tries to sign in
+var errAccountSuspended = errors.New("account is suspended")
+
+// errEmailNotVerified is returned when an account signs in before verifying its email
+var errEmailNotVerified = errors.New("email address is not verified; follow the link we emailed you or request a new one")
+
+// loginRejected reports whether a loginAccount error refuses the sign-in,
+// as opposed to a failure looking up the account
+func loginRejected(err error) bool {
+	return errors.Is(err, errAccountSuspended) || errors.Is(err, errEmailNotVerified)
+}
+
+// loginAccount returns the platform account of an OpenStack user, rejecting
+// suspended and, when required, unverified accounts. Users without a platform account, such as service users,
+// get an account with only the default user role set.
+func (h *AuthHandler) loginAccount(ctx context.Context, openstackUserID string) (*models.UserAccount, error) {
+	account, err := h.PostgresClient.GetUserAccountByOpenStackID(ctx, openstackUserID)
+	if errors.Is(err, client.ErrUserNotFound) {
+		return &models.UserAccount{OpenstackUserID: openstackUserID, Role: models.UserRoleUser}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load account: %v", err)
+	}
+
+	if account.Suspended {
+		return nil, errAccountSuspended
+	}
+	if h.RequireEmailVerification && !account.Verified {
+		return nil, errEmailNotVerified
+	}
+
+	return account, nil
+}
+
+// accountRole returns the platform role of an OpenStack user
+func (h *AuthHandler) accountRole(ctx context.Context, openstackUserID string) (string, error) {
+	account, err := h.loginAccount(ctx, openstackUserID)
+	if err != nil {
+		return "", err
+	}
+
+	return account.Role, nil
+}
+
 // Register handles user registration
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	ctx := c.Context()
@@ -291,8 +395,14 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Assign member role to user for the project
-	err = openstack.AssignRoleToUserOnProject(ctx, adminProvider, openstackUser.ID, project.ID, h.MemberRoleID)
+	// Assign the Keystone role backing the owner role to user for the project
+	ownerRoleID, err := h.RoleMapping().KeystoneRoleID(models.ProjectRoleOwner)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to resolve owner role: %v", err),
+		})
+	}
+	err = openstack.AssignRoleToUserOnProject(ctx, adminProvider, openstackUser.ID, project.ID, ownerRoleID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error: fmt.Sprintf("Failed to assign role: %v", err),
@@ -321,18 +431,22 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Associate user with project
-	_, err = h.PostgresClient.AssociateUserWithProject(ctx, userID, project.ID, h.MemberRoleID)
+	// Associate user with project as its owner
+	_, err = h.PostgresClient.AssociateUserWithProject(ctx, userID, project.ID, ownerRoleID, models.ProjectRoleOwner)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error: fmt.Sprintf("Failed to associate user with project: %v", err),
 		})
 	}
 
-	// Set user as verified directly (no email verification)
-	_, err = h.PostgresClient.VerifyUser(ctx, userID)
-	if err != nil {
-		fmt.Printf("Warning: Failed to mark user as verified: %v\n", err)
+	// Apply default quotas to the new project
+	if err := openstack.ApplyProjectQuotas(ctx, adminProvider, project.ID, h.Quotas); err != nil {
+		fmt.Printf("Warning: Failed to apply quotas to project %s: %v\n", project.ID, err)
+	}
+
+	// Email a verification link; the user can request another if this fails
+	if err := h.sendVerificationEmail(ctx, userID, req.Name, req.Email); err != nil {
+		fmt.Printf("Warning: Failed to send verification email to %s: %v\n", req.Email, err)
 	}
 
 	// Return success
@@ -340,7 +454,7 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		ID:        userID,
 		Email:     req.Email,
 		ProjectID: project.ID,
-		Message:   "User registered successfully. Your account is ready to use.",
+		Message:   "User registered successfully. Check your email for a link to verify your account.",
 	})
 }
 
@@ -386,13 +500,6 @@ func validatePasswordStrength(password string) error {
 	return nil
 }
 
-// sendVerificationEmail sends a verification email
-func (h *AuthHandler) sendVerificationEmail(email, token string) {
-	// This is a placeholder for sending verification emails
-	// In a real implementation, you would use an email service
-	fmt.Printf("Sending verification email to %s with token %s\n", email, token)
-}
-
 // ListProjects lists all projects for the authenticated user
 func (h *AuthHandler) ListProjects(c *fiber.Ctx) error {
 	// Get user claims from JWT
@@ -416,23 +523,32 @@ func (h *AuthHandler) ListProjects(c *fiber.Ctx) error {
 	// Create context
 	ctx := c.Context()
 
+	// Memberships are keyed by the platform account, not the OpenStack user
+	accountID := ""
+	if account, err := h.PostgresClient.GetUserAccountByOpenStackID(ctx, userID); err == nil {
+		accountID = account.ID
+	}
+
 	// Try to get user's projects from database first
-	dbProjects, err := h.PostgresClient.GetUserProjects(ctx, userID)
-	if err == nil && len(dbProjects) > 0 {
-		// Convert to model projects
-		projects := make([]models.Project, len(dbProjects))
-		for i, p := range dbProjects {
-			projects[i] = models.Project{
-				ID:       p.ID,
-				Name:     p.Name,
-				DomainID: p.DomainID,
+	if accountID != "" {
+		dbProjects, err := h.PostgresClient.GetUserProjects(ctx, accountID)
+		if err == nil && len(dbProjects) > 0 {
+			// Convert to model projects
+			projects := make([]models.Project, len(dbProjects))
+			for i, p := range dbProjects {
+				projects[i] = models.Project{
+					ID:       p.ID,
+					Name:     p.Name,
+					DomainID: p.DomainID,
+					Role:     p.Role,
+				}
 			}
-		}
 
-		// Return projects from database
-		return c.JSON(models.ProjectListResponse{
-			Projects: projects,
-		})
+			// Return projects from database
+			return c.JSON(models.ProjectListResponse{
+				Projects: projects,
+			})
+		}
 	}
 
 	// If no projects found in database or error occurred, try OpenStack
@@ -472,8 +588,12 @@ func (h *AuthHandler) ListProjects(c *fiber.Ctx) error {
 			fmt.Printf("Failed to save project %s: %v\n", p.ID, err)
 		}
 
-		// Associate user with project if not already associated
-		_, err = h.PostgresClient.AssociateUserWithProject(ctx, userID, p.ID, h.MemberRoleID)
+		// Associate user with project if not already associated. Keystone
+		// already grants access here, so record it as a plain membership.
+		if accountID == "" {
+			continue
+		}
+		_, err = h.PostgresClient.AssociateUserWithProject(ctx, accountID, p.ID, h.MemberRoleID, models.ProjectRoleMember)
 		if err != nil {
 			// Log error but continue
 			fmt.Printf("Failed to associate user %s with project %s: %v\n", userID, p.ID, err)
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/backup"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// BackupHandler handles backup policy endpoints
+type BackupHandler struct {
+	PostgresClient *client.PostgresClient
+	compute        *ComputeHandler
+}
+
+// NewBackupHandler creates a new backup handler
+func NewBackupHandler(postgresClient *client.PostgresClient) *BackupHandler {
+	return &BackupHandler{
+		PostgresClient: postgresClient,
+		compute:        NewComputeHandler(),
+	}
+}
+
+// projectBackupPolicy returns the backup policy named in the URL if it
+// belongs to the caller's project, or writes an error response and returns nil
+func (h *BackupHandler) projectBackupPolicy(c *fiber.Ctx) (*models.BackupPolicy, error) {
+	projectID, _ := c.Locals("project_id").(string)
+	policy, err := h.PostgresClient.GetBackupPolicy(c.Context(), projectID, c.Params("id"))
+	if errors.Is(err, client.ErrBackupPolicyNotFound) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Backup policy not found",
+		})
+	} else if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return policy, nil
+}
+
+// policyBackup returns a backup taken by a policy, or writes an error
+// response and returns nil
+func (h *BackupHandler) policyBackup(ctx context.Context, c *fiber.Ctx, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) (*models.Backup, error) {
+	allBackups, err := openstack.ListPolicyBackups(ctx, provider, policy)
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	for i := range allBackups {
+		if allBackups[i].ID == c.Params("backup_id") {
+			return &allBackups[i], nil
+		}
+	}
+
+	return nil, c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+		Error: "Backup not found",
+	})
+}
+
+// ListBackupPolicies lists the project's backup policies
+func (h *BackupHandler) ListBackupPolicies(c *fiber.Ctx) error {
+	projectID, _ := c.Locals("project_id").(string)
+	policies, err := h.PostgresClient.ListBackupPolicies(c.Context(), projectID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(policies)
+}
+
+// CreateBackupPolicy schedules automatic backups of an instance or volume
+func (h *BackupHandler) CreateBackupPolicy(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Parse request body
+	var req models.CreateBackupPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	userID, _ := c.Locals("user_id").(string)
+	policy := &models.BackupPolicy{
+		ProjectID:    projectID,
+		ResourceType: req.ResourceType,
+		ResourceID:   req.ResourceID,
+		Frequency:    req.Frequency,
+		Weekday:      req.Weekday,
+		WindowStart:  req.WindowStart,
+		WindowHours:  req.WindowHours,
+		Keep:         req.Keep,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		CreatedBy:    userID,
+	}
+	if err := backup.ValidatePolicy(policy); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Check the resource is in the caller's project
+	ctx := context.Background()
+	if policy.ResourceType == models.BackupResourceInstance {
+		computeClient, err := openstack.NewComputeClient(provider)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to create compute client: %v", err),
+			})
+		}
+		if _, err := servers.Get(ctx, computeClient, policy.ResourceID).Extract(); err != nil {
+			if gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+					Error: "Instance not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to get instance: %v", err),
+			})
+		}
+	} else {
+		blockStorageClient, err := openstack.NewBlockStorageClient(provider)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to create block storage client: %v", err),
+			})
+		}
+		if _, err := volumes.Get(ctx, blockStorageClient, policy.ResourceID).Extract(); err != nil {
+			if gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+					Error: "Volume not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to get volume: %v", err),
+			})
+		}
+	}
+
+	// Create policy
+	policy.NextRunAt = backup.NextRun(policy, time.Now())
+	if err := h.PostgresClient.CreateBackupPolicy(c.Context(), policy); err != nil {
+		if errors.Is(err, client.ErrBackupPolicyExists) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("This %s already has a backup policy", policy.ResourceType),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(policy)
+}
+
+// GetBackupPolicy gets a backup policy with the outcome of its last run
+func (h *BackupHandler) GetBackupPolicy(c *fiber.Ctx) error {
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	return c.JSON(policy)
+}
+
+// UpdateBackupPolicy changes a backup policy's schedule, retention or
+// enabled flag. Its next run is rescheduled from now.
+func (h *BackupHandler) UpdateBackupPolicy(c *fiber.Ctx) error {
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	// Parse request body
+	var req models.UpdateBackupPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	if req.Frequency != nil {
+		policy.Frequency = *req.Frequency
+	}
+	if req.Weekday != nil {
+		policy.Weekday = *req.Weekday
+	}
+	if req.WindowStart != nil {
+		policy.WindowStart = *req.WindowStart
+	}
+	if req.WindowHours != nil {
+		policy.WindowHours = *req.WindowHours
+	}
+	if req.Keep != nil {
+		policy.Keep = *req.Keep
+	}
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+	if err := backup.ValidatePolicy(policy); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Update policy
+	policy.NextRunAt = backup.NextRun(policy, time.Now())
+	if err := h.PostgresClient.UpdateBackupPolicy(c.Context(), policy); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(policy)
+}
+
+// DeleteBackupPolicy stops scheduled backups of a resource. Backups already
+// taken are kept.
+func (h *BackupHandler) DeleteBackupPolicy(c *fiber.Ctx) error {
+	projectID, _ := c.Locals("project_id").(string)
+	err := h.PostgresClient.DeleteBackupPolicy(c.Context(), projectID, c.Params("id"))
+	if errors.Is(err, client.ErrBackupPolicyNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Backup policy not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// ListPolicyBackups lists the backups a policy has taken, newest first
+func (h *BackupHandler) ListPolicyBackups(c *fiber.Ctx) error {
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	// Volume backups are listed across projects, so use the admin provider
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	allBackups, err := openstack.ListPolicyBackups(ctx, adminProvider, policy)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(allBackups)
+}
+
+// RunBackupPolicy takes a policy's backup now and prunes backups beyond its
+// keep count. The schedule is unchanged.
+func (h *BackupHandler) RunBackupPolicy(c *fiber.Ctx) error {
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	// Take the backup as the scheduler would
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	backupID, err := backup.RunPolicy(ctx, adminProvider, policy)
+	if backupID == "" {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if err != nil {
+		// The backup was taken; only pruning failed
+		fmt.Printf("Warning: failed to prune backups of policy %s: %v\n", policy.ID, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":   "Backup started",
+		"backup_id": backupID,
+	})
+}
+
+// DeletePolicyBackup deletes one of a policy's backups
+func (h *BackupHandler) DeletePolicyBackup(c *fiber.Ctx) error {
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	found, err := h.policyBackup(ctx, c, adminProvider, policy)
+	if found == nil {
+		return err
+	}
+
+	if err := openstack.DeletePolicyBackup(ctx, adminProvider, policy, found.ID); err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RestorePolicyBackup restores one of a policy's backups. An instance is
+// rebuilt from the backup, wiping its disk; a volume backup is restored into
+// a new volume.
+func (h *BackupHandler) RestorePolicyBackup(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	policy, err := h.projectBackupPolicy(c)
+	if policy == nil {
+		return err
+	}
+
+	// Parse request body; all options are optional
+	var req models.BackupRestoreRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	found, err := h.policyBackup(ctx, c, adminProvider, policy)
+	if found == nil {
+		return err
+	}
+	if found.Status != "active" && found.Status != "available" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Backup is %s; wait until it is ready", found.Status),
+		})
+	}
+
+	// Backups are held by the admin project, so they are restored with the
+	// admin credentials they were taken with
+	err = openstack.CheckPolicyResource(ctx, adminProvider, policy)
+	resourceGone := errors.Is(err, openstack.ErrBackupResourceNotInProject) || gophercloud.ResponseCodeIs(err, fiber.StatusNotFound)
+	switch {
+	case err == nil:
+	case resourceGone && policy.ResourceType == models.BackupResourceInstance:
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Instance not found; a backup can only be restored onto its own instance",
+		})
+	case gophercloud.ResponseCodeIs(err, fiber.StatusNotFound):
+		// A volume backup outlives its volume and restores into a new one
+	case resourceGone:
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Volume not found",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Restore a volume backup into a new volume in the caller's project
+	if policy.ResourceType == models.BackupResourceVolume {
+		name := req.Name
+		if name == "" {
+			name = fmt.Sprintf("restore-%s", found.Name)
+		}
+		volumeID, err := openstack.RestorePolicyVolumeBackup(ctx, adminProvider, provider, found, name)
+		if err != nil {
+			return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(models.BackupRestoreResponse{
+			Message:  "Restore started",
+			VolumeID: volumeID,
+		})
+	}
+
+	// Rebuild the instance from the backup
+	computeClient, err := openstack.NewComputeClient(adminProvider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create compute client: %v", err),
+		})
+	}
+	server, err := servers.Get(ctx, computeClient, policy.ResourceID).Extract()
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get instance: %v", err),
+		})
+	}
+	if reason := checkInstanceActionState("rebuild", server); reason != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: reason,
+		})
+	}
+
+	rebuilt, err := servers.Rebuild(ctx, computeClient, server.ID, servers.RebuildOpts{
+		ImageRef:  found.ID,
+		AdminPass: req.AdminPassword,
+	}).Extract()
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to restore backup: %v", err),
+		})
+	}
+
+	events.Publish(events.Event{
+		Type:         events.TypeInstanceAction,
+		ProjectID:    policy.ProjectID,
+		ResourceType: "instance",
+		ResourceID:   server.ID,
+		Data: map[string]interface{}{
+			"action":    "rebuild",
+			"backup_id": found.ID,
+		},
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(models.BackupRestoreResponse{
+		Message:       "Restore started",
+		InstanceID:    server.ID,
+		AdminPassword: rebuilt.AdminPass,
+	})
+}
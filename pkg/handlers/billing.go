@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// errNotAuthenticated is returned when a billing request carries no user
+var errNotAuthenticated = errors.New("user not authenticated")
+
+// invoiceOwnerID returns the Supabase user ID that the authenticated OpenStack
+// user's VPS invoices are recorded under
+func invoiceOwnerID(c *fiber.Ctx, supabaseClient *client.SupabaseClient) (string, error) {
+	openstackUserID, _ := c.Locals("user_id").(string)
+	if openstackUserID == "" {
+		return "", errNotAuthenticated
+	}
+
+	// Supabase stores OpenStack user IDs without dashes
+	user, err := supabaseClient.GetUserByOpenStackID(strings.ReplaceAll(openstackUserID, "-", ""))
+	if err != nil {
+		return "", fmt.Errorf("user not found: %v", err)
+	}
+
+	return user.ID, nil
+}
+
+// invoiceOwnerError responds to an invoiceOwnerID error
+func invoiceOwnerError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errNotAuthenticated) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User not authenticated",
+		})
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": fmt.Sprintf("User not found: %v", err),
+	})
+}
+
+// publishInvoicePaid tells the invoice owner's event streams that an invoice
+// was paid
+func publishInvoicePaid(invoice *models.VPSInvoice, paymentMethod string) {
+	events.Publish(events.Event{
+		Type:         events.TypeInvoicePaid,
+		UserID:       invoice.UserID,
+		ResourceType: "invoice",
+		ResourceID:   invoice.ID,
+		Data: map[string]interface{}{
+			"subscription_id": invoice.SubscriptionID,
+			"amount":          invoice.Amount,
+			"currency":        invoice.Currency,
+			"payment_method":  paymentMethod,
+		},
+	})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -14,47 +15,21 @@ import (
 )
 
 // ComputeHandler handles compute related endpoints
-type ComputeHandler struct {
-	JWTSecret string
-}
+type ComputeHandler struct{}
 
 // NewComputeHandler creates a new compute handler
-func NewComputeHandler(jwtSecret string) *ComputeHandler {
-	return &ComputeHandler{
-		JWTSecret: jwtSecret,
-	}
+func NewComputeHandler() *ComputeHandler {
+	return &ComputeHandler{}
 }
 
 // getProviderFromToken extracts the project-scoped provider from the JWT token
 func (h *ComputeHandler) getProviderFromToken(c *fiber.Ctx) (*gophercloud.ProviderClient, error) {
-	// Get token from Authorization header
-	authHeader := c.Get("Authorization")
-	if authHeader == "" || len(authHeader) < 8 || authHeader[:7] != "Bearer " {
-		return nil, fmt.Errorf("invalid or missing Authorization header")
+	// Get claims verified by the JWT middleware
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok || claims == nil {
+		return nil, fmt.Errorf("invalid or missing token")
 	}
 
-	// Parse token
-	tokenString := authHeader[7:]
-	fmt.Printf("Parsing JWT token: %s\n", tokenString)
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(h.JWTSecret), nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("invalid token: %v", err)
-	}
-
-	// Extract claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, fmt.Errorf("invalid token claims")
-	}
-
-	fmt.Printf("JWT Claims: %+v\n", claims)
-
 	// Extract username, password, domain, and project ID
 	username, ok := claims["username"].(string)
 	if !ok || username == "" {
@@ -82,7 +57,6 @@ func (h *ComputeHandler) getProviderFromToken(c *fiber.Ctx) (*gophercloud.Provid
 	// Try to get OpenStack token from JWT claims
 	openstackToken, ok := claims["openstack_token"].(string)
 	if ok && openstackToken != "" {
-		fmt.Printf("Found OpenStack token in JWT claims: %s\n", openstackToken[:20]+"...")
 		// Use the OpenStack token to authenticate
 		ctx := c.Context()
 		provider, err := openstack.AuthenticateWithToken(ctx, openstackToken, projectID)
@@ -97,7 +71,6 @@ func (h *ComputeHandler) getProviderFromToken(c *fiber.Ctx) (*gophercloud.Provid
 	// If no OpenStack token in claims, try header
 	tokenID := c.Get("X-Auth-Token")
 	if tokenID != "" {
-		fmt.Printf("Found X-Auth-Token header: %s\n", tokenID[:20]+"...")
 		ctx := c.Context()
 		provider, err := openstack.AuthenticateWithToken(ctx, tokenID, projectID)
 		if err != nil {
@@ -119,6 +92,47 @@ func (h *ComputeHandler) getProviderFromToken(c *fiber.Ctx) (*gophercloud.Provid
 	return provider, nil
 }
 
+// instanceFromServer converts a server to our model. Servers booted from a
+// volume have no image.
+func instanceFromServer(server *servers.Server) models.Instance {
+	// Convert addresses
+	addresses := make(map[string][]models.Address)
+	for networkName, networkAddresses := range server.Addresses {
+		addrs := []models.Address{}
+		networkAddrs, _ := networkAddresses.([]interface{})
+		for _, addr := range networkAddrs {
+			addrMap, _ := addr.(map[string]interface{})
+			addrType, _ := addrMap["OS-EXT-IPS:type"].(string)
+			addrValue, _ := addrMap["addr"].(string)
+			addrs = append(addrs, models.Address{
+				Type:    addrType,
+				Address: addrValue,
+			})
+		}
+		addresses[networkName] = addrs
+	}
+
+	// Convert metadata to map[string]interface{} if needed
+	metadata := make(map[string]interface{})
+	for k, v := range server.Metadata {
+		metadata[k] = v
+	}
+
+	flavorID, _ := server.Flavor["id"].(string)
+	imageID, _ := server.Image["id"].(string)
+
+	return models.Instance{
+		ID:        server.ID,
+		Name:      server.Name,
+		Status:    server.Status,
+		Flavor:    flavorID,
+		Image:     imageID,
+		Addresses: addresses,
+		Created:   server.Created,
+		Metadata:  metadata,
+	}
+}
+
 // ListInstances lists all instances in the project
 func (h *ComputeHandler) ListInstances(c *fiber.Ctx) error {
 	// Get provider from token
@@ -155,45 +169,15 @@ func (h *ComputeHandler) ListInstances(c *fiber.Ctx) error {
 
 	// Convert to our model
 	instances := make([]models.Instance, len(allServers))
-	for i, server := range allServers {
-		// Convert addresses
-		addresses := make(map[string][]models.Address)
-		for networkName, networkAddresses := range server.Addresses {
-			addrs := []models.Address{}
-			for _, addr := range networkAddresses.([]interface{}) {
-				addrMap := addr.(map[string]interface{})
-				address := models.Address{
-					Type:    addrMap["OS-EXT-IPS:type"].(string),
-					Address: addrMap["addr"].(string),
-				}
-				addrs = append(addrs, address)
-			}
-			addresses[networkName] = addrs
-		}
-
-		// Convert metadata to map[string]interface{} if needed
-		metadata := make(map[string]interface{})
-		for k, v := range server.Metadata {
-			metadata[k] = v
-		}
-
-		instances[i] = models.Instance{
-			ID:        server.ID,
-			Name:      server.Name,
-			Status:    server.Status,
-			Flavor:    server.Flavor["id"].(string),
-			Image:     server.Image["id"].(string),
-			Addresses: addresses,
-			Created:   server.Created,
-			Metadata:  metadata,
-		}
+	for i := range allServers {
+		instances[i] = instanceFromServer(&allServers[i])
 	}
 
 	// Return instances
 	return c.JSON(instances)
 }
 
-// CreateInstance creates a new instance
+// CreateInstance creates a new instance, or several when a count is given
 func (h *ComputeHandler) CreateInstance(c *fiber.Ctx) error {
 	// Parse request body
 	var req models.CreateInstanceRequest
@@ -203,39 +187,94 @@ func (h *ComputeHandler) CreateInstance(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create instance using internal method
-	instance, err := h.createInstanceInternal(c, req)
+	// Parse ?wait= before creating
+	wait, err := parseInstanceWait(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Create instances using internal method
+	instances, err := h.createInstances(c, req)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
 			Error: fmt.Sprintf("Failed to create instance: %v", err),
 		})
 	}
 
-	// Return instance
-	return c.Status(fiber.StatusCreated).JSON(instance)
+	// Hold the response until the instances settle when asked to
+	status := fiber.StatusCreated
+	if wait != nil {
+		reached, err := h.waitForInstances(c, instances, wait)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Failed to create instance: %v", err),
+			})
+		}
+		if !reached {
+			status = fiber.StatusAccepted
+		}
+	}
+
+	// Return instances
+	if _, maxCount, _ := instanceCountRange(&req); maxCount > 1 {
+		return c.Status(status).JSON(fiber.Map{
+			"instances": instances,
+		})
+	}
+	return c.Status(status).JSON(instances[0])
+}
+
+// waitForInstances waits for new instances to reach a status, refreshing them
+// in place. It reports whether all of them did before the timeout.
+func (h *ComputeHandler) waitForInstances(c *fiber.Ctx, instances []models.Instance, wait *instanceWait) (bool, error) {
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return false, fmt.Errorf("authentication error: %v", err)
+	}
+	computeClient, err := openstack.NewComputeClient(provider)
+	if err != nil {
+		return false, fmt.Errorf("failed to create compute client: %v", err)
+	}
+
+	ctx := context.Background()
+	deadline := time.Now().Add(wait.Timeout)
+	allReached := true
+	for i := range instances {
+		server, reached, err := waitForInstanceStatus(ctx, computeClient, instances[i].ID, wait.Status, deadline)
+		if err != nil {
+			return false, err
+		}
+		instances[i] = instanceFromServer(server)
+		allReached = allReached && reached
+	}
+
+	return allReached, nil
 }
 
 // createInstanceInternal is an internal method that creates a new instance
 // This can be called by other handlers
 func (h *ComputeHandler) createInstanceInternal(c *fiber.Ctx, req models.CreateInstanceRequest) (*models.Instance, error) {
+	instances, err := h.createInstances(c, req)
+	if err != nil {
+		return nil, err
+	}
+	return &instances[0], nil
+}
+
+// createInstances creates the instances of a create request. Validation
+// errors are returned as bad request errors.
+func (h *ComputeHandler) createInstances(c *fiber.Ctx, req models.CreateInstanceRequest) ([]models.Instance, error) {
 	// Get provider from token
 	provider, err := h.getProviderFromToken(c)
 	if err != nil {
 		return nil, fmt.Errorf("authentication error: %v", err)
 	}
 
-	// Validate required fields
-	if req.Name == "" {
-		return nil, fmt.Errorf("name is required")
-	}
-	if req.FlavorID == "" {
-		return nil, fmt.Errorf("flavorID is required")
-	}
-	if req.ImageID == "" {
-		return nil, fmt.Errorf("imageID is required")
-	}
-	if req.NetworkID == "" {
-		return nil, fmt.Errorf("networkID is required")
+	// Validate request
+	if err := validateCreateInstanceRequest(&req); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
 	// Create compute client
@@ -245,48 +284,58 @@ func (h *ComputeHandler) createInstanceInternal(c *fiber.Ctx, req models.CreateI
 	}
 
 	// Create server
-	createOpts := servers.CreateOpts{
-		Name:      req.Name,
-		FlavorRef: req.FlavorID,
-		ImageRef:  req.ImageID,
-		Networks: []servers.Network{
-			{
-				UUID: req.NetworkID,
-			},
-		},
-	}
-
-	// Add key name if provided
-	var serverCreateOpts servers.CreateOptsBuilder = createOpts
-	if req.KeyName != "" {
-		type createOptsWithKeyName struct {
-			servers.CreateOpts
-			KeyName string `json:"key_name,omitempty"`
+	ctx := context.Background()
+	createOpts, hintOpts := serverCreateOpts(&req)
+	if len(req.Tags) > 0 {
+		computeClient.Microversion = instanceTagsMicroversion
+	}
+	result := servers.Create(ctx, computeClient, createOpts, hintOpts)
+	computeClient.Microversion = ""
+
+	// A batch create returns its reservation; list the servers it made
+	if _, maxCount, _ := instanceCountRange(&req); maxCount > 1 {
+		var reservation struct {
+			ReservationID string `json:"reservation_id"`
+		}
+		if err := result.ExtractInto(&reservation); err != nil {
+			return nil, fmt.Errorf("failed to create servers: %w", err)
+		}
+
+		allPages, err := servers.List(computeClient, reservationListOpts{ReservationID: reservation.ReservationID}).AllPages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list created servers: %w", err)
+		}
+		allServers, err := servers.ExtractServers(allPages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract created servers: %w", err)
 		}
-		serverCreateOpts = createOptsWithKeyName{
-			CreateOpts: createOpts,
-			KeyName:    req.KeyName,
+		if len(allServers) == 0 {
+			return nil, fmt.Errorf("reservation %s created no servers", reservation.ReservationID)
 		}
+
+		instances := make([]models.Instance, len(allServers))
+		for i := range allServers {
+			instances[i] = instanceFromServer(&allServers[i])
+		}
+		return instances, nil
 	}
 
-	// Create server
-	ctx := context.Background()
-	server, err := servers.Create(ctx, computeClient, serverCreateOpts, nil).Extract()
+	server, err := result.Extract()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create server: %v", err)
+		return nil, fmt.Errorf("failed to create server: %w", err)
 	}
 
 	// Convert to our model
-	instance := &models.Instance{
+	instance := models.Instance{
 		ID:      server.ID,
-		Name:    server.Name,
+		Name:    req.Name,
 		Status:  server.Status,
 		Flavor:  req.FlavorID,
 		Image:   req.ImageID,
 		Created: server.Created,
 	}
 
-	return instance, nil
+	return []models.Instance{instance}, nil
 }
 
 // GetInstance gets an instance by ID
@@ -324,38 +373,8 @@ func (h *ComputeHandler) GetInstance(c *fiber.Ctx) error {
 		})
 	}
 
-	// Convert addresses
-	addresses := make(map[string][]models.Address)
-	for networkName, networkAddresses := range server.Addresses {
-		addrs := []models.Address{}
-		for _, addr := range networkAddresses.([]interface{}) {
-			addrMap := addr.(map[string]interface{})
-			address := models.Address{
-				Type:    addrMap["OS-EXT-IPS:type"].(string),
-				Address: addrMap["addr"].(string),
-			}
-			addrs = append(addrs, address)
-		}
-		addresses[networkName] = addrs
-	}
-
-	// Convert metadata to map[string]interface{} if needed
-	metadata := make(map[string]interface{})
-	for k, v := range server.Metadata {
-		metadata[k] = v
-	}
-
 	// Convert to our model
-	instance := models.Instance{
-		ID:        server.ID,
-		Name:      server.Name,
-		Status:    server.Status,
-		Flavor:    server.Flavor["id"].(string),
-		Image:     server.Image["id"].(string),
-		Addresses: addresses,
-		Created:   server.Created,
-		Metadata:  metadata,
-	}
+	instance := instanceFromServer(server)
 
 	// Return instance
 	return c.JSON(instance)
@@ -464,59 +483,14 @@ func (h *ComputeHandler) UpdateInstance(c *fiber.Ctx) error {
 	}
 
 	// Convert to our model
-	addresses := make(map[string][]models.Address)
-	for networkName, networkAddresses := range server.Addresses {
-		addrs := []models.Address{}
-		for _, addr := range networkAddresses.([]interface{}) {
-			addrMap := addr.(map[string]interface{})
-			address := models.Address{
-				Type:    addrMap["OS-EXT-IPS:type"].(string),
-				Address: addrMap["addr"].(string),
-			}
-			addrs = append(addrs, address)
-		}
-		addresses[networkName] = addrs
-	}
-
-	// Convert metadata to map[string]interface{} if needed
-	metadata := make(map[string]interface{})
-	for k, v := range server.Metadata {
-		metadata[k] = v
-	}
-
-	instance := models.Instance{
-		ID:        server.ID,
-		Name:      server.Name,
-		Status:    server.Status,
-		Flavor:    server.Flavor["id"].(string),
-		Image:     server.Image["id"].(string),
-		Addresses: addresses,
-		Created:   server.Created,
-		Metadata:  metadata,
-	}
+	instance := instanceFromServer(server)
 
 	// Return updated instance
 	return c.JSON(instance)
 }
 
-// PerformInstanceAction performs an action on an instance
+// PerformInstanceAction performs a start, stop or reboot action on an instance
 func (h *ComputeHandler) PerformInstanceAction(c *fiber.Ctx) error {
-	// Get provider from token
-	provider, err := h.getProviderFromToken(c)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
-			Error: fmt.Sprintf("Authentication error: %v", err),
-		})
-	}
-
-	// Get instance ID from URL
-	instanceID := c.Params("id")
-	if instanceID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
-			Error: "Instance ID is required",
-		})
-	}
-
 	// Parse request body
 	var req models.InstanceActionRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -532,47 +506,34 @@ func (h *ComputeHandler) PerformInstanceAction(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create compute client
-	computeClient, err := openstack.NewComputeClient(provider)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
-			Error: fmt.Sprintf("Failed to create compute client: %v", err),
-		})
-	}
-
-	// Perform action
-	ctx := context.Background()
-	var actionErr error
-
+	var run func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error
 	switch req.Action {
 	case "start":
-		actionErr = servers.Start(ctx, computeClient, instanceID).ExtractErr()
+		run = func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+			return servers.Start(ctx, computeClient, id).ExtractErr()
+		}
 	case "stop":
-		actionErr = servers.Stop(ctx, computeClient, instanceID).ExtractErr()
+		run = func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+			return servers.Stop(ctx, computeClient, id).ExtractErr()
+		}
 	case "reboot":
 		rebootType := servers.SoftReboot
 		if req.Type == "HARD" {
 			rebootType = servers.HardReboot
 		}
-		actionErr = servers.Reboot(ctx, computeClient, instanceID, servers.RebootOpts{
-			Type: rebootType,
-		}).ExtractErr()
+		run = func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+			return servers.Reboot(ctx, computeClient, id, servers.RebootOpts{
+				Type: rebootType,
+			}).ExtractErr()
+		}
 	default:
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
 			Error: fmt.Sprintf("Unsupported action: %s", req.Action),
 		})
 	}
 
-	if actionErr != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
-			Error: fmt.Sprintf("Failed to perform action %s: %v", req.Action, actionErr),
-		})
-	}
-
-	// Return success
-	return c.JSON(models.SuccessResponse{
-		Message: fmt.Sprintf("Action %s performed successfully", req.Action),
-	})
+	// Perform action
+	return h.runSimpleInstanceAction(c, req.Action, run)
 }
 
 // ListFlavors lists all flavors in the project
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// instanceActionStates lists the statuses an instance must be in for an
+// action to run. Actions not listed run in any status.
+var instanceActionStates = map[string][]string{
+	"start":          {"SHUTOFF"},
+	"stop":           {"ACTIVE", "ERROR", "RESCUE"},
+	"reboot":         {"ACTIVE", "SHUTOFF", "PAUSED", "SUSPENDED", "ERROR"},
+	"resize":         {"ACTIVE", "SHUTOFF"},
+	"confirm-resize": {"VERIFY_RESIZE"},
+	"revert-resize":  {"VERIFY_RESIZE"},
+	"rebuild":        {"ACTIVE", "SHUTOFF", "ERROR"},
+	"rescue":         {"ACTIVE", "SHUTOFF", "ERROR"},
+	"unrescue":       {"RESCUE"},
+	"shelve":         {"ACTIVE", "SHUTOFF", "PAUSED", "SUSPENDED"},
+	"unshelve":       {"SHELVED", "SHELVED_OFFLOADED"},
+	"pause":          {"ACTIVE"},
+	"unpause":        {"PAUSED"},
+	"suspend":        {"ACTIVE"},
+	"resume":         {"SUSPENDED"},
+	"reset-password": {"ACTIVE"},
+	"snapshot":       {"ACTIVE", "SHUTOFF", "PAUSED", "SUSPENDED"},
+
+	"attach-interface": {"ACTIVE", "SHUTOFF", "PAUSED", "SUSPENDED"},
+	"detach-interface": {"ACTIVE", "SHUTOFF", "PAUSED", "SUSPENDED"},
+}
+
+// instanceActionFunc runs an action on an instance, returning the instance's
+// admin password when the action sets one
+type instanceActionFunc func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error)
+
+// checkInstanceActionState reports why an instance cannot run an action in
+// its current state, or an empty string when it can
+func checkInstanceActionState(action string, server *servers.Server) string {
+	if server.Locked != nil && *server.Locked && action != "lock" && action != "unlock" {
+		return "Instance is locked; unlock it first"
+	}
+	if server.TaskState != "" && action != "lock" && action != "unlock" {
+		return fmt.Sprintf("Instance is busy with task %s", server.TaskState)
+	}
+
+	states, ok := instanceActionStates[action]
+	if !ok {
+		return ""
+	}
+	for _, state := range states {
+		if server.Status == state {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Cannot %s an instance in status %s (requires %s)", action, server.Status, strings.Join(states, " or "))
+}
+
+// instanceActionErrorStatus maps an action's error to the status returned to
+// the caller
+func instanceActionErrorStatus(err error) int {
+	if fiberErr, ok := err.(*fiber.Error); ok {
+		return fiberErr.Code
+	}
+
+	switch {
+	case gophercloud.ResponseCodeIs(err, fiber.StatusBadRequest):
+		return fiber.StatusBadRequest
+	case gophercloud.ResponseCodeIs(err, fiber.StatusNotFound):
+		return fiber.StatusNotFound
+	case gophercloud.ResponseCodeIs(err, fiber.StatusConflict):
+		return fiber.StatusConflict
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// generateAdminPassword returns a random instance admin password
+func generateAdminPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// runInstanceAction checks that the instance named in the URL may run an
+// action in its current state, then runs it and writes the response
+func (h *ComputeHandler) runInstanceAction(c *fiber.Ctx, action string, run instanceActionFunc) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Create compute client
+	computeClient, err := openstack.NewComputeClient(provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create compute client: %v", err),
+		})
+	}
+
+	// Parse ?wait= before acting
+	wait, err := parseInstanceWait(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Get server and check its state
+	ctx := context.Background()
+	server, err := servers.Get(ctx, computeClient, instanceID).Extract()
+	if err != nil {
+		if gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+				Error: "Instance not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get instance: %v", err),
+		})
+	}
+	if reason := checkInstanceActionState(action, server); reason != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: reason,
+		})
+	}
+
+	// Perform action
+	adminPassword, err := run(ctx, computeClient, server)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to perform action %s: %v", action, err),
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	events.Publish(events.Event{
+		Type:         events.TypeInstanceAction,
+		ProjectID:    projectID,
+		ResourceType: "instance",
+		ResourceID:   server.ID,
+		Data: map[string]interface{}{
+			"action": action,
+		},
+	})
+
+	resp := models.InstanceActionResponse{
+		Message:       fmt.Sprintf("Action %s performed successfully", action),
+		AdminPassword: adminPassword,
+	}
+
+	// Hold the response until the instance settles when asked to
+	if wait != nil {
+		waited, reached, err := waitForInstanceStatus(ctx, computeClient, server.ID, wait.Status, time.Now().Add(wait.Timeout))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Action %s failed: %v", action, err),
+			})
+		}
+		instance := instanceFromServer(waited)
+		resp.Instance = &instance
+		if !reached {
+			resp.Message = fmt.Sprintf("Action %s accepted; instance is still %s after %s", action, waited.Status, wait.Timeout)
+			return c.Status(fiber.StatusAccepted).JSON(resp)
+		}
+	}
+
+	// Return success
+	return c.JSON(resp)
+}
+
+// runSimpleInstanceAction runs an action that takes no options and sets no
+// password
+func (h *ComputeHandler) runSimpleInstanceAction(c *fiber.Ctx, action string, run func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error) error {
+	return h.runInstanceAction(c, action, func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		return "", run(ctx, computeClient, server.ID)
+	})
+}
+
+// ResizeInstance resizes an instance to another flavor. The resize must then
+// be confirmed or reverted.
+func (h *ComputeHandler) ResizeInstance(c *fiber.Ctx) error {
+	// Parse request body
+	var req models.InstanceResizeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.FlavorID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Flavor ID is required",
+		})
+	}
+
+	return h.runInstanceAction(c, "resize", func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		if currentFlavor, _ := server.Flavor["id"].(string); currentFlavor == req.FlavorID {
+			return "", fiber.NewError(fiber.StatusBadRequest, "instance already has flavor "+req.FlavorID)
+		}
+		return "", servers.Resize(ctx, computeClient, server.ID, servers.ResizeOpts{
+			FlavorRef: req.FlavorID,
+		}).ExtractErr()
+	})
+}
+
+// ConfirmResizeInstance confirms a pending resize
+func (h *ComputeHandler) ConfirmResizeInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "confirm-resize", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.ConfirmResize(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// RevertResizeInstance reverts a pending resize to the original flavor
+func (h *ComputeHandler) RevertResizeInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "revert-resize", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.RevertResize(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// RebuildInstance rebuilds an instance from an image, wiping its root disk
+func (h *ComputeHandler) RebuildInstance(c *fiber.Ctx) error {
+	// Parse request body
+	var req models.InstanceRebuildRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.ImageID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Image ID is required",
+		})
+	}
+
+	return h.runInstanceAction(c, "rebuild", func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		rebuilt, err := servers.Rebuild(ctx, computeClient, server.ID, servers.RebuildOpts{
+			ImageRef:  req.ImageID,
+			Name:      req.Name,
+			AdminPass: req.AdminPassword,
+			Metadata:  req.Metadata,
+		}).Extract()
+		if err != nil {
+			return "", err
+		}
+		return rebuilt.AdminPass, nil
+	})
+}
+
+// RescueInstance boots an instance from a rescue image with its root disk
+// attached, for recovering an unbootable instance
+func (h *ComputeHandler) RescueInstance(c *fiber.Ctx) error {
+	// Parse request body; all options are optional
+	var req models.InstanceRescueRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	return h.runInstanceAction(c, "rescue", func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		return servers.Rescue(ctx, computeClient, server.ID, servers.RescueOpts{
+			AdminPass:      req.AdminPassword,
+			RescueImageRef: req.ImageID,
+		}).Extract()
+	})
+}
+
+// UnrescueInstance returns a rescued instance to normal operation
+func (h *ComputeHandler) UnrescueInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "unrescue", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Unrescue(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// ShelveInstance shelves an instance, releasing its compute resources
+func (h *ComputeHandler) ShelveInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "shelve", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Shelve(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// UnshelveInstance restores a shelved instance
+func (h *ComputeHandler) UnshelveInstance(c *fiber.Ctx) error {
+	// Parse request body; all options are optional
+	var req models.InstanceUnshelveRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	return h.runInstanceAction(c, "unshelve", func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		return "", servers.Unshelve(ctx, computeClient, server.ID, servers.UnshelveOpts{
+			AvailabilityZone: req.AvailabilityZone,
+		}).ExtractErr()
+	})
+}
+
+// PauseInstance pauses an instance, keeping its state in memory
+func (h *ComputeHandler) PauseInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "pause", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Pause(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// UnpauseInstance unpauses a paused instance
+func (h *ComputeHandler) UnpauseInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "unpause", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Unpause(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// SuspendInstance suspends an instance, saving its state to disk
+func (h *ComputeHandler) SuspendInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "suspend", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Suspend(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// ResumeInstance resumes a suspended instance
+func (h *ComputeHandler) ResumeInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "resume", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Resume(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// LockInstance locks an instance against changes until it is unlocked
+func (h *ComputeHandler) LockInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "lock", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Lock(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// UnlockInstance unlocks a locked instance
+func (h *ComputeHandler) UnlockInstance(c *fiber.Ctx) error {
+	return h.runSimpleInstanceAction(c, "unlock", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return servers.Unlock(ctx, computeClient, id).ExtractErr()
+	})
+}
+
+// ResetInstancePassword sets an instance's admin password, generating one when
+// none is given. The guest must run an agent that applies the change.
+func (h *ComputeHandler) ResetInstancePassword(c *fiber.Ctx) error {
+	// Parse request body; the password is optional
+	var req models.InstanceResetPasswordRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	password := req.Password
+	if password == "" {
+		generated, err := generateAdminPassword()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		password = generated
+	} else if len(password) < 8 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Password must be at least 8 characters",
+		})
+	}
+
+	return h.runInstanceAction(c, "reset-password", func(ctx context.Context, computeClient *gophercloud.ServiceClient, server *servers.Server) (string, error) {
+		if err := servers.ChangeAdminPassword(ctx, computeClient, server.ID, password).ExtractErr(); err != nil {
+			return "", err
+		}
+		return password, nil
+	})
+}
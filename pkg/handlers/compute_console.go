@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+const (
+	// defaultConsoleLogLines is the number of console log lines returned when
+	// none are asked for
+	defaultConsoleLogLines = 100
+
+	// maxConsoleLogLines caps the console log lines returned at once
+	maxConsoleLogLines = 10000
+)
+
+// consoleLogLines parses the lines query parameter of a console log request
+func consoleLogLines(c *fiber.Ctx) (int, error) {
+	lines := c.QueryInt("lines", defaultConsoleLogLines)
+	if lines <= 0 || lines > maxConsoleLogLines {
+		return 0, fmt.Errorf("lines must be between 1 and %d", maxConsoleLogLines)
+	}
+	return lines, nil
+}
+
+// consoleErrorStatus maps a console error to the status returned to the caller
+func consoleErrorStatus(err error) int {
+	if errors.Is(err, openstack.ErrUnsupportedConsoleType) {
+		return fiber.StatusBadRequest
+	}
+	return instanceActionErrorStatus(err)
+}
+
+// CreateInstanceConsole opens a remote console to an instance and returns its URL
+func (h *ComputeHandler) CreateInstanceConsole(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Parse request body; the console type is optional
+	var req models.InstanceConsoleRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	// Create console
+	console, err := openstack.CreateRemoteConsole(context.Background(), provider, instanceID, req.Type)
+	if err != nil {
+		return c.Status(consoleErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(console)
+}
+
+// GetInstanceConsoleLog returns the last lines of an instance's serial console log
+func (h *ComputeHandler) GetInstanceConsoleLog(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	lines, err := consoleLogLines(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Get console log
+	output, err := openstack.GetConsoleLog(context.Background(), provider, instanceID, lines)
+	if err != nil {
+		return c.Status(consoleErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(models.InstanceConsoleLogResponse{
+		Lines:  lines,
+		Output: output,
+	})
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/keypairs"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+const (
+	// maxUserDataSize is the largest base64-encoded user data Nova accepts
+	maxUserDataSize = 65535
+
+	// maxInstanceMetadataItems, maxInstanceMetadataLength, maxInstanceTags and
+	// maxInstanceTagLength are Nova's limits on instance metadata and tags
+	maxInstanceMetadataItems  = 128
+	maxInstanceMetadataLength = 255
+	maxInstanceTags           = 50
+	maxInstanceTagLength      = 60
+
+	// instanceTagsMicroversion is the first compute microversion accepting
+	// tags on create
+	instanceTagsMicroversion = "2.52"
+)
+
+// userDataPrefixes are the starts of the user data formats cloud-init reads
+var userDataPrefixes = [][]byte{
+	[]byte("#cloud-config"),
+	[]byte("#!"),
+	[]byte("#include"),
+	[]byte("#cloud-boothook"),
+	[]byte("#part-handler"),
+	[]byte("#upstart-job"),
+	[]byte("## template: jinja"),
+	[]byte("Content-Type: multipart/"),
+	{0x1f, 0x8b}, // gzip
+}
+
+// encodeUserData checks that user data, given plain or base64-encoded, is in
+// a format cloud-init reads and fits Nova's size limit. It returns the user
+// data base64-encoded.
+func encodeUserData(userData string) (string, error) {
+	content := []byte(userData)
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(userData)); err == nil {
+		content = decoded
+	}
+
+	valid := false
+	for _, prefix := range userDataPrefixes {
+		if bytes.HasPrefix(content, prefix) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("user_data must be a #cloud-config document, a script starting with #!, or another format cloud-init reads")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+	if len(encoded) > maxUserDataSize {
+		return "", fmt.Errorf("user_data is %d bytes base64-encoded; the limit is %d", len(encoded), maxUserDataSize)
+	}
+	return encoded, nil
+}
+
+// instanceNetworks returns the NICs of an instance create request
+func instanceNetworks(req *models.CreateInstanceRequest) []models.InstanceNetwork {
+	networks := req.Networks
+	if req.NetworkID != "" {
+		networks = append([]models.InstanceNetwork{{NetworkID: req.NetworkID}}, networks...)
+	}
+	return networks
+}
+
+// instanceCountRange returns the minimum and maximum number of instances a
+// create request asks for
+func instanceCountRange(req *models.CreateInstanceRequest) (int, int, error) {
+	if req.Count < 0 || req.MinCount < 0 || req.MaxCount < 0 {
+		return 0, 0, fmt.Errorf("count, min_count and max_count must not be negative")
+	}
+	if req.Count > 0 {
+		if req.MinCount > 0 || req.MaxCount > 0 {
+			return 0, 0, fmt.Errorf("count cannot be combined with min_count or max_count")
+		}
+		return req.Count, req.Count, nil
+	}
+
+	minCount, maxCount := req.MinCount, req.MaxCount
+	if minCount == 0 {
+		minCount = 1
+	}
+	if maxCount == 0 {
+		maxCount = minCount
+	}
+	if minCount > maxCount {
+		return 0, 0, fmt.Errorf("min_count must not exceed max_count")
+	}
+	return minCount, maxCount, nil
+}
+
+// validateCreateInstanceRequest checks an instance create request and fills in
+// its defaults
+func validateCreateInstanceRequest(req *models.CreateInstanceRequest) error {
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if req.FlavorID == "" {
+		return fmt.Errorf("flavorID is required")
+	}
+
+	// Boot source
+	if req.BootVolume != nil {
+		if req.BootVolume.SourceType == "" {
+			req.BootVolume.SourceType = "image"
+		}
+		switch req.BootVolume.SourceType {
+		case "image":
+			if req.BootVolume.SourceID == "" {
+				req.BootVolume.SourceID = req.ImageID
+			}
+			if req.BootVolume.Size <= 0 {
+				return fmt.Errorf("boot_volume.size is required when booting from an image")
+			}
+		case "snapshot":
+			if req.BootVolume.Size < 0 {
+				return fmt.Errorf("boot_volume.size must not be negative")
+			}
+		case "volume":
+			if req.BootVolume.Size != 0 {
+				return fmt.Errorf("boot_volume.size cannot be set for an existing volume")
+			}
+		default:
+			return fmt.Errorf("boot_volume.source_type must be image, snapshot or volume")
+		}
+		if req.BootVolume.SourceID == "" {
+			return fmt.Errorf("boot_volume.source_id is required")
+		}
+	} else if req.ImageID == "" {
+		return fmt.Errorf("imageID is required")
+	}
+
+	_, maxCount, err := instanceCountRange(req)
+	if err != nil {
+		return err
+	}
+	if maxCount > 1 && req.BootVolume != nil && req.BootVolume.SourceType == "volume" {
+		return fmt.Errorf("an existing volume can only boot a single instance")
+	}
+
+	// Networks
+	networks := instanceNetworks(req)
+	if len(networks) == 0 {
+		return fmt.Errorf("networkID is required")
+	}
+	for i, network := range networks {
+		switch {
+		case network.NetworkID == "" && network.PortID == "":
+			return fmt.Errorf("networks[%d] needs a network_id or port_id", i)
+		case network.NetworkID != "" && network.PortID != "":
+			return fmt.Errorf("networks[%d] cannot have both a network_id and a port_id", i)
+		case network.PortID != "" && network.FixedIP != "":
+			return fmt.Errorf("networks[%d] cannot set a fixed_ip on an existing port", i)
+		case network.PortID != "" && maxCount > 1:
+			return fmt.Errorf("an existing port can only be attached to a single instance")
+		case network.FixedIP != "" && maxCount > 1:
+			return fmt.Errorf("a fixed IP can only be given to a single instance")
+		}
+	}
+
+	// User data
+	if req.UserData != "" {
+		encoded, err := encodeUserData(req.UserData)
+		if err != nil {
+			return err
+		}
+		req.UserData = encoded
+	}
+
+	// Metadata and tags
+	if len(req.Metadata) > maxInstanceMetadataItems {
+		return fmt.Errorf("metadata has more than %d items", maxInstanceMetadataItems)
+	}
+	for key, value := range req.Metadata {
+		if key == "" || len(key) > maxInstanceMetadataLength || len(value) > maxInstanceMetadataLength {
+			return fmt.Errorf("metadata keys must be 1 to %d characters and values at most %d", maxInstanceMetadataLength, maxInstanceMetadataLength)
+		}
+	}
+	if len(req.Tags) > maxInstanceTags {
+		return fmt.Errorf("at most %d tags are allowed", maxInstanceTags)
+	}
+	for _, tag := range req.Tags {
+		if tag == "" || len(tag) > maxInstanceTagLength || strings.ContainsAny(tag, ",/") {
+			return fmt.Errorf("tag %q must be 1 to %d characters without commas or slashes", tag, maxInstanceTagLength)
+		}
+	}
+
+	return nil
+}
+
+// serverCreateOpts builds the Nova create options of a validated instance
+// create request
+func serverCreateOpts(req *models.CreateInstanceRequest) (servers.CreateOptsBuilder, servers.SchedulerHintOptsBuilder) {
+	minCount, maxCount, _ := instanceCountRange(req)
+
+	networks := []servers.Network{}
+	for _, network := range instanceNetworks(req) {
+		networks = append(networks, servers.Network{
+			UUID:    network.NetworkID,
+			Port:    network.PortID,
+			FixedIP: network.FixedIP,
+		})
+	}
+
+	createOpts := servers.CreateOpts{
+		Name:             req.Name,
+		FlavorRef:        req.FlavorID,
+		ImageRef:         req.ImageID,
+		Networks:         networks,
+		SecurityGroups:   req.SecurityGroups,
+		AvailabilityZone: req.AvailabilityZone,
+		Metadata:         req.Metadata,
+		Tags:             req.Tags,
+	}
+	if req.UserData != "" {
+		// Already base64-encoded, which gophercloud passes through
+		createOpts.UserData = []byte(req.UserData)
+	}
+	if maxCount > 1 {
+		createOpts.Min = minCount
+		createOpts.Max = maxCount
+	}
+
+	// Boot from a volume instead of the image
+	if req.BootVolume != nil {
+		createOpts.ImageRef = ""
+		createOpts.BlockDevice = []servers.BlockDevice{
+			{
+				SourceType:          servers.SourceType(req.BootVolume.SourceType),
+				UUID:                req.BootVolume.SourceID,
+				DestinationType:     servers.DestinationVolume,
+				VolumeSize:          req.BootVolume.Size,
+				VolumeType:          req.BootVolume.VolumeType,
+				DeleteOnTermination: req.BootVolume.DeleteOnTermination,
+				BootIndex:           0,
+			},
+		}
+	}
+
+	// Add key name if provided
+	var opts servers.CreateOptsBuilder = keypairs.CreateOptsExt{
+		CreateOptsBuilder: createOpts,
+		KeyName:           req.KeyName,
+	}
+	if maxCount > 1 {
+		opts = batchCreateOpts{CreateOptsBuilder: opts}
+	}
+
+	// Place the instance in a server group
+	var hintOpts servers.SchedulerHintOptsBuilder
+	if req.ServerGroupID != "" {
+		hintOpts = servers.SchedulerHintOpts{Group: req.ServerGroupID}
+	}
+
+	return opts, hintOpts
+}
+
+// batchCreateOpts asks Nova to return the reservation of a batch create, which
+// names all of its servers, instead of the first server
+type batchCreateOpts struct {
+	servers.CreateOptsBuilder
+}
+
+// ToServerCreateMap adds return_reservation_id to the base create options
+func (opts batchCreateOpts) ToServerCreateMap() (map[string]any, error) {
+	base, err := opts.CreateOptsBuilder.ToServerCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	base["server"].(map[string]any)["return_reservation_id"] = true
+	return base, nil
+}
+
+// reservationListOpts lists the servers of a batch create
+type reservationListOpts struct {
+	ReservationID string `q:"reservation_id"`
+}
+
+// ToServerListQuery formats reservationListOpts into a query string
+func (opts reservationListOpts) ToServerListQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	if err != nil {
+		return "", err
+	}
+	return q.String(), nil
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/secgroups"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// ListInstanceInterfaces lists the NICs attached to an instance
+func (h *ComputeHandler) ListInstanceInterfaces(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	interfaces, err := openstack.ListServerInterfaces(context.Background(), provider, instanceID)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(interfaces)
+}
+
+// AttachInstanceInterface hot-plugs a NIC into an instance, from an existing
+// port or a new port on a network
+func (h *ComputeHandler) AttachInstanceInterface(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Parse request body
+	var req models.AttachInterfaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if (req.PortID == "") == (req.NetworkID == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Either port_id or network_id is required",
+		})
+	}
+	if req.FixedIP != "" && req.NetworkID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "fixed_ip can only be given with network_id; set a port's fixed IPs on the port",
+		})
+	}
+
+	// Create compute client
+	computeClient, err := openstack.NewComputeClient(provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create compute client: %v", err),
+		})
+	}
+
+	// Get server and check its state
+	ctx := context.Background()
+	server, err := servers.Get(ctx, computeClient, instanceID).Extract()
+	if err != nil {
+		if gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+				Error: "Instance not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get instance: %v", err),
+		})
+	}
+	if reason := checkInstanceActionState("attach-interface", server); reason != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: reason,
+		})
+	}
+
+	// Attach interface
+	iface, err := openstack.AttachServerInterface(ctx, computeClient, server.ID, req)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	events.Publish(events.Event{
+		Type:         events.TypeInstanceAction,
+		ProjectID:    projectID,
+		ResourceType: "instance",
+		ResourceID:   server.ID,
+		Data: map[string]interface{}{
+			"action":  "attach-interface",
+			"port_id": iface.PortID,
+		},
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(iface)
+}
+
+// DetachInstanceInterface unplugs a NIC from an instance. A port created by
+// attaching a network is deleted; an attached port is kept for reuse.
+func (h *ComputeHandler) DetachInstanceInterface(c *fiber.Ctx) error {
+	portID := c.Params("port_id")
+	if portID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Port ID is required",
+		})
+	}
+
+	return h.runSimpleInstanceAction(c, "detach-interface", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return openstack.DetachServerInterface(ctx, computeClient, id, portID)
+	})
+}
+
+// ListInstanceSecurityGroups lists the security groups applied to an instance
+func (h *ComputeHandler) ListInstanceSecurityGroups(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	groups, err := openstack.ListServerSecurityGroups(context.Background(), provider, instanceID)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(groups)
+}
+
+// AddInstanceSecurityGroup applies a security group to all of an instance's
+// ports
+func (h *ComputeHandler) AddInstanceSecurityGroup(c *fiber.Ctx) error {
+	// Parse request body
+	var req models.InstanceSecurityGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.SecurityGroup == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Security group is required",
+		})
+	}
+
+	return h.runSimpleInstanceAction(c, "add-security-group", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return secgroups.AddServer(ctx, computeClient, id, req.SecurityGroup).ExtractErr()
+	})
+}
+
+// RemoveInstanceSecurityGroup removes a security group, named in the URL by
+// name or ID, from all of an instance's ports
+func (h *ComputeHandler) RemoveInstanceSecurityGroup(c *fiber.Ctx) error {
+	group := c.Params("group")
+	if group == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Security group is required",
+		})
+	}
+
+	return h.runSimpleInstanceAction(c, "remove-security-group", func(ctx context.Context, computeClient *gophercloud.ServiceClient, id string) error {
+		return secgroups.RemoveServer(ctx, computeClient, id, group).ExtractErr()
+	})
+}
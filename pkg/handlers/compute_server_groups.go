@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// serverGroupPolicies are the placement policies a server group may have
+var serverGroupPolicies = map[string]bool{
+	models.ServerGroupAffinity:         true,
+	models.ServerGroupAntiAffinity:     true,
+	models.ServerGroupSoftAffinity:     true,
+	models.ServerGroupSoftAntiAffinity: true,
+}
+
+// ListServerGroups lists the project's server groups
+func (h *ComputeHandler) ListServerGroups(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	groups, err := openstack.ListServerGroups(context.Background(), provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(groups)
+}
+
+// CreateServerGroup creates a server group; instances join it by passing its
+// ID as server_group_id when they are created
+func (h *ComputeHandler) CreateServerGroup(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Parse request body
+	var req models.CreateServerGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name is required",
+		})
+	}
+	if !serverGroupPolicies[req.Policy] {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Policy must be affinity, anti-affinity, soft-affinity or soft-anti-affinity",
+		})
+	}
+
+	group, err := openstack.CreateServerGroup(context.Background(), provider, req.Name, req.Policy)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+// GetServerGroup gets a server group with its member instances
+func (h *ComputeHandler) GetServerGroup(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get server group ID from URL
+	groupID := c.Params("id")
+	if groupID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Server group ID is required",
+		})
+	}
+
+	group, err := openstack.GetServerGroup(context.Background(), provider, groupID)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(group)
+}
+
+// DeleteServerGroup deletes a server group; its instances keep running where
+// they are
+func (h *ComputeHandler) DeleteServerGroup(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get server group ID from URL
+	groupID := c.Params("id")
+	if groupID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Server group ID is required",
+		})
+	}
+
+	if err := openstack.DeleteServerGroup(context.Background(), provider, groupID); err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// ListAvailabilityZones lists the availability zones instances and volumes
+// can be created in
+func (h *ComputeHandler) ListAvailabilityZones(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	zones, err := openstack.ListAvailabilityZones(context.Background(), provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(zones)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// snapshotErrorStatus maps a snapshot lookup error to the status returned to
+// the caller
+func snapshotErrorStatus(err error) int {
+	if errors.Is(err, openstack.ErrNotSnapshot) {
+		return fiber.StatusNotFound
+	}
+	return instanceActionErrorStatus(err)
+}
+
+// CreateInstanceSnapshot snapshots an instance into a private image, which
+// can boot new instances or rebuild existing ones once it is active
+func (h *ComputeHandler) CreateInstanceSnapshot(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Parse request body; all options are optional
+	var req models.InstanceSnapshotRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	// Create compute client
+	computeClient, err := openstack.NewComputeClient(provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create compute client: %v", err),
+		})
+	}
+
+	// Get server and check its state
+	ctx := context.Background()
+	server, err := servers.Get(ctx, computeClient, instanceID).Extract()
+	if err != nil {
+		if gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+				Error: "Instance not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get instance: %v", err),
+		})
+	}
+	if reason := checkInstanceActionState("snapshot", server); reason != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: reason,
+		})
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", server.Name, time.Now().UTC().Format("20060102-150405"))
+	}
+
+	// Create snapshot
+	imageID, err := openstack.CreateServerSnapshot(ctx, provider, server.ID, name, req.Metadata)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	events.Publish(events.Event{
+		Type:         events.TypeInstanceAction,
+		ProjectID:    projectID,
+		ResourceType: "instance",
+		ResourceID:   server.ID,
+		Data: map[string]interface{}{
+			"action":      "snapshot",
+			"snapshot_id": imageID,
+		},
+	})
+
+	// Nova registers the image before returning, but fall back to what we
+	// know if Glance is slow to show it
+	snapshot, err := openstack.GetSnapshot(ctx, provider, imageID)
+	if err != nil {
+		snapshot = &models.Snapshot{
+			ID:         imageID,
+			Name:       name,
+			InstanceID: server.ID,
+			Status:     "queued",
+			ProjectID:  projectID,
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(snapshot)
+}
+
+// ListInstanceSnapshots lists the snapshots taken of an instance
+func (h *ComputeHandler) ListInstanceSnapshots(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get instance ID from URL
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	snapshots, err := openstack.ListSnapshots(context.Background(), provider, projectID, instanceID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(snapshots)
+}
+
+// ListSnapshots lists the project's instance snapshots, including those of
+// deleted instances
+func (h *ComputeHandler) ListSnapshots(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	snapshots, err := openstack.ListSnapshots(context.Background(), provider, projectID, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(snapshots)
+}
+
+// GetSnapshot gets a snapshot, whose status shows when it is ready to use
+func (h *ComputeHandler) GetSnapshot(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get snapshot ID from URL
+	snapshotID := c.Params("id")
+	if snapshotID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Snapshot ID is required",
+		})
+	}
+
+	snapshot, err := openstack.GetSnapshot(context.Background(), provider, snapshotID)
+	if err != nil {
+		status := snapshotErrorStatus(err)
+		if status == fiber.StatusNotFound {
+			return c.Status(status).JSON(models.ErrorResponse{
+				Error: "Snapshot not found",
+			})
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(snapshot)
+}
+
+// GetSnapshotUsage returns the snapshot storage the project is billed for
+func (h *ComputeHandler) GetSnapshotUsage(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	snapshots, err := openstack.ListSnapshots(context.Background(), provider, projectID, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(openstack.SummarizeSnapshotUsage(snapshots))
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+const (
+	// defaultInstanceWaitTimeout and maxInstanceWaitTimeout bound how long a
+	// ?wait= request holds its response
+	defaultInstanceWaitTimeout = 2 * time.Minute
+	maxInstanceWaitTimeout     = 10 * time.Minute
+
+	// instanceWaitInterval is how often a waiting request checks the instance
+	instanceWaitInterval = 2 * time.Second
+)
+
+// instanceWaitStatuses are the statuses an instance settles in, which a
+// request may wait for
+var instanceWaitStatuses = map[string]bool{
+	"ACTIVE":            true,
+	"SHUTOFF":           true,
+	"PAUSED":            true,
+	"SUSPENDED":         true,
+	"RESCUE":            true,
+	"VERIFY_RESIZE":     true,
+	"SHELVED":           true,
+	"SHELVED_OFFLOADED": true,
+}
+
+// instanceWait is a request to hold the response until instances reach a status
+type instanceWait struct {
+	Status  string
+	Timeout time.Duration
+}
+
+// parseInstanceWait parses the ?wait=<status>&timeout=<duration> query
+// parameters. It returns nil when the request does not wait. A timeout
+// without a unit is in seconds.
+func parseInstanceWait(c *fiber.Ctx) (*instanceWait, error) {
+	status := strings.ToUpper(c.Query("wait"))
+	if status == "" {
+		return nil, nil
+	}
+	if !instanceWaitStatuses[status] {
+		return nil, fmt.Errorf("cannot wait for status %s", status)
+	}
+
+	wait := &instanceWait{
+		Status:  status,
+		Timeout: defaultInstanceWaitTimeout,
+	}
+	if timeout := c.Query("timeout"); timeout != "" {
+		if seconds, err := strconv.Atoi(timeout); err == nil {
+			wait.Timeout = time.Duration(seconds) * time.Second
+		} else if wait.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout %q", timeout)
+		}
+		if wait.Timeout <= 0 || wait.Timeout > maxInstanceWaitTimeout {
+			return nil, fmt.Errorf("timeout must be between 1s and %s", maxInstanceWaitTimeout)
+		}
+	}
+
+	return wait, nil
+}
+
+// waitForInstanceStatus polls an instance until it reaches a status or the
+// deadline passes. It returns the instance's last state and whether the
+// status was reached, or an error when the instance goes to ERROR.
+func waitForInstanceStatus(ctx context.Context, computeClient *gophercloud.ServiceClient, serverID, status string, deadline time.Time) (*servers.Server, bool, error) {
+	for {
+		server, err := servers.Get(ctx, computeClient, serverID).Extract()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get instance: %w", err)
+		}
+		if server.Status == status {
+			return server, true, nil
+		}
+		if server.Status == "ERROR" {
+			if server.Fault.Message != "" {
+				return server, false, fmt.Errorf("instance %s went to ERROR: %s", serverID, server.Fault.Message)
+			}
+			return server, false, fmt.Errorf("instance %s went to ERROR", serverID)
+		}
+
+		if time.Now().Add(instanceWaitInterval).After(deadline) {
+			return server, false, nil
+		}
+		time.Sleep(instanceWaitInterval)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// eventStreamHeartbeat is how often an idle event stream sends a comment so
+// proxies keep the connection open
+const eventStreamHeartbeat = 15 * time.Second
+
+// EventsHandler handles the event stream
+type EventsHandler struct {
+	SupabaseClient *client.SupabaseClient
+	PostgresClient *client.PostgresClient
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(supabaseClient *client.SupabaseClient, postgresClient *client.PostgresClient) *EventsHandler {
+	return &EventsHandler{
+		SupabaseClient: supabaseClient,
+		PostgresClient: postgresClient,
+	}
+}
+
+// eventStreamAccess is what a stream was opened with, kept so that access
+// can be checked again while it is open. The request context is released
+// once the stream starts, so nothing is read from it afterwards.
+type eventStreamAccess struct {
+	sessionID string
+	apiKeyID  string
+	userID    string
+	projectID string
+	isAdmin   bool
+}
+
+// stillAllowed reports whether the caller may keep receiving the project's
+// events: their session or API key must still be active and, unless they
+// are an administrator, they must still be a member of the project
+func (h *EventsHandler) stillAllowed(ctx context.Context, access eventStreamAccess) bool {
+	if h.PostgresClient == nil {
+		return true
+	}
+
+	if access.apiKeyID != "" {
+		apiKey, err := h.PostgresClient.GetAPIKey(ctx, access.apiKeyID)
+		if err != nil || apiKey.RevokedAt != nil {
+			return false
+		}
+		if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+			return false
+		}
+	} else {
+		active, err := h.PostgresClient.IsSessionActive(ctx, access.sessionID)
+		if err != nil || !active {
+			return false
+		}
+	}
+
+	if access.isAdmin {
+		return true
+	}
+	role, err := h.PostgresClient.GetProjectRole(ctx, access.userID, access.projectID)
+	return err == nil && role != ""
+}
+
+// StreamEvents streams the events of the caller's project as Server-Sent
+// Events. ?types= limits the stream to a comma-separated list of event types.
+// Access is checked again on every heartbeat, and the stream is closed when
+// the session is revoked, the caller leaves the project or the access token
+// expires.
+func (h *EventsHandler) StreamEvents(c *fiber.Ctx) error {
+	projectID, _ := c.Locals("project_id").(string)
+	if projectID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "A project-scoped token is required",
+		})
+	}
+
+	// Invoices belong to accounts, so subscribe to the account's events too
+	userID := ""
+	if h.SupabaseClient != nil {
+		if ownerID, err := invoiceOwnerID(c, h.SupabaseClient); err == nil {
+			userID = ownerID
+		}
+	}
+
+	types := make(map[string]bool)
+	for _, eventType := range strings.Split(c.Query("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types[eventType] = true
+		}
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	access := eventStreamAccess{
+		projectID: projectID,
+		isAdmin:   middleware.IsAdmin(c),
+	}
+	access.sessionID, _ = c.Locals("session_id").(string)
+	access.apiKeyID, _ = c.Locals("api_key_id").(string)
+	access.userID, _ = c.Locals("user_id").(string)
+
+	// Access tokens end the stream when they expire; API keys have no token
+	var expiresAt time.Time
+	if claims, ok := c.Locals("user").(jwt.MapClaims); ok && access.apiKeyID == "" {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			expiresAt = exp.Time
+		}
+	}
+
+	subscription := events.Subscribe(projectID, userID)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer subscription.Close()
+
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		var expired <-chan time.Time
+		if !expiresAt.IsZero() {
+			expiry := time.NewTimer(time.Until(expiresAt))
+			defer expiry.Stop()
+			expired = expiry.C
+		}
+
+		// Tell the client the stream is open
+		fmt.Fprintf(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case event := <-subscription.Events:
+				if len(types) > 0 && !types[event.Type] {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			case <-heartbeat.C:
+				if !h.stillAllowed(context.Background(), access) {
+					fmt.Fprintf(w, "event: closed\ndata: {\"reason\":\"access revoked\"}\n\n")
+					w.Flush()
+					return
+				}
+				fmt.Fprintf(w, ": heartbeat\n\n")
+			case <-expired:
+				fmt.Fprintf(w, "event: closed\ndata: {\"reason\":\"token expired\"}\n\n")
+				w.Flush()
+				return
+			}
+
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// publishJobProgress tells event streams how a background job such as VPS
+// provisioning is getting on. Stages are "started", "completed" and "failed".
+func publishJobProgress(job, stage, projectID, userID, resourceType, resourceID string, jobErr error) {
+	data := map[string]interface{}{
+		"job":   job,
+		"stage": stage,
+	}
+	if jobErr != nil {
+		data["error"] = jobErr.Error()
+	}
+
+	events.Publish(events.Event{
+		Type:         events.TypeJobProgress,
+		ProjectID:    projectID,
+		UserID:       userID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Data:         data,
+	})
+}
@@ -11,13 +11,15 @@ import (
 
 // FloatingIPHandler handles floating IP related endpoints
 type FloatingIPHandler struct {
-	Client *client.OpenStackClient
+	Client  *client.OpenStackClient
+	compute *ComputeHandler
 }
 
 // NewFloatingIPHandler creates a new floating IP handler
 func NewFloatingIPHandler(client *client.OpenStackClient) *FloatingIPHandler {
 	return &FloatingIPHandler{
-		Client: client,
+		Client:  client,
+		compute: NewComputeHandler(),
 	}
 }
 
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// floatingIPTargetErrorStatus maps an error finding or updating an instance's
+// floating IP to the status returned to the caller
+func floatingIPTargetErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, openstack.ErrInstanceHasNoPorts):
+		return fiber.StatusNotFound
+	case errors.Is(err, openstack.ErrFixedIPNotFound):
+		return fiber.StatusBadRequest
+	case errors.Is(err, openstack.ErrNoRouterPath):
+		return fiber.StatusConflict
+	default:
+		return instanceActionErrorStatus(err)
+	}
+}
+
+// userNetworkClient creates a network client scoped to the caller's project,
+// so that instance floating IPs are only looked up and changed there
+func (h *FloatingIPHandler) userNetworkClient(c *fiber.Ctx) (*gophercloud.ServiceClient, string, error) {
+	compute := h.compute
+	if compute == nil {
+		compute = NewComputeHandler()
+	}
+
+	provider, err := compute.getProviderFromToken(c)
+	if err != nil {
+		return nil, "", err
+	}
+	networkClient, err := openstack.NewNetworkClient(provider)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	projectID, _ := c.Locals("project_id").(string)
+	return networkClient, projectID, nil
+}
+
+// getProjectFloatingIP gets a floating IP of the caller's project. One in
+// another project is reported as not found.
+func getProjectFloatingIP(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID, floatingIPID string) (*models.FloatingIP, int, error) {
+	floatingIP, err := openstack.GetFloatingIP(ctx, networkClient, floatingIPID)
+	if err != nil {
+		return nil, instanceActionErrorStatus(err), err
+	}
+	if floatingIP.ProjectID != projectID {
+		return nil, fiber.StatusNotFound, errors.New("floating IP not found")
+	}
+	return floatingIP, fiber.StatusOK, nil
+}
+
+// AssociateFloatingIP handles pointing a floating IP at an instance. The
+// instance's address is chosen on a subnet that a router connects to the
+// floating IP's network.
+func (h *FloatingIPHandler) AssociateFloatingIP(c *fiber.Ctx) error {
+	// Get floating IP ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Floating IP ID is required",
+		})
+	}
+
+	// Parse request body
+	var req models.AssociateFloatingIPRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.InstanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Get network client from token
+	networkClient, projectID, err := h.userNetworkClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get the project's floating IP to learn its network
+	ctx := context.Background()
+	floatingIP, status, err := getProjectFloatingIP(ctx, networkClient, projectID, id)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get floating IP: %v", err),
+		})
+	}
+
+	// Find the instance's port on a subnet routed to that network
+	target, err := openstack.ResolveFloatingIPTarget(ctx, networkClient, projectID, req.InstanceID, floatingIP.FloatingNetworkID, req.FixedIP)
+	if err != nil {
+		return c.Status(floatingIPTargetErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to associate floating IP: %v", err),
+		})
+	}
+
+	// Associate floating IP
+	associated, err := openstack.AssociateFloatingIP(ctx, networkClient, floatingIP.ID, target)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Return floating IP
+	return c.JSON(associated)
+}
+
+// DisassociateFloatingIP handles unmapping a floating IP from its instance,
+// keeping it allocated
+func (h *FloatingIPHandler) DisassociateFloatingIP(c *fiber.Ctx) error {
+	// Get floating IP ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Floating IP ID is required",
+		})
+	}
+
+	// Get network client from token
+	networkClient, projectID, err := h.userNetworkClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Check the floating IP belongs to the project
+	ctx := context.Background()
+	floatingIP, status, err := getProjectFloatingIP(ctx, networkClient, projectID, id)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get floating IP: %v", err),
+		})
+	}
+
+	// Disassociate floating IP
+	disassociated, err := openstack.DisassociateFloatingIP(ctx, networkClient, floatingIP.ID)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Return floating IP
+	return c.JSON(disassociated)
+}
+
+// CreateInstancePublicIP handles allocating a floating IP for an instance and
+// associating it in one call
+func (h *FloatingIPHandler) CreateInstancePublicIP(c *fiber.Ctx) error {
+	// Get instance ID
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+
+	// Parse request body; all options are optional
+	var req models.InstancePublicIPRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	// Get network client from token
+	networkClient, projectID, err := h.userNetworkClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Find the instance's port on a subnet routed to an external network
+	ctx := context.Background()
+	target, err := openstack.ResolveFloatingIPTarget(ctx, networkClient, projectID, instanceID, req.FloatingNetworkID, req.FixedIP)
+	if err != nil {
+		return c.Status(floatingIPTargetErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to allocate public IP: %v", err),
+		})
+	}
+
+	// Refuse a second floating IP for the same address
+	existing, err := openstack.ListServerFloatingIPs(ctx, networkClient, projectID, instanceID)
+	if err != nil {
+		return c.Status(floatingIPTargetErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	for _, fip := range existing {
+		if fip.PortID == target.PortID && fip.FixedIP == target.FixedIP {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Instance address %s already has public IP %s", fip.FixedIP, fip.FloatingIP),
+			})
+		}
+	}
+
+	// Allocate and associate floating IP
+	floatingIP, err := openstack.CreateFloatingIPFor(ctx, networkClient, req.Description, target)
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Return floating IP
+	return c.Status(fiber.StatusCreated).JSON(floatingIP)
+}
+
+// DeleteInstancePublicIP handles disassociating an instance's floating IPs;
+// ?release=true also releases them
+func (h *FloatingIPHandler) DeleteInstancePublicIP(c *fiber.Ctx) error {
+	// Get instance ID
+	instanceID := c.Params("id")
+	if instanceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Instance ID is required",
+		})
+	}
+	release := c.QueryBool("release", false)
+
+	// Get network client from token
+	networkClient, projectID, err := h.userNetworkClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get the instance's floating IPs
+	ctx := context.Background()
+	floatingIPs, err := openstack.ListServerFloatingIPs(ctx, networkClient, projectID, instanceID)
+	if err != nil {
+		return c.Status(floatingIPTargetErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if len(floatingIPs) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Instance has no public IP",
+		})
+	}
+
+	// Disassociate or release each of them
+	result := make([]models.FloatingIP, 0, len(floatingIPs))
+	for _, fip := range floatingIPs {
+		if release {
+			if err := openstack.DeleteFloatingIP(ctx, networkClient, fip.ID); err != nil {
+				return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+					Error: err.Error(),
+				})
+			}
+			continue
+		}
+
+		disassociated, err := openstack.DisassociateFloatingIP(ctx, networkClient, fip.ID)
+		if err != nil {
+			return c.Status(instanceActionErrorStatus(err)).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		result = append(result, *disassociated)
+	}
+
+	if release {
+		return c.Status(fiber.StatusNoContent).Send(nil)
+	}
+
+	// Return the disassociated floating IPs
+	return c.JSON(result)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -26,12 +27,10 @@ func NewFlutterwaveHandler(supabaseClient *client.SupabaseClient, flutterwaveCli
 
 // CreatePayment creates a new payment using Flutterwave
 func (h *FlutterwaveHandler) CreatePayment(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Parse request body
@@ -79,7 +78,7 @@ func (h *FlutterwaveHandler) CreatePayment(c *fiber.Ctx) error {
 	}
 
 	// Generate a unique transaction reference
-	txRef := fmt.Sprintf("LSFW-%s-%s", invoice.ID[:8], uuid.New().String()[:8])
+	txRef := flutterwaveTxRefPrefix(invoice) + uuid.New().String()[:8]
 
 	// Create Flutterwave payment request
 	baseURL := c.BaseURL()
@@ -138,12 +137,33 @@ func (h *FlutterwaveHandler) CreatePayment(c *fiber.Ctx) error {
 	})
 }
 
+// flutterwaveTxRefPrefix returns the prefix of every transaction reference
+// generated for an invoice
+func flutterwaveTxRefPrefix(invoice *models.VPSInvoice) string {
+	return fmt.Sprintf("LSFW-%s-", invoice.ID[:8])
+}
+
+// checkFlutterwaveCharge checks that a verified transaction paid an invoice in full
+func checkFlutterwaveCharge(transaction *client.FlutterwaveVerifyTransactionResponse, invoice *models.VPSInvoice) error {
+	switch {
+	case transaction.Data.Status != "successful":
+		return fmt.Errorf("transaction was not successful: %s", transaction.Data.Status)
+	case !strings.HasPrefix(transaction.Data.TxRef, flutterwaveTxRefPrefix(invoice)):
+		return fmt.Errorf("transaction reference %s does not belong to invoice %s", transaction.Data.TxRef, invoice.ID)
+	case !strings.EqualFold(transaction.Data.Currency, invoice.Currency):
+		return fmt.Errorf("transaction currency %s does not match invoice currency %s", transaction.Data.Currency, invoice.Currency)
+	case transaction.Data.Amount < invoice.Amount:
+		return fmt.Errorf("transaction amount %.2f is less than invoice amount %.2f", transaction.Data.Amount, invoice.Amount)
+	}
+	return nil
+}
+
 // HandleWebhook handles Flutterwave webhook events
 func (h *FlutterwaveHandler) HandleWebhook(c *fiber.Ctx) error {
 	// Get signature from header
 	signature := c.Get("verif-hash")
 	if signature == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Missing signature",
 		})
 	}
@@ -151,7 +171,7 @@ func (h *FlutterwaveHandler) HandleWebhook(c *fiber.Ctx) error {
 	// Read request body
 	body := c.Body()
 
-	// Verify webhook signature
+	// Verify webhook signature against FLUTTERWAVE_SECRET_HASH
 	if !h.FlutterwaveClient.VerifyWebhookSignature(signature, body) {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid signature",
@@ -184,6 +204,24 @@ func (h *FlutterwaveHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
+		// Webhooks are retried, so a paid invoice is already done
+		if invoice.Status == "paid" {
+			return c.SendStatus(fiber.StatusOK)
+		}
+
+		// Confirm the charge with Flutterwave rather than trusting the payload
+		transaction, err := h.FlutterwaveClient.VerifyTransaction(fmt.Sprint(event.Data.ID))
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to verify transaction: %v", err),
+			})
+		}
+		if err := checkFlutterwaveCharge(transaction, invoice); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
 		// Update invoice status to paid
 		now := time.Now()
 		invoiceUpdates := map[string]interface{}{
@@ -198,6 +236,8 @@ func (h *FlutterwaveHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
+		publishInvoicePaid(invoice, "flutterwave")
+
 		// Get subscription
 		subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
 		if err != nil {
@@ -264,6 +304,13 @@ func (h *FlutterwaveHandler) VerifyPayment(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check the charge paid this invoice in full
+	if err := checkFlutterwaveCharge(response, invoice); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Update invoice status to paid if not already
 	if invoice.Status != "paid" {
 		now := time.Now()
@@ -279,6 +326,8 @@ func (h *FlutterwaveHandler) VerifyPayment(c *fiber.Ctx) error {
 			})
 		}
 
+		publishInvoicePaid(invoice, "flutterwave")
+
 		// Get subscription
 		subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
 		if err != nil {
@@ -323,12 +372,10 @@ func (h *FlutterwaveHandler) GetPaymentStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Get all invoices for the user
@@ -15,41 +15,19 @@ import (
 )
 
 // ImageHandler handles image related endpoints
-type ImageHandler struct {
-	JWTSecret string
-}
+type ImageHandler struct{}
 
 // NewImageHandler creates a new image handler
-func NewImageHandler(jwtSecret string) *ImageHandler {
-	return &ImageHandler{
-		JWTSecret: jwtSecret,
-	}
+func NewImageHandler() *ImageHandler {
+	return &ImageHandler{}
 }
 
 // getProviderFromToken extracts the project-scoped provider from the JWT token
 func (h *ImageHandler) getProviderFromToken(c *fiber.Ctx) (*gophercloud.ProviderClient, error) {
-	// Get token from Authorization header
-	authHeader := c.Get("Authorization")
-	if authHeader == "" || len(authHeader) < 8 || authHeader[:7] != "Bearer " {
-		return nil, fmt.Errorf("invalid or missing Authorization header")
-	}
-
-	// Parse token
-	tokenString := authHeader[7:]
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(h.JWTSecret), nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("invalid token: %v", err)
-	}
-
-	// Extract claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, fmt.Errorf("invalid token claims")
+	// Get claims verified by the JWT middleware
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok || claims == nil {
+		return nil, fmt.Errorf("invalid or missing token")
 	}
 
 	// Extract username, password, domain, and project ID
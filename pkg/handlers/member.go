@@ -0,0 +1,534 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/mailer"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+	"github.com/lineserve/lineserve-api/pkg/signing"
+)
+
+// invitationTTL is how long a project invitation can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// invitationTokenType marks JWTs that carry a project invitation
+const invitationTokenType = "project_invitation"
+
+// MemberHandler handles project membership and invitations
+type MemberHandler struct {
+	PostgresClient *client.PostgresClient
+	Keys           *signing.KeySet
+	RoleMapping    openstack.ProjectRoleMapping
+
+	// Outgoing email and the frontend base URL for links in it
+	Mailer     mailer.Mailer
+	AppBaseURL string
+}
+
+// NewMemberHandler creates a new member handler
+func NewMemberHandler(postgresClient *client.PostgresClient, keys *signing.KeySet, roleMapping openstack.ProjectRoleMapping) *MemberHandler {
+	return &MemberHandler{
+		PostgresClient: postgresClient,
+		Keys:           keys,
+		RoleMapping:    roleMapping,
+	}
+}
+
+// callerProjectRole returns the caller's role in the token's project, as
+// resolved by the RBAC middleware. Platform admins act as owners.
+func callerProjectRole(c *fiber.Ctx) string {
+	if middleware.IsAdmin(c) {
+		return models.ProjectRoleOwner
+	}
+	role, _ := c.Locals("project_role").(string)
+	return role
+}
+
+// ListMembers lists the members of a project
+func (h *MemberHandler) ListMembers(c *fiber.Ctx) error {
+	// Get members from database
+	members, err := h.PostgresClient.ListProjectMembers(c.Context(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list members: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"members": members,
+	})
+}
+
+// UpdateMemberRole changes the project role of a member
+func (h *MemberHandler) UpdateMemberRole(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Parse request body
+	var req models.UpdateMemberRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate role
+	if !models.IsValidProjectRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Invalid project role: %s", req.Role),
+		})
+	}
+	if req.Role == models.ProjectRoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Use transfer-ownership to make someone the project owner",
+		})
+	}
+
+	// Get the member being changed
+	member, err := h.PostgresClient.GetProjectMember(ctx, projectID, c.Params("user_id"))
+	if errors.Is(err, client.ErrMemberNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Member not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if member.Role == models.ProjectRoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "The owner's role can only change through an ownership transfer",
+		})
+	}
+
+	// Move the Keystone assignment to the role backing the new project role
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	roleID, err := openstack.ChangeProjectRoleAssignment(ctx, adminProvider, h.RoleMapping, member.OpenstackUserID, projectID, member.Role, req.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update role assignment: %v", err),
+		})
+	}
+
+	// Update membership in database
+	if err := h.PostgresClient.UpdateProjectMemberRole(ctx, projectID, member.UserID, roleID, req.Role); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update member: %v", err),
+		})
+	}
+
+	member.Role = req.Role
+	return c.JSON(member)
+}
+
+// RemoveMember removes a member from a project and revokes their Keystone
+// assignment. Members may also remove themselves.
+func (h *MemberHandler) RemoveMember(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Get the member being removed
+	member, err := h.PostgresClient.GetProjectMember(ctx, projectID, c.Params("user_id"))
+	if errors.Is(err, client.ErrMemberNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Member not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Only members with the members permission can remove someone else, and
+	// an API key must grant it too
+	currentUserID, _ := c.Locals("user_id").(string)
+	if member.OpenstackUserID != currentUserID {
+		if !middleware.APIKeyAllows(c, models.PermissionMembersManage) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("This API key does not have the %s permission", models.PermissionMembersManage),
+			})
+		}
+		if !models.ProjectRoleAllows(callerProjectRole(c), models.PermissionMembersManage) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: "Your project role does not allow managing members",
+			})
+		}
+	}
+
+	if member.Role == models.ProjectRoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "The project owner cannot be removed; transfer ownership first",
+		})
+	}
+
+	// Revoke the Keystone assignment backing the member's role
+	roleID, err := h.RoleMapping.KeystoneRoleID(member.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to resolve role: %v", err),
+		})
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	if err := openstack.UnassignRoleFromUserOnProject(ctx, adminProvider, member.OpenstackUserID, projectID, roleID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to revoke role assignment: %v", err),
+		})
+	}
+
+	// Remove membership from database
+	if err := h.PostgresClient.RemoveProjectMember(ctx, projectID, member.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to remove member: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// TransferOwnership makes another member the project owner. The previous
+// owner stays on the project as an admin.
+func (h *MemberHandler) TransferOwnership(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Parse request body
+	var req models.TransferOwnershipRequest
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "user_id is required",
+		})
+	}
+
+	// Find the current owner and the new owner among the members
+	members, err := h.PostgresClient.ListProjectMembers(ctx, projectID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list members: %v", err),
+		})
+	}
+
+	var currentOwner, newOwner *models.ProjectMember
+	for i := range members {
+		if members[i].Role == models.ProjectRoleOwner {
+			currentOwner = &members[i]
+		}
+		if members[i].UserID == req.UserID {
+			newOwner = &members[i]
+		}
+	}
+
+	if newOwner == nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "The new owner must already be a member of the project",
+		})
+	}
+	if currentOwner == nil {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Project has no current owner",
+		})
+	}
+	if currentOwner.UserID == newOwner.UserID {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "User is already the project owner",
+		})
+	}
+
+	// Update Keystone assignments for both users
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	newOwnerRoleID, err := openstack.ChangeProjectRoleAssignment(ctx, adminProvider, h.RoleMapping, newOwner.OpenstackUserID, projectID, newOwner.Role, models.ProjectRoleOwner)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update new owner's role assignment: %v", err),
+		})
+	}
+
+	currentOwnerRoleID, err := openstack.ChangeProjectRoleAssignment(ctx, adminProvider, h.RoleMapping, currentOwner.OpenstackUserID, projectID, currentOwner.Role, models.ProjectRoleAdmin)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update current owner's role assignment: %v", err),
+		})
+	}
+
+	// Swap roles in database
+	if err := h.PostgresClient.TransferProjectOwnership(ctx, projectID, currentOwner.UserID, newOwner.UserID, currentOwnerRoleID, newOwnerRoleID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to transfer ownership: %v", err),
+		})
+	}
+
+	return c.JSON(models.SuccessResponse{
+		Message: fmt.Sprintf("Ownership transferred to %s", newOwner.Email),
+	})
+}
+
+// CreateInvitation invites someone to the project by email
+func (h *MemberHandler) CreateInvitation(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Parse request body
+	var req models.CreateInvitationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate email and role
+	req.Email = strings.TrimSpace(req.Email)
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid email format",
+		})
+	}
+	if req.Role == "" {
+		req.Role = models.ProjectRoleMember
+	}
+	if !models.IsValidProjectRole(req.Role) || req.Role == models.ProjectRoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Invalid invitation role: %s", req.Role),
+		})
+	}
+
+	// Fail early if the role cannot be backed by a Keystone assignment
+	if _, err := h.RoleMapping.KeystoneRoleID(req.Role); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Get the inviting account
+	currentUserID, _ := c.Locals("user_id").(string)
+	inviter, err := h.PostgresClient.GetUserAccountByOpenStackID(ctx, currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Only platform accounts can send invitations",
+		})
+	}
+
+	// Save invitation
+	invitation := &models.ProjectInvitation{
+		ProjectID: projectID,
+		Email:     req.Email,
+		Role:      req.Role,
+		InvitedBy: inviter.ID,
+		ExpiresAt: time.Now().Add(invitationTTL),
+	}
+	if err := h.PostgresClient.CreateProjectInvitation(ctx, invitation); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create invitation: %v", err),
+		})
+	}
+
+	// Sign the acceptance token
+	token, err := h.signInvitationToken(invitation)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to generate invitation token",
+		})
+	}
+
+	h.sendInvitationEmail(invitation, inviter.Name, token)
+
+	return c.Status(fiber.StatusCreated).JSON(models.CreateInvitationResponse{
+		Invitation: *invitation,
+		Token:      token,
+	})
+}
+
+// ListInvitations lists the pending invitations of a project
+func (h *MemberHandler) ListInvitations(c *fiber.Ctx) error {
+	// Get invitations from database
+	invitations, err := h.PostgresClient.ListProjectInvitations(c.Context(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list invitations: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"invitations": invitations,
+	})
+}
+
+// RevokeInvitation revokes a pending invitation
+func (h *MemberHandler) RevokeInvitation(c *fiber.Ctx) error {
+	err := h.PostgresClient.RevokeProjectInvitation(c.Context(), c.Params("id"), c.Params("invitation_id"))
+	if errors.Is(err, client.ErrInvitationNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Pending invitation not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// AcceptInvitation accepts an invitation with its signed token, creating the
+// Keystone assignment and the project membership
+func (h *MemberHandler) AcceptInvitation(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "token is required",
+		})
+	}
+
+	// Verify the token and load the invitation it refers to
+	invitationID, err := h.parseInvitationToken(req.Token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Invalid invitation token: %v", err),
+		})
+	}
+
+	invitation, err := h.PostgresClient.GetProjectInvitation(ctx, invitationID)
+	if err != nil || invitation.Status != "pending" {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Invitation is no longer valid",
+		})
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return c.Status(fiber.StatusGone).JSON(models.ErrorResponse{
+			Error: "Invitation has expired",
+		})
+	}
+
+	// The invitation can only be accepted by the account it was sent to
+	currentUserID, _ := c.Locals("user_id").(string)
+	account, err := h.PostgresClient.GetUserAccountByOpenStackID(ctx, currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Only platform accounts can accept invitations",
+		})
+	}
+	if !strings.EqualFold(account.Email, invitation.Email) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "This invitation was sent to a different email address",
+		})
+	}
+
+	if _, err := h.PostgresClient.GetProjectMember(ctx, invitation.ProjectID, account.ID); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "You are already a member of this project",
+		})
+	}
+
+	// Create the Keystone assignment
+	roleID, err := h.RoleMapping.KeystoneRoleID(invitation.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to resolve role: %v", err),
+		})
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	if err := openstack.AssignRoleToUserOnProject(ctx, adminProvider, account.OpenstackUserID, invitation.ProjectID, roleID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to assign role: %v", err),
+		})
+	}
+
+	// Record the membership, undoing the assignment if that fails
+	if err := h.PostgresClient.AcceptProjectInvitation(ctx, invitation, account.ID, roleID); err != nil {
+		if unassignErr := openstack.UnassignRoleFromUserOnProject(ctx, adminProvider, account.OpenstackUserID, invitation.ProjectID, roleID); unassignErr != nil {
+			fmt.Printf("Warning: Failed to roll back role assignment for %s: %v\n", account.Email, unassignErr)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to accept invitation: %v", err),
+		})
+	}
+
+	return c.JSON(models.Project{
+		ID:   invitation.ProjectID,
+		Role: invitation.Role,
+	})
+}
+
+// signInvitationToken signs a token referring to an invitation
+func (h *MemberHandler) signInvitationToken(invitation *models.ProjectInvitation) (string, error) {
+	return h.Keys.Sign(jwt.MapClaims{
+		"typ":        invitationTokenType,
+		"jti":        invitation.ID,
+		"project_id": invitation.ProjectID,
+		"email":      invitation.Email,
+		"exp":        invitation.ExpiresAt.Unix(),
+	})
+}
+
+// parseInvitationToken verifies an invitation token and returns the invitation ID
+func (h *MemberHandler) parseInvitationToken(tokenString string) (string, error) {
+	claims, err := h.Keys.Parse(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	if claims["typ"] != invitationTokenType {
+		return "", fmt.Errorf("not an invitation token")
+	}
+
+	invitationID, _ := claims["jti"].(string)
+	if invitationID == "" {
+		return "", fmt.Errorf("missing invitation ID")
+	}
+
+	return invitationID, nil
+}
+
+// sendInvitationEmail emails an invitation with a link carrying its acceptance token
+func (h *MemberHandler) sendInvitationEmail(invitation *models.ProjectInvitation, inviterName, token string) {
+	if h.Mailer == nil {
+		fmt.Printf("Sending project invitation for project %s from %s to %s\n", invitation.ProjectID, inviterName, invitation.Email)
+		return
+	}
+
+	link := h.AppBaseURL + "/accept-invitation?token=" + url.QueryEscape(token)
+	mailer.SendAsync(h.Mailer, mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("%s invited you to a project", inviterName),
+		Body: fmt.Sprintf("Hi,\n\n%s invited you to join their project as %s. Sign in or create an account with this email address, then accept the invitation here:\n\n%s\n\nThe invitation expires on %s.\n",
+			inviterName, invitation.Role, link, invitation.ExpiresAt.Format("2 January 2006")),
+	})
+}
@@ -1,36 +1,68 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
 	"github.com/lineserve/lineserve-api/pkg/models"
 )
 
 // MPesaHandler handles M-Pesa-related requests
 type MPesaHandler struct {
 	SupabaseClient *client.SupabaseClient
+	PostgresClient *client.PostgresClient
 	MPesaClient    *client.MPesaClient
+
+	// STK push reconciliation; see ReconcileSTKPushes
+	STKQueryDelay time.Duration
+	STKMaxQueries int
+	STKAttemptTTL time.Duration
 }
 
 // NewMPesaHandler creates a new M-Pesa handler
-func NewMPesaHandler(supabaseClient *client.SupabaseClient, mpesaClient *client.MPesaClient) *MPesaHandler {
+func NewMPesaHandler(supabaseClient *client.SupabaseClient, postgresClient *client.PostgresClient, mpesaClient *client.MPesaClient) *MPesaHandler {
 	return &MPesaHandler{
 		SupabaseClient: supabaseClient,
+		PostgresClient: postgresClient,
 		MPesaClient:    mpesaClient,
 	}
 }
 
+// formatMPesaPhone converts a Kenyan phone number to the 254XXXXXXXXX form M-Pesa expects
+func formatMPesaPhone(phoneNumber string) string {
+	phoneNumber = strings.TrimPrefix(strings.TrimSpace(phoneNumber), "+")
+	if strings.HasPrefix(phoneNumber, "0") {
+		return "254" + phoneNumber[1:]
+	} else if phoneNumber != "" && !strings.HasPrefix(phoneNumber, "254") {
+		return "254" + phoneNumber
+	}
+	return phoneNumber
+}
+
+// MPesaPhoneKey identifies the phone number an STK push request targets, for
+// rate limiting pushes per phone
+func MPesaPhoneKey(c *fiber.Ctx) string {
+	var req models.MPesaSTKPushRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return ""
+	}
+	return formatMPesaPhone(req.PhoneNumber)
+}
+
 // InitiateSTKPush initiates an STK push request to the customer's phone
 func (h *MPesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Parse request body
@@ -77,14 +109,29 @@ func (h *MPesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
 		})
 	}
 
-	// Format phone number (remove leading zero if present and add country code)
-	phoneNumber := req.PhoneNumber
-	if len(phoneNumber) > 0 && phoneNumber[0] == '0' {
-		phoneNumber = "254" + phoneNumber[1:]
-	} else if len(phoneNumber) > 0 && phoneNumber[0:3] != "254" {
-		phoneNumber = "254" + phoneNumber
+	// Don't push again while an earlier push may still be paid
+	if attempt, err := h.PostgresClient.GetLatestSTKAttempt(c.Context(), invoice.ID); err == nil && attempt.Status == models.MPesaSTKStatusPending {
+		status, err := h.reconcileSTKAttempt(c.Context(), invoice, attempt)
+		if err != nil {
+			fmt.Printf("Warning: Failed to query STK push %s: %v\n", attempt.CheckoutRequestID, err)
+		}
+
+		switch {
+		case status == models.MPesaSTKStatusPaid:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invoice is already paid",
+			})
+		case status == models.MPesaSTKStatusPending && (err == nil || time.Since(attempt.CreatedAt) < h.STKQueryDelay):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":               "An earlier STK push for this invoice is still awaiting the customer",
+				"checkout_request_id": attempt.CheckoutRequestID,
+			})
+		}
 	}
 
+	// Format phone number (remove leading zero if present and add country code)
+	phoneNumber := formatMPesaPhone(req.PhoneNumber)
+
 	// Generate timestamp for M-Pesa
 	timestamp := time.Now().Format("20060102150405")
 
@@ -99,11 +146,11 @@ func (h *MPesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
 		Password:          password,
 		Timestamp:         timestamp,
 		TransactionType:   "CustomerPayBillOnline",
-		Amount:            fmt.Sprintf("%.0f", invoice.Amount), // Convert to string without decimal
+		Amount:            fmt.Sprintf("%.0f", mpesaAmountDue(invoice)),
 		PartyA:            phoneNumber,
 		PartyB:            shortCode,
 		PhoneNumber:       phoneNumber,
-		CallBackURL:       fmt.Sprintf("%s/v1/mpesa/callback", c.BaseURL()),
+		CallBackURL:       h.MPesaClient.CallbackURL(c.BaseURL(), "callback"),
 		AccountReference:  invoice.ID[:8], // Use first 8 chars of invoice ID
 		TransactionDesc:   fmt.Sprintf("Payment for VPS plan %s", invoice.PlanCode),
 	}
@@ -127,6 +174,20 @@ func (h *MPesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
 		fmt.Printf("Failed to update invoice with M-Pesa checkout request ID: %v\n", err)
 	}
 
+	// Record the attempt so it can be reconciled if the callback never arrives
+	attempt := &models.MPesaSTKAttempt{
+		InvoiceID:         invoice.ID,
+		UserID:            userID,
+		PhoneNumber:       phoneNumber,
+		Amount:            mpesaAmountDue(invoice),
+		MerchantRequestID: stkResp.MerchantRequestID,
+		CheckoutRequestID: stkResp.CheckoutRequestID,
+	}
+	if err := h.PostgresClient.CreateSTKAttempt(c.Context(), attempt); err != nil {
+		// Log the error but continue
+		fmt.Printf("Failed to record STK push attempt: %v\n", err)
+	}
+
 	// Return response
 	return c.JSON(models.MPesaSTKPushResponse{
 		MerchantRequestID:   stkResp.MerchantRequestID,
@@ -137,6 +198,19 @@ func (h *MPesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
 	})
 }
 
+// VerifyCallback is middleware that rejects M-Pesa callbacks from outside the
+// allowed networks or without the secret callback token in the path
+func (h *MPesaHandler) VerifyCallback(c *fiber.Ctx) error {
+	if !h.MPesaClient.VerifyCallback(middleware.ClientIP(c), c.Params("token")) {
+		fmt.Printf("Warning: Rejected M-Pesa callback to %s from %s\n", c.Route().Path, middleware.ClientIP(c))
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
+	return c.Next()
+}
+
 // HandleSTKPushCallback handles the callback from M-Pesa after STK push
 func (h *MPesaHandler) HandleSTKPushCallback(c *fiber.Ctx) error {
 	// Parse callback body
@@ -151,104 +225,131 @@ func (h *MPesaHandler) HandleSTKPushCallback(c *fiber.Ctx) error {
 	resultCode := callback.Body.StkCallback.ResultCode
 	checkoutRequestID := callback.Body.StkCallback.CheckoutRequestID
 
-	// Get invoice from Supabase by checkout request ID
-	invoice, err := h.SupabaseClient.GetVPSInvoiceByMPesaCheckoutRequestID(checkoutRequestID)
+	// Get the push and its invoice
+	invoice, attempt, err := h.stkPushInvoice(c.Context(), checkoutRequestID)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": fmt.Sprintf("Invoice not found: %v", err),
 		})
 	}
 
+	// Extract payment details from callback
+	var mpesaReceiptNumber string
+	var phoneNumber string
+	for _, item := range callback.Body.StkCallback.CallbackMetadata.Item {
+		switch item.Name {
+		case "MpesaReceiptNumber":
+			mpesaReceiptNumber = fmt.Sprintf("%v", item.Value)
+		case "PhoneNumber":
+			phoneNumber = fmt.Sprintf("%v", item.Value)
+		}
+	}
+
+	// Apply the outcome
+	if err := h.completeSTKPush(c.Context(), invoice, attempt, resultCode, callback.Body.StkCallback.ResultDesc, mpesaReceiptNumber, phoneNumber); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to record payment: %v", err),
+		})
+	}
+
 	// Check if payment was successful
+	if resultCode != 0 {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"ResultCode": 1,
+			"ResultDesc": "Rejected",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	})
+}
+
+// completeSTKPush applies the outcome of an STK push, whether it came from
+// the callback or a status query: on success the invoice is marked paid and
+// its subscription activated, otherwise the invoice is marked failed. A paid
+// invoice is never undone, and only the invoice's latest push can fail it.
+// attempt is nil for pushes sent before attempts were recorded.
+func (h *MPesaHandler) completeSTKPush(ctx context.Context, invoice *models.VPSInvoice, attempt *models.MPesaSTKAttempt, resultCode int, resultDesc, receiptNo, phoneNumber string) error {
+	status := models.MPesaSTKStatusPaid
 	if resultCode == 0 {
-		// Extract payment details from callback
-		var mpesaReceiptNumber string
-		var phoneNumber string
-		var amount float64
-
-		for _, item := range callback.Body.StkCallback.CallbackMetadata.Item {
-			switch item.Name {
-			case "MpesaReceiptNumber":
-				mpesaReceiptNumber = item.Value.(string)
-			case "PhoneNumber":
-				phoneNumber = fmt.Sprintf("%v", item.Value)
-			case "Amount":
-				switch v := item.Value.(type) {
-				case float64:
-					amount = v
-				case string:
-					fmt.Sscanf(v, "%f", &amount)
-				}
+		if invoice.Status != "paid" {
+			if err := h.markInvoicePaid(invoice, receiptNo, phoneNumber); err != nil {
+				return err
 			}
 		}
-
-		// Update invoice status to paid
-		now := time.Now()
-		invoiceUpdates := map[string]interface{}{
-			"status":             "paid",
-			"mpesa_receipt_no":   mpesaReceiptNumber,
-			"mpesa_phone_number": phoneNumber,
-			"paid_at":            now,
-		}
-		_, err = h.SupabaseClient.UpdateVPSInvoice(invoice.ID, invoiceUpdates)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to update invoice: %v", err),
-			})
+	} else {
+		status = models.MPesaSTKStatusFailed
+		if invoice.Status != "paid" && (attempt == nil || attempt.CheckoutRequestID == invoice.MPesaCheckoutRequestID) {
+			// Update invoice status to failed
+			updates := map[string]interface{}{
+				"status": "failed",
+			}
+			if _, err := h.SupabaseClient.UpdateVPSInvoice(invoice.ID, updates); err != nil {
+				// Log the error but continue
+				fmt.Printf("Failed to update invoice status: %v\n", err)
+			}
 		}
+	}
 
-		// Get subscription
-		subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to get subscription: %v", err),
-			})
+	// Record the outcome on the attempt
+	if attempt != nil {
+		if _, err := h.PostgresClient.CompleteSTKAttempt(ctx, attempt.ID, status, strconv.Itoa(resultCode), resultDesc, receiptNo); err != nil {
+			fmt.Printf("Warning: Failed to record outcome of STK push %s: %v\n", attempt.CheckoutRequestID, err)
 		}
+	}
 
-		// Update subscription status to active
-		subscriptionUpdates := map[string]interface{}{
-			"status":     "active",
-			"start_date": now,
-		}
-		_, err = h.SupabaseClient.UpdateVPSSubscription(subscription.ID, subscriptionUpdates)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to update subscription: %v", err),
-			})
-		}
+	return nil
+}
 
-		// Return success
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"ResultCode": 0,
-			"ResultDesc": "Accepted",
-		})
-	} else {
-		// Update invoice status to failed
-		updates := map[string]interface{}{
-			"status": "failed",
-		}
-		_, err = h.SupabaseClient.UpdateVPSInvoice(invoice.ID, updates)
-		if err != nil {
-			// Log the error but continue
-			fmt.Printf("Failed to update invoice status: %v\n", err)
-		}
+// markInvoicePaid records an M-Pesa payment on an invoice and activates the
+// invoice's subscription
+func (h *MPesaHandler) markInvoicePaid(invoice *models.VPSInvoice, receiptNo, phoneNumber string) error {
+	// Update invoice status to paid
+	now := time.Now()
+	invoiceUpdates := map[string]interface{}{
+		"status":            "paid",
+		"payment_method_id": "mpesa",
+		"paid_at":           now,
+	}
+	// Status queries do not report the receipt or phone
+	if receiptNo != "" {
+		invoiceUpdates["mpesa_receipt_no"] = receiptNo
+	}
+	if phoneNumber != "" {
+		invoiceUpdates["mpesa_phone_number"] = phoneNumber
+	}
+	if _, err := h.SupabaseClient.UpdateVPSInvoice(invoice.ID, invoiceUpdates); err != nil {
+		return fmt.Errorf("failed to update invoice: %v", err)
+	}
 
-		// Return failure
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"ResultCode": 1,
-			"ResultDesc": "Rejected",
-		})
+	publishInvoicePaid(invoice, "mpesa")
+
+	// Get subscription
+	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %v", err)
+	}
+
+	// Update subscription status to active
+	subscriptionUpdates := map[string]interface{}{
+		"status":     "active",
+		"start_date": now,
+	}
+	if _, err := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, subscriptionUpdates); err != nil {
+		return fmt.Errorf("failed to update subscription: %v", err)
 	}
+
+	return nil
 }
 
 // CheckSTKPushStatus checks the status of an STK push transaction
 func (h *MPesaHandler) CheckSTKPushStatus(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Parse request body
@@ -266,8 +367,8 @@ func (h *MPesaHandler) CheckSTKPushStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get invoice from Supabase by checkout request ID
-	invoice, err := h.SupabaseClient.GetVPSInvoiceByMPesaCheckoutRequestID(req.CheckoutRequestID)
+	// Get the push and its invoice
+	invoice, attempt, err := h.stkPushInvoice(c.Context(), req.CheckoutRequestID)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": fmt.Sprintf("Invoice not found: %v", err),
@@ -291,12 +392,29 @@ func (h *MPesaHandler) CheckSTKPushStatus(c *fiber.Ctx) error {
 
 	// Query STK push status
 	statusResp, err := h.MPesaClient.QuerySTKPushStatus(shortCode, password, timestamp, req.CheckoutRequestID)
-	if err != nil {
+	if errors.Is(err, client.ErrSTKPushProcessing) {
+		return c.JSON(models.MPesaSTKPushStatusResponse{
+			CheckoutRequestID:   req.CheckoutRequestID,
+			ResponseDescription: "The transaction is being processed",
+			Status:              models.MPesaSTKStatusPending,
+		})
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to check STK push status: %v", err),
 		})
 	}
 
+	// Apply the outcome as the callback would
+	var status string
+	if attempt != nil {
+		status = attempt.Status
+		if status == models.MPesaSTKStatusPending {
+			if status, err = h.applySTKQueryResult(c.Context(), invoice, attempt, statusResp); err != nil {
+				fmt.Printf("Warning: Failed to apply STK push status: %v\n", err)
+			}
+		}
+	}
+
 	// Return response
 	return c.JSON(models.MPesaSTKPushStatusResponse{
 		ResponseCode:        statusResp.ResponseCode,
@@ -304,13 +422,63 @@ func (h *MPesaHandler) CheckSTKPushStatus(c *fiber.Ctx) error {
 		MerchantRequestID:   statusResp.MerchantRequestID,
 		CheckoutRequestID:   statusResp.CheckoutRequestID,
 		CustomerMessage:     statusResp.CustomerMessage,
+		ResultCode:          statusResp.ResultCode,
+		ResultDesc:          statusResp.ResultDesc,
+		Status:              status,
 	})
 }
 
-// RegisterRoutes registers the M-Pesa routes
-func (h *MPesaHandler) RegisterRoutes(app *fiber.App) {
-	mpesa := app.Group("/v1/mpesa")
-	mpesa.Post("/stk-push", h.InitiateSTKPush)
-	mpesa.Post("/callback", h.HandleSTKPushCallback)
-	mpesa.Post("/check-status", h.CheckSTKPushStatus)
+// ListSTKAttempts lists the STK pushes sent for an invoice
+func (h *MPesaHandler) ListSTKAttempts(c *fiber.Ctx) error {
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get invoice from Supabase
+	invoice, err := h.SupabaseClient.GetVPSInvoiceByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invoice not found: %v", err),
+		})
+	}
+
+	// Check if invoice belongs to user
+	if invoice.UserID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You do not have permission to view this invoice",
+		})
+	}
+
+	attempts, err := h.PostgresClient.ListSTKAttempts(c.Context(), invoice.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to list STK push attempts: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"attempts": attempts,
+	})
+}
+
+// stkPushInvoice gets an STK push attempt and its invoice by checkout request
+// ID. Pushes sent before attempts were recorded have no attempt and are found
+// by their invoice's latest checkout request ID.
+func (h *MPesaHandler) stkPushInvoice(ctx context.Context, checkoutRequestID string) (*models.VPSInvoice, *models.MPesaSTKAttempt, error) {
+	attempt, err := h.PostgresClient.GetSTKAttemptByCheckoutRequestID(ctx, checkoutRequestID)
+	if errors.Is(err, client.ErrSTKAttemptNotFound) {
+		invoice, err := h.SupabaseClient.GetVPSInvoiceByMPesaCheckoutRequestID(checkoutRequestID)
+		return invoice, nil, err
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	invoice, err := h.SupabaseClient.GetVPSInvoiceByID(attempt.InvoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return invoice, attempt, nil
 }
@@ -0,0 +1,584 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// paybillCurrency is the currency Paybill payments arrive and are credited in
+const paybillCurrency = "KES"
+
+// paybillAccountPrefix starts every customer Paybill account number
+const paybillAccountPrefix = "LS"
+
+// paybillAccountNumber returns the Paybill account number of a billing
+// account: LS followed by the first 8 characters of its Supabase user ID
+func paybillAccountNumber(userID string) string {
+	id := strings.ToUpper(strings.ReplaceAll(userID, "-", ""))
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return paybillAccountPrefix + id
+}
+
+// isHexPrefix reports whether s is an 8-character hex ID prefix
+func isHexPrefix(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// mpesaAmountDue is what M-Pesa must collect for an invoice. M-Pesa takes
+// whole shillings, so invoices are charged their amount rounded, as STK
+// pushes do.
+func mpesaAmountDue(invoice *models.VPSInvoice) float64 {
+	return math.Round(invoice.Amount)
+}
+
+// c2bMatch is what a Paybill account number refers to: an invoice, or a
+// customer account when only userID is set
+type c2bMatch struct {
+	invoice *models.VPSInvoice
+	userID  string
+}
+
+// found reports whether the account number matched anything
+func (m c2bMatch) found() bool {
+	return m.invoice != nil || m.userID != ""
+}
+
+// matchBillRef resolves the account number a customer entered to an invoice,
+// by ID or 8-character invoice number, or to a customer account by Paybill
+// account number. When it matches nothing the note says why.
+func (h *MPesaHandler) matchBillRef(billRef string) (c2bMatch, string, error) {
+	ref := strings.TrimSpace(billRef)
+
+	// Full invoice ID
+	if _, err := uuid.Parse(ref); err == nil {
+		invoice, err := h.SupabaseClient.GetVPSInvoiceByID(strings.ToLower(ref))
+		if err != nil {
+			return c2bMatch{}, fmt.Sprintf("no invoice %s: %v", ref, err), nil
+		}
+		return c2bMatch{invoice: invoice, userID: invoice.UserID}, "", nil
+	}
+
+	// Customers add spaces, dashes and hashes to what they type
+	compact := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "#", "").Replace(ref))
+
+	switch {
+	case strings.HasPrefix(compact, paybillAccountPrefix) && isHexPrefix(compact[len(paybillAccountPrefix):]):
+		users, err := h.SupabaseClient.GetLineserveCloudUsersByIDPrefix(compact[len(paybillAccountPrefix):])
+		if err != nil {
+			return c2bMatch{}, "", fmt.Errorf("failed to look up account %s: %v", compact, err)
+		}
+		if len(users) != 1 {
+			return c2bMatch{}, fmt.Sprintf("account number %s matches %d accounts", compact, len(users)), nil
+		}
+		return c2bMatch{userID: users[0].ID}, "", nil
+
+	case isHexPrefix(compact):
+		invoices, err := h.SupabaseClient.GetVPSInvoicesByIDPrefix(compact)
+		if err != nil {
+			return c2bMatch{}, "", fmt.Errorf("failed to look up invoice %s: %v", compact, err)
+		}
+		if len(invoices) != 1 {
+			return c2bMatch{}, fmt.Sprintf("invoice number %s matches %d invoices", compact, len(invoices)), nil
+		}
+		return c2bMatch{invoice: &invoices[0], userID: invoices[0].UserID}, "", nil
+	}
+
+	return c2bMatch{}, fmt.Sprintf("account number %q is not an invoice or account number", billRef), nil
+}
+
+// invoiceSettleable reports whether an invoice can still be paid
+func invoiceSettleable(invoice *models.VPSInvoice) bool {
+	return invoice.Status != "paid" && invoice.Status != "expired" && invoice.ExpiresAt.After(time.Now())
+}
+
+// invoiceInPaybillCurrency reports whether an invoice is billed in the
+// currency Paybill credit is held in. Credit is never applied across
+// currencies.
+func invoiceInPaybillCurrency(invoice *models.VPSInvoice) bool {
+	return strings.EqualFold(invoice.Currency, paybillCurrency)
+}
+
+// unpaidInvoices lists an account's payable invoices in the Paybill
+// currency, oldest first
+func (h *MPesaHandler) unpaidInvoices(userID string) ([]models.VPSInvoice, error) {
+	invoices, err := h.SupabaseClient.GetVPSInvoicesByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Invoices come newest first
+	var unpaid []models.VPSInvoice
+	for i := len(invoices) - 1; i >= 0; i-- {
+		if invoiceSettleable(&invoices[i]) && invoiceInPaybillCurrency(&invoices[i]) {
+			unpaid = append(unpaid, invoices[i])
+		}
+	}
+
+	return unpaid, nil
+}
+
+// applyC2BPayment books a Paybill payment to the matched account's credit
+// and settles what the credit covers: the matched invoice, or the account's
+// oldest unpaid invoices. Only invoices billed in the Paybill currency are
+// settled. Overpayments and underpayments stay as credit.
+// Every booking is keyed by transaction or invoice, so applying a payment
+// again does not double count it.
+func (h *MPesaHandler) applyC2BPayment(ctx context.Context, payment *models.MPesaC2BPayment, match c2bMatch) (string, string, error) {
+	// Book the payment as credit
+	_, err := h.PostgresClient.CreditAccount(ctx, &models.CreditTransaction{
+		UserID:      match.userID,
+		Currency:    paybillCurrency,
+		Amount:      payment.Amount,
+		Kind:        "mpesa_c2b",
+		Reference:   "mpesa-c2b:" + payment.TransID,
+		Description: fmt.Sprintf("M-Pesa payment %s from %s", payment.TransID, payment.MSISDN),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	// Choose the invoices to settle
+	var invoices []models.VPSInvoice
+	if match.invoice != nil {
+		if !invoiceSettleable(match.invoice) {
+			return models.MPesaC2BStatusCredited, fmt.Sprintf("invoice %s is %s; held as account credit", match.invoice.ID[:8], match.invoice.Status), nil
+		}
+		if !invoiceInPaybillCurrency(match.invoice) {
+			return models.MPesaC2BStatusCredited, fmt.Sprintf("invoice %s is billed in %s, not %s; held as account credit", match.invoice.ID[:8], match.invoice.Currency, paybillCurrency), nil
+		}
+		invoices = []models.VPSInvoice{*match.invoice}
+	} else {
+		invoices, err = h.unpaidInvoices(match.userID)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get invoices: %v", err)
+		}
+	}
+
+	// Settle invoices from credit
+	status := models.MPesaC2BStatusCredited
+	note := "held as account credit"
+	for i := range invoices {
+		invoice := &invoices[i]
+		due := mpesaAmountDue(invoice)
+
+		// An invoice debited earlier but not marked paid is marked paid now
+		_, err := h.PostgresClient.DebitAccount(ctx, &models.CreditTransaction{
+			UserID:      match.userID,
+			Currency:    paybillCurrency,
+			Amount:      due,
+			Kind:        "invoice",
+			Reference:   "invoice:" + invoice.ID,
+			Description: fmt.Sprintf("VPS invoice %s", invoice.ID[:8]),
+		})
+		if errors.Is(err, client.ErrInsufficientCredit) {
+			if match.invoice != nil {
+				balance, _ := h.PostgresClient.GetCreditBalance(ctx, match.userID, paybillCurrency)
+				note = fmt.Sprintf("invoice %s underpaid by %s %.2f; held as account credit", invoice.ID[:8], paybillCurrency, due-balance)
+			}
+			break
+		} else if err != nil {
+			return "", "", err
+		}
+
+		if err := h.markInvoicePaid(invoice, payment.TransID, payment.MSISDN); err != nil {
+			return "", "", err
+		}
+		status, note = models.MPesaC2BStatusApplied, ""
+	}
+
+	return status, note, nil
+}
+
+// settleC2BPayment applies a payment to what it matched and records the
+// outcome. Payments that match nothing or cannot be applied are queued for
+// admin review. resolvedBy is the admin who matched the payment, if any.
+func (h *MPesaHandler) settleC2BPayment(ctx context.Context, payment *models.MPesaC2BPayment, match c2bMatch, note, resolvedBy string) error {
+	status := models.MPesaC2BStatusUnmatched
+	if match.found() {
+		applied, appliedNote, err := h.applyC2BPayment(ctx, payment, match)
+		if err != nil {
+			fmt.Printf("Warning: Failed to apply M-Pesa payment %s: %v\n", payment.TransID, err)
+			note = fmt.Sprintf("could not be applied: %v", err)
+		} else {
+			status, note = applied, appliedNote
+		}
+	}
+
+	var invoiceID string
+	if match.invoice != nil {
+		invoiceID = match.invoice.ID
+	}
+
+	if err := h.PostgresClient.UpdateMPesaC2BPayment(ctx, payment.ID, status, invoiceID, match.userID, note, resolvedBy); err != nil {
+		return err
+	}
+
+	payment.Status, payment.InvoiceID, payment.UserID, payment.Note = status, invoiceID, match.userID, note
+	return nil
+}
+
+// c2bResult responds to a C2B validation or confirmation
+func c2bResult(c *fiber.Ctx, code, description string) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"ResultCode": code,
+		"ResultDesc": description,
+	})
+}
+
+// HandleC2BValidation decides whether M-Pesa should accept a Paybill payment.
+// Payments are accepted so that unmatched ones reach the review queue, unless
+// MPESA_C2B_REJECT_UNMATCHED is set.
+func (h *MPesaHandler) HandleC2BValidation(c *fiber.Ctx) error {
+	// Parse callback body
+	var callback client.C2BCallback
+	if err := c.BodyParser(&callback); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid callback body: %v", err),
+		})
+	}
+
+	// Check the amount
+	amount, err := strconv.ParseFloat(callback.TransAmount, 64)
+	if err != nil || amount <= 0 {
+		return c2bResult(c, client.C2BResultInvalidAmount, "Rejected")
+	}
+
+	// Check the account number
+	if h.MPesaClient.C2BRejectUnmatched {
+		match, _, err := h.matchBillRef(callback.BillRefNumber)
+		if err != nil {
+			fmt.Printf("Warning: Failed to validate M-Pesa account number %q: %v\n", callback.BillRefNumber, err)
+		} else if !match.found() {
+			return c2bResult(c, client.C2BResultInvalidAccountNumber, "Rejected")
+		}
+	}
+
+	return c2bResult(c, client.C2BResultAccepted, "Accepted")
+}
+
+// HandleC2BConfirmation records a completed Paybill payment and applies it
+// to the invoice or account its account number refers to
+func (h *MPesaHandler) HandleC2BConfirmation(c *fiber.Ctx) error {
+	// Parse callback body
+	var callback client.C2BCallback
+	if err := c.BodyParser(&callback); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid callback body: %v", err),
+		})
+	}
+
+	amount, err := strconv.ParseFloat(callback.TransAmount, 64)
+	if err != nil || callback.TransID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid payment details",
+		})
+	}
+
+	// Record the payment; confirmations may be repeated
+	payment, created, err := h.PostgresClient.RecordMPesaC2BPayment(c.Context(), &models.MPesaC2BPayment{
+		TransID:           callback.TransID,
+		TransType:         callback.TransactionType,
+		TransTime:         callback.TransTime,
+		Amount:            amount,
+		BusinessShortCode: callback.BusinessShortCode,
+		BillRefNumber:     callback.BillRefNumber,
+		MSISDN:            callback.MSISDN,
+		PayerName:         strings.Join(strings.Fields(callback.FirstName+" "+callback.MiddleName+" "+callback.LastName), " "),
+	}, c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to record payment: %v", err),
+		})
+	}
+	if !created && payment.Status != models.MPesaC2BStatusReceived {
+		return c2bResult(c, client.C2BResultAccepted, "Accepted")
+	}
+
+	// Match and apply the payment
+	match, note, err := h.matchBillRef(payment.BillRefNumber)
+	if err != nil {
+		note = err.Error()
+	}
+	if err := h.settleC2BPayment(c.Context(), payment, match, note, ""); err != nil {
+		fmt.Printf("Warning: Failed to record outcome of M-Pesa payment %s: %v\n", payment.TransID, err)
+	}
+
+	return c2bResult(c, client.C2BResultAccepted, "Accepted")
+}
+
+// GetPaybillDetails returns the Paybill number and account number the
+// authenticated user pays into, with their credit balance and ledger
+func (h *MPesaHandler) GetPaybillDetails(c *fiber.Ctx) error {
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get credit balance and ledger
+	balance, err := h.PostgresClient.GetCreditBalance(c.Context(), userID, paybillCurrency)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get credit balance: %v", err),
+		})
+	}
+
+	limit, offset := parsePagination(c)
+	transactions, err := h.PostgresClient.ListCreditTransactions(c.Context(), userID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get credit transactions: %v", err),
+		})
+	}
+
+	return c.JSON(models.PaybillDetailsResponse{
+		Paybill:       h.MPesaClient.C2BShortCode,
+		AccountNumber: paybillAccountNumber(userID),
+		Currency:      paybillCurrency,
+		Balance:       balance,
+		Transactions:  transactions,
+	})
+}
+
+// ListC2BPayments lists Paybill payments for admins. It shows the review
+// queue of unmatched payments unless another status, or "all", is asked for.
+func (h *MPesaHandler) ListC2BPayments(c *fiber.Ctx) error {
+	limit, offset := parsePagination(c)
+
+	status := c.Query("status", models.MPesaC2BStatusUnmatched)
+	if status == "all" {
+		status = ""
+	}
+
+	// Get payments from database
+	payments, err := h.PostgresClient.ListMPesaC2BPayments(c.Context(), status, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to list payments: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "mpesa_c2b.list", "", "", map[string]interface{}{"status": status})
+
+	return c.JSON(fiber.Map{
+		"payments": payments,
+	})
+}
+
+// GetC2BPayment gets a single Paybill payment for admins
+func (h *MPesaHandler) GetC2BPayment(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	payment, err := h.PostgresClient.GetMPesaC2BPayment(c.Context(), id)
+	if err != nil {
+		return c2bPaymentError(c, err)
+	}
+
+	middleware.SetAuditAction(c, "mpesa_c2b.view", "mpesa_c2b_payment", id, nil)
+
+	return c.JSON(payment)
+}
+
+// ResolveC2BPayment lets an admin apply an unmatched Paybill payment to an
+// invoice or account, or dismiss it once it has been settled some other way,
+// such as a refund
+func (h *MPesaHandler) ResolveC2BPayment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.MPesaC2BResolveRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
+
+	payment, err := h.PostgresClient.GetMPesaC2BPayment(ctx, id)
+	if err != nil {
+		return c2bPaymentError(c, err)
+	}
+
+	// Only payments that were not applied can be resolved
+	if payment.Status != models.MPesaC2BStatusUnmatched && payment.Status != models.MPesaC2BStatusReceived {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Payment is already %s", payment.Status),
+		})
+	}
+
+	adminID, _ := c.Locals("user_id").(string)
+
+	// Find what the payment is for
+	var match c2bMatch
+	switch {
+	case req.Dismiss:
+		if req.Note == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A note is required to dismiss a payment",
+			})
+		}
+		if err := h.PostgresClient.UpdateMPesaC2BPayment(ctx, id, models.MPesaC2BStatusDismissed, "", "", req.Note, adminID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to dismiss payment: %v", err),
+			})
+		}
+		middleware.SetAuditAction(c, "mpesa_c2b.dismiss", "mpesa_c2b_payment", id, map[string]interface{}{"note": req.Note})
+		payment.Status, payment.Note = models.MPesaC2BStatusDismissed, req.Note
+		return c.JSON(payment)
+
+	case req.InvoiceID != "":
+		invoice, err := h.SupabaseClient.GetVPSInvoiceByID(req.InvoiceID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invoice not found: %v", err),
+			})
+		}
+		match = c2bMatch{invoice: invoice, userID: invoice.UserID}
+
+	case req.UserID != "":
+		user, err := h.SupabaseClient.GetLineserveCloudUserByID(req.UserID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": fmt.Sprintf("User not found: %v", err),
+			})
+		}
+		match = c2bMatch{userID: user.ID}
+
+	case req.AccountNumber != "":
+		var note string
+		match, note, err = h.matchBillRef(req.AccountNumber)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if !match.found() {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": note,
+			})
+		}
+
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "One of invoice_id, user_id, account_number or dismiss is required",
+		})
+	}
+
+	middleware.SetAuditAction(c, "mpesa_c2b.resolve", "mpesa_c2b_payment", id, map[string]interface{}{
+		"invoice_id": req.InvoiceID,
+		"user_id":    match.userID,
+		"note":       req.Note,
+	})
+
+	// Apply the payment
+	if err := h.settleC2BPayment(ctx, payment, match, req.Note, adminID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to resolve payment: %v", err),
+		})
+	}
+	if payment.Status == models.MPesaC2BStatusUnmatched {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Payment %s", payment.Note),
+		})
+	}
+
+	return c.JSON(payment)
+}
+
+// RegisterC2BURLs registers the Paybill validation and confirmation URLs of
+// this API with M-Pesa
+func (h *MPesaHandler) RegisterC2BURLs(c *fiber.Ctx) error {
+	// Accept payments if the validation URL cannot be reached
+	resp, err := h.MPesaClient.RegisterC2BURL(client.C2BRegisterURLRequest{
+		ShortCode:       h.MPesaClient.C2BShortCode,
+		ResponseType:    "Completed",
+		ConfirmationURL: h.MPesaClient.PaybillURL(c.BaseURL(), "confirmation"),
+		ValidationURL:   h.MPesaClient.PaybillURL(c.BaseURL(), "validation"),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to register C2B URLs: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "mpesa_c2b.register_urls", "", "", map[string]interface{}{"short_code": h.MPesaClient.C2BShortCode})
+
+	return c.JSON(resp)
+}
+
+// SimulateC2BPayment has the M-Pesa sandbox make a Paybill payment, which
+// then arrives at the confirmation URL like a real one
+func (h *MPesaHandler) SimulateC2BPayment(c *fiber.Ctx) error {
+	if !h.MPesaClient.IsSandbox {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "C2B simulation is only available in the M-Pesa sandbox",
+		})
+	}
+
+	// Parse request body
+	var req models.MPesaC2BSimulateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
+
+	if req.Amount < 1 || req.PhoneNumber == "" || req.BillRefNumber == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Amount, phone number and bill reference number are required",
+		})
+	}
+
+	resp, err := h.MPesaClient.SimulateC2B(client.C2BSimulateRequest{
+		ShortCode:     h.MPesaClient.C2BShortCode,
+		CommandID:     "CustomerPayBillOnline",
+		Amount:        fmt.Sprintf("%.0f", req.Amount),
+		Msisdn:        formatMPesaPhone(req.PhoneNumber),
+		BillRefNumber: req.BillRefNumber,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to simulate C2B payment: %v", err),
+		})
+	}
+
+	middleware.SetAuditAction(c, "mpesa_c2b.simulate", "", "", map[string]interface{}{
+		"amount":          req.Amount,
+		"bill_ref_number": req.BillRefNumber,
+	})
+
+	return c.JSON(resp)
+}
+
+// c2bPaymentError responds to a C2B payment lookup error
+func c2bPaymentError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, client.ErrC2BPaymentNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fmt.Sprintf("Failed to get payment: %v", err),
+	})
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// stkResultProcessing is the result code of a queried STK push the customer
+// has not yet responded to
+const stkResultProcessing = 4999
+
+// stkReconcileBatchSize bounds the pushes looked at in one reconciliation run
+const stkReconcileBatchSize = 100
+
+// reconcileSTKAttempt queries the outcome of a pending STK push and applies
+// it. It returns the attempt's status, which stays pending while the customer
+// has not responded.
+func (h *MPesaHandler) reconcileSTKAttempt(ctx context.Context, invoice *models.VPSInvoice, attempt *models.MPesaSTKAttempt) (string, error) {
+	// Generate timestamp and password for M-Pesa
+	timestamp := time.Now().Format("20060102150405")
+	shortCode := h.MPesaClient.GetBusinessShortCode()
+	password := h.MPesaClient.GeneratePassword(shortCode, h.MPesaClient.GetPassKey(), timestamp)
+
+	// Query STK push status
+	resp, err := h.MPesaClient.QuerySTKPushStatus(shortCode, password, timestamp, attempt.CheckoutRequestID)
+	if recordErr := h.PostgresClient.RecordSTKAttemptQuery(ctx, attempt.ID, ""); recordErr != nil {
+		fmt.Printf("Warning: %v\n", recordErr)
+	}
+	if errors.Is(err, client.ErrSTKPushProcessing) {
+		return models.MPesaSTKStatusPending, nil
+	} else if err != nil {
+		return models.MPesaSTKStatusPending, err
+	}
+
+	return h.applySTKQueryResult(ctx, invoice, attempt, resp)
+}
+
+// applySTKQueryResult applies the outcome reported by an STK push query and
+// returns the attempt's status
+func (h *MPesaHandler) applySTKQueryResult(ctx context.Context, invoice *models.VPSInvoice, attempt *models.MPesaSTKAttempt, resp *client.MPesaResponse) (string, error) {
+	if resp.ResultCode == "" {
+		return models.MPesaSTKStatusPending, nil
+	}
+
+	resultCode, err := strconv.Atoi(resp.ResultCode)
+	if err != nil {
+		return models.MPesaSTKStatusPending, fmt.Errorf("unexpected STK push result code %q", resp.ResultCode)
+	}
+	if resultCode == stkResultProcessing {
+		return models.MPesaSTKStatusPending, nil
+	}
+
+	if err := h.completeSTKPush(ctx, invoice, attempt, resultCode, resp.ResultDesc, "", attempt.PhoneNumber); err != nil {
+		return models.MPesaSTKStatusPending, err
+	}
+
+	if resultCode == 0 {
+		return models.MPesaSTKStatusPaid, nil
+	}
+	return models.MPesaSTKStatusFailed, nil
+}
+
+// ReconcileSTKPushes settles STK pushes whose callbacks have not arrived.
+// Pushes older than STKQueryDelay are queried, up to STKMaxQueries times.
+// After that a transaction status query is sent, when one is configured,
+// whose result arrives at the transaction status result URL. Pushes still
+// unsettled after STKAttemptTTL are marked expired. It returns how many
+// pushes were settled.
+func (h *MPesaHandler) ReconcileSTKPushes(ctx context.Context) (int, error) {
+	attempts, err := h.PostgresClient.ListPendingSTKAttempts(ctx, time.Now().Add(-h.STKQueryDelay), stkReconcileBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for i := range attempts {
+		attempt := &attempts[i]
+
+		// Give up on pushes whose outcome never became known
+		if time.Since(attempt.CreatedAt) > h.STKAttemptTTL {
+			if _, err := h.PostgresClient.CompleteSTKAttempt(ctx, attempt.ID, models.MPesaSTKStatusExpired, "", "No outcome received", ""); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+			continue
+		}
+
+		invoice, err := h.SupabaseClient.GetVPSInvoiceByID(attempt.InvoiceID)
+		if err != nil {
+			fmt.Printf("Warning: Failed to get invoice %s of STK push %s: %v\n", attempt.InvoiceID, attempt.CheckoutRequestID, err)
+			continue
+		}
+
+		// Query the push
+		if attempt.Queries < h.STKMaxQueries {
+			status, err := h.reconcileSTKAttempt(ctx, invoice, attempt)
+			if err != nil {
+				fmt.Printf("Warning: Failed to query STK push %s: %v\n", attempt.CheckoutRequestID, err)
+			} else if status != models.MPesaSTKStatusPending {
+				settled++
+			}
+			continue
+		}
+
+		// Fall back to a transaction status query, once
+		if attempt.StatusConversationID == "" && h.MPesaClient.CanQueryTransactionStatus() {
+			if err := h.queryTransactionStatus(ctx, attempt); err != nil {
+				fmt.Printf("Warning: Failed to query transaction status of STK push %s: %v\n", attempt.CheckoutRequestID, err)
+			}
+		}
+	}
+
+	return settled, nil
+}
+
+// queryTransactionStatus asks M-Pesa for the status of an STK push's
+// transaction. Without a receipt number the push is identified by its
+// checkout request ID.
+func (h *MPesaHandler) queryTransactionStatus(ctx context.Context, attempt *models.MPesaSTKAttempt) error {
+	resp, err := h.MPesaClient.TransactionStatus(client.TransactionStatusRequest{
+		Initiator:              h.MPesaClient.Initiator,
+		SecurityCredential:     h.MPesaClient.SecurityCredential,
+		CommandID:              "TransactionStatusQuery",
+		OriginalConversationID: attempt.CheckoutRequestID,
+		PartyA:                 h.MPesaClient.GetBusinessShortCode(),
+		IdentifierType:         "4",
+		ResultURL:              h.MPesaClient.CallbackURL(h.MPesaClient.CallbackBaseURL, "transaction-status/result"),
+		QueueTimeOutURL:        h.MPesaClient.CallbackURL(h.MPesaClient.CallbackBaseURL, "transaction-status/timeout"),
+		Remarks:                "STK push reconciliation",
+		Occasion:               attempt.InvoiceID[:8],
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.PostgresClient.RecordSTKAttemptQuery(ctx, attempt.ID, resp.ConversationID)
+}
+
+// HandleTransactionStatusResult applies the result of a transaction status
+// query sent for an STK push
+func (h *MPesaHandler) HandleTransactionStatusResult(c *fiber.Ctx) error {
+	// Parse callback body
+	var result client.TransactionStatusResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid callback body: %v", err),
+		})
+	}
+
+	accepted := fiber.Map{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	}
+
+	// Get the push the query was sent for
+	attempt, err := h.PostgresClient.GetSTKAttemptByStatusConversationID(c.Context(), result.Result.ConversationID)
+	if err != nil {
+		fmt.Printf("Warning: Transaction status result for unknown query %s: %v\n", result.Result.ConversationID, err)
+		return c.JSON(accepted)
+	}
+	if attempt.Status != models.MPesaSTKStatusPending {
+		return c.JSON(accepted)
+	}
+
+	// A failed query says nothing about the payment; the push expires if
+	// nothing else settles it
+	if result.Result.ResultCode != 0 {
+		fmt.Printf("Warning: Transaction status query for STK push %s failed: %s\n", attempt.CheckoutRequestID, result.Result.ResultDesc)
+		return c.JSON(accepted)
+	}
+
+	invoice, err := h.SupabaseClient.GetVPSInvoiceByID(attempt.InvoiceID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invoice not found: %v", err),
+		})
+	}
+
+	// Apply the outcome
+	transactionStatus := result.Parameter("TransactionStatus")
+	switch strings.ToLower(transactionStatus) {
+	case "completed":
+		err = h.completeSTKPush(c.Context(), invoice, attempt, 0, transactionStatus, result.Parameter("ReceiptNo"), attempt.PhoneNumber)
+	case "failed", "cancelled", "declined", "expired":
+		err = h.completeSTKPush(c.Context(), invoice, attempt, 1, transactionStatus, "", attempt.PhoneNumber)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to record payment: %v", err),
+		})
+	}
+
+	return c.JSON(accepted)
+}
+
+// HandleTransactionStatusTimeout acknowledges a transaction status query that
+// timed out in M-Pesa's queue
+func (h *MPesaHandler) HandleTransactionStatusTimeout(c *fiber.Ctx) error {
+	fmt.Printf("Warning: M-Pesa transaction status query timed out: %s\n", string(c.Body()))
+
+	return c.JSON(fiber.Map{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	})
+}
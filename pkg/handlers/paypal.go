@@ -157,6 +157,8 @@ func (h *PayPalHandler) CaptureOrder(c *fiber.Ctx) error {
 		})
 	}
 
+	publishInvoicePaid(invoice, "paypal")
+
 	// Get subscription
 	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
 	if err != nil {
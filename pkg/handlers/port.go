@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// PortHandler handles network port endpoints. Ports are managed in the
+// caller's project, so that they can be attached to its instances.
+type PortHandler struct {
+	compute *ComputeHandler
+}
+
+// NewPortHandler creates a new port handler
+func NewPortHandler() *PortHandler {
+	return &PortHandler{
+		compute: NewComputeHandler(),
+	}
+}
+
+// portErrorStatus maps a port error to the status returned to the caller
+func portErrorStatus(err error) int {
+	if errors.Is(err, openstack.ErrPortSecurityDisabled) {
+		return fiber.StatusBadRequest
+	}
+	return instanceActionErrorStatus(err)
+}
+
+// ListPorts lists the project's ports; ?network_id= and ?instance_id= limit
+// them to a network's or an instance's
+func (h *PortHandler) ListPorts(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	ports, err := openstack.ListPorts(context.Background(), provider, c.Query("network_id"), c.Query("instance_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(ports)
+}
+
+// CreatePort creates a port, which can then be attached to an instance at
+// create time or hot-plugged into a running one
+func (h *PortHandler) CreatePort(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Parse request body
+	var req models.CreatePortRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.NetworkID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Network ID is required",
+		})
+	}
+	if msg := validatePortAddresses(req.FixedIPs, req.AllowedAddressPairs); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: msg,
+		})
+	}
+
+	port, err := openstack.CreatePort(context.Background(), provider, req)
+	if err != nil {
+		return c.Status(portErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(port)
+}
+
+// GetPort gets a port
+func (h *PortHandler) GetPort(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get port ID from URL
+	portID := c.Params("id")
+	if portID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Port ID is required",
+		})
+	}
+
+	port, err := openstack.GetPort(context.Background(), provider, portID)
+	if err != nil {
+		return c.Status(portErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(port)
+}
+
+// UpdatePort changes a port's name, fixed IPs, security groups, allowed
+// address pairs or port security
+func (h *PortHandler) UpdatePort(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get port ID from URL
+	portID := c.Params("id")
+	if portID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Port ID is required",
+		})
+	}
+
+	// Parse request body
+	var req models.UpdatePortRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	var fixedIPs []models.PortFixedIP
+	if req.FixedIPs != nil {
+		if len(*req.FixedIPs) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "A port needs at least one fixed IP",
+			})
+		}
+		fixedIPs = *req.FixedIPs
+	}
+	var pairs []models.PortAddressPair
+	if req.AllowedAddressPairs != nil {
+		pairs = *req.AllowedAddressPairs
+	}
+	if msg := validatePortAddresses(fixedIPs, pairs); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: msg,
+		})
+	}
+
+	port, err := openstack.UpdatePort(context.Background(), provider, portID, req)
+	if err != nil {
+		return c.Status(portErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(port)
+}
+
+// DeletePort deletes a port, unplugging it from its instance
+func (h *PortHandler) DeletePort(c *fiber.Ctx) error {
+	// Get provider from token
+	provider, err := h.compute.getProviderFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Authentication error: %v", err),
+		})
+	}
+
+	// Get port ID from URL
+	portID := c.Params("id")
+	if portID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Port ID is required",
+		})
+	}
+
+	if err := openstack.DeletePort(context.Background(), provider, portID); err != nil {
+		return c.Status(portErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// validatePortAddresses reports what is wrong with requested fixed IPs and
+// allowed address pairs, or returns an empty string
+func validatePortAddresses(fixedIPs []models.PortFixedIP, pairs []models.PortAddressPair) string {
+	for _, ip := range fixedIPs {
+		if ip.SubnetID == "" && ip.IPAddress == "" {
+			return "Each fixed IP needs a subnet_id or an ip_address"
+		}
+	}
+	for _, pair := range pairs {
+		if pair.IPAddress == "" {
+			return "Each allowed address pair needs an ip_address"
+		}
+	}
+	return ""
+}
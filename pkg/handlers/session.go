@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// Default token lifetimes, used when the handler is not configured otherwise
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+	defaultSessionLifetime = 30 * 24 * time.Hour
+)
+
+// issuedTokens holds an access token and refresh token issued for a session
+type issuedTokens struct {
+	AccessToken      string
+	ExpiresAt        time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// generateRefreshToken returns a random refresh token and the hash stored for it
+func generateRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes a refresh token for storage and lookup
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// startSession records a new session for the caller and issues its first
+// access and refresh tokens. The session must have its user, scope and expiry set.
+func (h *AuthHandler) startSession(c *fiber.Ctx, session *models.Session, role string) (*issuedTokens, error) {
+	refreshToken, refreshHash, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshExpiresAt := minTime(time.Now().Add(h.refreshTokenTTL()), session.ExpiresAt)
+
+	// Save session with its first refresh token
+	session.UserAgent = c.Get(fiber.HeaderUserAgent)
+	session.IPAddress = middleware.ClientIP(c)
+	if err := h.PostgresClient.CreateSession(c.Context(), session, refreshHash, refreshExpiresAt); err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := h.signAccessToken(session, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &issuedTokens{
+		AccessToken:      accessToken,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// signAccessToken issues a short-lived access token for a session
+func (h *AuthHandler) signAccessToken(session *models.Session, role string) (string, time.Time, error) {
+	// Set claims
+	expiresAt := minTime(time.Now().Add(h.accessTokenTTL()), session.ExpiresAt)
+	claims := jwt.MapClaims{}
+	claims["typ"] = middleware.AccessTokenType
+	claims["sid"] = session.ID
+	claims["user_id"] = session.UserID
+	claims["username"] = session.Username
+	claims["domain_name"] = session.DomainName
+	claims["role"] = role
+	claims["mfa"] = session.MFA
+	claims["exp"] = expiresAt.Unix()
+	if session.ProjectID != "" {
+		claims["project_id"] = session.ProjectID
+		claims["openstack_token"] = session.OpenstackToken
+	}
+
+	// Sign token with the current signing key
+	encodedToken, err := h.Keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	return encodedToken, expiresAt, nil
+}
+
+// accessTokenTTL returns the configured access token lifetime
+func (h *AuthHandler) accessTokenTTL() time.Duration {
+	if h.AccessTokenTTL > 0 {
+		return h.AccessTokenTTL
+	}
+	return defaultAccessTokenTTL
+}
+
+// refreshTokenTTL returns the configured refresh token lifetime
+func (h *AuthHandler) refreshTokenTTL() time.Duration {
+	if h.RefreshTokenTTL > 0 {
+		return h.RefreshTokenTTL
+	}
+	return defaultRefreshTokenTTL
+}
+
+// sessionLifetime returns the configured maximum session lifetime
+func (h *AuthHandler) sessionLifetime() time.Duration {
+	if h.SessionLifetime > 0 {
+		return h.SessionLifetime
+	}
+	return defaultSessionLifetime
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Presenting a refresh token twice revokes its session.
+func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "refresh_token is required",
+		})
+	}
+
+	// Rotate the refresh token
+	newRefreshToken, newRefreshHash, err := generateRefreshToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	session, refreshExpiresAt, err := h.PostgresClient.RotateRefreshToken(ctx, hashRefreshToken(req.RefreshToken), newRefreshHash, h.refreshTokenTTL())
+	switch {
+	case errors.Is(err, client.ErrRefreshTokenReused):
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Refresh token was already used; the session has been revoked",
+		})
+	case errors.Is(err, client.ErrRefreshTokenNotFound), errors.Is(err, client.ErrSessionExpired):
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid or expired refresh token",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Resolve the platform role again so role changes, suspensions and the
+	// email verification requirement apply
+	role, err := h.accountRole(ctx, session.UserID)
+	if loginRejected(err) {
+		reason := "account suspended"
+		if errors.Is(err, errEmailNotVerified) {
+			reason = "email not verified"
+		}
+		h.PostgresClient.RevokeSession(ctx, session.ID, reason)
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	accessToken, expiresAt, err := h.signAccessToken(session, role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to generate token",
+		})
+	}
+
+	return c.JSON(models.TokenResponse{
+		Token:            accessToken,
+		RefreshToken:     newRefreshToken,
+		ExpiresAt:        expiresAt,
+		RefreshExpiresAt: refreshExpiresAt,
+	})
+}
+
+// Logout revokes the session of the current access token
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	sessionID, _ := c.Locals("session_id").(string)
+	if sessionID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "API keys have no session; revoke the key instead",
+		})
+	}
+
+	if err := h.PostgresClient.RevokeSession(c.Context(), sessionID, "logout"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// ListSessions lists the caller's active sessions
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	currentSessionID, _ := c.Locals("session_id").(string)
+
+	// Get sessions from database
+	sessions, err := h.PostgresClient.ListActiveSessions(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession revokes one of the caller's sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	ctx := c.Context()
+	userID, _ := c.Locals("user_id").(string)
+
+	sessionID := c.Params("id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Session not found",
+		})
+	}
+
+	// Get the session, hiding sessions of other users
+	session, err := h.PostgresClient.GetSession(ctx, sessionID)
+	if errors.Is(err, client.ErrSessionNotFound) || (err == nil && session.UserID != userID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Session not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if err := h.PostgresClient.RevokeSession(ctx, session.ID, "revoked by user"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RevokeOtherSessions revokes every session of the caller except the current one
+func (h *AuthHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	currentSessionID, _ := c.Locals("session_id").(string)
+
+	revoked, err := h.PostgresClient.RevokeUserSessions(c.Context(), userID, currentSessionID, "revoked by user")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"revoked": revoked,
+	})
+}
@@ -28,12 +28,10 @@ func NewStripeHandler(supabaseClient *client.SupabaseClient, stripeClient *clien
 
 // CreateCheckoutSession creates a new Stripe checkout session for a VPS invoice
 func (h *StripeHandler) CreateCheckoutSession(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID invoices are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Parse request body
@@ -81,35 +79,13 @@ func (h *StripeHandler) CreateCheckoutSession(c *fiber.Ctx) error {
 	}
 
 	// Get user's Stripe customer ID or create a new customer
-	var customerID string
-	user, err := h.SupabaseClient.GetUserByID(userID)
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": fmt.Sprintf("Failed to get user: %v", err),
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
 		})
 	}
 
-	if user.StripeCustomerID == "" {
-		// Create a new customer in Stripe
-		customer, err := h.StripeClient.CreateCustomer(c.Context(), user.Email, user.Name)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to create Stripe customer: %v", err),
-			})
-		}
-		customerID = customer.ID
-
-		// Update user with Stripe customer ID
-		err = h.SupabaseClient.UpdateUserStripeCustomerID(userID, customerID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to update user with Stripe customer ID: %v", err),
-			})
-		}
-	} else {
-		customerID = user.StripeCustomerID
-	}
-
 	// Generate success and cancel URLs
 	baseURL := c.BaseURL()
 	if baseURL == "" {
@@ -199,6 +175,16 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
+		// Subscription checkouts are paid through their Stripe invoices
+		if session.Mode == stripe.CheckoutSessionModeSubscription {
+			if err := h.linkStripeCheckout(c.Context(), &session); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": fmt.Sprintf("Failed to link subscription: %v", err),
+				})
+			}
+			break
+		}
+
 		// Find the invoice with this session ID
 		invoice, err := h.SupabaseClient.GetVPSInvoiceByStripeSessionID(session.ID)
 		if err != nil {
@@ -221,6 +207,8 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
+		publishInvoicePaid(invoice, "stripe")
+
 		// Update subscription status to active
 		subscriptionUpdates := map[string]interface{}{
 			"status":     "active",
@@ -236,7 +224,7 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 		// Provision VPS if OpenStack client is available
 		// This would typically be handled by the VPS handler after payment confirmation
 
-	case "invoice.paid":
+	case "invoice.paid", "invoice.payment_failed":
 		// Handle subscription renewal payments
 		var stripeInvoice stripe.Invoice
 		err := json.Unmarshal(event.Data.Raw, &stripeInvoice)
@@ -246,30 +234,19 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
-		// If this is a subscription invoice, update the subscription
-		if stripeInvoice.Subscription != nil {
-			// Find the subscription with this Stripe subscription ID
-			subscription, err := h.SupabaseClient.GetVPSSubscriptionByStripeID(stripeInvoice.Subscription.ID)
-			if err != nil {
-				// This might be a new subscription, so we don't return an error
-				fmt.Printf("Subscription not found for Stripe subscription ID %s: %v\n", stripeInvoice.Subscription.ID, err)
-			} else {
-				// Update subscription with new period end
-				subscriptionUpdates := map[string]interface{}{
-					"end_date":         time.Unix(stripeInvoice.PeriodEnd, 0),
-					"renewal_due_date": time.Unix(stripeInvoice.PeriodEnd, 0),
-				}
-				_, err = h.SupabaseClient.UpdateVPSSubscription(subscription.ID, subscriptionUpdates)
-				if err != nil {
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-						"error": fmt.Sprintf("Failed to update subscription: %v", err),
-					})
-				}
-			}
+		if event.Type == "invoice.paid" {
+			err = h.handleStripeInvoicePaid(c.Context(), &stripeInvoice)
+		} else {
+			err = h.handleStripeInvoicePaymentFailed(c.Context(), &stripeInvoice)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to process %s: %v", event.Type, err),
+			})
 		}
 
-	case "customer.subscription.deleted":
-		// Handle subscription cancellation
+	case "customer.subscription.updated", "customer.subscription.deleted":
+		// Handle subscription status changes and cancellation
 		var subscription stripe.Subscription
 		err := json.Unmarshal(event.Data.Raw, &subscription)
 		if err != nil {
@@ -278,22 +255,9 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 			})
 		}
 
-		// Find the subscription with this Stripe subscription ID
-		vpsSubscription, err := h.SupabaseClient.GetVPSSubscriptionByStripeID(subscription.ID)
-		if err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": fmt.Sprintf("Subscription not found for Stripe subscription ID %s: %v", subscription.ID, err),
-			})
-		}
-
-		// Update subscription status to cancelled
-		subscriptionUpdates := map[string]interface{}{
-			"status": "cancelled",
-		}
-		_, err = h.SupabaseClient.UpdateVPSSubscription(vpsSubscription.ID, subscriptionUpdates)
-		if err != nil {
+		if err := h.syncStripeSubscription(c.Context(), &subscription); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to update subscription: %v", err),
+				"error": fmt.Sprintf("Failed to process %s: %v", event.Type, err),
 			})
 		}
 	}
@@ -301,14 +265,14 @@ func (h *StripeHandler) HandleWebhook(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusOK)
 }
 
-// CreateSubscription creates a new Stripe subscription
+// CreateSubscription subscribes the user to a VPS plan billed by Stripe. The
+// VPS subscription is created pending and activated once Stripe takes the
+// first payment.
 func (h *StripeHandler) CreateSubscription(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID subscriptions are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Parse request body
@@ -319,41 +283,55 @@ func (h *StripeHandler) CreateSubscription(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate price ID
-	if req.PriceID == "" {
+	// Validate commit period
+	if !validCommitPeriods[req.CommitPeriod] {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Price ID is required",
+			"error": "Invalid commit period. Must be 1, 3, 6, 12, or 24 months",
+		})
+	}
+
+	// Get the plan
+	plan, err := h.SupabaseClient.GetVPSPlanByCode(req.PlanCode)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Plan not found: %v", err),
+		})
+	}
+
+	// Get the Stripe price billing the plan for the period
+	priceID := h.StripeClient.PriceID(plan.PlanCode, req.CommitPeriod)
+	if priceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Plan %s is not available with Stripe for a %d-month commit period", plan.PlanCode, req.CommitPeriod),
 		})
 	}
 
 	// Get user's Stripe customer ID or create a new customer
-	var customerID string
-	user, err := h.SupabaseClient.GetUserByID(userID)
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": fmt.Sprintf("Failed to get user: %v", err),
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
 		})
 	}
 
-	if user.StripeCustomerID == "" {
-		// Create a new customer in Stripe
-		customer, err := h.StripeClient.CreateCustomer(c.Context(), user.Email, user.Name)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to create Stripe customer: %v", err),
-			})
-		}
-		customerID = customer.ID
-
-		// Update user with Stripe customer ID
-		err = h.SupabaseClient.UpdateUserStripeCustomerID(userID, customerID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to update user with Stripe customer ID: %v", err),
-			})
-		}
-	} else {
-		customerID = user.StripeCustomerID
+	// Create subscription with pending status until Stripe takes the first payment
+	now := time.Now()
+	endDate := now.AddDate(0, req.CommitPeriod, 0)
+	subscription, err := h.SupabaseClient.CreateVPSSubscription(&models.VPSSubscription{
+		UserID:         userID,
+		PlanID:         plan.ID,
+		CommitPeriod:   req.CommitPeriod,
+		Price:          vpsPlanPrice(plan, req.CommitPeriod),
+		StartDate:      now,
+		EndDate:        endDate,
+		RenewalDueDate: endDate,
+		AutoRenew:      true,
+		Status:         "pending",
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create subscription: %v", err),
+		})
 	}
 
 	// Generate success and cancel URLs
@@ -361,12 +339,20 @@ func (h *StripeHandler) CreateSubscription(c *fiber.Ctx) error {
 	if baseURL == "" {
 		baseURL = "https://lineserve.net" // Default URL if not available from context
 	}
-	successURL := fmt.Sprintf("%s/subscription/success", baseURL)
-	cancelURL := fmt.Sprintf("%s/subscription/cancel", baseURL)
+	successURL := fmt.Sprintf("%s/subscription/success?subscription_id=%s", baseURL, subscription.ID)
+	cancelURL := fmt.Sprintf("%s/subscription/cancel?subscription_id=%s", baseURL, subscription.ID)
 
 	// Create checkout session for subscription
-	session, err := h.StripeClient.CreateSubscriptionCheckoutSession(c.Context(), customerID, req.PriceID, successURL, cancelURL)
+	session, err := h.StripeClient.CreateSubscriptionCheckoutSession(c.Context(), customerID, priceID, subscription.ID, successURL, cancelURL)
 	if err != nil {
+		// Don't leave a subscription behind that can never be paid
+		updates := map[string]interface{}{
+			"status": "cancelled",
+		}
+		if _, updateErr := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, updates); updateErr != nil {
+			fmt.Printf("Failed to update subscription status: %v\n", updateErr)
+		}
+
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to create subscription checkout session: %v", err),
 		})
@@ -374,19 +360,19 @@ func (h *StripeHandler) CreateSubscription(c *fiber.Ctx) error {
 
 	// Return checkout session URL
 	return c.JSON(models.StripeCheckoutResponse{
-		CheckoutURL: session.URL,
-		SessionID:   session.ID,
+		CheckoutURL:    session.URL,
+		SessionID:      session.ID,
+		SubscriptionID: subscription.ID,
 	})
 }
 
-// CancelSubscription cancels a Stripe subscription
+// CancelSubscription cancels a Stripe subscription. Cancelling immediately
+// also stops the subscription's instance.
 func (h *StripeHandler) CancelSubscription(c *fiber.Ctx) error {
-	// Get user ID from context
-	userID := c.Locals("user_id").(string)
-	if userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated",
-		})
+	// Get the Supabase user ID subscriptions are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
 	}
 
 	// Get subscription ID from URL
@@ -437,9 +423,9 @@ func (h *StripeHandler) CancelSubscription(c *fiber.Ctx) error {
 	}
 
 	subscriptionUpdates := map[string]interface{}{
-		"status": status,
+		"auto_renew": false,
 	}
-	updatedSubscription, err := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, subscriptionUpdates)
+	updatedSubscription, err := h.updateVPSSubscriptionStatus(c.Context(), subscription, status, subscriptionUpdates)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to update subscription: %v", err),
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// stripePaymentMethod converts a Stripe payment method to its API model
+func stripePaymentMethod(paymentMethod *stripe.PaymentMethod, defaultID string) models.StripePaymentMethod {
+	method := models.StripePaymentMethod{
+		ID:        paymentMethod.ID,
+		IsDefault: paymentMethod.ID == defaultID,
+	}
+	if paymentMethod.Card != nil {
+		method.Brand = string(paymentMethod.Card.Brand)
+		method.Last4 = paymentMethod.Card.Last4
+		method.ExpMonth = paymentMethod.Card.ExpMonth
+		method.ExpYear = paymentMethod.Card.ExpYear
+	}
+	return method
+}
+
+// defaultPaymentMethodID returns the ID of a customer's default payment
+// method, or "" when there is none
+func (h *StripeHandler) defaultPaymentMethodID(ctx context.Context, customerID string) (string, error) {
+	customer, err := h.StripeClient.GetCustomer(ctx, customerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Stripe customer: %v", err)
+	}
+	if customer.InvoiceSettings == nil || customer.InvoiceSettings.DefaultPaymentMethod == nil {
+		return "", nil
+	}
+	return customer.InvoiceSettings.DefaultPaymentMethod.ID, nil
+}
+
+// customerPaymentMethod gets a payment method saved to a customer. It returns
+// nil when the payment method does not exist or belongs to someone else.
+func (h *StripeHandler) customerPaymentMethod(ctx context.Context, customerID, paymentMethodID string) *stripe.PaymentMethod {
+	paymentMethod, err := h.StripeClient.GetPaymentMethod(ctx, paymentMethodID)
+	if err != nil || paymentMethod.Customer == nil || paymentMethod.Customer.ID != customerID {
+		return nil
+	}
+	return paymentMethod
+}
+
+// setDefaultPaymentMethod makes a payment method the customer's default and
+// the one the user's Stripe-billed VPS subscriptions renew with
+func (h *StripeHandler) setDefaultPaymentMethod(ctx context.Context, userID, customerID, paymentMethodID string) error {
+	if err := h.StripeClient.SetDefaultPaymentMethod(ctx, customerID, paymentMethodID); err != nil {
+		return fmt.Errorf("failed to set default payment method: %v", err)
+	}
+
+	// Subscriptions keep the card they were checked out with unless told otherwise
+	subscriptions, err := h.SupabaseClient.GetVPSSubscriptionsByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscriptions: %v", err)
+	}
+	for _, subscription := range subscriptions {
+		if subscription.StripeSubscriptionID == "" || subscription.Status == "cancelled" {
+			continue
+		}
+		if err := h.StripeClient.SetSubscriptionPaymentMethod(ctx, subscription.StripeSubscriptionID, paymentMethodID); err != nil {
+			fmt.Printf("Warning: Failed to set payment method of subscription %s: %v\n", subscription.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// ListPaymentMethods lists the cards saved for the user
+func (h *StripeHandler) ListPaymentMethods(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	defaultID, err := h.defaultPaymentMethodID(c.Context(), customerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	paymentMethods, err := h.StripeClient.ListPaymentMethods(c.Context(), customerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to list payment methods: %v", err),
+		})
+	}
+
+	methods := []models.StripePaymentMethod{}
+	for _, paymentMethod := range paymentMethods {
+		methods = append(methods, stripePaymentMethod(paymentMethod, defaultID))
+	}
+
+	return c.JSON(fiber.Map{
+		"payment_methods": methods,
+	})
+}
+
+// CreateSetupIntent starts saving a card. The client confirms the setup
+// intent with Stripe.js, which saves the card to the user's customer.
+func (h *StripeHandler) CreateSetupIntent(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	setupIntent, err := h.StripeClient.CreateSetupIntent(c.Context(), customerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create setup intent: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.StripeSetupIntentResponse{
+		SetupIntentID: setupIntent.ID,
+		ClientSecret:  setupIntent.ClientSecret,
+	})
+}
+
+// AddPaymentMethod saves a payment method to the user. The first saved
+// payment method becomes the default.
+func (h *StripeHandler) AddPaymentMethod(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Parse request body
+	var req models.StripeAddPaymentMethodRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
+
+	// Validate payment method ID
+	if req.PaymentMethodID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Payment method ID is required",
+		})
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	// Attach the payment method unless a setup intent already saved it
+	paymentMethod, err := h.StripeClient.GetPaymentMethod(c.Context(), req.PaymentMethodID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment method not found",
+		})
+	}
+	if paymentMethod.Customer == nil {
+		if err := h.StripeClient.AttachPaymentMethod(c.Context(), paymentMethod.ID, customerID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to attach payment method: %v", err),
+			})
+		}
+	} else if paymentMethod.Customer.ID != customerID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment method not found",
+		})
+	}
+
+	defaultID, err := h.defaultPaymentMethodID(c.Context(), customerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if req.SetDefault || defaultID == "" {
+		if err := h.setDefaultPaymentMethod(c.Context(), userID, customerID, paymentMethod.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		defaultID = paymentMethod.ID
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(stripePaymentMethod(paymentMethod, defaultID))
+}
+
+// SetDefaultPaymentMethod makes a saved payment method the one renewals and
+// one-click invoice payments are charged to
+func (h *StripeHandler) SetDefaultPaymentMethod(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	paymentMethod := h.customerPaymentMethod(c.Context(), customerID, c.Params("id"))
+	if paymentMethod == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment method not found",
+		})
+	}
+
+	if err := h.setDefaultPaymentMethod(c.Context(), userID, customerID, paymentMethod.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(stripePaymentMethod(paymentMethod, paymentMethod.ID))
+}
+
+// DeletePaymentMethod removes a saved payment method
+func (h *StripeHandler) DeletePaymentMethod(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	paymentMethod := h.customerPaymentMethod(c.Context(), customerID, c.Params("id"))
+	if paymentMethod == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment method not found",
+		})
+	}
+
+	if err := h.StripeClient.DetachPaymentMethod(c.Context(), paymentMethod.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to remove payment method: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// CreateBillingPortalSession opens the Stripe customer portal, where the user
+// manages their cards, subscriptions and Stripe invoices
+func (h *StripeHandler) CreateBillingPortalSession(c *fiber.Ctx) error {
+	// Get the Supabase user ID payments are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return invoiceOwnerError(c, err)
+	}
+
+	// Parse request body, which is optional
+	var req models.StripeBillingPortalRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid request body: %v", err),
+			})
+		}
+	}
+
+	// Get user's Stripe customer ID or create a new customer
+	customerID, err := h.stripeCustomerID(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+		})
+	}
+
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		baseURL := c.BaseURL()
+		if baseURL == "" {
+			baseURL = "https://lineserve.net" // Default URL if not available from context
+		}
+		returnURL = fmt.Sprintf("%s/billing", baseURL)
+	}
+
+	portalSession, err := h.StripeClient.CreateBillingPortalSession(c.Context(), customerID, returnURL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create billing portal session: %v", err),
+		})
+	}
+
+	return c.JSON(models.StripeBillingPortalResponse{
+		URL: portalSession.URL,
+	})
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// stripeCustomerID returns a user's Stripe customer ID, creating the customer
+// on first use
+func (h *StripeHandler) stripeCustomerID(ctx context.Context, userID string) (string, error) {
+	user, err := h.SupabaseClient.GetUserByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %v", err)
+	}
+	if user.StripeCustomerID != "" {
+		return user.StripeCustomerID, nil
+	}
+
+	// Create a new customer in Stripe
+	customer, err := h.StripeClient.CreateCustomer(ctx, user.Email, user.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Stripe customer: %v", err)
+	}
+
+	// Update user with Stripe customer ID
+	if err := h.SupabaseClient.UpdateUserStripeCustomerID(userID, customer.ID); err != nil {
+		return "", fmt.Errorf("failed to update user with Stripe customer ID: %v", err)
+	}
+
+	return customer.ID, nil
+}
+
+// vpsSubscriptionLapsed reports whether a VPS subscription status is one
+// whose instance is kept stopped
+func vpsSubscriptionLapsed(status string) bool {
+	return status == "expired" || status == "cancelled"
+}
+
+// stripeSubscriptionStatus maps a Stripe subscription's state to the status of
+// the VPS subscription it bills for
+func stripeSubscriptionStatus(subscription *stripe.Subscription) string {
+	switch subscription.Status {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+		if subscription.CancelAtPeriodEnd {
+			return "cancelling"
+		}
+		return "active"
+	case stripe.SubscriptionStatusPastDue:
+		return "grace"
+	case stripe.SubscriptionStatusUnpaid:
+		return "expired"
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return "cancelled"
+	default:
+		return "pending"
+	}
+}
+
+// updateVPSSubscriptionStatus moves a VPS subscription to a status along with
+// other updates. The subscription's instance is stopped when the subscription
+// lapses and started again when a lapsed subscription is paid up.
+func (h *StripeHandler) updateVPSSubscriptionStatus(ctx context.Context, subscription *models.VPSSubscription, status string, updates map[string]interface{}) (*models.VPSSubscription, error) {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = status
+
+	updatedSubscription, err := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, updates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %v", err)
+	}
+
+	lapsed := vpsSubscriptionLapsed(status)
+	if subscription.InstanceID != "" && lapsed != vpsSubscriptionLapsed(subscription.Status) {
+		// Instances live in their owners' projects, so use the admin provider
+		adminProvider, err := openstack.GetAdminProvider(ctx)
+		if err == nil {
+			err = openstack.SetServerRunning(ctx, adminProvider, subscription.InstanceID, !lapsed)
+		}
+		if err != nil {
+			fmt.Printf("Warning: Failed to set instance %s of subscription %s running=%t: %v\n", subscription.InstanceID, subscription.ID, !lapsed, err)
+		}
+	}
+
+	return updatedSubscription, nil
+}
+
+// vpsSubscriptionForStripe gets the VPS subscription a Stripe subscription
+// bills for, linking the two on first sight. It returns nil when the Stripe
+// subscription bills for no VPS subscription.
+func (h *StripeHandler) vpsSubscriptionForStripe(stripeSubscriptionID string, metadata map[string]string) (*models.VPSSubscription, error) {
+	// Subscriptions are linked once their checkout completes
+	if subscription, err := h.SupabaseClient.GetVPSSubscriptionByStripeID(stripeSubscriptionID); err == nil {
+		return h.SupabaseClient.GetVPSSubscriptionByID(subscription.ID)
+	}
+
+	// Events can arrive before the checkout's, so fall back to the VPS
+	// subscription named in the Stripe subscription's metadata
+	vpsSubscriptionID := metadata[client.StripeVPSSubscriptionKey]
+	if vpsSubscriptionID == "" {
+		return nil, nil
+	}
+
+	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(vpsSubscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription %s: %v", vpsSubscriptionID, err)
+	}
+	if subscription.StripeSubscriptionID == "" {
+		updates := map[string]interface{}{
+			"stripe_subscription_id": stripeSubscriptionID,
+		}
+		if _, err := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, updates); err != nil {
+			return nil, fmt.Errorf("failed to link subscription: %v", err)
+		}
+		subscription.StripeSubscriptionID = stripeSubscriptionID
+	}
+
+	return subscription, nil
+}
+
+// stripeInvoiceMetadata returns the metadata of the subscription a Stripe
+// invoice bills for, which Stripe copies onto its subscription lines
+func stripeInvoiceMetadata(invoice *stripe.Invoice) map[string]string {
+	if invoice.Lines == nil {
+		return nil
+	}
+	for _, line := range invoice.Lines.Data {
+		if line.Metadata[client.StripeVPSSubscriptionKey] != "" {
+			return line.Metadata
+		}
+	}
+	return nil
+}
+
+// stripeInvoicePeriodEnd returns the end of the service period a Stripe
+// invoice bills for. The invoice's own period is the one just ended, so the
+// end is taken from its lines.
+func stripeInvoicePeriodEnd(invoice *stripe.Invoice) time.Time {
+	periodEnd := invoice.PeriodEnd
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if line.Period != nil && line.Period.End > periodEnd {
+				periodEnd = line.Period.End
+			}
+		}
+	}
+	return time.Unix(periodEnd, 0)
+}
+
+// linkStripeCheckout links a VPS subscription to the Stripe subscription its
+// checkout created, activating it when the first payment was taken
+func (h *StripeHandler) linkStripeCheckout(ctx context.Context, session *stripe.CheckoutSession) error {
+	if session.ClientReferenceID == "" || session.Subscription == nil {
+		return nil
+	}
+
+	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(session.ClientReferenceID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription %s: %v", session.ClientReferenceID, err)
+	}
+
+	updates := map[string]interface{}{
+		"stripe_subscription_id": session.Subscription.ID,
+	}
+	if session.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid && subscription.Status == "pending" {
+		updates["start_date"] = time.Now()
+		_, err = h.updateVPSSubscriptionStatus(ctx, subscription, "active", updates)
+		return err
+	}
+
+	if _, err := h.SupabaseClient.UpdateVPSSubscription(subscription.ID, updates); err != nil {
+		return fmt.Errorf("failed to update subscription: %v", err)
+	}
+	return nil
+}
+
+// recordStripeInvoicePayment records a paid Stripe invoice as a paid VPS
+// invoice of the subscription, once per Stripe invoice
+func (h *StripeHandler) recordStripeInvoicePayment(subscription *models.VPSSubscription, invoice *stripe.Invoice) error {
+	existing, err := h.SupabaseClient.GetVPSInvoicesByStripePaymentID(invoice.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get invoices: %v", err)
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	planCode := ""
+	if subscription.Plan != nil {
+		planCode = subscription.Plan.PlanCode
+	}
+
+	now := time.Now()
+	paidInvoice, err := h.SupabaseClient.CreateVPSInvoice(&models.VPSInvoice{
+		UserID:          subscription.UserID,
+		SubscriptionID:  subscription.ID,
+		PlanCode:        planCode,
+		PeriodMonths:    subscription.CommitPeriod,
+		Amount:          float64(invoice.AmountPaid) / 100, // Convert from cents
+		Currency:        strings.ToUpper(string(invoice.Currency)),
+		Status:          "paid",
+		PaymentMethodID: "stripe",
+		StripePaymentID: invoice.ID,
+		ExpiresAt:       now,
+		PaidAt:          &now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invoice: %v", err)
+	}
+	publishInvoicePaid(paidInvoice, "stripe")
+
+	return nil
+}
+
+// handleStripeInvoicePaid renews a VPS subscription to the end of the period
+// a paid Stripe invoice covers
+func (h *StripeHandler) handleStripeInvoicePaid(ctx context.Context, invoice *stripe.Invoice) error {
+	if invoice.Subscription == nil {
+		return nil
+	}
+
+	subscription, err := h.vpsSubscriptionForStripe(invoice.Subscription.ID, stripeInvoiceMetadata(invoice))
+	if err != nil {
+		return err
+	}
+	if subscription == nil {
+		fmt.Printf("Warning: Paid Stripe invoice %s bills for no VPS subscription\n", invoice.ID)
+		return nil
+	}
+
+	// Record the payment
+	if err := h.recordStripeInvoicePayment(subscription, invoice); err != nil {
+		return err
+	}
+
+	// A cancelled subscription stays cancelled, even if a final invoice is paid
+	if subscription.Status == "cancelled" {
+		return nil
+	}
+
+	// Extend the subscription to the end of the paid period
+	periodEnd := stripeInvoicePeriodEnd(invoice)
+	updates := map[string]interface{}{
+		"end_date":         periodEnd,
+		"renewal_due_date": periodEnd,
+	}
+	if subscription.Status == "pending" {
+		updates["start_date"] = time.Now()
+	}
+
+	status := "active"
+	if subscription.Status == "cancelling" {
+		status = "cancelling"
+	}
+	_, err = h.updateVPSSubscriptionStatus(ctx, subscription, status, updates)
+	return err
+}
+
+// handleStripeInvoicePaymentFailed puts a VPS subscription into grace while
+// Stripe retries a failed renewal payment
+func (h *StripeHandler) handleStripeInvoicePaymentFailed(ctx context.Context, invoice *stripe.Invoice) error {
+	if invoice.Subscription == nil {
+		return nil
+	}
+
+	subscription, err := h.vpsSubscriptionForStripe(invoice.Subscription.ID, stripeInvoiceMetadata(invoice))
+	if err != nil {
+		return err
+	}
+	if subscription == nil {
+		return nil
+	}
+
+	fmt.Printf("Warning: Stripe payment for subscription %s failed (attempt %d)\n", subscription.ID, invoice.AttemptCount)
+
+	// A first payment that fails leaves the subscription pending
+	if subscription.Status != "active" && subscription.Status != "cancelling" {
+		return nil
+	}
+
+	_, err = h.updateVPSSubscriptionStatus(ctx, subscription, "grace", nil)
+	return err
+}
+
+// syncStripeSubscription brings a VPS subscription in line with the Stripe
+// subscription billing it
+func (h *StripeHandler) syncStripeSubscription(ctx context.Context, stripeSubscription *stripe.Subscription) error {
+	subscription, err := h.vpsSubscriptionForStripe(stripeSubscription.ID, stripeSubscription.Metadata)
+	if err != nil {
+		return err
+	}
+	if subscription == nil {
+		fmt.Printf("Warning: Stripe subscription %s bills for no VPS subscription\n", stripeSubscription.ID)
+		return nil
+	}
+
+	// Cancellation is final; later events may arrive out of order
+	if subscription.Status == "cancelled" {
+		return nil
+	}
+
+	status := stripeSubscriptionStatus(stripeSubscription)
+	updates := map[string]interface{}{
+		"auto_renew": status == "active" || status == "grace",
+	}
+	if status != "cancelled" && stripeSubscription.CurrentPeriodEnd > 0 {
+		periodEnd := time.Unix(stripeSubscription.CurrentPeriodEnd, 0)
+		updates["end_date"] = periodEnd
+		updates["renewal_due_date"] = periodEnd
+	}
+
+	_, err = h.updateVPSSubscriptionStatus(ctx, subscription, status, updates)
+	return err
+}
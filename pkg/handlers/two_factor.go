@@ -0,0 +1,555 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/totp"
+)
+
+const (
+	// mfaChallengeTokenType is the typ claim of login challenge tokens
+	mfaChallengeTokenType = "mfa_challenge"
+
+	// mfaChallengeTTL is how long the second login step may take
+	mfaChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts is the number of wrong codes that invalidates a challenge
+	maxMFAAttempts = 5
+
+	// recoveryCodeCount is the number of recovery codes issued at a time
+	recoveryCodeCount = 10
+
+	// defaultTOTPIssuer names the service in authenticator apps
+	defaultTOTPIssuer = "Lineserve"
+)
+
+// recoveryCodeEncoding encodes recovery codes in lowercase base32
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns new recovery codes and the hashes stored for them
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		code := recoveryCodeEncoding.EncodeToString(buf)
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, dashes and spaces
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashRefreshToken(code)
+}
+
+// totpIssuer returns the issuer shown in authenticator apps
+func (h *AuthHandler) totpIssuer() string {
+	if h.TOTPIssuer != "" {
+		return h.TOTPIssuer
+	}
+	return defaultTOTPIssuer
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when
+// allowRecovery is set. Accepted codes are consumed and cannot be used again.
+func (h *AuthHandler) verifySecondFactor(ctx context.Context, accountID, code string, allowRecovery bool) (bool, error) {
+	status, err := h.PostgresClient.GetTwoFactorStatus(ctx, accountID)
+	if err != nil {
+		return false, err
+	}
+	if status.Secret == "" {
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(status.Secret, code, time.Now(), status.LastStep); ok {
+		return h.PostgresClient.RecordTOTPStep(ctx, accountID, step)
+	}
+
+	if !allowRecovery || !status.Enabled || len(code) <= totp.Digits {
+		return false, nil
+	}
+
+	return h.PostgresClient.UseRecoveryCode(ctx, accountID, hashRecoveryCode(code))
+}
+
+// challengeSecondFactor ends the password step of a login for an account with
+// two-factor authentication. The session that would have been started is kept
+// server side and a short-lived challenge token is returned instead, to be
+// exchanged at /v1/login/2fa together with a code.
+func (h *AuthHandler) challengeSecondFactor(c *fiber.Ctx, session *models.Session, projects []models.Project) error {
+	challenge := &models.MFAChallenge{
+		UserID:           session.UserID,
+		Username:         session.Username,
+		DomainName:       session.DomainName,
+		ProjectID:        session.ProjectID,
+		OpenstackToken:   session.OpenstackToken,
+		Projects:         projects,
+		SessionExpiresAt: session.ExpiresAt,
+		ExpiresAt:        minTime(time.Now().Add(mfaChallengeTTL), session.ExpiresAt),
+	}
+	if err := h.PostgresClient.CreateMFAChallenge(c.Context(), challenge); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	claims := jwt.MapClaims{
+		"typ":      mfaChallengeTokenType,
+		"jti":      challenge.ID,
+		"sub":      challenge.UserID,
+		"username": challenge.Username,
+		"exp":      challenge.ExpiresAt.Unix(),
+	}
+	challengeToken, err := h.Keys.Sign(claims)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to generate token",
+		})
+	}
+
+	return c.JSON(models.MFAChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: challengeToken,
+		ExpiresAt:      challenge.ExpiresAt,
+	})
+}
+
+// TwoFactorLoginAccount returns the username a login challenge was issued to,
+// so that wrong codes lock the same account as wrong passwords
+func (h *AuthHandler) TwoFactorLoginAccount(c *fiber.Ctx) string {
+	var req models.MFALoginRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.ChallengeToken == "" {
+		return ""
+	}
+
+	claims, err := h.Keys.Parse(req.ChallengeToken)
+	if err != nil || claims["typ"] != mfaChallengeTokenType {
+		return ""
+	}
+
+	username, _ := claims["username"].(string)
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CompleteTwoFactorLogin exchanges a login challenge token and a TOTP or
+// recovery code for the session the password step would have started
+func (h *AuthHandler) CompleteTwoFactorLogin(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.MFALoginRequest
+	if err := c.BodyParser(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "challenge_token and code are required",
+		})
+	}
+
+	// Verify the challenge token
+	claims, err := h.Keys.Parse(req.ChallengeToken)
+	challengeID, _ := claims["jti"].(string)
+	if err != nil || claims["typ"] != mfaChallengeTokenType || uuid.Validate(challengeID) != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid or expired login challenge",
+		})
+	}
+
+	challenge, err := h.PostgresClient.GetMFAChallenge(ctx, challengeID)
+	if errors.Is(err, client.ErrMFAChallengeNotFound) {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid or expired login challenge",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= maxMFAAttempts {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid or expired login challenge; sign in again",
+		})
+	}
+
+	// Resolve the account again, rejecting accounts suspended in the meantime
+	account, err := h.loginAccount(ctx, challenge.UserID)
+	if loginRejected(err) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Check the code, counting failures against the challenge
+	valid, err := h.verifySecondFactor(ctx, account.ID, req.Code, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !valid {
+		attempts, err := h.PostgresClient.RecordMFAChallengeFailure(ctx, challenge.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":              "Invalid authentication code",
+			"attempts_remaining": max(maxMFAAttempts-attempts, 0),
+		})
+	}
+
+	// Each challenge starts at most one session
+	completed, err := h.PostgresClient.CompleteMFAChallenge(ctx, challenge.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !completed {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid or expired login challenge; sign in again",
+		})
+	}
+
+	// Start a session and issue its tokens
+	tokens, err := h.startSession(c, &models.Session{
+		UserID:         challenge.UserID,
+		Username:       challenge.Username,
+		DomainName:     challenge.DomainName,
+		ProjectID:      challenge.ProjectID,
+		OpenstackToken: challenge.OpenstackToken,
+		MFA:            true,
+		ExpiresAt:      challenge.SessionExpiresAt,
+	}, account.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to generate token",
+		})
+	}
+
+	if challenge.ProjectID != "" {
+		return c.JSON(models.ProjectScopeResponse{
+			Token:            tokens.AccessToken,
+			RefreshToken:     tokens.RefreshToken,
+			ProjectID:        challenge.ProjectID,
+			ExpiresAt:        tokens.ExpiresAt,
+			RefreshExpiresAt: tokens.RefreshExpiresAt,
+		})
+	}
+
+	projects := challenge.Projects
+	if projects == nil {
+		projects = []models.Project{}
+	}
+	return c.JSON(models.LoginResponse{
+		Token:            tokens.AccessToken,
+		RefreshToken:     tokens.RefreshToken,
+		UserID:           challenge.UserID,
+		Projects:         projects,
+		ExpiresAt:        tokens.ExpiresAt,
+		RefreshExpiresAt: tokens.RefreshExpiresAt,
+	})
+}
+
+// errTwoFactorWithAPIKey is returned when two-factor settings are managed with an API key
+var errTwoFactorWithAPIKey = errors.New("two-factor authentication cannot be managed with an API key")
+
+// twoFactorAccount returns the platform account of the caller
+func (h *AuthHandler) twoFactorAccount(c *fiber.Ctx) (*models.UserAccount, error) {
+	if middleware.IsAPIKeyRequest(c) {
+		return nil, errTwoFactorWithAPIKey
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	return h.PostgresClient.GetUserAccountByOpenStackID(c.Context(), userID)
+}
+
+// twoFactorAccountError responds to an error returned by twoFactorAccount
+func twoFactorAccountError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errTwoFactorWithAPIKey):
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication cannot be managed with an API key",
+		})
+	case errors.Is(err, client.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication requires a Lineserve account",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+}
+
+// twoFactorRequired reports whether the platform requires two-factor
+// authentication for an account
+func (h *AuthHandler) twoFactorRequired(ctx context.Context, account *models.UserAccount) (bool, error) {
+	if account.Role != models.UserRoleAdmin {
+		return false, nil
+	}
+
+	settings, err := h.PostgresClient.GetSecuritySettings(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return settings.RequireAdmin2FA, nil
+}
+
+// GetTwoFactorStatus returns the caller's two-factor authentication state
+func (h *AuthHandler) GetTwoFactorStatus(c *fiber.Ctx) error {
+	account, err := h.twoFactorAccount(c)
+	if err != nil {
+		return twoFactorAccountError(c, err)
+	}
+
+	status, err := h.PostgresClient.GetTwoFactorStatus(c.Context(), account.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	status.Required, err = h.twoFactorRequired(c.Context(), account)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(status)
+}
+
+// EnrollTwoFactor starts two-factor enrollment by generating a TOTP secret.
+// Two-factor authentication is only enabled once a code is verified, so
+// enrolling again replaces a secret that was never verified.
+func (h *AuthHandler) EnrollTwoFactor(c *fiber.Ctx) error {
+	account, err := h.twoFactorAccount(c)
+	if err != nil {
+		return twoFactorAccountError(c, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	stored, err := h.PostgresClient.SetPendingTOTPSecret(c.Context(), account.ID, secret)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !stored {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication is already enabled",
+		})
+	}
+
+	return c.JSON(models.TwoFactorEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(h.totpIssuer(), account.Email, secret),
+	})
+}
+
+// VerifyTwoFactor completes enrollment with a code from the authenticator app,
+// enables two-factor authentication and returns recovery codes. Later logins
+// need a code; existing sessions are not affected.
+func (h *AuthHandler) VerifyTwoFactor(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	account, err := h.twoFactorAccount(c)
+	if err != nil {
+		return twoFactorAccountError(c, err)
+	}
+
+	// Parse request body
+	var req models.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "code is required",
+		})
+	}
+
+	status, err := h.PostgresClient.GetTwoFactorStatus(ctx, account.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if status.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication is already enabled",
+		})
+	}
+	if status.Secret == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Start enrollment first",
+		})
+	}
+
+	step, ok := totp.Validate(status.Secret, req.Code, time.Now(), status.LastStep)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid authentication code",
+		})
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if err := h.PostgresClient.EnableTwoFactor(ctx, account.ID, step, hashes); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(models.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes. A current
+// TOTP code is required.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	account, err := h.twoFactorAccount(c)
+	if err != nil {
+		return twoFactorAccountError(c, err)
+	}
+	if !account.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication is not enabled",
+		})
+	}
+
+	// Parse request body
+	var req models.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "code is required",
+		})
+	}
+
+	valid, err := h.verifySecondFactor(ctx, account.ID, req.Code, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid authentication code",
+		})
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	if err := h.PostgresClient.ReplaceRecoveryCodes(ctx, account.ID, hashes); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(models.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the caller. A TOTP
+// or recovery code is required. Administrators cannot disable it while the
+// platform requires it.
+func (h *AuthHandler) DisableTwoFactor(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	account, err := h.twoFactorAccount(c)
+	if err != nil {
+		return twoFactorAccountError(c, err)
+	}
+	if !account.TwoFactorEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication is not enabled",
+		})
+	}
+
+	required, err := h.twoFactorRequired(ctx, account)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if required {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Two-factor authentication is required for admin accounts",
+		})
+	}
+
+	// Parse request body
+	var req models.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "code is required",
+		})
+	}
+
+	valid, err := h.verifySecondFactor(ctx, account.ID, req.Code, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid authentication code",
+		})
+	}
+
+	if err := h.PostgresClient.DisableTwoFactor(ctx, account.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/client"
+	"github.com/lineserve/lineserve-api/pkg/middleware"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// projectTeardownTimeout bounds how long a confirmed project teardown may run
+const projectTeardownTimeout = 30 * time.Minute
+
+// maxProjectNameLength is the longest display name a project may have
+const maxProjectNameLength = 64
+
+// UserProjectHandler handles creating, updating and deleting user projects
+type UserProjectHandler struct {
+	PostgresClient    *client.PostgresClient
+	RoleMapping       openstack.ProjectRoleMapping
+	Quotas            openstack.ProjectQuotas
+	ExternalNetworkID string
+	BootstrapCIDR     string
+	MaxOwnedProjects  int
+}
+
+// NewUserProjectHandler creates a new user project handler
+func NewUserProjectHandler(postgresClient *client.PostgresClient, roleMapping openstack.ProjectRoleMapping, quotas openstack.ProjectQuotas, externalNetworkID, bootstrapCIDR string, maxOwnedProjects int) *UserProjectHandler {
+	return &UserProjectHandler{
+		PostgresClient:    postgresClient,
+		RoleMapping:       roleMapping,
+		Quotas:            quotas,
+		ExternalNetworkID: externalNetworkID,
+		BootstrapCIDR:     bootstrapCIDR,
+		MaxOwnedProjects:  maxOwnedProjects,
+	}
+}
+
+// CreateProject creates an additional project owned by the caller
+func (h *UserProjectHandler) CreateProject(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	// Parse request body
+	var req models.CreateProjectRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate name and network options
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxProjectNameLength {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Project name is required and must be at most %d characters", maxProjectNameLength),
+		})
+	}
+	cidr := h.BootstrapCIDR
+	if req.CIDR != "" {
+		if _, _, err := net.ParseCIDR(req.CIDR); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid CIDR",
+			})
+		}
+		cidr = req.CIDR
+	}
+
+	// Get the caller's account
+	openstackUserID, _ := c.Locals("user_id").(string)
+	account, err := h.PostgresClient.GetUserAccountByOpenStackID(ctx, openstackUserID)
+	if errors.Is(err, client.ErrUserNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: "Account not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	// Enforce the per-account project limit
+	if h.MaxOwnedProjects > 0 {
+		owned, err := h.PostgresClient.CountOwnedProjects(ctx, account.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+		if owned >= h.MaxOwnedProjects {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("You can own at most %d projects", h.MaxOwnedProjects),
+			})
+		}
+	}
+
+	ownerRoleID, err := h.RoleMapping.KeystoneRoleID(models.ProjectRoleOwner)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to resolve owner role: %v", err),
+		})
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	// Create the OpenStack project. Keystone names are unique per domain, so
+	// the display name is kept in the database only.
+	keystoneName := fmt.Sprintf("lineserve-project-%s", strings.Split(uuid.New().String(), "-")[0])
+	project, err := openstack.CreateProject(ctx, adminProvider, keystoneName, req.Description, "default")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to create OpenStack project: %v", err),
+		})
+	}
+
+	// rollback removes the Keystone project if a later step fails
+	rollback := func() {
+		if err := openstack.DeleteProject(context.Background(), adminProvider, project.ID); err != nil {
+			fmt.Printf("Warning: Failed to roll back project %s: %v\n", project.ID, err)
+		}
+	}
+
+	// Make the caller the project owner
+	if err := openstack.AssignRoleToUserOnProject(ctx, adminProvider, openstackUserID, project.ID, ownerRoleID); err != nil {
+		rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to assign role: %v", err),
+		})
+	}
+
+	// Save project and membership to database
+	err = h.PostgresClient.SaveProject(ctx, struct {
+		ID          string
+		Name        string
+		Description string
+		DomainID    string
+		Enabled     bool
+	}{
+		ID:          project.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		DomainID:    project.DomainID,
+		Enabled:     true,
+	})
+	if err == nil {
+		_, err = h.PostgresClient.AssociateUserWithProject(ctx, account.ID, project.ID, ownerRoleID, models.ProjectRoleOwner)
+	}
+	if err != nil {
+		rollback()
+		h.PostgresClient.DeleteProjectRecords(context.Background(), project.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to save project: %v", err),
+		})
+	}
+
+	response := models.CreateProjectResponse{
+		Project: models.Project{
+			ID:          project.ID,
+			Name:        req.Name,
+			Description: req.Description,
+			Enabled:     true,
+			DomainID:    project.DomainID,
+			Role:        models.ProjectRoleOwner,
+		},
+	}
+
+	// Quotas and networking are best effort; the project is usable without them
+	var warnings []string
+	if err := openstack.ApplyProjectQuotas(ctx, adminProvider, project.ID, h.Quotas); err != nil {
+		fmt.Printf("Warning: Failed to apply quotas to project %s: %v\n", project.ID, err)
+		warnings = append(warnings, "default quotas could not be applied")
+	}
+
+	if req.BootstrapNetwork {
+		network, err := openstack.BootstrapProjectNetwork(ctx, adminProvider, project.ID, cidr, h.ExternalNetworkID)
+		if err != nil {
+			fmt.Printf("Warning: Failed to bootstrap network for project %s: %v\n", project.ID, err)
+			warnings = append(warnings, fmt.Sprintf("network bootstrap incomplete: %v", err))
+		}
+		response.Network = network
+	}
+	response.Warning = strings.Join(warnings, "; ")
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// UpdateProject renames a project or changes its description
+func (h *UserProjectHandler) UpdateProject(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Parse request body
+	var req models.UpdateProjectRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.Name == nil && req.Description == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name or description is required",
+		})
+	}
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if *req.Name == "" || len(*req.Name) > maxProjectNameLength {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Project name must be between 1 and %d characters", maxProjectNameLength),
+			})
+		}
+	}
+
+	// Check the caller may manage the project
+	if allowed, err := h.canManageProject(c, projectID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Only the project owner can change the project",
+		})
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	// Get current project details
+	keystoneProject, err := openstack.GetProject(ctx, adminProvider, projectID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Project not found: %v", err),
+		})
+	}
+	project, err := h.PostgresClient.GetProjectRecord(ctx, projectID)
+	if errors.Is(err, client.ErrProjectNotFound) {
+		project = &models.Project{ID: projectID, Name: keystoneProject.Name, DomainID: keystoneProject.DomainID, Enabled: true}
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !project.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Project is being deleted",
+		})
+	}
+
+	if req.Name != nil {
+		project.Name = *req.Name
+	}
+	if req.Description != nil {
+		project.Description = *req.Description
+	}
+
+	// Update the description in Keystone; the Keystone name stays unique
+	if _, err := openstack.UpdateProject(ctx, adminProvider, projectID, keystoneProject.Name, project.Description); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to update OpenStack project: %v", err),
+		})
+	}
+
+	// Save project to database
+	err = h.PostgresClient.SaveProject(ctx, struct {
+		ID          string
+		Name        string
+		Description string
+		DomainID    string
+		Enabled     bool
+	}{
+		ID:          project.ID,
+		Name:        project.Name,
+		Description: project.Description,
+		DomainID:    project.DomainID,
+		Enabled:     true,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(project)
+}
+
+// DeleteProject deletes an empty project. A project that still has resources
+// is only deleted with ?teardown=true&confirm=<project name>, which removes
+// every resource in the background first.
+func (h *UserProjectHandler) DeleteProject(c *fiber.Ctx) error {
+	ctx := c.Context()
+	projectID := c.Params("id")
+
+	// Check the caller may manage the project
+	if allowed, err := h.canManageProject(c, projectID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	} else if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Only the project owner can delete the project",
+		})
+	}
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	// Get the project name used for confirmation
+	project, err := h.PostgresClient.GetProjectRecord(ctx, projectID)
+	if errors.Is(err, client.ErrProjectNotFound) {
+		keystoneProject, err := openstack.GetProject(ctx, adminProvider, projectID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("Project not found: %v", err),
+			})
+		}
+		project = &models.Project{ID: projectID, Name: keystoneProject.Name, Enabled: true}
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if !project.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Project deletion is already in progress",
+		})
+	}
+
+	// Check for remaining resources
+	resources, err := openstack.ListProjectResources(ctx, adminProvider, projectID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to list project resources: %v", err),
+		})
+	}
+
+	if !resources.IsEmpty() {
+		if c.Query("teardown") != "true" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":     "Project still has resources; delete them first or pass teardown=true with confirm=<project name>",
+				"resources": resources,
+			})
+		}
+		if c.Query("confirm") != project.Name {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Confirmation does not match the project name",
+			})
+		}
+
+		// Disable the project so it cannot be changed while it is torn down
+		if err := h.PostgresClient.SetProjectEnabled(ctx, projectID, false); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+
+		go h.teardownProject(projectID)
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message":    "Project teardown started",
+			"project_id": projectID,
+			"resources":  resources,
+		})
+	}
+
+	// Delete the empty project
+	if err := h.deleteProject(ctx, projectID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// canManageProject reports whether the caller may change or delete a project
+func (h *UserProjectHandler) canManageProject(c *fiber.Ctx, projectID string) (bool, error) {
+	if middleware.IsAdmin(c) {
+		return true, nil
+	}
+
+	openstackUserID, _ := c.Locals("user_id").(string)
+	role, err := h.PostgresClient.GetProjectRole(c.Context(), openstackUserID, projectID)
+	if err != nil {
+		return false, err
+	}
+
+	return models.ProjectRoleAllows(role, models.PermissionProjectManage), nil
+}
+
+// teardownProject removes every resource in a project and then deletes it.
+// On failure the project is re-enabled so the owner can retry.
+func (h *UserProjectHandler) teardownProject(projectID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), projectTeardownTimeout)
+	defer cancel()
+
+	publishJobProgress("project_teardown", "started", projectID, "", "project", projectID, nil)
+
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err == nil {
+		err = openstack.TeardownProjectResources(ctx, adminProvider, projectID)
+	}
+	if err == nil {
+		err = h.deleteProject(ctx, projectID)
+	}
+	if err != nil {
+		publishJobProgress("project_teardown", "failed", projectID, "", "project", projectID, err)
+		fmt.Printf("Project teardown of %s failed: %v\n", projectID, err)
+		if err := h.PostgresClient.SetProjectEnabled(context.Background(), projectID, true); err != nil {
+			fmt.Printf("Failed to re-enable project %s: %v\n", projectID, err)
+		}
+		return
+	}
+
+	publishJobProgress("project_teardown", "completed", projectID, "", "project", projectID, nil)
+	fmt.Printf("Project %s torn down and deleted\n", projectID)
+}
+
+// deleteProject deletes a project from Keystone and removes its records
+func (h *UserProjectHandler) deleteProject(ctx context.Context, projectID string) error {
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get admin provider: %v", err)
+	}
+
+	if err := openstack.DeleteProject(ctx, adminProvider, projectID); err != nil {
+		return err
+	}
+
+	return h.PostgresClient.DeleteProjectRecords(ctx, projectID)
+}
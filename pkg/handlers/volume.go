@@ -1,12 +1,24 @@
 package handlers
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
 	"github.com/lineserve/lineserve-api/internal/services"
 	"github.com/lineserve/lineserve-api/pkg/client"
 	"github.com/lineserve/lineserve-api/pkg/models"
 )
 
+// volumeErrorStatus returns the HTTP status for a failed block storage request
+func volumeErrorStatus(err error) int {
+	if gophercloud.ResponseCodeIs(err, fiber.StatusRequestEntityTooLarge) {
+		// Cinder reports exceeded quotas as 413
+		return fiber.StatusRequestEntityTooLarge
+	}
+	return instanceActionErrorStatus(err)
+}
+
 // VolumeHandler handles volume related endpoints
 type VolumeHandler struct {
 	Client *client.OpenStackClient
@@ -90,7 +102,19 @@ func (h *VolumeHandler) CreateVolume(c *fiber.Ctx) error {
 			Error: "Name is required",
 		})
 	}
-	if req.Size <= 0 {
+	sources := 0
+	for _, source := range []string{req.ImageID, req.SnapshotID, req.SourceVolumeID} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Only one of image_id, snapshot_id and source_volume_id may be given",
+		})
+	}
+	// A snapshot or volume source gives the size when none is given
+	if req.Size < 0 || (req.Size == 0 && req.SnapshotID == "" && req.SourceVolumeID == "") {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
 			Error: "Size must be greater than 0",
 		})
@@ -102,7 +126,7 @@ func (h *VolumeHandler) CreateVolume(c *fiber.Ctx) error {
 	// Create volume
 	volume, err := volumeService.CreateVolume(req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
 			Error: "Failed to create volume: " + err.Error(),
 		})
 	}
@@ -177,6 +201,58 @@ func (h *VolumeHandler) DeleteVolume(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateVolume handles renaming a volume or changing its description
+func (h *VolumeHandler) UpdateVolume(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume ID is required",
+		})
+	}
+
+	// Parse request body
+	var req models.UpdateVolumeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.Name == nil && req.Description == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name or description is required",
+		})
+	}
+	if req.Name != nil && *req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name cannot be empty",
+		})
+	}
+
+	// Create volume service
+	volumeService := services.NewVolumeService(h.Client)
+
+	// Update volume
+	volume, err := volumeService.UpdateVolume(id, req)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to update volume: " + err.Error(),
+		})
+	}
+
+	// Return volume
+	return c.JSON(volume)
+}
+
 // AttachVolume handles attaching a volume to an instance
 func (h *VolumeHandler) AttachVolume(c *fiber.Ctx) error {
 	// Check if OpenStack client is available
@@ -316,8 +392,20 @@ func (h *VolumeHandler) ResizeVolume(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only available volumes and attached ones, online, can be extended
+	if volume.Status != "available" && volume.Status != "in-use" {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Volume cannot be resized while " + volume.Status,
+		})
+	}
+
 	// Resize volume
-	err = volumeService.ResizeVolume(volumeID, req)
+	err = volumeService.ResizeVolume(volumeID, req, volume.Status == "in-use")
+	if errors.Is(err, services.ErrOnlineExtendUnsupported) {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: "Failed to resize volume: " + err.Error(),
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error: "Failed to resize volume: " + err.Error(),
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/internal/services"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ListVolumeBackups handles listing volume backups; ?volume_id= limits them
+// to one volume
+func (h *VolumeHandler) ListVolumeBackups(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Create volume backup service
+	backupService := services.NewVolumeBackupService(h.Client)
+
+	// Get volume backups
+	backups, err := backupService.ListVolumeBackups(c.Query("volume_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to list volume backups: " + err.Error(),
+		})
+	}
+
+	// Return volume backups
+	return c.JSON(backups)
+}
+
+// CreateVolumeBackup handles backing up a volume
+func (h *VolumeHandler) CreateVolumeBackup(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Parse request body
+	var req models.CreateVolumeBackupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.VolumeID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume ID is required",
+		})
+	}
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name is required",
+		})
+	}
+
+	// Create volume backup service
+	backupService := services.NewVolumeBackupService(h.Client)
+
+	// Create volume backup
+	backup, err := backupService.CreateVolumeBackup(req)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to create volume backup: " + err.Error(),
+		})
+	}
+
+	// Return volume backup
+	return c.Status(fiber.StatusAccepted).JSON(backup)
+}
+
+// GetVolumeBackup handles getting a volume backup by ID
+func (h *VolumeHandler) GetVolumeBackup(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume backup ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume backup ID is required",
+		})
+	}
+
+	// Create volume backup service
+	backupService := services.NewVolumeBackupService(h.Client)
+
+	// Get volume backup
+	backup, err := backupService.GetVolumeBackup(id)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to get volume backup: " + err.Error(),
+		})
+	}
+
+	// Return volume backup
+	return c.JSON(backup)
+}
+
+// DeleteVolumeBackup handles deleting a volume backup by ID
+func (h *VolumeHandler) DeleteVolumeBackup(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume backup ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume backup ID is required",
+		})
+	}
+
+	// Create volume backup service
+	backupService := services.NewVolumeBackupService(h.Client)
+
+	// Delete volume backup
+	err := backupService.DeleteVolumeBackup(id)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to delete volume backup: " + err.Error(),
+		})
+	}
+
+	// Return success
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse{
+		Message: "Volume backup deleted successfully",
+	})
+}
+
+// RestoreVolumeBackup handles restoring a volume backup into a new volume or
+// over an existing available one
+func (h *VolumeHandler) RestoreVolumeBackup(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume backup ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume backup ID is required",
+		})
+	}
+
+	// Parse request body; an empty body restores into a new volume
+	var req models.RestoreVolumeBackupRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Error: "Invalid request body",
+			})
+		}
+	}
+
+	// Create volume backup service
+	backupService := services.NewVolumeBackupService(h.Client)
+
+	// Restore volume backup
+	restore, err := backupService.RestoreVolumeBackup(id, req)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to restore volume backup: " + err.Error(),
+		})
+	}
+
+	// Return restore
+	return c.Status(fiber.StatusAccepted).JSON(restore)
+}
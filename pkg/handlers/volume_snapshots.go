@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/internal/services"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ListVolumeSnapshots handles listing volume snapshots; ?volume_id= limits
+// them to one volume
+func (h *VolumeHandler) ListVolumeSnapshots(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Create volume snapshot service
+	snapshotService := services.NewVolumeSnapshotService(h.Client)
+
+	// Get volume snapshots
+	snapshots, err := snapshotService.ListVolumeSnapshots(c.Query("volume_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to list volume snapshots: " + err.Error(),
+		})
+	}
+
+	// Return volume snapshots
+	return c.JSON(snapshots)
+}
+
+// CreateVolumeSnapshot handles snapshotting a volume
+func (h *VolumeHandler) CreateVolumeSnapshot(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Parse request body
+	var req models.CreateVolumeSnapshotRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.VolumeID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume ID is required",
+		})
+	}
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name is required",
+		})
+	}
+
+	// Create volume snapshot service
+	snapshotService := services.NewVolumeSnapshotService(h.Client)
+
+	// Create volume snapshot
+	snapshot, err := snapshotService.CreateVolumeSnapshot(req)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to create volume snapshot: " + err.Error(),
+		})
+	}
+
+	// Return volume snapshot
+	return c.Status(fiber.StatusCreated).JSON(snapshot)
+}
+
+// GetVolumeSnapshot handles getting a volume snapshot by ID
+func (h *VolumeHandler) GetVolumeSnapshot(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume snapshot ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume snapshot ID is required",
+		})
+	}
+
+	// Create volume snapshot service
+	snapshotService := services.NewVolumeSnapshotService(h.Client)
+
+	// Get volume snapshot
+	snapshot, err := snapshotService.GetVolumeSnapshot(id)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to get volume snapshot: " + err.Error(),
+		})
+	}
+
+	// Return volume snapshot
+	return c.JSON(snapshot)
+}
+
+// UpdateVolumeSnapshot handles renaming a volume snapshot or changing its
+// description
+func (h *VolumeHandler) UpdateVolumeSnapshot(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume snapshot ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume snapshot ID is required",
+		})
+	}
+
+	// Parse request body
+	var req models.UpdateVolumeSnapshotRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if req.Name == nil && req.Description == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name or description is required",
+		})
+	}
+	if req.Name != nil && *req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Name cannot be empty",
+		})
+	}
+
+	// Create volume snapshot service
+	snapshotService := services.NewVolumeSnapshotService(h.Client)
+
+	// Update volume snapshot
+	snapshot, err := snapshotService.UpdateVolumeSnapshot(id, req)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to update volume snapshot: " + err.Error(),
+		})
+	}
+
+	// Return volume snapshot
+	return c.JSON(snapshot)
+}
+
+// DeleteVolumeSnapshot handles deleting a volume snapshot by ID
+func (h *VolumeHandler) DeleteVolumeSnapshot(c *fiber.Ctx) error {
+	// Check if OpenStack client is available
+	if h.Client == nil || h.Client.Volume == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(models.ErrorResponse{
+			Error: "OpenStack volume service unavailable",
+		})
+	}
+
+	// Get volume snapshot ID
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "Volume snapshot ID is required",
+		})
+	}
+
+	// Create volume snapshot service
+	snapshotService := services.NewVolumeSnapshotService(h.Client)
+
+	// Delete volume snapshot
+	err := snapshotService.DeleteVolumeSnapshot(id)
+	if err != nil {
+		return c.Status(volumeErrorStatus(err)).JSON(models.ErrorResponse{
+			Error: "Failed to delete volume snapshot: " + err.Error(),
+		})
+	}
+
+	// Return success
+	return c.Status(fiber.StatusOK).JSON(models.SuccessResponse{
+		Message: "Volume snapshot deleted successfully",
+	})
+}
@@ -1,21 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
+	"github.com/lineserve/lineserve-api/pkg/backup"
 	"github.com/lineserve/lineserve-api/pkg/client"
 	"github.com/lineserve/lineserve-api/pkg/middleware"
 	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/stripe/stripe-go/v72"
 )
 
+// vpsInvoiceMetadataKey is the Stripe metadata key holding the VPS invoice a
+// payment is for
+const vpsInvoiceMetadataKey = "vps_invoice_id"
+
 // VPSHandler handles VPS-related requests
 type VPSHandler struct {
 	SupabaseClient  *client.SupabaseClient
 	OpenStackClient *client.OpenStackClient
+	// StripeClient charges invoices to saved cards; card payments are
+	// unavailable without it
+	StripeClient *client.StripeClient
+	// PostgresClient keeps the backup policies of plans with backups;
+	// VPS backups are not scheduled without it
+	PostgresClient *client.PostgresClient
 }
 
 // NewVPSHandler creates a new VPS handler
@@ -26,6 +40,33 @@ func NewVPSHandler(supabaseClient *client.SupabaseClient, openStackClient *clien
 	}
 }
 
+// vpsBackupKeep is how many daily backups a plan with backups keeps
+const vpsBackupKeep = 7
+
+// validCommitPeriods are the commit periods, in months, a plan can be taken for
+var validCommitPeriods = map[int]bool{1: true, 3: true, 6: true, 12: true, 24: true}
+
+// vpsPlanPrice returns a plan's price for a commit period in months, falling
+// back to the monthly price when the period has none
+func vpsPlanPrice(plan *models.VPSPlan, commitPeriod int) float64 {
+	var price float64
+	switch commitPeriod {
+	case 3:
+		price = plan.PriceCommit3M
+	case 6:
+		price = plan.PriceCommit6M
+	case 12:
+		price = plan.PriceCommit12M
+	case 24:
+		price = plan.PriceCommit24M
+	}
+
+	if price == 0 {
+		price = plan.PriceMonthly
+	}
+	return price
+}
+
 // ListPlans lists all available VPS plans
 func (h *VPSHandler) ListPlans(c *fiber.Ctx) error {
 	plans, err := h.SupabaseClient.GetVPSPlans()
@@ -59,8 +100,7 @@ func (h *VPSHandler) Subscribe(c *fiber.Ctx) error {
 	}
 
 	// Validate commit period
-	validPeriods := map[int]bool{1: true, 3: true, 6: true, 12: true, 24: true}
-	if !validPeriods[req.CommitPeriod] {
+	if !validCommitPeriods[req.CommitPeriod] {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid commit period. Must be 1, 3, 6, 12, or 24 months",
 		})
@@ -75,26 +115,7 @@ func (h *VPSHandler) Subscribe(c *fiber.Ctx) error {
 	}
 
 	// Calculate price based on commit period
-	var price float64
-	switch req.CommitPeriod {
-	case 1:
-		price = plan.PriceMonthly
-	case 3:
-		price = plan.PriceCommit3M
-	case 6:
-		price = plan.PriceCommit6M
-	case 12:
-		price = plan.PriceCommit12M
-	case 24:
-		price = plan.PriceCommit24M
-	default:
-		price = plan.PriceMonthly
-	}
-
-	// If price for the selected period is not set, fall back to monthly
-	if price == 0 {
-		price = plan.PriceMonthly
-	}
+	price := vpsPlanPrice(plan, req.CommitPeriod)
 
 	// Calculate dates
 	now := time.Now()
@@ -162,8 +183,11 @@ func (h *VPSHandler) Subscribe(c *fiber.Ctx) error {
 			networkID := "default-network-id" // This should be replaced with actual network ID
 
 			// Create instance using the compute client directly
+			publishJobProgress("vps_provisioning", "started", projectID, createdSubscription.UserID, "subscription", createdSubscription.ID, nil)
 			instance, err := h.provisionInstance(projectID, instanceName, plan.OpenStackFlavorID, imageID, networkID)
 			if err != nil {
+				publishJobProgress("vps_provisioning", "failed", projectID, createdSubscription.UserID, "subscription", createdSubscription.ID, err)
+
 				// Update subscription with error status
 				updates := map[string]interface{}{
 					"status": "error",
@@ -189,6 +213,8 @@ func (h *VPSHandler) Subscribe(c *fiber.Ctx) error {
 				// Log the error but continue
 				fmt.Printf("Failed to update subscription with instance ID: %v\n", err)
 			}
+			h.scheduleVPSBackups(plan, projectID, instance.ID)
+			publishJobProgress("vps_provisioning", "completed", projectID, createdSubscription.UserID, "subscription", createdSubscription.ID, nil)
 		}
 	}
 
@@ -202,7 +228,7 @@ func (h *VPSHandler) Subscribe(c *fiber.Ctx) error {
 // provisionInstance provisions a new compute instance in OpenStack
 func (h *VPSHandler) provisionInstance(projectID, name, flavorID, imageID, networkID string) (*models.Instance, error) {
 	// Create a new compute handler
-	computeHandler := NewComputeHandler("")
+	computeHandler := NewComputeHandler()
 
 	// Create instance request
 	req := models.CreateInstanceRequest{
@@ -226,6 +252,33 @@ func (h *VPSHandler) provisionInstance(projectID, name, flavorID, imageID, netwo
 	return instance, nil
 }
 
+// scheduleVPSBackups gives the instance of a plan with backups a daily backup
+// policy, which the customer can change like any other
+func (h *VPSHandler) scheduleVPSBackups(plan *models.VPSPlan, projectID, instanceID string) {
+	if h.PostgresClient == nil || plan == nil || !plan.IsBackupAvail {
+		return
+	}
+
+	policy := &models.BackupPolicy{
+		ProjectID:    projectID,
+		ResourceType: models.BackupResourceInstance,
+		ResourceID:   instanceID,
+		Frequency:    models.BackupFrequencyDaily,
+		Keep:         vpsBackupKeep,
+		Enabled:      true,
+		CreatedBy:    "vps",
+	}
+	if err := backup.ValidatePolicy(policy); err != nil {
+		fmt.Printf("Failed to schedule backups of instance %s: %v\n", instanceID, err)
+		return
+	}
+	policy.NextRunAt = backup.NextRun(policy, time.Now())
+	if err := h.PostgresClient.CreateBackupPolicy(context.Background(), policy); err != nil && !errors.Is(err, client.ErrBackupPolicyExists) {
+		// Log the error but continue
+		fmt.Printf("Failed to schedule backups of instance %s: %v\n", instanceID, err)
+	}
+}
+
 // ListSubscriptions lists all VPS subscriptions for the authenticated user
 func (h *VPSHandler) ListSubscriptions(c *fiber.Ctx) error {
 	// Get OpenStack user ID from context
@@ -390,8 +443,7 @@ func (h *VPSHandler) CreateOrder(c *fiber.Ctx) error {
 	}
 
 	// Validate commit period
-	validPeriods := map[int]bool{1: true, 3: true, 6: true, 12: true, 24: true}
-	if !validPeriods[req.CommitPeriod] {
+	if !validCommitPeriods[req.CommitPeriod] {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid commit period. Must be 1, 3, 6, 12, or 24 months",
 		})
@@ -406,26 +458,7 @@ func (h *VPSHandler) CreateOrder(c *fiber.Ctx) error {
 	}
 
 	// Calculate price based on commit period
-	var price float64
-	switch req.CommitPeriod {
-	case 1:
-		price = plan.PriceMonthly
-	case 3:
-		price = plan.PriceCommit3M
-	case 6:
-		price = plan.PriceCommit6M
-	case 12:
-		price = plan.PriceCommit12M
-	case 24:
-		price = plan.PriceCommit24M
-	default:
-		price = plan.PriceMonthly
-	}
-
-	// If price for the selected period is not set, fall back to monthly
-	if price == 0 {
-		price = plan.PriceMonthly
-	}
+	price := vpsPlanPrice(plan, req.CommitPeriod)
 
 	// Calculate dates
 	now := time.Now()
@@ -550,7 +583,8 @@ func (h *VPSHandler) GetInvoice(c *fiber.Ctx) error {
 	return c.JSON(invoice)
 }
 
-// PayInvoice pays a VPS invoice and provisions the VPS
+// PayInvoice pays a VPS invoice with a saved card, the default card unless
+// another is given, and provisions the VPS
 func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 	// Get OpenStack user ID from context
 	openstackUserIDInterface := c.Locals("user_id")
@@ -624,25 +658,101 @@ func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 		})
 	}
 
-	// Process payment (this would integrate with a payment gateway in a real implementation)
-	// For this example, we'll just simulate a successful payment
-	paymentSuccessful := true
-	paymentIntentID := "pi_" + uuid.New().String()
+	// Charge the invoice to a saved card
+	if h.StripeClient == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Card payments are not available",
+		})
+	}
+
+	var paymentIntent *stripe.PaymentIntent
+	if req.PaymentIntentID != "" {
+		// Resume a payment the customer has since authenticated
+		paymentIntent, err = h.StripeClient.GetPaymentIntent(c.Context(), req.PaymentIntentID)
+		if err != nil || paymentIntent.Metadata[vpsInvoiceMetadataKey] != invoice.ID {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Payment not found",
+			})
+		}
+	} else {
+		// Get the user's Stripe customer
+		stripeUser, err := h.SupabaseClient.GetUserByID(userID)
+		if err != nil || stripeUser.StripeCustomerID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "No saved card. Add one with POST /v1/stripe/payment-methods",
+			})
+		}
+		customerID := stripeUser.StripeCustomerID
 
-	if !paymentSuccessful {
-		// Update invoice status to failed
-		updates := map[string]interface{}{
-			"status":            "failed",
-			"payment_method_id": req.PaymentMethodID,
+		// Use the default card unless another saved card is given
+		paymentMethodID := req.PaymentMethodID
+		if paymentMethodID == "" {
+			customer, err := h.StripeClient.GetCustomer(c.Context(), customerID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": fmt.Sprintf("Failed to get Stripe customer: %v", err),
+				})
+			}
+			if customer.InvoiceSettings == nil || customer.InvoiceSettings.DefaultPaymentMethod == nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "No default card. Set one with POST /v1/stripe/payment-methods/:id/default",
+				})
+			}
+			paymentMethodID = customer.InvoiceSettings.DefaultPaymentMethod.ID
 		}
-		_, err := h.SupabaseClient.UpdateVPSInvoice(id, updates)
+
+		// Charge the card; repeated requests for the same invoice and card are
+		// charged once. A decline stamps updated_at, so a retry after it is a
+		// new attempt rather than a replay of the stored decline.
+		paymentIntent, err = h.StripeClient.ChargePaymentMethod(
+			c.Context(),
+			int64(math.Round(invoice.Amount*100)), // Convert to cents
+			strings.ToLower(invoice.Currency),
+			customerID,
+			paymentMethodID,
+			fmt.Sprintf("VPS Plan %s - %d months", invoice.PlanCode, invoice.PeriodMonths),
+			fmt.Sprintf("vps-invoice-%s-%s-%d", invoice.ID, paymentMethodID, invoice.UpdatedAt.UnixMicro()),
+			map[string]string{vpsInvoiceMetadataKey: invoice.ID},
+		)
 		if err != nil {
-			// Log the error but continue
-			fmt.Printf("Failed to update invoice status: %v\n", err)
+			var stripeErr *stripe.Error
+			if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+				// Update invoice status to failed
+				updates := map[string]interface{}{
+					"status":            "failed",
+					"payment_method_id": "stripe",
+					"updated_at":        time.Now(),
+				}
+				_, updateErr := h.SupabaseClient.UpdateVPSInvoice(id, updates)
+				if updateErr != nil {
+					// Log the error but continue
+					fmt.Printf("Failed to update invoice status: %v\n", updateErr)
+				}
+
+				return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+					"error": fmt.Sprintf("Payment failed: %s", stripeErr.Msg),
+				})
+			}
+
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to charge card: %v", err),
+			})
 		}
+	}
 
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Payment failed",
+	switch paymentIntent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+	case stripe.PaymentIntentStatusRequiresAction:
+		// The client completes authentication with Stripe.js, then pays again
+		// with the payment intent ID
+		return c.Status(fiber.StatusPaymentRequired).JSON(models.VPSInvoiceActionRequiredResponse{
+			Error:           "Payment requires authentication",
+			PaymentIntentID: paymentIntent.ID,
+			ClientSecret:    paymentIntent.ClientSecret,
+		})
+	default:
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+			"error": fmt.Sprintf("Payment was not completed (status %s)", paymentIntent.Status),
 		})
 	}
 
@@ -650,8 +760,9 @@ func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 	now := time.Now()
 	invoiceUpdates := map[string]interface{}{
 		"status":            "paid",
-		"payment_method_id": req.PaymentMethodID,
-		"payment_intent_id": paymentIntentID,
+		"payment_method_id": "stripe",
+		"payment_intent_id": paymentIntent.ID,
+		"stripe_payment_id": paymentIntent.ID,
 		"paid_at":           now,
 	}
 	_, err = h.SupabaseClient.UpdateVPSInvoice(id, invoiceUpdates)
@@ -661,6 +772,8 @@ func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 		})
 	}
 
+	publishInvoicePaid(invoice, "stripe")
+
 	// Get subscription
 	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(invoice.SubscriptionID)
 	if err != nil {
@@ -698,8 +811,11 @@ func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 			networkID := "default-network-id" // This should be replaced with actual network ID
 
 			// Create instance
+			publishJobProgress("vps_provisioning", "started", projectID, subscription.UserID, "subscription", subscription.ID, nil)
 			instance, err := h.provisionInstance(projectID, instanceName, subscription.Plan.OpenStackFlavorID, imageID, networkID)
 			if err != nil {
+				publishJobProgress("vps_provisioning", "failed", projectID, subscription.UserID, "subscription", subscription.ID, err)
+
 				// Update subscription with error status
 				errorUpdates := map[string]interface{}{
 					"status": "provisioning_failed",
@@ -725,6 +841,8 @@ func (h *VPSHandler) PayInvoice(c *fiber.Ctx) error {
 				// Log the error but continue
 				fmt.Printf("Failed to update subscription with instance ID: %v\n", err)
 			}
+			h.scheduleVPSBackups(subscription.Plan, projectID, instance.ID)
+			publishJobProgress("vps_provisioning", "completed", projectID, subscription.UserID, "subscription", subscription.ID, nil)
 
 			instanceID = instance.ID
 		}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// ownedSubscriptionInstance returns the instance of a VPS subscription owned
+// by the caller, or writes an error response and returns an empty ID
+func (h *VPSHandler) ownedSubscriptionInstance(c *fiber.Ctx) (string, error) {
+	// Get the Supabase user ID subscriptions are recorded under
+	userID, err := invoiceOwnerID(c, h.SupabaseClient)
+	if err != nil {
+		return "", invoiceOwnerError(c, err)
+	}
+
+	// Get subscription from Supabase
+	subscription, err := h.SupabaseClient.GetVPSSubscriptionByID(c.Params("id"))
+	if err != nil {
+		return "", c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Subscription not found: %v", err),
+		})
+	}
+
+	// Check if subscription belongs to user
+	if subscription.UserID != userID {
+		return "", c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You do not have permission to access this subscription",
+		})
+	}
+	if subscription.InstanceID == "" {
+		return "", c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Subscription has no instance yet",
+		})
+	}
+
+	return subscription.InstanceID, nil
+}
+
+// CreateSubscriptionConsole opens a remote console to a VPS subscription's instance
+func (h *VPSHandler) CreateSubscriptionConsole(c *fiber.Ctx) error {
+	instanceID, err := h.ownedSubscriptionInstance(c)
+	if instanceID == "" {
+		return err
+	}
+
+	// Parse request body; the console type is optional
+	var req models.InstanceConsoleRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid request body: %v", err),
+			})
+		}
+	}
+
+	// VPS instances live in their owners' projects, so use the admin provider
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	console, err := openstack.CreateRemoteConsole(ctx, adminProvider, instanceID, req.Type)
+	if err != nil {
+		return c.Status(consoleErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(console)
+}
+
+// GetSubscriptionConsoleLog returns the last lines of a VPS subscription's
+// instance console log
+func (h *VPSHandler) GetSubscriptionConsoleLog(c *fiber.Ctx) error {
+	instanceID, err := h.ownedSubscriptionInstance(c)
+	if instanceID == "" {
+		return err
+	}
+
+	lines, err := consoleLogLines(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// VPS instances live in their owners' projects, so use the admin provider
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	output, err := openstack.GetConsoleLog(ctx, adminProvider, instanceID, lines)
+	if err != nil {
+		return c.Status(consoleErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(models.InstanceConsoleLogResponse{
+		Lines:  lines,
+		Output: output,
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/image/v2/images"
+	"github.com/lineserve/lineserve-api/pkg/events"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// ListSubscriptionSnapshots lists the snapshots of a VPS subscription's
+// instance, which it can be reinstalled from
+func (h *VPSHandler) ListSubscriptionSnapshots(c *fiber.Ctx) error {
+	instanceID, err := h.ownedSubscriptionInstance(c)
+	if instanceID == "" {
+		return err
+	}
+
+	// VPS instances live in their owners' projects, so use the admin provider
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+
+	snapshots, err := openstack.ListSnapshots(ctx, adminProvider, "", instanceID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"snapshots": snapshots,
+	})
+}
+
+// ReinstallSubscription rebuilds a VPS subscription's instance from a public
+// image or an image owned by the instance's project, such as one of its
+// snapshots. The instance's disk is wiped.
+func (h *VPSHandler) ReinstallSubscription(c *fiber.Ctx) error {
+	// Parse request body
+	var req models.VPSReinstallRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
+	if req.ImageID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "image_id is required",
+		})
+	}
+
+	instanceID, err := h.ownedSubscriptionInstance(c)
+	if instanceID == "" {
+		return err
+	}
+
+	// VPS instances live in their owners' projects, so use the admin provider
+	ctx := context.Background()
+	adminProvider, err := openstack.GetAdminProvider(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get admin provider: %v", err),
+		})
+	}
+	computeClient, err := openstack.NewComputeClient(adminProvider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create compute client: %v", err),
+		})
+	}
+	imageClient, err := openstack.NewImageClient(adminProvider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create image client: %v", err),
+		})
+	}
+
+	// Get server and check its state
+	server, err := servers.Get(ctx, computeClient, instanceID).Extract()
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get instance: %v", err),
+		})
+	}
+	if reason := checkInstanceActionState("rebuild", server); reason != "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": reason,
+		})
+	}
+
+	// The admin provider sees every image, so only allow the ones the
+	// instance's project could boot itself
+	img, err := images.Get(ctx, imageClient, req.ImageID).Extract()
+	if err != nil && !gophercloud.ResponseCodeIs(err, fiber.StatusNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get image: %v", err),
+		})
+	}
+	if err != nil || (img.Visibility != images.ImageVisibilityPublic && img.Owner != server.TenantID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Image not found",
+		})
+	}
+	if img.Status != images.ImageStatusActive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Image is %s; wait until it is active", img.Status),
+		})
+	}
+
+	// Rebuild the instance
+	rebuilt, err := servers.Rebuild(ctx, computeClient, server.ID, servers.RebuildOpts{
+		ImageRef:  img.ID,
+		AdminPass: req.AdminPassword,
+	}).Extract()
+	if err != nil {
+		return c.Status(instanceActionErrorStatus(err)).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to reinstall instance: %v", err),
+		})
+	}
+
+	events.Publish(events.Event{
+		Type:         events.TypeInstanceAction,
+		ProjectID:    server.TenantID,
+		ResourceType: "instance",
+		ResourceID:   server.ID,
+		Data: map[string]interface{}{
+			"action":   "rebuild",
+			"image_id": img.ID,
+		},
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(models.InstanceActionResponse{
+		Message:       "Reinstall started",
+		AdminPassword: rebuilt.AdminPass,
+	})
+}
@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each message to an .eml file instead of sending it, for
+// development and tests
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewFileMailer creates a new file mailer
+func NewFileMailer(dir, from string) *FileMailer {
+	if dir == "" {
+		dir = "mail"
+	}
+	return &FileMailer{
+		Dir:  dir,
+		From: from,
+	}
+}
+
+// Send writes a message to the mail directory
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail directory: %v", err)
+	}
+
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%04d-%s.eml", time.Now().Format("20060102T150405"), m.seq, safeFileName(msg.To))
+	m.mu.Unlock()
+
+	if err := os.WriteFile(filepath.Join(m.Dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+
+	return nil
+}
+
+// safeFileName replaces characters that do not belong in a file name
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// LogMailer logs the recipient and subject of messages instead of sending
+// them. Bodies are left out since they carry verification and reset links.
+type LogMailer struct {
+	From string
+}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{From: from}
+}
+
+// Send logs a message's recipient and subject
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if _, err := buildMessage(m.From, msg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Mail to %s: %s\n", msg.To, msg.Subject)
+	return nil
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/openstack"
+)
+
+// APIKeyPrefix starts every personal API key
+const APIKeyPrefix = "lsk_"
+
+// APIKeyTokenType is the typ claim set for requests authenticated with an API key
+const APIKeyTokenType = "api_key"
+
+// apiKeyTokenMargin is how long before expiry a cached OpenStack token is renewed
+const apiKeyTokenMargin = 5 * time.Minute
+
+// APIKeyStore looks up personal API keys. GetActiveAPIKeyByHash returns a nil
+// key when no unrevoked, unexpired key matches, together with the owner's
+// platform role and whether the owner is suspended.
+type APIKeyStore interface {
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, string, bool, error)
+	TouchAPIKey(ctx context.Context, id, ipAddress string) error
+}
+
+// AuthStore is the storage used by the authentication middleware
+type AuthStore interface {
+	SessionStore
+	APIKeyStore
+}
+
+// HashAPIKey hashes an API key for storage and lookup
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKey splits an API key of the form lsk_<prefix>_<secret> into its
+// public prefix and its secret
+func ParseAPIKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", "", false
+	}
+
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", "", false
+	}
+
+	return prefix, secret, true
+}
+
+// apiKeyProvider is an OpenStack provider authenticated with an API key's
+// application credential
+type apiKeyProvider struct {
+	provider  *gophercloud.ProviderClient
+	expiresAt time.Time
+}
+
+// apiKeyProviderCache caches OpenStack providers per API key so requests do
+// not authenticate against Keystone every time
+type apiKeyProviderCache struct {
+	mu        sync.Mutex
+	providers map[string]apiKeyProvider
+}
+
+// get returns a provider for an API key, authenticating if none is cached or
+// the cached token is about to expire
+func (pc *apiKeyProviderCache) get(ctx context.Context, keyHash, credentialID, secret string) (*gophercloud.ProviderClient, error) {
+	pc.mu.Lock()
+	cached, ok := pc.providers[keyHash]
+	pc.mu.Unlock()
+	if ok && time.Until(cached.expiresAt) > apiKeyTokenMargin {
+		return cached.provider, nil
+	}
+
+	provider, expiresAt, err := openstack.AuthenticateWithApplicationCredential(ctx, credentialID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	// Drop expired entries while holding the lock
+	for hash, entry := range pc.providers {
+		if time.Now().After(entry.expiresAt) {
+			delete(pc.providers, hash)
+		}
+	}
+	pc.providers[keyHash] = apiKeyProvider{provider: provider, expiresAt: expiresAt}
+
+	return provider, nil
+}
+
+// authenticateAPIKey authenticates a request made with a personal API key. The
+// request gets the same context as one made with a project token for the
+// key's project, restricted to the key's permissions.
+func authenticateAPIKey(c *fiber.Ctx, store APIKeyStore, cache *apiKeyProviderCache, key string) error {
+	_, secret, ok := ParseAPIKey(key)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid API key",
+		})
+	}
+
+	// Look up the key by its hash
+	keyHash := HashAPIKey(key)
+	apiKey, role, suspended, err := store.GetActiveAPIKeyByHash(c.Context(), keyHash)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: "Failed to check API key",
+		})
+	}
+	if apiKey == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error: "Invalid, expired or revoked API key",
+		})
+	}
+	if suspended {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "account is suspended",
+		})
+	}
+
+	claims := jwt.MapClaims{
+		"typ":         APIKeyTokenType,
+		"api_key_id":  apiKey.ID,
+		"user_id":     apiKey.UserID,
+		"username":    apiKey.Username,
+		"domain_name": apiKey.DomainName,
+		"role":        role,
+	}
+
+	// Project keys act through their Keystone application credential
+	if apiKey.ProjectID != "" {
+		provider, err := cache.get(c.Context(), keyHash, apiKey.ApplicationCredentialID, secret)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error: "API key was rejected by OpenStack",
+			})
+		}
+
+		claims["project_id"] = apiKey.ProjectID
+		claims["openstack_token"] = provider.Token()
+		c.Locals("provider", provider)
+		c.Locals("has_project_scope", true)
+	}
+
+	// Last-used tracking must not fail the request
+	store.TouchAPIKey(c.Context(), apiKey.ID, ClientIP(c))
+
+	// Store claims in context
+	c.Locals("user", claims)
+	c.Locals("session_id", "")
+	c.Locals("api_key_id", apiKey.ID)
+	c.Locals("api_key_permissions", apiKey.Permissions)
+	c.Locals("user_id", apiKey.UserID)
+	c.Locals("username", apiKey.Username)
+	c.Locals("project_id", apiKey.ProjectID)
+	c.Locals("domain_name", apiKey.DomainName)
+	c.Locals("role", role)
+
+	return c.Next()
+}
+
+// IsAPIKeyRequest reports whether the request was authenticated with an API key
+func IsAPIKeyRequest(c *fiber.Ctx) bool {
+	keyID, _ := c.Locals("api_key_id").(string)
+	return keyID != ""
+}
+
+// APIKeyAllows reports whether the API key used for a request grants a
+// permission. Keys without a permission list allow everything their owner's
+// role allows, as do requests that did not use an API key.
+func APIKeyAllows(c *fiber.Ctx, permission string) bool {
+	permissions, _ := c.Locals("api_key_permissions").([]string)
+	if !IsAPIKeyRequest(c) || len(permissions) == 0 || permission == "" {
+		return true
+	}
+
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeysForbidden is middleware for routes that manage the account itself,
+// such as sessions, two-factor settings and API keys. These have no project
+// permission to restrict a key to, so only signed-in users may use them.
+func APIKeysForbidden() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IsAPIKeyRequest(c) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: "This endpoint cannot be used with an API key",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// APIKeyProjectPermission is middleware for routes that name a project in a
+// URL parameter and check the caller's role in the handler. An API key may
+// only act on its own project, and only with the permission.
+func APIKeyProjectPermission(param, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !IsAPIKeyRequest(c) {
+			return c.Next()
+		}
+
+		projectID, _ := c.Locals("project_id").(string)
+		if c.Params(param) != projectID {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: "API key is not scoped to this project",
+			})
+		}
+		if !APIKeyAllows(c, permission) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: fmt.Sprintf("This API key does not have the %s permission", permission),
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// AuditLogStore persists admin audit log entries
+type AuditLogStore interface {
+	InsertAdminAuditLog(ctx context.Context, entry *models.AdminAuditLog) error
+}
+
+// SetAuditAction lets a handler describe the action being audited. When not
+// set, AdminAudit derives the action from the request method and route.
+func SetAuditAction(c *fiber.Ctx, action, targetType, targetID string, details map[string]interface{}) {
+	c.Locals("audit_action", action)
+	c.Locals("audit_target_type", targetType)
+	c.Locals("audit_target_id", targetID)
+	if details != nil {
+		c.Locals("audit_details", details)
+	}
+}
+
+// AdminAudit is middleware that records every request made through the admin API
+func AdminAudit(store AuditLogStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Run the handler first so the outcome can be recorded
+		handlerErr := c.Next()
+
+		statusCode := c.Response().StatusCode()
+		if handlerErr != nil {
+			statusCode = fiber.StatusInternalServerError
+			if e, ok := handlerErr.(*fiber.Error); ok {
+				statusCode = e.Code
+			}
+		}
+
+		adminUserID, _ := c.Locals("user_id").(string)
+		adminUsername, _ := c.Locals("username").(string)
+
+		entry := &models.AdminAuditLog{
+			AdminUserID:   adminUserID,
+			AdminUsername: adminUsername,
+			Method:        c.Method(),
+			Path:          c.Path(),
+			StatusCode:    statusCode,
+			IPAddress:     ClientIP(c),
+		}
+
+		// Use the handler-provided description if there is one
+		if action, ok := c.Locals("audit_action").(string); ok && action != "" {
+			entry.Action = action
+			entry.TargetType, _ = c.Locals("audit_target_type").(string)
+			entry.TargetID, _ = c.Locals("audit_target_id").(string)
+		} else {
+			entry.Action = strings.ToLower(c.Method()) + " " + c.Route().Path
+			entry.TargetID = c.Params("id")
+		}
+		if details, ok := c.Locals("audit_details").(map[string]interface{}); ok {
+			entry.Details = details
+		}
+
+		// Audit failures must not change the response, but they must be visible
+		if err := store.InsertAdminAuditLog(context.Background(), entry); err != nil {
+			log.Printf("Warning: failed to write admin audit log for %s %s: %v", entry.Method, entry.Path, err)
+		}
+
+		return handlerErr
+	}
+}
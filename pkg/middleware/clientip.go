@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TrustedProxies is a set of proxy IPs and networks allowed to report the
+// client IP in a proxy header
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses proxy IPs and CIDRs
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// contains reports whether an IP is one of the trusted proxies
+func (p TrustedProxies) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClientIP is middleware that works out the client IP of a request
+// and stores it for ClientIP. When the peer is a trusted proxy, the proxy
+// header is read from the right, skipping trusted hops, so the first address
+// a trusted proxy did not add is taken. Entries to its left were written by
+// the client and are ignored. Without a header the peer address is used.
+func ResolveClientIP(header string, trusted TrustedProxies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		clientIP := c.Context().RemoteIP()
+
+		if header != "" && trusted.contains(clientIP) {
+			// Join repeated headers in the order they were added
+			var hops []string
+			for _, value := range c.Request().Header.PeekAll(header) {
+				hops = append(hops, strings.Split(string(value), ",")...)
+			}
+
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := net.ParseIP(strings.TrimSpace(hops[i]))
+				if hop == nil {
+					break
+				}
+				clientIP = hop
+				if !trusted.contains(hop) {
+					break
+				}
+			}
+		}
+
+		c.Locals("client_ip", clientIP.String())
+		return c.Next()
+	}
+}
+
+// ClientIP returns the client IP resolved by ResolveClientIP, or the peer
+// address when it did not run
+func ClientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("client_ip").(string); ok && ip != "" {
+		return ip
+	}
+	return c.IP()
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import "github.com/gofiber/fiber/v2"
+
+// RouteGroup registers routes with a fixed chain of route-level middleware.
+//
+// Fiber applies group middleware added with Use to every route sharing the
+// group's path prefix, including routes of sibling groups. The protected and
+// project-scoped groups both live at /v1/, so their middleware would otherwise
+// leak onto unrelated routes such as /v1/admin or public webhooks.
+type RouteGroup struct {
+	router   fiber.Router
+	handlers []fiber.Handler
+}
+
+// NewRouteGroup creates a route group on top of a fiber router
+func NewRouteGroup(router fiber.Router, handlers ...fiber.Handler) *RouteGroup {
+	return &RouteGroup{
+		router:   router,
+		handlers: handlers,
+	}
+}
+
+// Use appends middleware to the routes registered on this group afterwards
+func (g *RouteGroup) Use(handlers ...fiber.Handler) *RouteGroup {
+	g.handlers = append(g.handlers, handlers...)
+	return g
+}
+
+// Group creates a sub-group that inherits the current middleware chain
+func (g *RouteGroup) Group(prefix string, handlers ...fiber.Handler) *RouteGroup {
+	chain := make([]fiber.Handler, 0, len(g.handlers)+len(handlers))
+	chain = append(chain, g.handlers...)
+	chain = append(chain, handlers...)
+
+	return &RouteGroup{
+		router:   g.router.Group(prefix),
+		handlers: chain,
+	}
+}
+
+// chain returns the middleware chain followed by the route handlers
+func (g *RouteGroup) chain(handlers []fiber.Handler) []fiber.Handler {
+	all := make([]fiber.Handler, 0, len(g.handlers)+len(handlers))
+	all = append(all, g.handlers...)
+	return append(all, handlers...)
+}
+
+// Get registers a GET route
+func (g *RouteGroup) Get(path string, handlers ...fiber.Handler) {
+	g.router.Get(path, g.chain(handlers)...)
+}
+
+// Post registers a POST route
+func (g *RouteGroup) Post(path string, handlers ...fiber.Handler) {
+	g.router.Post(path, g.chain(handlers)...)
+}
+
+// Put registers a PUT route
+func (g *RouteGroup) Put(path string, handlers ...fiber.Handler) {
+	g.router.Put(path, g.chain(handlers)...)
+}
+
+// Patch registers a PATCH route
+func (g *RouteGroup) Patch(path string, handlers ...fiber.Handler) {
+	g.router.Patch(path, g.chain(handlers)...)
+}
+
+// Delete registers a DELETE route
+func (g *RouteGroup) Delete(path string, handlers ...fiber.Handler) {
+	g.router.Delete(path, g.chain(handlers)...)
+}
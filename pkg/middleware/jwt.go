@@ -1,17 +1,34 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lineserve/lineserve-api/pkg/models"
+	"github.com/lineserve/lineserve-api/pkg/signing"
 )
 
-// JWTMiddleware creates a JWT middleware
-func JWTMiddleware(jwtSecret string) fiber.Handler {
+// SessionStore checks whether the session an access token belongs to is still active
+type SessionStore interface {
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// SecuritySettingsStore reads the platform security settings
+type SecuritySettingsStore interface {
+	GetSecuritySettings(ctx context.Context) (*models.SecuritySettings, error)
+}
+
+// AccessTokenType is the typ claim of access tokens
+const AccessTokenType = "access"
+
+// JWTMiddleware creates a JWT middleware. Access tokens must carry the ID of
+// an active session, so revoking a session takes effect immediately. Requests
+// may instead authenticate with a personal API key ("Authorization: ApiKey ...").
+func JWTMiddleware(keys *signing.KeySet, store AuthStore) fiber.Handler {
+	providers := &apiKeyProviderCache{providers: map[string]apiKeyProvider{}}
+
 	return func(c *fiber.Ctx) error {
 		// Get authorization header
 		authHeader := c.Get("Authorization")
@@ -21,6 +38,11 @@ func JWTMiddleware(jwtSecret string) fiber.Handler {
 			})
 		}
 
+		// API keys are looked up instead of verified as JWTs
+		if strings.HasPrefix(authHeader, "ApiKey ") {
+			return authenticateAPIKey(c, store, providers, strings.TrimPrefix(authHeader, "ApiKey "))
+		}
+
 		// Check if the header has the Bearer prefix
 		if !strings.HasPrefix(authHeader, "Bearer ") {
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
@@ -31,51 +53,49 @@ func JWTMiddleware(jwtSecret string) fiber.Handler {
 		// Extract the token
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Parse the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
-
+		// Verify the token against the key named by its kid header
+		claims, err := keys.Parse(tokenString)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
 				Error: fmt.Sprintf("Invalid token: %v", err),
 			})
 		}
 
-		// Check if the token is valid
-		if !token.Valid {
+		// Only access tokens grant API access
+		if claims["typ"] != AccessTokenType {
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
-				Error: "Invalid token",
+				Error: "Invalid token type",
 			})
 		}
 
-		// Get claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		// Check that the session has not been revoked
+		sessionID, _ := claims["sid"].(string)
+		if sessionID == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
-				Error: "Invalid token claims",
+				Error: "Invalid token: missing session",
 			})
 		}
-
-		// Check if the token is expired
-		if exp, ok := claims["exp"].(float64); ok {
-			if time.Now().Unix() > int64(exp) {
-				return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
-					Error: "Token expired",
-				})
-			}
+		active, err := store.IsSessionActive(c.Context(), sessionID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error: "Failed to check session",
+			})
+		}
+		if !active {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error: "Session has been revoked or has expired",
+			})
 		}
 
 		// Store claims in context
 		c.Locals("user", claims)
+		c.Locals("session_id", sessionID)
 		c.Locals("user_id", claims["user_id"])
 		c.Locals("username", claims["username"])
 		c.Locals("project_id", claims["project_id"])
 		c.Locals("domain_name", claims["domain_name"])
+		c.Locals("role", claims["role"])
+		c.Locals("mfa", claims["mfa"] == true)
 
 		// If this is a project-scoped token, mark it in the context
 		if projectID, ok := claims["project_id"].(string); ok && projectID != "" {
@@ -90,17 +110,20 @@ func JWTMiddleware(jwtSecret string) fiber.Handler {
 
 // IsAdmin checks if the user has admin role
 func IsAdmin(c *fiber.Ctx) bool {
-	role := c.Locals("role")
-	if role == nil {
+	role, ok := c.Locals("role").(string)
+	if !ok {
 		return false
 	}
 
 	// Check if role is admin
-	return role.(string) == "admin"
+	return role == models.UserRoleAdmin
 }
 
-// AdminRequired is middleware that checks if the user has admin role
-func AdminRequired() fiber.Handler {
+// AdminRequired is middleware that checks if the user has admin role. When
+// the platform requires two-factor authentication for administrators, the
+// session must also have been started with a second factor. API keys never
+// reach the admin API, whatever their owner's role.
+func AdminRequired(settings SecuritySettingsStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Check if user is admin
 		if !IsAdmin(c) {
@@ -109,6 +132,26 @@ func AdminRequired() fiber.Handler {
 			})
 		}
 
+		// Admin access needs a signed-in session
+		if IsAPIKeyRequest(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "The admin API cannot be used with an API key",
+			})
+		}
+
+		// Check the second factor if the platform requires it
+		security, err := settings.GetSecuritySettings(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to load security settings",
+			})
+		}
+		if mfa, _ := c.Locals("mfa").(bool); security.RequireAdmin2FA && !mfa {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Two-factor authentication is required for admin access; enable it and sign in again",
+			})
+		}
+
 		// Continue
 		return c.Next()
 	}
@@ -33,3 +33,19 @@ func ProjectScopeRequired() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// ProjectParamMatches is middleware that ensures the project ID in a route
+// parameter is the project the token is scoped to
+func ProjectParamMatches(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, _ := c.Locals("project_id").(string)
+		if c.Params(param) != projectID {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error: "Token is not scoped to this project",
+			})
+		}
+
+		// Continue
+		return c.Next()
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// RateLimitStore counts hits against keys in fixed windows. The memory store
+// suits a single replica; the Postgres store shares counts between replicas.
+type RateLimitStore interface {
+	// IncrementRateLimit counts a hit against a key, opening a new window when
+	// none is open, and returns the count in the window and when it ends
+	IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+
+	// GetRateLimit returns the count in a key's open window without counting a
+	// hit; the count is zero when no window is open
+	GetRateLimit(ctx context.Context, key string) (int, time.Time, error)
+
+	// ResetRateLimit closes a key's window
+	ResetRateLimit(ctx context.Context, key string) error
+}
+
+// RateLimitKeyFunc identifies what a request is counted against, such as the
+// client IP or an account. An empty key exempts the request from the policy.
+type RateLimitKeyFunc func(c *fiber.Ctx) string
+
+// RateLimitPolicy allows Limit requests per key in each Window. The name
+// namespaces the counters, so a policy per route gives per-route limits.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    RateLimitKeyFunc
+}
+
+// KeyByIP counts requests per client IP
+func KeyByIP(c *fiber.Ctx) string {
+	return ClientIP(c)
+}
+
+// KeyByUser counts requests per authenticated user; it must run after JWTMiddleware
+func KeyByUser(c *fiber.Ctx) string {
+	userID, _ := c.Locals("user_id").(string)
+	return userID
+}
+
+// KeyByBodyField counts requests per value of a JSON body field, such as the
+// username of a login, compared case-insensitively
+func KeyByBodyField(field string) RateLimitKeyFunc {
+	return func(c *fiber.Ctx) string {
+		var body map[string]interface{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return ""
+		}
+		value, _ := body[field].(string)
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+}
+
+// RateLimit is middleware that rejects requests over any of the policies with
+// 429 Too Many Requests. Responses carry X-RateLimit-Limit, -Remaining and
+// -Reset (Unix time) for the policy closest to its limit, and rejections carry
+// Retry-After. If the store fails, requests are let through.
+func RateLimit(store RateLimitStore, policies ...RateLimitPolicy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		var (
+			tightest  *RateLimitPolicy
+			remaining = math.MaxInt
+			resetAt   time.Time
+			exceeded  bool
+		)
+
+		for i := range policies {
+			policy := &policies[i]
+
+			key := policy.Key(c)
+			if key == "" {
+				continue
+			}
+
+			count, reset, err := store.IncrementRateLimit(ctx, "rate:"+policy.Name+":"+key, policy.Window)
+			if err != nil {
+				log.Printf("Warning: Rate limit check failed for %s: %v", policy.Name, err)
+				continue
+			}
+
+			left := policy.Limit - count
+			if count > policy.Limit {
+				left = 0
+				// Report the policy that blocks longest
+				if !exceeded || reset.After(resetAt) {
+					tightest, remaining, resetAt = policy, left, reset
+				}
+				exceeded = true
+			} else if !exceeded && left < remaining {
+				tightest, remaining, resetAt = policy, left, reset
+			}
+		}
+
+		if tightest != nil {
+			c.Set("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			c.Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+		}
+
+		if exceeded {
+			return tooManyRequests(c, resetAt, "Too many requests")
+		}
+
+		return c.Next()
+	}
+}
+
+// LoginLockoutPolicy locks an account for LockoutDuration once MaxFailures
+// sign-ins fail within FailureWindow. The name namespaces the failure count,
+// so that each sign-in step counts and clears its own failures; all steps
+// share the account's lock.
+type LoginLockoutPolicy struct {
+	Name            string
+	MaxFailures     int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+
+	// Account identifies the account a sign-in is for
+	Account RateLimitKeyFunc
+}
+
+// LoginLockout is middleware for sign-in routes that counts 401 responses
+// against the account and refuses sign-ins to a locked account. A successful
+// response clears the failures of the policy's step only, so passing the
+// password step does not clear wrong second factor codes.
+func LoginLockout(store RateLimitStore, policy LoginLockoutPolicy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		account := policy.Account(c)
+		if account == "" {
+			return c.Next()
+		}
+		lockKey := "login-lock:" + account
+		failureKey := "login-failures:" + policy.Name + ":" + account
+
+		// Refuse sign-ins while the account is locked
+		locked, unlockAt, err := store.GetRateLimit(ctx, lockKey)
+		if err != nil {
+			log.Printf("Warning: Login lockout check failed: %v", err)
+		} else if locked > 0 {
+			return tooManyRequests(c, unlockAt, "Account temporarily locked after repeated failed sign-ins")
+		}
+
+		handlerErr := c.Next()
+
+		// Count the outcome
+		switch status := c.Response().StatusCode(); {
+		case handlerErr == nil && status == fiber.StatusUnauthorized:
+			failures, _, err := store.IncrementRateLimit(ctx, failureKey, policy.FailureWindow)
+			if err != nil {
+				log.Printf("Warning: Failed to record failed sign-in: %v", err)
+			} else if failures >= policy.MaxFailures {
+				log.Printf("Locking account %s for %s after %d failed sign-ins from %s", account, policy.LockoutDuration, failures, ClientIP(c))
+				if _, _, err := store.IncrementRateLimit(ctx, lockKey, policy.LockoutDuration); err != nil {
+					log.Printf("Warning: Failed to lock account: %v", err)
+				}
+				store.ResetRateLimit(ctx, failureKey)
+			}
+		case handlerErr == nil && status >= 200 && status < 300:
+			if err := store.ResetRateLimit(ctx, failureKey); err != nil {
+				log.Printf("Warning: Failed to clear failed sign-ins: %v", err)
+			}
+		}
+
+		return handlerErr
+	}
+}
+
+// tooManyRequests rejects a request until a time with 429 and Retry-After
+func tooManyRequests(c *fiber.Ctx, until time.Time, message string) error {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{
+		Error: fmt.Sprintf("%s; try again in %s", message, (time.Duration(retryAfter) * time.Second).String()),
+	})
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryRateLimitSweepInterval is how often expired windows are dropped
+const memoryRateLimitSweepInterval = time.Minute
+
+// rateLimitWindow is a counter and the end of its window
+type rateLimitWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryRateLimitStore keeps rate limit counters in process memory. Counts are
+// not shared between replicas; use the Postgres store for those.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateLimitWindow
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore creates a new in-memory rate limit store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows:   make(map[string]*rateLimitWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// IncrementRateLimit counts a hit against a key
+func (s *MemoryRateLimitStore) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = &rateLimitWindow{resetAt: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+
+	return w.count, w.resetAt, nil
+}
+
+// GetRateLimit returns the count in a key's open window
+func (s *MemoryRateLimitStore) GetRateLimit(ctx context.Context, key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[key]
+	if !ok || !time.Now().Before(w.resetAt) {
+		return 0, time.Time{}, nil
+	}
+
+	return w.count, w.resetAt, nil
+}
+
+// ResetRateLimit closes a key's window
+func (s *MemoryRateLimitStore) ResetRateLimit(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.windows, key)
+	return nil
+}
+
+// sweep drops expired windows so the map does not grow without bound; the
+// caller must hold the lock
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryRateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, w := range s.windows {
+		if !now.Before(w.resetAt) {
+			delete(s.windows, key)
+		}
+	}
+}
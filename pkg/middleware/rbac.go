@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ProjectRoleStore looks up a user's role in a project
+type ProjectRoleStore interface {
+	GetProjectRole(ctx context.Context, openstackUserID, projectID string) (string, error)
+}
+
+// loadProjectRole resolves the caller's role in the token's project and
+// caches it in the request context
+func loadProjectRole(c *fiber.Ctx, store ProjectRoleStore) (string, error) {
+	if role, ok := c.Locals("project_role").(string); ok && role != "" {
+		return role, nil
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	projectID, _ := c.Locals("project_id").(string)
+
+	role, err := store.GetProjectRole(c.Context(), userID, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	c.Locals("project_role", role)
+	return role, nil
+}
+
+// checkProjectPermission verifies the caller holds a permission in the token's project
+func checkProjectPermission(c *fiber.Ctx, store ProjectRoleStore, permission string) error {
+	// API keys may be restricted to fewer permissions than their owner has
+	if !APIKeyAllows(c, permission) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("This API key does not have the %s permission", permission),
+		})
+	}
+
+	// Unscoped tokens act on the caller's own account, not on a project
+	if hasProjectScope, _ := c.Locals("has_project_scope").(bool); !hasProjectScope {
+		return c.Next()
+	}
+
+	// Platform administrators are not restricted by project roles
+	if IsAdmin(c) {
+		return c.Next()
+	}
+
+	role, err := loadProjectRole(c, store)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Failed to resolve project role: %v", err),
+		})
+	}
+
+	if role == "" {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "You are not a member of this project",
+		})
+	}
+
+	if permission != "" && !models.ProjectRoleAllows(role, permission) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Your project role (%s) does not allow this action", role),
+		})
+	}
+
+	return c.Next()
+}
+
+// ProjectPermission is middleware that enforces project roles on project-scoped
+// tokens. Read-only requests (GET and HEAD) need readPermission, everything
+// else needs writePermission.
+func ProjectPermission(store ProjectRoleStore, readPermission, writePermission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		permission := writePermission
+		if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			permission = readPermission
+		}
+
+		return checkProjectPermission(c, store, permission)
+	}
+}
+
+// RequireProjectPermission is middleware that requires a single project
+// permission regardless of the request method
+func RequireProjectPermission(store ProjectRoleStore, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return checkProjectPermission(c, store, permission)
+	}
+}
+
+// ProjectMemberRequired is middleware that only requires membership in the
+// token's project, whatever the role
+func ProjectMemberRequired(store ProjectRoleStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return checkProjectPermission(c, store, "")
+	}
+}
@@ -11,10 +11,12 @@ type LoginRequest struct {
 
 // LoginResponse represents the login response body
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	UserID    string    `json:"user_id"`
-	Projects  []Project `json:"projects"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token            string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	UserID           string    `json:"user_id"`
+	Projects         []Project `json:"projects"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
 }
 
 // ProjectScopeRequest represents a request to get a project-scoped token
@@ -27,9 +29,139 @@ type ProjectScopeRequest struct {
 
 // ProjectScopeResponse represents the response for a project-scoped token
 type ProjectScopeResponse struct {
-	Token     string    `json:"token"`
-	ProjectID string    `json:"project_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token            string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ProjectID        string    `json:"project_id"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// RefreshTokenRequest represents a request to exchange a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse represents a new access and refresh token pair
+type TokenResponse struct {
+	Token            string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// Session represents a signed-in session. Each session is one refresh token
+// family; rotating the refresh token keeps the same session.
+type Session struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"user_id"`
+	Username       string     `json:"username"`
+	DomainName     string     `json:"domain_name,omitempty"`
+	ProjectID      string     `json:"project_id,omitempty"`
+	OpenstackToken string     `json:"-"`
+	UserAgent      string     `json:"user_agent,omitempty"`
+	IPAddress      string     `json:"ip_address,omitempty"`
+	MFA            bool       `json:"mfa"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     time.Time  `json:"last_used_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	Current        bool       `json:"current,omitempty"`
+}
+
+// MFAChallengeResponse is returned by the password step of a login when the
+// account uses two-factor authentication
+type MFAChallengeResponse struct {
+	MFARequired    bool      `json:"mfa_required"`
+	ChallengeToken string    `json:"challenge_token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// MFALoginRequest completes a login with a TOTP or recovery code
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// MFAChallenge holds the outcome of a login's password step until the second
+// factor is presented. ProjectID is set for project token logins.
+type MFAChallenge struct {
+	ID               string
+	UserID           string
+	Username         string
+	DomainName       string
+	ProjectID        string
+	OpenstackToken   string
+	Projects         []Project
+	SessionExpiresAt time.Time
+	Attempts         int
+	ExpiresAt        time.Time
+	UsedAt           *time.Time
+}
+
+// TwoFactorStatus represents an account's two-factor authentication state
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	Required               bool       `json:"required"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	Secret                 string     `json:"-"`
+	LastStep               int64      `json:"-"`
+}
+
+// TwoFactorEnrollResponse returns a new TOTP secret to add to an authenticator app
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest carries a TOTP code, or a recovery code where accepted
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse returns new recovery codes. They are only shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SecuritySettings represents platform-wide security settings
+type SecuritySettings struct {
+	RequireAdmin2FA bool `json:"require_admin_2fa"`
+}
+
+// APIKey represents a personal API key. Only a hash of the key is stored;
+// project-scoped keys are backed by a Keystone application credential.
+type APIKey struct {
+	ID                      string     `json:"id"`
+	UserID                  string     `json:"user_id"`
+	Username                string     `json:"-"`
+	DomainName              string     `json:"-"`
+	Name                    string     `json:"name"`
+	Prefix                  string     `json:"prefix"`
+	ProjectID               string     `json:"project_id,omitempty"`
+	Permissions             []string   `json:"permissions,omitempty"`
+	ApplicationCredentialID string     `json:"-"`
+	ExpiresAt               *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt              *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP              string     `json:"last_used_ip,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	RevokedAt               *time.Time `json:"-"`
+}
+
+// CreateAPIKeyRequest represents a request to create a personal API key.
+// ProjectID defaults to the project of the caller's token; Permissions
+// defaults to everything the caller's project role allows.
+type CreateAPIKeyRequest struct {
+	Name        string     `json:"name"`
+	ProjectID   string     `json:"project_id"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse returns a new API key. The key is only ever shown here.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
 }
 
 // RegisterRequest represents a user registration request
@@ -48,6 +180,23 @@ type RegisterResponse struct {
 	Message   string `json:"message"`
 }
 
+// VerifyEmailRequest represents a request to confirm an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest represents a request naming an account by email, such as
+// resending a verification link or asking for a password reset
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents a request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 // LineserveCloudUser represents a user in the lineserve_cloud_users table
 type LineserveCloudUser struct {
 	ID                 string    `json:"id"`
@@ -59,6 +208,8 @@ type LineserveCloudUser struct {
 	OpenstackProjectID string    `json:"openstack_project_id"`
 	CreatedAt          time.Time `json:"created_at"`
 	Verified           bool      `json:"verified"`
+	Role               string    `json:"role,omitempty"`
+	Suspended          bool      `json:"suspended,omitempty"`
 }
 
 // UserProject represents a mapping between a user and a project
@@ -67,9 +218,61 @@ type UserProject struct {
 	UserID    string    `json:"user_id"`
 	ProjectID string    `json:"project_id"`
 	RoleID    string    `json:"role_id"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ProjectMember represents a member of a project
+type ProjectMember struct {
+	UserID          string    `json:"user_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	Role            string    `json:"role"`
+	OpenstackUserID string    `json:"-"`
+	JoinedAt        time.Time `json:"joined_at"`
+}
+
+// ProjectInvitation represents an invitation to join a project
+type ProjectInvitation struct {
+	ID         string     `json:"id"`
+	ProjectID  string     `json:"project_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  string     `json:"invited_by"`
+	Status     string     `json:"status"` // pending, accepted, revoked
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy string     `json:"accepted_by,omitempty"`
+}
+
+// CreateInvitationRequest represents a request to invite someone to a project
+type CreateInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// CreateInvitationResponse represents the response for a new invitation
+type CreateInvitationResponse struct {
+	Invitation ProjectInvitation `json:"invitation"`
+	Token      string            `json:"token"`
+}
+
+// AcceptInvitationRequest represents a request to accept a project invitation
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// UpdateMemberRoleRequest represents a request to change a member's project role
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// TransferOwnershipRequest represents a request to transfer project ownership
+type TransferOwnershipRequest struct {
+	UserID string `json:"user_id"`
+}
+
 // EmailVerification represents an email verification record
 type EmailVerification struct {
 	ID        string    `json:"id"`
@@ -96,6 +299,125 @@ type TokenClaims struct {
 	Role      string `json:"role,omitempty"`
 }
 
+// Platform roles stored on lineserve_cloud_users.role
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+// UserAccount represents a platform account as seen by administrators
+type UserAccount struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Phone            string     `json:"phone"`
+	OpenstackUserID  string     `json:"openstack_user_id"`
+	Role             string     `json:"role"`
+	Verified         bool       `json:"verified"`
+	Suspended        bool       `json:"suspended"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspendedReason  string     `json:"suspended_reason,omitempty"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// UserAccountListResponse represents the response for listing accounts
+type UserAccountListResponse struct {
+	Users  []UserAccount `json:"users"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+// AdminUpdateRoleRequest represents a request to change an account's platform role
+type AdminUpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminSuspendRequest represents a request to suspend an account
+type AdminSuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AdminAuditLog represents an audited administrator action
+type AdminAuditLog struct {
+	ID            string                 `json:"id"`
+	AdminUserID   string                 `json:"admin_user_id"`
+	AdminUsername string                 `json:"admin_username"`
+	Action        string                 `json:"action"`
+	Method        string                 `json:"method"`
+	Path          string                 `json:"path"`
+	TargetType    string                 `json:"target_type,omitempty"`
+	TargetID      string                 `json:"target_id,omitempty"`
+	StatusCode    int                    `json:"status_code"`
+	Details       map[string]interface{} `json:"details,omitempty"`
+	IPAddress     string                 `json:"ip_address"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+// Project roles stored on lineserve_cloud_user_projects.project_role
+const (
+	ProjectRoleOwner    = "owner"
+	ProjectRoleAdmin    = "admin"
+	ProjectRoleMember   = "member"
+	ProjectRoleBilling  = "billing"
+	ProjectRoleReadOnly = "read-only"
+)
+
+// Project permissions checked by the RBAC middleware
+const (
+	PermissionResourcesRead  = "resources:read"
+	PermissionResourcesWrite = "resources:write"
+	PermissionBillingRead    = "billing:read"
+	PermissionBillingWrite   = "billing:write"
+	PermissionMembersManage  = "members:manage"
+	PermissionProjectManage  = "project:manage"
+)
+
+// ProjectRolePermissions lists the permissions granted by each project role
+var ProjectRolePermissions = map[string][]string{
+	ProjectRoleOwner: {
+		PermissionResourcesRead, PermissionResourcesWrite,
+		PermissionBillingRead, PermissionBillingWrite,
+		PermissionMembersManage, PermissionProjectManage,
+	},
+	ProjectRoleAdmin: {
+		PermissionResourcesRead, PermissionResourcesWrite,
+		PermissionBillingRead, PermissionBillingWrite,
+		PermissionMembersManage,
+	},
+	ProjectRoleMember: {
+		PermissionResourcesRead, PermissionResourcesWrite,
+	},
+	ProjectRoleBilling: {
+		PermissionBillingRead, PermissionBillingWrite,
+	},
+	ProjectRoleReadOnly: {
+		PermissionResourcesRead, PermissionBillingRead,
+	},
+}
+
+// IsValidProjectRole reports whether role is a known project role
+func IsValidProjectRole(role string) bool {
+	_, ok := ProjectRolePermissions[role]
+	return ok
+}
+
+// IsValidPermission reports whether permission is a known project permission
+func IsValidPermission(permission string) bool {
+	return ProjectRoleAllows(ProjectRoleOwner, permission)
+}
+
+// ProjectRoleAllows reports whether a project role grants a permission
+func ProjectRoleAllows(role, permission string) bool {
+	for _, p := range ProjectRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
 // Address represents an instance network address
 type Address struct {
 	Type    string `json:"type"`
@@ -114,13 +436,46 @@ type Instance struct {
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// CreateInstanceRequest represents a request to create an instance
+// CreateInstanceRequest represents a request to create an instance. NetworkID
+// attaches a single NIC; Networks attaches several NICs or pre-created ports.
+// Count creates that many instances; MinCount and MaxCount create as many as
+// fit in the project's quota within those bounds.
 type CreateInstanceRequest struct {
-	Name      string `json:"name"`
-	FlavorID  string `json:"flavor_id"`
-	ImageID   string `json:"image_id"`
-	NetworkID string `json:"network_id"`
-	KeyName   string `json:"key_name,omitempty"`
+	Name             string              `json:"name"`
+	FlavorID         string              `json:"flavor_id"`
+	ImageID          string              `json:"image_id,omitempty"` // Not needed when booting from a volume or snapshot
+	NetworkID        string              `json:"network_id,omitempty"`
+	Networks         []InstanceNetwork   `json:"networks,omitempty"`
+	KeyName          string              `json:"key_name,omitempty"`
+	SecurityGroups   []string            `json:"security_groups,omitempty"` // Security group names
+	UserData         string              `json:"user_data,omitempty"`       // cloud-config or script, plain or base64
+	BootVolume       *InstanceBootVolume `json:"boot_volume,omitempty"`
+	AvailabilityZone string              `json:"availability_zone,omitempty"`
+	ServerGroupID    string              `json:"server_group_id,omitempty"`
+	Metadata         map[string]string   `json:"metadata,omitempty"`
+	Tags             []string            `json:"tags,omitempty"`
+	Count            int                 `json:"count,omitempty"`
+	MinCount         int                 `json:"min_count,omitempty"`
+	MaxCount         int                 `json:"max_count,omitempty"`
+}
+
+// InstanceNetwork represents a NIC of a new instance, on a network or an
+// existing port
+type InstanceNetwork struct {
+	NetworkID string `json:"network_id,omitempty"`
+	PortID    string `json:"port_id,omitempty"`
+	FixedIP   string `json:"fixed_ip,omitempty"`
+}
+
+// InstanceBootVolume represents the root volume of an instance booted from a
+// volume. The volume is created from an image or snapshot, or is an existing
+// volume.
+type InstanceBootVolume struct {
+	SourceType          string `json:"source_type,omitempty"` // "image" (default), "snapshot" or "volume"
+	SourceID            string `json:"source_id,omitempty"`   // Defaults to the image ID for image sources
+	Size                int    `json:"size,omitempty"`        // Size in GB; required for image and snapshot sources
+	VolumeType          string `json:"volume_type,omitempty"`
+	DeleteOnTermination bool   `json:"delete_on_termination"`
 }
 
 // UpdateInstanceRequest represents a request to update an instance
@@ -136,6 +491,254 @@ type InstanceActionRequest struct {
 	Type   string `json:"type,omitempty"` // For reboot: "SOFT" or "HARD"
 }
 
+// InstanceResizeRequest represents a request to resize an instance to another flavor
+type InstanceResizeRequest struct {
+	FlavorID string `json:"flavor_id" binding:"required"`
+}
+
+// InstanceRebuildRequest represents a request to rebuild an instance from an image
+type InstanceRebuildRequest struct {
+	ImageID       string            `json:"image_id" binding:"required"`
+	Name          string            `json:"name,omitempty"`
+	AdminPassword string            `json:"admin_password,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// InstanceRescueRequest represents a request to boot an instance into rescue mode
+type InstanceRescueRequest struct {
+	ImageID       string `json:"image_id,omitempty"` // Defaults to the instance's own image
+	AdminPassword string `json:"admin_password,omitempty"`
+}
+
+// InstanceUnshelveRequest represents a request to unshelve an instance
+type InstanceUnshelveRequest struct {
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+}
+
+// InstanceResetPasswordRequest represents a request to reset an instance's admin password
+type InstanceResetPasswordRequest struct {
+	Password string `json:"password,omitempty"` // Generated when empty
+}
+
+// InstanceActionResponse represents the result of an instance action
+type InstanceActionResponse struct {
+	Message       string    `json:"message"`
+	AdminPassword string    `json:"admin_password,omitempty"` // Only returned when generated or set
+	Instance      *Instance `json:"instance,omitempty"`       // Only returned when waiting with ?wait=
+}
+
+// InstanceConsoleRequest represents a request for a remote console
+type InstanceConsoleRequest struct {
+	Type string `json:"type,omitempty"` // "novnc" (default), "spice" or "serial"
+}
+
+// InstanceConsole represents a remote console session of an instance
+type InstanceConsole struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	URL      string `json:"url"`
+}
+
+// InstanceConsoleLogResponse represents the tail of an instance's console log
+type InstanceConsoleLogResponse struct {
+	Lines  int    `json:"lines"`
+	Output string `json:"output"`
+}
+
+// InstanceInterface represents a NIC attached to an instance
+type InstanceInterface struct {
+	PortID     string        `json:"port_id"`
+	NetworkID  string        `json:"network_id"`
+	MACAddress string        `json:"mac_address"`
+	FixedIPs   []PortFixedIP `json:"fixed_ips"`
+	Status     string        `json:"status"`
+}
+
+// AttachInterfaceRequest represents a request to hot-plug a NIC into an
+// instance, from an existing port or a new one on a network
+type AttachInterfaceRequest struct {
+	PortID    string `json:"port_id,omitempty"`
+	NetworkID string `json:"network_id,omitempty"`
+	FixedIP   string `json:"fixed_ip,omitempty"` // Only with network_id
+}
+
+// InstanceSecurityGroupRequest represents a request to add a security group
+// to all of an instance's ports
+type InstanceSecurityGroupRequest struct {
+	SecurityGroup string `json:"security_group"` // Name or ID
+}
+
+// InstanceSecurityGroup represents a security group applied to an instance
+type InstanceSecurityGroup struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Server group policies
+const (
+	ServerGroupAffinity         = "affinity"
+	ServerGroupAntiAffinity     = "anti-affinity"
+	ServerGroupSoftAffinity     = "soft-affinity"
+	ServerGroupSoftAntiAffinity = "soft-anti-affinity"
+)
+
+// ServerGroup represents a group of instances placed on the same hypervisor
+// (affinity) or on different ones (anti-affinity). Soft policies place
+// instances as well as they can instead of failing.
+type ServerGroup struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Policy    string   `json:"policy"`
+	Members   []string `json:"members"` // Instance IDs
+	ProjectID string   `json:"project_id"`
+}
+
+// CreateServerGroupRequest represents a request to create a server group
+type CreateServerGroupRequest struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// AvailabilityZone represents a compute or volume availability zone
+type AvailabilityZone struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+}
+
+// AvailabilityZones represents the availability zones instances and volumes
+// can be placed in
+type AvailabilityZones struct {
+	Compute []AvailabilityZone `json:"compute"`
+	Volume  []AvailabilityZone `json:"volume"`
+}
+
+// InstanceSnapshotRequest represents a request to snapshot an instance
+type InstanceSnapshotRequest struct {
+	Name     string            `json:"name,omitempty"` // Defaults to the instance name and time
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Snapshot represents an image created from an instance
+type Snapshot struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	InstanceID   string    `json:"instance_id"`
+	Status       string    `json:"status"` // queued, saving, active, killed, deleted
+	Size         int64     `json:"size"`   // in bytes
+	MinDisk      int       `json:"min_disk"`
+	VolumeBacked bool      `json:"volume_backed"` // Disk data is held in Cinder volume snapshots
+	ProjectID    string    `json:"project_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// SnapshotUsage represents the snapshot storage a project is billed for
+type SnapshotUsage struct {
+	Count     int     `json:"count"`
+	SizeBytes int64   `json:"size_bytes"`
+	SizeGB    float64 `json:"size_gb"`
+}
+
+// VPSReinstallRequest represents a request to reinstall a VPS from an image
+// or one of its snapshots
+type VPSReinstallRequest struct {
+	ImageID       string `json:"image_id"`
+	AdminPassword string `json:"admin_password,omitempty"`
+}
+
+// Backup policy resource types
+const (
+	BackupResourceInstance = "instance"
+	BackupResourceVolume   = "volume"
+)
+
+// Backup policy frequencies
+const (
+	BackupFrequencyDaily  = "daily"
+	BackupFrequencyWeekly = "weekly"
+)
+
+// Backup policy run outcomes
+const (
+	BackupRunSucceeded = "succeeded"
+	BackupRunFailed    = "failed"
+	// BackupRunMissed is a run whose window passed before the scheduler got to it
+	BackupRunMissed = "missed"
+)
+
+// BackupPolicy schedules automatic backups of an instance, as Nova snapshots,
+// or of a volume, as Cinder backups, keeping the newest Keep of them
+type BackupPolicy struct {
+	ID           string     `json:"id"`
+	ProjectID    string     `json:"project_id"`
+	ResourceType string     `json:"resource_type"` // instance or volume
+	ResourceID   string     `json:"resource_id"`
+	Frequency    string     `json:"frequency"`    // daily or weekly
+	Weekday      int        `json:"weekday"`      // 0 (Sunday) to 6; weekly policies only
+	WindowStart  string     `json:"window_start"` // HH:MM in UTC
+	WindowHours  int        `json:"window_hours"` // How long after window_start a backup may start
+	Keep         int        `json:"keep"`
+	Enabled      bool       `json:"enabled"`
+	NextRunAt    time.Time  `json:"next_run_at"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastStatus   string     `json:"last_status,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedBy    string     `json:"created_by"` // OpenStack user ID, or "vps" for a VPS plan's backups
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// CreateBackupPolicyRequest represents a request to create a backup policy
+type CreateBackupPolicyRequest struct {
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	Frequency    string `json:"frequency"`
+	Weekday      int    `json:"weekday,omitempty"`
+	WindowStart  string `json:"window_start,omitempty"` // Defaults to 00:00
+	WindowHours  int    `json:"window_hours,omitempty"` // Defaults to 4
+	Keep         int    `json:"keep"`
+	Enabled      *bool  `json:"enabled,omitempty"` // Defaults to true
+}
+
+// UpdateBackupPolicyRequest represents a request to change a backup policy's
+// schedule or retention; omitted fields are unchanged
+type UpdateBackupPolicyRequest struct {
+	Frequency   *string `json:"frequency,omitempty"`
+	Weekday     *int    `json:"weekday,omitempty"`
+	WindowStart *string `json:"window_start,omitempty"`
+	WindowHours *int    `json:"window_hours,omitempty"`
+	Keep        *int    `json:"keep,omitempty"`
+	Enabled     *bool   `json:"enabled,omitempty"`
+}
+
+// Backup represents a backup taken by a backup policy
+type Backup struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PolicyID     string    `json:"policy_id"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	Status       string    `json:"status"`
+	SizeGB       float64   `json:"size_gb"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// BackupRestoreRequest represents a request to restore a backup. Instance
+// backups rebuild the instance; volume backups restore into a new volume.
+type BackupRestoreRequest struct {
+	Name          string `json:"name,omitempty"`           // Name of the new volume
+	AdminPassword string `json:"admin_password,omitempty"` // Admin password of the rebuilt instance
+}
+
+// BackupRestoreResponse represents the result of restoring a backup
+type BackupRestoreResponse struct {
+	Message       string `json:"message"`
+	InstanceID    string `json:"instance_id,omitempty"`
+	AdminPassword string `json:"admin_password,omitempty"`
+	VolumeID      string `json:"volume_id,omitempty"`
+}
+
 // Flavor represents a compute flavor
 type Flavor struct {
 	ID       string `json:"id"`
@@ -202,10 +805,15 @@ type VolumeAttachment struct {
 type Volume struct {
 	ID               string             `json:"id"`
 	Name             string             `json:"name"`
+	Description      string             `json:"description,omitempty"`
 	Status           string             `json:"status"`
 	Size             int                `json:"size"`
 	VolumeType       string             `json:"volume_type"`
 	AvailabilityZone string             `json:"availability_zone"`
+	Bootable         bool               `json:"bootable"`
+	SnapshotID       string             `json:"snapshot_id,omitempty"`      // Snapshot the volume was created from
+	SourceVolumeID   string             `json:"source_volume_id,omitempty"` // Volume the volume was cloned from
+	ImageID          string             `json:"image_id,omitempty"`         // Image the volume was created from
 	CreatedAt        time.Time          `json:"created_at"`
 	Attachments      []VolumeAttachment `json:"attachments"`
 }
@@ -235,11 +843,90 @@ type CreateKeyPairRequest struct {
 }
 
 // CreateVolumeRequest represents a request to create a volume
+// At most one source may be given. Size defaults to the size of a snapshot or
+// volume source.
 type CreateVolumeRequest struct {
 	Name             string `json:"name"`
+	Description      string `json:"description,omitempty"`
 	Size             int    `json:"size"`
 	VolumeType       string `json:"volume_type,omitempty"`
 	AvailabilityZone string `json:"availability_zone,omitempty"`
+	ImageID          string `json:"image_id,omitempty"`
+	SnapshotID       string `json:"snapshot_id,omitempty"`
+	SourceVolumeID   string `json:"source_volume_id,omitempty"`
+}
+
+// UpdateVolumeRequest represents a request to rename or redescribe a volume
+type UpdateVolumeRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// VolumeSnapshot represents a point-in-time copy of a volume
+type VolumeSnapshot struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	VolumeID    string    `json:"volume_id"`
+	Status      string    `json:"status"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CreateVolumeSnapshotRequest represents a request to snapshot a volume
+type CreateVolumeSnapshotRequest struct {
+	VolumeID    string `json:"volume_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Force       bool   `json:"force,omitempty"` // Snapshot a volume that is attached to an instance
+}
+
+// UpdateVolumeSnapshotRequest represents a request to rename or redescribe a
+// volume snapshot
+type UpdateVolumeSnapshotRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// VolumeBackup represents a backup of a volume kept in backup storage
+type VolumeBackup struct {
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description,omitempty"`
+	VolumeID            string    `json:"volume_id"`
+	SnapshotID          string    `json:"snapshot_id,omitempty"`
+	Status              string    `json:"status"`
+	Size                int       `json:"size"`
+	Incremental         bool      `json:"incremental"`
+	HasDependentBackups bool      `json:"has_dependent_backups"` // Incremental backups depend on it, so it cannot be deleted
+	FailReason          string    `json:"fail_reason,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// CreateVolumeBackupRequest represents a request to back up a volume, which
+// may be attached to an instance
+type CreateVolumeBackupRequest struct {
+	VolumeID    string `json:"volume_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	SnapshotID  string `json:"snapshot_id,omitempty"` // Back up one of the volume's snapshots instead
+	Incremental bool   `json:"incremental,omitempty"`
+}
+
+// RestoreVolumeBackupRequest represents a request to restore a volume backup
+// into a new volume, or over an existing available volume
+type RestoreVolumeBackupRequest struct {
+	VolumeID string `json:"volume_id,omitempty"`
+	Name     string `json:"name,omitempty"` // Name of the new volume
+}
+
+// VolumeBackupRestore represents a started volume backup restore
+type VolumeBackupRestore struct {
+	BackupID   string `json:"backup_id"`
+	VolumeID   string `json:"volume_id"`
+	VolumeName string `json:"volume_name"`
 }
 
 // Project represents an identity project
@@ -249,6 +936,7 @@ type Project struct {
 	Description string `json:"description,omitempty"`
 	Enabled     bool   `json:"enabled,omitempty"`
 	DomainID    string `json:"domain_id,omitempty"`
+	Role        string `json:"role,omitempty"`
 }
 
 // ProjectListResponse represents the response for listing projects
@@ -256,6 +944,57 @@ type ProjectListResponse struct {
 	Projects []Project `json:"projects"`
 }
 
+// CreateProjectRequest represents a request to create an additional project
+type CreateProjectRequest struct {
+	Name             string `json:"name"`
+	Description      string `json:"description,omitempty"`
+	BootstrapNetwork bool   `json:"bootstrap_network,omitempty"`
+	CIDR             string `json:"cidr,omitempty"`
+}
+
+// UpdateProjectRequest represents a request to rename or describe a project
+type UpdateProjectRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// ProjectNetwork holds the network resources bootstrapped for a new project
+type ProjectNetwork struct {
+	NetworkID string `json:"network_id"`
+	SubnetID  string `json:"subnet_id,omitempty"`
+	RouterID  string `json:"router_id,omitempty"`
+}
+
+// CreateProjectResponse represents the response for creating a project
+type CreateProjectResponse struct {
+	Project Project         `json:"project"`
+	Network *ProjectNetwork `json:"network,omitempty"`
+	Warning string          `json:"warning,omitempty"`
+}
+
+// ProjectResource identifies a resource that belongs to a project
+type ProjectResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ProjectResources lists the resources that remain in a project
+type ProjectResources struct {
+	Instances      []ProjectResource `json:"instances"`
+	Volumes        []ProjectResource `json:"volumes"`
+	FloatingIPs    []ProjectResource `json:"floating_ips"`
+	Routers        []ProjectResource `json:"routers"`
+	Networks       []ProjectResource `json:"networks"`
+	SecurityGroups []ProjectResource `json:"security_groups"`
+	Snapshots      []ProjectResource `json:"snapshots"`
+}
+
+// IsEmpty reports whether no resources remain
+func (r *ProjectResources) IsEmpty() bool {
+	return len(r.Instances) == 0 && len(r.Volumes) == 0 && len(r.FloatingIPs) == 0 &&
+		len(r.Routers) == 0 && len(r.Networks) == 0 && len(r.SecurityGroups) == 0 && len(r.Snapshots) == 0
+}
+
 // FloatingIP represents a floating IP
 type FloatingIP struct {
 	ID                string `json:"id"`
@@ -283,6 +1022,78 @@ type UpdateFloatingIPRequest struct {
 	FixedIP *string `json:"fixed_ip_address,omitempty"`
 }
 
+// AssociateFloatingIPRequest represents a request to point a floating IP at
+// an instance
+type AssociateFloatingIPRequest struct {
+	InstanceID string `json:"instance_id"`
+	FixedIP    string `json:"fixed_ip,omitempty"` // Picks one of the instance's addresses
+}
+
+// InstancePublicIPRequest represents a request to allocate a floating IP for
+// an instance and associate it
+type InstancePublicIPRequest struct {
+	FloatingNetworkID string `json:"floating_network_id,omitempty"` // Defaults to the external network the instance's router reaches
+	FixedIP           string `json:"fixed_ip,omitempty"`
+	Description       string `json:"description,omitempty"`
+}
+
+// Port represents a network port, which gives an instance a NIC with fixed
+// IPs on a network
+type Port struct {
+	ID                  string            `json:"id"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description,omitempty"`
+	NetworkID           string            `json:"network_id"`
+	Status              string            `json:"status"`
+	MACAddress          string            `json:"mac_address"`
+	FixedIPs            []PortFixedIP     `json:"fixed_ips"`
+	SecurityGroups      []string          `json:"security_groups"` // Security group IDs
+	AllowedAddressPairs []PortAddressPair `json:"allowed_address_pairs"`
+	PortSecurityEnabled bool              `json:"port_security_enabled"`
+	AdminStateUp        bool              `json:"admin_state_up"`
+	InstanceID          string            `json:"instance_id,omitempty"` // Set when an instance uses the port
+	DeviceOwner         string            `json:"device_owner,omitempty"`
+	ProjectID           string            `json:"project_id"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+// PortFixedIP represents a fixed IP of a port. A subnet without an address
+// takes the next free address on the subnet.
+type PortFixedIP struct {
+	SubnetID  string `json:"subnet_id,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// PortAddressPair represents an extra address a port may send from, such as a
+// virtual IP shared by an HA pair
+type PortAddressPair struct {
+	IPAddress  string `json:"ip_address"` // An address or CIDR
+	MACAddress string `json:"mac_address,omitempty"`
+}
+
+// CreatePortRequest represents a request to create a port
+type CreatePortRequest struct {
+	NetworkID           string            `json:"network_id"`
+	Name                string            `json:"name,omitempty"`
+	Description         string            `json:"description,omitempty"`
+	FixedIPs            []PortFixedIP     `json:"fixed_ips,omitempty"`
+	SecurityGroups      *[]string         `json:"security_groups,omitempty"` // Defaults to the project's default group
+	AllowedAddressPairs []PortAddressPair `json:"allowed_address_pairs,omitempty"`
+	PortSecurityEnabled *bool             `json:"port_security_enabled,omitempty"`
+}
+
+// UpdatePortRequest represents a request to update a port. Lists replace the
+// port's current ones.
+type UpdatePortRequest struct {
+	Name                *string            `json:"name,omitempty"`
+	Description         *string            `json:"description,omitempty"`
+	FixedIPs            *[]PortFixedIP     `json:"fixed_ips,omitempty"`
+	SecurityGroups      *[]string          `json:"security_groups,omitempty"`
+	AllowedAddressPairs *[]PortAddressPair `json:"allowed_address_pairs,omitempty"`
+	PortSecurityEnabled *bool              `json:"port_security_enabled,omitempty"`
+}
+
 // SecurityGroup represents a security group
 type SecurityGroup struct {
 	ID                 string              `json:"id"`
@@ -570,9 +1381,10 @@ type VPSOrderResponse struct {
 
 // VPSInvoicePayRequest represents a request to pay a VPS invoice
 type VPSInvoicePayRequest struct {
-	PaymentMethodID string `json:"payment_method_id" binding:"required"`
-	PaymentMethod   string `json:"payment_method,omitempty"` // "card", "paypal"
+	PaymentMethodID string `json:"payment_method_id,omitempty"` // Saved Stripe card; the default card when empty
+	PaymentMethod   string `json:"payment_method,omitempty"`    // "card", "paypal"
 	PayPalOrderID   string `json:"paypal_order_id,omitempty"`
+	PaymentIntentID string `json:"payment_intent_id,omitempty"` // Card payment the customer has since authenticated
 }
 
 // VPSInvoicePayResponse represents the response for a VPS invoice payment
@@ -583,6 +1395,14 @@ type VPSInvoicePayResponse struct {
 	RedirectURL    string `json:"redirect_url,omitempty"` // For PayPal redirect flow
 }
 
+// VPSInvoiceActionRequiredResponse represents a card payment the customer must
+// authenticate before it completes
+type VPSInvoiceActionRequiredResponse struct {
+	Error           string `json:"error"`
+	PaymentIntentID string `json:"payment_intent_id"`
+	ClientSecret    string `json:"client_secret"`
+}
+
 // PayPalCreateOrderRequest represents a request to create a PayPal order
 type PayPalCreateOrderRequest struct {
 	InvoiceID string `json:"invoice_id" binding:"required"`
@@ -658,13 +1478,16 @@ type StripeCheckoutRequest struct {
 
 // StripeCheckoutResponse represents a response from creating a checkout session
 type StripeCheckoutResponse struct {
-	CheckoutURL string `json:"checkout_url"`
-	SessionID   string `json:"session_id"`
+	CheckoutURL    string `json:"checkout_url"`
+	SessionID      string `json:"session_id"`
+	SubscriptionID string `json:"subscription_id,omitempty"` // VPS subscription the checkout pays for
 }
 
-// StripeSubscriptionRequest represents a request to create a subscription
+// StripeSubscriptionRequest represents a request to subscribe to a VPS plan
+// billed by Stripe
 type StripeSubscriptionRequest struct {
-	PriceID string `json:"price_id"`
+	PlanCode     string `json:"plan_code"`
+	CommitPeriod int    `json:"commit_period"` // in months
 }
 
 // StripeCancelSubscriptionRequest represents a request to cancel a subscription
@@ -672,6 +1495,39 @@ type StripeCancelSubscriptionRequest struct {
 	CancelAtPeriodEnd bool `json:"cancel_at_period_end"`
 }
 
+// StripePaymentMethod represents a card saved to a user's Stripe customer
+type StripePaymentMethod struct {
+	ID        string `json:"id"`
+	Brand     string `json:"brand"`
+	Last4     string `json:"last4"`
+	ExpMonth  uint64 `json:"exp_month"`
+	ExpYear   uint64 `json:"exp_year"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// StripeAddPaymentMethodRequest represents a request to save a payment method
+type StripeAddPaymentMethodRequest struct {
+	PaymentMethodID string `json:"payment_method_id"`
+	SetDefault      bool   `json:"set_default"`
+}
+
+// StripeSetupIntentResponse represents a setup intent the client confirms to
+// save a card
+type StripeSetupIntentResponse struct {
+	SetupIntentID string `json:"setup_intent_id"`
+	ClientSecret  string `json:"client_secret"`
+}
+
+// StripeBillingPortalRequest represents a request to open the Stripe customer portal
+type StripeBillingPortalRequest struct {
+	ReturnURL string `json:"return_url,omitempty"`
+}
+
+// StripeBillingPortalResponse represents a Stripe customer portal session
+type StripeBillingPortalResponse struct {
+	URL string `json:"url"`
+}
+
 // MPesaSTKPushRequest represents a request to initiate an STK push
 type MPesaSTKPushRequest struct {
 	InvoiceID   string `json:"invoice_id"`
@@ -699,4 +1555,112 @@ type MPesaSTKPushStatusResponse struct {
 	MerchantRequestID   string `json:"merchant_request_id"`
 	CheckoutRequestID   string `json:"checkout_request_id"`
 	CustomerMessage     string `json:"customer_message"`
+	ResultCode          string `json:"result_code,omitempty"`
+	ResultDesc          string `json:"result_desc,omitempty"`
+	Status              string `json:"status,omitempty"`
+}
+
+// M-Pesa STK push attempt statuses
+const (
+	MPesaSTKStatusPending = "pending"
+	MPesaSTKStatusPaid    = "paid"
+	MPesaSTKStatusFailed  = "failed"
+	// MPesaSTKStatusExpired is an attempt whose outcome never became known
+	MPesaSTKStatusExpired = "expired"
+)
+
+// MPesaSTKAttempt represents one STK push sent for an invoice
+type MPesaSTKAttempt struct {
+	ID                   string     `json:"id"`
+	InvoiceID            string     `json:"invoice_id"`
+	UserID               string     `json:"user_id"`
+	PhoneNumber          string     `json:"phone_number"`
+	Amount               float64    `json:"amount"`
+	MerchantRequestID    string     `json:"merchant_request_id"`
+	CheckoutRequestID    string     `json:"checkout_request_id"`
+	Status               string     `json:"status"`
+	ResultCode           string     `json:"result_code,omitempty"`
+	ResultDesc           string     `json:"result_desc,omitempty"`
+	ReceiptNo            string     `json:"receipt_no,omitempty"`
+	Queries              int        `json:"queries"`
+	LastQueriedAt        *time.Time `json:"last_queried_at,omitempty"`
+	StatusConversationID string     `json:"-"`
+	CreatedAt            time.Time  `json:"created_at"`
+	CompletedAt          *time.Time `json:"completed_at,omitempty"`
+}
+
+// M-Pesa C2B payment statuses
+const (
+	// MPesaC2BStatusReceived is a confirmed payment not yet reconciled
+	MPesaC2BStatusReceived = "received"
+	// MPesaC2BStatusApplied is a payment that settled at least one invoice
+	MPesaC2BStatusApplied = "applied"
+	// MPesaC2BStatusCredited is a payment held as account credit
+	MPesaC2BStatusCredited = "credited"
+	// MPesaC2BStatusUnmatched is a payment waiting for admin review
+	MPesaC2BStatusUnmatched = "unmatched"
+	// MPesaC2BStatusDismissed is a payment an admin settled outside the platform
+	MPesaC2BStatusDismissed = "dismissed"
+)
+
+// MPesaC2BPayment represents a Paybill payment received through C2B
+type MPesaC2BPayment struct {
+	ID                string     `json:"id"`
+	TransID           string     `json:"trans_id"`
+	TransType         string     `json:"trans_type"`
+	TransTime         string     `json:"trans_time"`
+	Amount            float64    `json:"amount"`
+	BusinessShortCode string     `json:"business_short_code"`
+	BillRefNumber     string     `json:"bill_ref_number"`
+	MSISDN            string     `json:"msisdn"`
+	PayerName         string     `json:"payer_name,omitempty"`
+	Status            string     `json:"status"`
+	InvoiceID         string     `json:"invoice_id,omitempty"`
+	UserID            string     `json:"user_id,omitempty"`
+	Note              string     `json:"note,omitempty"`
+	ResolvedBy        string     `json:"resolved_by,omitempty"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// MPesaC2BResolveRequest represents an admin decision on an unmatched Paybill
+// payment: apply it to an invoice or account, or dismiss it
+type MPesaC2BResolveRequest struct {
+	InvoiceID     string `json:"invoice_id,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+	Dismiss       bool   `json:"dismiss,omitempty"`
+	Note          string `json:"note,omitempty"`
+}
+
+// MPesaC2BSimulateRequest represents a request to simulate a Paybill payment
+// in the sandbox
+type MPesaC2BSimulateRequest struct {
+	Amount        float64 `json:"amount"`
+	PhoneNumber   string  `json:"phone_number"`
+	BillRefNumber string  `json:"bill_ref_number"`
+}
+
+// CreditTransaction represents an entry in an account's credit ledger;
+// payments are positive and invoice settlements negative
+type CreditTransaction struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Currency    string    `json:"currency"`
+	Amount      float64   `json:"amount"`
+	Kind        string    `json:"kind"`
+	Reference   string    `json:"reference"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PaybillDetailsResponse tells a customer how to pay by Paybill and shows
+// their account credit
+type PaybillDetailsResponse struct {
+	Paybill       string              `json:"paybill"`
+	AccountNumber string              `json:"account_number"`
+	Currency      string              `json:"currency"`
+	Balance       float64             `json:"balance"`
+	Transactions  []CreditTransaction `json:"transactions"`
 }
@@ -158,3 +158,39 @@ func AssignRoleToUserOnProject(ctx context.Context, provider *gophercloud.Provid
 
 	return nil
 }
+
+// SetUserEnabled enables or disables a user (admin only)
+func SetUserEnabled(ctx context.Context, provider *gophercloud.ProviderClient, userID string, enabled bool) error {
+	identityClient, err := NewIdentityClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create identity client: %w", err)
+	}
+
+	updateOpts := users.UpdateOpts{
+		Enabled: &enabled,
+	}
+
+	if _, err := users.Update(ctx, identityClient, userID, updateOpts).Extract(); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+// SetUserPassword replaces a user's password (admin only)
+func SetUserPassword(ctx context.Context, provider *gophercloud.ProviderClient, userID, password string) error {
+	identityClient, err := NewIdentityClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create identity client: %w", err)
+	}
+
+	updateOpts := users.UpdateOpts{
+		Password: password,
+	}
+
+	if _, err := users.Update(ctx, identityClient, userID, updateOpts).Extract(); err != nil {
+		return fmt.Errorf("failed to update user password: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/applicationcredentials"
+)
+
+// CreateApplicationCredential creates a Keystone application credential for
+// the user that owns the provider's token. The credential is bound to the
+// token's project and roles and cannot create further credentials.
+func CreateApplicationCredential(ctx context.Context, provider *gophercloud.ProviderClient, userID, name, description, secret string, expiresAt *time.Time) (string, error) {
+	identityClient, err := NewIdentityClient(provider)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity client: %w", err)
+	}
+
+	createOpts := applicationcredentials.CreateOpts{
+		Name:        name,
+		Description: description,
+		Secret:      secret,
+		ExpiresAt:   expiresAt,
+	}
+
+	credential, err := applicationcredentials.Create(ctx, identityClient, userID, createOpts).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to create application credential: %w", err)
+	}
+
+	return credential.ID, nil
+}
+
+// DeleteApplicationCredential deletes a user's application credential (admin only)
+func DeleteApplicationCredential(ctx context.Context, provider *gophercloud.ProviderClient, userID, credentialID string) error {
+	identityClient, err := NewIdentityClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create identity client: %w", err)
+	}
+
+	err = applicationcredentials.Delete(ctx, identityClient, userID, credentialID).ExtractErr()
+	if err != nil {
+		// The credential is already gone, which is the desired state
+		if gophercloud.ResponseCodeIs(err, 404) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete application credential: %w", err)
+	}
+
+	return nil
+}
+
+// AuthenticateWithApplicationCredential authenticates with an application
+// credential. The token is scoped to the credential's project. Returns the
+// provider client and the token's expiry.
+func AuthenticateWithApplicationCredential(ctx context.Context, credentialID, secret string) (*gophercloud.ProviderClient, time.Time, error) {
+	authOpts := gophercloud.AuthOptions{
+		IdentityEndpoint:            os.Getenv("OS_AUTH_URL"),
+		ApplicationCredentialID:     credentialID,
+		ApplicationCredentialSecret: secret,
+		AllowReauth:                 true,
+	}
+
+	// Authenticate with OpenStack
+	provider, err := openstack.AuthenticatedClient(ctx, authOpts)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to authenticate with application credential: %w", err)
+	}
+
+	authResult, err := GetAuthResult(ctx, provider)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	token, err := authResult.ExtractToken()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to extract token: %w", err)
+	}
+
+	return provider, token.ExpiresAt, nil
+}
@@ -0,0 +1,348 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/backups"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/image/v2/images"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// backupPolicyProperty is the image property, and the prefix of the Cinder
+// backup description, naming the backup policy that took a backup
+const backupPolicyProperty = "lineserve_backup_policy"
+
+// restoreVolumePollInterval is how often a volume being created for a restore
+// is checked
+const restoreVolumePollInterval = 2 * time.Second
+
+// ErrBackupResourceNotInProject is returned when a backup policy's instance or
+// volume does not belong to the policy's project
+var ErrBackupResourceNotInProject = errors.New("the instance or volume does not belong to the backup policy's project")
+
+// backupImageListOpts lists the snapshot images taken by a backup policy
+type backupImageListOpts struct {
+	PolicyID  string `q:"lineserve_backup_policy"`
+	ImageType string `q:"image_type"`
+	Owner     string `q:"owner"`
+}
+
+// ToImageListQuery formats backupImageListOpts into a query string
+func (opts backupImageListOpts) ToImageListQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	if err != nil {
+		return "", err
+	}
+	return q.String(), nil
+}
+
+// volumeBackupListOpts lists the Cinder backups of a volume with details
+type volumeBackupListOpts struct {
+	AllTenants bool   `q:"all_tenants"`
+	VolumeID   string `q:"volume_id"`
+}
+
+// ToBackupListDetailQuery formats volumeBackupListOpts into a query string
+func (opts volumeBackupListOpts) ToBackupListDetailQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	if err != nil {
+		return "", err
+	}
+	return q.String(), nil
+}
+
+// backupDescription is the description of the Cinder backups a policy takes
+func backupDescription(policyID string) string {
+	return fmt.Sprintf("%s=%s", backupPolicyProperty, policyID)
+}
+
+// tokenProjectID returns the project a provider's token is scoped to
+func tokenProjectID(ctx context.Context, provider *gophercloud.ProviderClient) (string, error) {
+	authResult, err := GetAuthResult(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+	project, err := authResult.ExtractProject()
+	if err != nil {
+		return "", fmt.Errorf("failed to get token project: %w", err)
+	}
+	if project == nil {
+		return "", fmt.Errorf("token is not scoped to a project")
+	}
+	return project.ID, nil
+}
+
+// CheckPolicyResource verifies that a backup policy's instance or volume
+// belongs to the policy's project. Backups are taken and restored with admin
+// credentials, which reach every project, so this is checked before each.
+func CheckPolicyResource(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) error {
+	var projectID string
+
+	switch policy.ResourceType {
+	case models.BackupResourceInstance:
+		computeClient, err := NewComputeClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create compute client: %w", err)
+		}
+		server, err := servers.Get(ctx, computeClient, policy.ResourceID).Extract()
+		if err != nil {
+			return fmt.Errorf("failed to get instance: %w", err)
+		}
+		projectID = server.TenantID
+
+	case models.BackupResourceVolume:
+		blockStorageClient, err := NewBlockStorageClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create block storage client: %w", err)
+		}
+		volume, err := volumes.Get(ctx, blockStorageClient, policy.ResourceID).Extract()
+		if err != nil {
+			return fmt.Errorf("failed to get volume: %w", err)
+		}
+		projectID = volume.TenantID
+
+	default:
+		return fmt.Errorf("unsupported backup resource type %s", policy.ResourceType)
+	}
+
+	if projectID != policy.ProjectID {
+		return ErrBackupResourceNotInProject
+	}
+	return nil
+}
+
+// CreatePolicyBackup backs up a backup policy's instance or volume with admin
+// credentials and returns the new backup's ID. Backups of volumes in use are
+// taken live.
+func CreatePolicyBackup(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) (string, error) {
+	if err := CheckPolicyResource(ctx, provider, policy); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("backup-%s", time.Now().UTC().Format("20060102-1504"))
+
+	switch policy.ResourceType {
+	case models.BackupResourceInstance:
+		computeClient, err := NewComputeClient(provider)
+		if err != nil {
+			return "", fmt.Errorf("failed to create compute client: %w", err)
+		}
+		imageID, err := servers.CreateImage(ctx, computeClient, policy.ResourceID, servers.CreateImageOpts{
+			Name:     name,
+			Metadata: map[string]string{backupPolicyProperty: policy.ID},
+		}).ExtractImageID()
+		if err != nil {
+			return "", fmt.Errorf("failed to snapshot instance: %w", err)
+		}
+		return imageID, nil
+
+	case models.BackupResourceVolume:
+		blockStorageClient, err := NewBlockStorageClient(provider)
+		if err != nil {
+			return "", fmt.Errorf("failed to create block storage client: %w", err)
+		}
+		backup, err := backups.Create(ctx, blockStorageClient, backups.CreateOpts{
+			VolumeID:    policy.ResourceID,
+			Name:        name,
+			Description: backupDescription(policy.ID),
+			Force:       true,
+		}).Extract()
+		if err != nil {
+			return "", fmt.Errorf("failed to back up volume: %w", err)
+		}
+		return backup.ID, nil
+
+	default:
+		return "", fmt.Errorf("unsupported backup resource type %s", policy.ResourceType)
+	}
+}
+
+// ListPolicyBackups lists the backups a backup policy has taken with the
+// admin credentials of the provider, newest first. Only backups owned by the
+// provider's project, of the policy's instance or volume, are listed, so that
+// a project cannot pass off its own images or backups as the policy's.
+func ListPolicyBackups(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy) ([]models.Backup, error) {
+	ownerProjectID, err := tokenProjectID(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []models.Backup{}
+
+	switch policy.ResourceType {
+	case models.BackupResourceInstance:
+		imageClient, err := NewImageClient(provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create image client: %w", err)
+		}
+		allPages, err := images.List(imageClient, backupImageListOpts{
+			PolicyID:  policy.ID,
+			ImageType: "snapshot",
+			Owner:     ownerProjectID,
+		}).AllPages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups: %w", err)
+		}
+		allImages, err := images.ExtractImages(allPages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract backups: %w", err)
+		}
+		for _, img := range allImages {
+			if img.Owner != ownerProjectID {
+				continue
+			}
+			result = append(result, models.Backup{
+				ID:           img.ID,
+				Name:         img.Name,
+				PolicyID:     policy.ID,
+				ResourceType: policy.ResourceType,
+				ResourceID:   policy.ResourceID,
+				Status:       string(img.Status),
+				SizeGB:       float64(img.SizeBytes) / bytesPerGB,
+				CreatedAt:    img.CreatedAt,
+			})
+		}
+
+	case models.BackupResourceVolume:
+		blockStorageClient, err := NewBlockStorageClient(provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create block storage client: %w", err)
+		}
+		allPages, err := backups.ListDetail(blockStorageClient, volumeBackupListOpts{
+			AllTenants: true,
+			VolumeID:   policy.ResourceID,
+		}).AllPages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups: %w", err)
+		}
+		allBackups, err := backups.ExtractBackups(allPages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract backups: %w", err)
+		}
+		for _, backup := range allBackups {
+			if backup.ProjectID != ownerProjectID || backup.VolumeID != policy.ResourceID ||
+				backup.Description != backupDescription(policy.ID) {
+				continue
+			}
+			result = append(result, models.Backup{
+				ID:           backup.ID,
+				Name:         backup.Name,
+				PolicyID:     policy.ID,
+				ResourceType: policy.ResourceType,
+				ResourceID:   policy.ResourceID,
+				Status:       backup.Status,
+				SizeGB:       float64(backup.Size),
+				CreatedAt:    backup.CreatedAt,
+			})
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported backup resource type %s", policy.ResourceType)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// DeletePolicyBackup deletes a backup taken by a backup policy
+func DeletePolicyBackup(ctx context.Context, provider *gophercloud.ProviderClient, policy *models.BackupPolicy, backupID string) error {
+	switch policy.ResourceType {
+	case models.BackupResourceInstance:
+		imageClient, err := NewImageClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create image client: %w", err)
+		}
+		if err := images.Delete(ctx, imageClient, backupID).ExtractErr(); err != nil {
+			return fmt.Errorf("failed to delete backup %s: %w", backupID, err)
+		}
+
+	case models.BackupResourceVolume:
+		blockStorageClient, err := NewBlockStorageClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create block storage client: %w", err)
+		}
+		if err := backups.Delete(ctx, blockStorageClient, backupID).ExtractErr(); err != nil {
+			return fmt.Errorf("failed to delete backup %s: %w", backupID, err)
+		}
+
+	default:
+		return fmt.Errorf("unsupported backup resource type %s", policy.ResourceType)
+	}
+
+	return nil
+}
+
+// RestorePolicyVolumeBackup restores a policy's Cinder backup into a new
+// volume in the policy's project. Backups are owned by the admin project and
+// not visible to the customer, so the customer's provider creates the empty
+// volume and the admin provider restores into it. It returns the volume's ID.
+func RestorePolicyVolumeBackup(ctx context.Context, adminProvider, projectProvider *gophercloud.ProviderClient, backup *models.Backup, name string) (string, error) {
+	projectClient, err := NewBlockStorageClient(projectProvider)
+	if err != nil {
+		return "", fmt.Errorf("failed to create block storage client: %w", err)
+	}
+	adminClient, err := NewBlockStorageClient(adminProvider)
+	if err != nil {
+		return "", fmt.Errorf("failed to create block storage client: %w", err)
+	}
+
+	volume, err := volumes.Create(ctx, projectClient, volumes.CreateOpts{
+		Name: name,
+		Size: int(math.Ceil(backup.SizeGB)),
+	}, nil).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume to restore into: %w", err)
+	}
+
+	// Cinder only restores into an available volume
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	if err := waitForVolumeAvailable(waitCtx, projectClient, volume.ID); err != nil {
+		return "", fmt.Errorf("volume %s to restore into did not become available: %w", volume.ID, err)
+	}
+
+	restore, err := backups.RestoreFromBackup(ctx, adminClient, backup.ID, backups.RestoreOpts{
+		VolumeID: volume.ID,
+	}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to restore backup into volume %s: %w", volume.ID, err)
+	}
+
+	return restore.VolumeID, nil
+}
+
+// waitForVolumeAvailable polls a volume until it is available, fails or the
+// context ends
+func waitForVolumeAvailable(ctx context.Context, blockStorageClient *gophercloud.ServiceClient, volumeID string) error {
+	ticker := time.NewTicker(restoreVolumePollInterval)
+	defer ticker.Stop()
+
+	for {
+		volume, err := volumes.Get(ctx, blockStorageClient, volumeID).Extract()
+		if err != nil {
+			return err
+		}
+		switch volume.Status {
+		case "available":
+			return nil
+		case "error":
+			return fmt.Errorf("volume is in error state")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
 	"github.com/lineserve/lineserve-api/pkg/client"
 	"github.com/lineserve/lineserve-api/pkg/models"
@@ -46,3 +47,23 @@ func CreateInstance(client *client.OpenStackClient, projectID, name, flavorID, i
 
 	return instance, nil
 }
+
+// SetServerRunning starts or stops a server. Nova refuses to start a running
+// server or stop a stopped one, so that refusal counts as success.
+func SetServerRunning(ctx context.Context, provider *gophercloud.ProviderClient, serverID string, running bool) error {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	if running {
+		err = servers.Start(ctx, computeClient, serverID).ExtractErr()
+	} else {
+		err = servers.Stop(ctx, computeClient, serverID).ExtractErr()
+	}
+	if err != nil && !gophercloud.ResponseCodeIs(err, 409) {
+		return fmt.Errorf("failed to set server running state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/remoteconsoles"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// remoteConsoleMicroversion is the first compute microversion with the
+// remote consoles API
+const remoteConsoleMicroversion = "2.6"
+
+// ErrUnsupportedConsoleType is returned for a console type that is not offered
+var ErrUnsupportedConsoleType = errors.New("unsupported console type")
+
+// consoleTypes maps the console types offered to Nova's protocol and type
+var consoleTypes = map[string]remoteconsoles.CreateOpts{
+	"novnc": {
+		Protocol: remoteconsoles.ConsoleProtocolVNC,
+		Type:     remoteconsoles.ConsoleTypeNoVNC,
+	},
+	"spice": {
+		Protocol: remoteconsoles.ConsoleProtocolSPICE,
+		Type:     remoteconsoles.ConsoleTypeSPICEHTML5,
+	},
+	"serial": {
+		Protocol: remoteconsoles.ConsoleProtocolSerial,
+		Type:     remoteconsoles.ConsoleTypeSerial,
+	},
+}
+
+// CreateRemoteConsole creates a remote console session for a server and
+// returns its URL. An empty console type means noVNC.
+func CreateRemoteConsole(ctx context.Context, provider *gophercloud.ProviderClient, serverID, consoleType string) (*models.InstanceConsole, error) {
+	if consoleType == "" {
+		consoleType = "novnc"
+	}
+	opts, ok := consoleTypes[consoleType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedConsoleType, consoleType)
+	}
+
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	computeClient.Microversion = remoteConsoleMicroversion
+
+	console, err := remoteconsoles.Create(ctx, computeClient, serverID, opts).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s console: %w", consoleType, err)
+	}
+
+	return &models.InstanceConsole{
+		Type:     consoleType,
+		Protocol: console.Protocol,
+		URL:      console.URL,
+	}, nil
+}
+
+// GetConsoleLog returns the last lines of a server's serial console log, or
+// the whole log when lines is zero
+func GetConsoleLog(ctx context.Context, provider *gophercloud.ProviderClient, serverID string, lines int) (string, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return "", fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	output, err := servers.ShowConsoleOutput(ctx, computeClient, serverID, servers.ShowConsoleOutputOpts{
+		Length: lines,
+	}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to get console log: %w", err)
+	}
+
+	return output, nil
+}
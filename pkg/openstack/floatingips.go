@@ -0,0 +1,274 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+var (
+	// ErrInstanceHasNoPorts is returned when an instance has no NICs in the
+	// project to give a floating IP to
+	ErrInstanceHasNoPorts = errors.New("instance not found or has no network interfaces")
+
+	// ErrFixedIPNotFound is returned when an instance has no NIC with the
+	// requested fixed IP
+	ErrFixedIPNotFound = errors.New("instance has no network interface with that fixed IP")
+
+	// ErrNoRouterPath is returned when none of an instance's subnets is
+	// connected by a router to the floating IP's network
+	ErrNoRouterPath = errors.New("no router connects the instance's subnets to the external network")
+)
+
+// FloatingIPTarget is the instance port and fixed IP a floating IP maps to
+type FloatingIPTarget struct {
+	PortID            string
+	FixedIP           string
+	FloatingNetworkID string // External network the port's subnet reaches
+}
+
+// ResolveFloatingIPTarget finds the port and IPv4 address of a server that a
+// floating IP on an external network can be associated with: one on a subnet
+// that a router connects to the network. An empty floating network ID takes
+// the first external network one of the server's subnets reaches, and an
+// empty fixed IP the first address that qualifies. Only the server's ports in
+// the project are considered.
+func ResolveFloatingIPTarget(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID, serverID, floatingNetworkID, fixedIP string) (*FloatingIPTarget, error) {
+	serverPorts, err := listServerPorts(ctx, networkClient, projectID, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if len(serverPorts) == 0 {
+		return nil, ErrInstanceHasNoPorts
+	}
+
+	// Map the subnets that routers connect to external networks
+	reachable, err := routedSubnets(ctx, networkClient, projectID, floatingNetworkID)
+	if err != nil {
+		return nil, err
+	}
+
+	fixedIPFound := false
+	for _, port := range serverPorts {
+		for _, ip := range port.FixedIPs {
+			if fixedIP != "" && ip.IPAddress != fixedIP {
+				continue
+			}
+			fixedIPFound = true
+
+			// Floating IPs are IPv4 only
+			if parsed := net.ParseIP(ip.IPAddress); parsed == nil || parsed.To4() == nil {
+				continue
+			}
+			if networkID, ok := reachable[ip.SubnetID]; ok {
+				return &FloatingIPTarget{
+					PortID:            port.ID,
+					FixedIP:           ip.IPAddress,
+					FloatingNetworkID: networkID,
+				}, nil
+			}
+		}
+	}
+
+	if fixedIP != "" && !fixedIPFound {
+		return nil, ErrFixedIPNotFound
+	}
+	if floatingNetworkID != "" {
+		return nil, fmt.Errorf("%w %s; add a router with a gateway on it and an interface on the instance's subnet", ErrNoRouterPath, floatingNetworkID)
+	}
+	return nil, fmt.Errorf("%w; add a router with an external gateway and an interface on the instance's subnet", ErrNoRouterPath)
+}
+
+// listServerPorts lists a server's ports in a project
+func listServerPorts(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID, serverID string) ([]ports.Port, error) {
+	portPages, err := ports.List(networkClient, ports.ListOpts{
+		DeviceID:  serverID,
+		ProjectID: projectID,
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instance ports: %w", err)
+	}
+	serverPorts, err := ports.ExtractPorts(portPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract instance ports: %w", err)
+	}
+	return serverPorts, nil
+}
+
+// routedSubnets maps the project's subnets with a router interface to the
+// external network the router's gateway is on, limited to one network when
+// given. Routers and their interfaces are listed in one call each.
+func routedSubnets(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID, floatingNetworkID string) (map[string]string, error) {
+	routerPages, err := routers.List(networkClient, routers.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routers: %w", err)
+	}
+	allRouters, err := routers.ExtractRouters(routerPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract routers: %w", err)
+	}
+
+	// Gateway network of each router that reaches an external network
+	gateways := map[string]string{}
+	for _, router := range allRouters {
+		gatewayNetworkID := router.GatewayInfo.NetworkID
+		if gatewayNetworkID == "" || (floatingNetworkID != "" && gatewayNetworkID != floatingNetworkID) {
+			continue
+		}
+		gateways[router.ID] = gatewayNetworkID
+	}
+	if len(gateways) == 0 {
+		return map[string]string{}, nil
+	}
+
+	portPages, err := ports.List(networkClient, ports.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list router ports: %w", err)
+	}
+	projectPorts, err := ports.ExtractPorts(portPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract router ports: %w", err)
+	}
+
+	reachable := map[string]string{}
+	for _, port := range projectPorts {
+		gatewayNetworkID, ok := gateways[port.DeviceID]
+		if !ok || !isRouterInterface(port.DeviceOwner) {
+			continue
+		}
+		for _, ip := range port.FixedIPs {
+			if _, ok := reachable[ip.SubnetID]; !ok {
+				reachable[ip.SubnetID] = gatewayNetworkID
+			}
+		}
+	}
+
+	return reachable, nil
+}
+
+// isRouterInterface reports whether a port device owner is a router's subnet
+// interface, rather than its gateway
+func isRouterInterface(deviceOwner string) bool {
+	return strings.HasPrefix(deviceOwner, "network:router_interface") ||
+		deviceOwner == "network:ha_router_replicated_interface"
+}
+
+// GetFloatingIP gets a floating IP
+func GetFloatingIP(ctx context.Context, networkClient *gophercloud.ServiceClient, floatingIPID string) (*models.FloatingIP, error) {
+	fip, err := floatingips.Get(ctx, networkClient, floatingIPID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get floating IP: %w", err)
+	}
+
+	result := floatingIPFromNeutron(fip)
+	return &result, nil
+}
+
+// AssociateFloatingIP points a floating IP at a port's fixed IP
+func AssociateFloatingIP(ctx context.Context, networkClient *gophercloud.ServiceClient, floatingIPID string, target *FloatingIPTarget) (*models.FloatingIP, error) {
+	fip, err := floatingips.Update(ctx, networkClient, floatingIPID, floatingips.UpdateOpts{
+		PortID:  &target.PortID,
+		FixedIP: target.FixedIP,
+	}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to associate floating IP: %w", err)
+	}
+
+	result := floatingIPFromNeutron(fip)
+	return &result, nil
+}
+
+// CreateFloatingIPFor allocates a floating IP in the client's project already
+// pointed at a port's fixed IP
+func CreateFloatingIPFor(ctx context.Context, networkClient *gophercloud.ServiceClient, description string, target *FloatingIPTarget) (*models.FloatingIP, error) {
+	fip, err := floatingips.Create(ctx, networkClient, floatingips.CreateOpts{
+		FloatingNetworkID: target.FloatingNetworkID,
+		PortID:            target.PortID,
+		FixedIP:           target.FixedIP,
+		Description:       description,
+	}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate floating IP: %w", err)
+	}
+
+	result := floatingIPFromNeutron(fip)
+	return &result, nil
+}
+
+// DeleteFloatingIP releases a floating IP
+func DeleteFloatingIP(ctx context.Context, networkClient *gophercloud.ServiceClient, floatingIPID string) error {
+	if err := floatingips.Delete(ctx, networkClient, floatingIPID).ExtractErr(); err != nil {
+		return fmt.Errorf("failed to release floating IP: %w", err)
+	}
+	return nil
+}
+
+// DisassociateFloatingIP unmaps a floating IP from its port, keeping it
+// allocated to the project
+func DisassociateFloatingIP(ctx context.Context, networkClient *gophercloud.ServiceClient, floatingIPID string) (*models.FloatingIP, error) {
+	noPort := ""
+	fip, err := floatingips.Update(ctx, networkClient, floatingIPID, floatingips.UpdateOpts{
+		PortID: &noPort,
+	}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to disassociate floating IP: %w", err)
+	}
+
+	result := floatingIPFromNeutron(fip)
+	return &result, nil
+}
+
+// ListServerFloatingIPs lists the floating IPs associated with a server's
+// ports in a project
+func ListServerFloatingIPs(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID, serverID string) ([]models.FloatingIP, error) {
+	serverPorts, err := listServerPorts(ctx, networkClient, projectID, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if len(serverPorts) == 0 {
+		return nil, ErrInstanceHasNoPorts
+	}
+
+	result := []models.FloatingIP{}
+	for _, port := range serverPorts {
+		fipPages, err := floatingips.List(networkClient, floatingips.ListOpts{PortID: port.ID, ProjectID: projectID}).AllPages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list floating IPs: %w", err)
+		}
+		fips, err := floatingips.ExtractFloatingIPs(fipPages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract floating IPs: %w", err)
+		}
+		for i := range fips {
+			result = append(result, floatingIPFromNeutron(&fips[i]))
+		}
+	}
+
+	return result, nil
+}
+
+// floatingIPFromNeutron converts a Neutron floating IP to our model
+func floatingIPFromNeutron(fip *floatingips.FloatingIP) models.FloatingIP {
+	return models.FloatingIP{
+		ID:                fip.ID,
+		FloatingIP:        fip.FloatingIP,
+		FloatingNetworkID: fip.FloatingNetworkID,
+		Status:            fip.Status,
+		PortID:            fip.PortID,
+		FixedIP:           fip.FixedIP,
+		RouterID:          fip.RouterID,
+		Description:       fip.Description,
+		ProjectID:         fip.ProjectID,
+		CreatedAt:         fip.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         fip.UpdatedAt.Format(time.RFC3339),
+	}
+}
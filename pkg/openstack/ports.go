@@ -0,0 +1,336 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/attachinterfaces"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/secgroups"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/portsecurity"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ErrPortSecurityDisabled is returned when a port without port security is
+// given security groups or allowed address pairs, which it cannot enforce
+var ErrPortSecurityDisabled = errors.New("a port without port security cannot have security groups or allowed address pairs")
+
+// portWithSecurity is a Neutron port with its port security setting
+type portWithSecurity struct {
+	ports.Port
+	portsecurity.PortSecurityExt
+}
+
+// ListPorts lists the ports visible to the provider. A network ID limits the
+// list to the network's ports and an instance ID to the instance's.
+func ListPorts(ctx context.Context, provider *gophercloud.ProviderClient, networkID, instanceID string) ([]models.Port, error) {
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	allPages, err := ports.List(networkClient, ports.ListOpts{
+		NetworkID: networkID,
+		DeviceID:  instanceID,
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ports: %w", err)
+	}
+	var allPorts []portWithSecurity
+	if err := ports.ExtractPortsInto(allPages, &allPorts); err != nil {
+		return nil, fmt.Errorf("failed to extract ports: %w", err)
+	}
+
+	result := make([]models.Port, 0, len(allPorts))
+	for i := range allPorts {
+		result = append(result, portFromNeutron(&allPorts[i]))
+	}
+
+	return result, nil
+}
+
+// GetPort gets a port
+func GetPort(ctx context.Context, provider *gophercloud.ProviderClient, portID string) (*models.Port, error) {
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	var port portWithSecurity
+	if err := ports.Get(ctx, networkClient, portID).ExtractInto(&port); err != nil {
+		return nil, fmt.Errorf("failed to get port: %w", err)
+	}
+
+	result := portFromNeutron(&port)
+	return &result, nil
+}
+
+// CreatePort creates a port. A port without port security gets no security
+// groups unless the request names some, which is an error.
+func CreatePort(ctx context.Context, provider *gophercloud.ProviderClient, req models.CreatePortRequest) (*models.Port, error) {
+	securityGroups := req.SecurityGroups
+	if req.PortSecurityEnabled != nil && !*req.PortSecurityEnabled {
+		if (securityGroups != nil && len(*securityGroups) > 0) || len(req.AllowedAddressPairs) > 0 {
+			return nil, ErrPortSecurityDisabled
+		}
+		// Otherwise Neutron applies the default group and refuses the port
+		securityGroups = &[]string{}
+	}
+
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	createOpts := portsecurity.PortCreateOptsExt{
+		CreateOptsBuilder: ports.CreateOpts{
+			NetworkID:           req.NetworkID,
+			Name:                req.Name,
+			Description:         req.Description,
+			FixedIPs:            neutronFixedIPs(req.FixedIPs),
+			SecurityGroups:      securityGroups,
+			AllowedAddressPairs: neutronAddressPairs(req.AllowedAddressPairs),
+		},
+		PortSecurityEnabled: req.PortSecurityEnabled,
+	}
+
+	var port portWithSecurity
+	if err := ports.Create(ctx, networkClient, createOpts).ExtractInto(&port); err != nil {
+		return nil, fmt.Errorf("failed to create port: %w", err)
+	}
+
+	result := portFromNeutron(&port)
+	return &result, nil
+}
+
+// UpdatePort changes a port. Turning port security off also clears the
+// port's security groups and allowed address pairs unless the request sets
+// them, which is an error.
+func UpdatePort(ctx context.Context, provider *gophercloud.ProviderClient, portID string, req models.UpdatePortRequest) (*models.Port, error) {
+	updateOpts := ports.UpdateOpts{
+		Name:           req.Name,
+		Description:    req.Description,
+		SecurityGroups: req.SecurityGroups,
+	}
+	if req.FixedIPs != nil {
+		updateOpts.FixedIPs = neutronFixedIPs(*req.FixedIPs)
+	}
+	if req.AllowedAddressPairs != nil {
+		pairs := neutronAddressPairs(*req.AllowedAddressPairs)
+		updateOpts.AllowedAddressPairs = &pairs
+	}
+
+	if req.PortSecurityEnabled != nil && !*req.PortSecurityEnabled {
+		if (req.SecurityGroups != nil && len(*req.SecurityGroups) > 0) ||
+			(req.AllowedAddressPairs != nil && len(*req.AllowedAddressPairs) > 0) {
+			return nil, ErrPortSecurityDisabled
+		}
+		updateOpts.SecurityGroups = &[]string{}
+		updateOpts.AllowedAddressPairs = &[]ports.AddressPair{}
+	}
+
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	var port portWithSecurity
+	err = ports.Update(ctx, networkClient, portID, portsecurity.PortUpdateOptsExt{
+		UpdateOptsBuilder:   updateOpts,
+		PortSecurityEnabled: req.PortSecurityEnabled,
+	}).ExtractInto(&port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update port: %w", err)
+	}
+
+	result := portFromNeutron(&port)
+	return &result, nil
+}
+
+// DeletePort deletes a port, detaching it from its instance
+func DeletePort(ctx context.Context, provider *gophercloud.ProviderClient, portID string) error {
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	if err := ports.Delete(ctx, networkClient, portID).ExtractErr(); err != nil {
+		return fmt.Errorf("failed to delete port: %w", err)
+	}
+
+	return nil
+}
+
+// ListServerInterfaces lists the NICs attached to a server
+func ListServerInterfaces(ctx context.Context, provider *gophercloud.ProviderClient, serverID string) ([]models.InstanceInterface, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	allPages, err := attachinterfaces.List(computeClient, serverID).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	allInterfaces, err := attachinterfaces.ExtractInterfaces(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract interfaces: %w", err)
+	}
+
+	result := make([]models.InstanceInterface, 0, len(allInterfaces))
+	for i := range allInterfaces {
+		result = append(result, interfaceFromNova(&allInterfaces[i]))
+	}
+
+	return result, nil
+}
+
+// AttachServerInterface hot-plugs a NIC into a server, from an existing port
+// or a new port on a network
+func AttachServerInterface(ctx context.Context, computeClient *gophercloud.ServiceClient, serverID string, req models.AttachInterfaceRequest) (*models.InstanceInterface, error) {
+	createOpts := attachinterfaces.CreateOpts{
+		PortID:    req.PortID,
+		NetworkID: req.NetworkID,
+	}
+	if req.FixedIP != "" {
+		createOpts.FixedIPs = []attachinterfaces.FixedIP{{IPAddress: req.FixedIP}}
+	}
+
+	iface, err := attachinterfaces.Create(ctx, computeClient, serverID, createOpts).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach interface: %w", err)
+	}
+
+	result := interfaceFromNova(iface)
+	return &result, nil
+}
+
+// DetachServerInterface unplugs a NIC from a server. A port Nova created for
+// the NIC is deleted; a port attached by ID is kept.
+func DetachServerInterface(ctx context.Context, computeClient *gophercloud.ServiceClient, serverID, portID string) error {
+	if err := attachinterfaces.Delete(ctx, computeClient, serverID, portID).ExtractErr(); err != nil {
+		return fmt.Errorf("failed to detach interface: %w", err)
+	}
+	return nil
+}
+
+// ListServerSecurityGroups lists the security groups applied to a server
+func ListServerSecurityGroups(ctx context.Context, provider *gophercloud.ProviderClient, serverID string) ([]models.InstanceSecurityGroup, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	allPages, err := secgroups.ListByServer(computeClient, serverID).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list security groups: %w", err)
+	}
+	allGroups, err := secgroups.ExtractSecurityGroups(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract security groups: %w", err)
+	}
+
+	result := make([]models.InstanceSecurityGroup, 0, len(allGroups))
+	for _, group := range allGroups {
+		result = append(result, models.InstanceSecurityGroup{
+			ID:          group.ID,
+			Name:        group.Name,
+			Description: group.Description,
+		})
+	}
+
+	return result, nil
+}
+
+// portFromNeutron converts a Neutron port to our model
+func portFromNeutron(port *portWithSecurity) models.Port {
+	result := models.Port{
+		ID:                  port.ID,
+		Name:                port.Name,
+		Description:         port.Description,
+		NetworkID:           port.NetworkID,
+		Status:              port.Status,
+		MACAddress:          port.MACAddress,
+		FixedIPs:            make([]models.PortFixedIP, 0, len(port.FixedIPs)),
+		SecurityGroups:      port.SecurityGroups,
+		AllowedAddressPairs: make([]models.PortAddressPair, 0, len(port.AllowedAddressPairs)),
+		PortSecurityEnabled: port.PortSecurityEnabled,
+		AdminStateUp:        port.AdminStateUp,
+		DeviceOwner:         port.DeviceOwner,
+		ProjectID:           port.ProjectID,
+		CreatedAt:           port.CreatedAt,
+		UpdatedAt:           port.UpdatedAt,
+	}
+	if result.SecurityGroups == nil {
+		result.SecurityGroups = []string{}
+	}
+	// Nova owns instance ports as compute:<availability zone>
+	if strings.HasPrefix(port.DeviceOwner, "compute:") {
+		result.InstanceID = port.DeviceID
+	}
+	for _, ip := range port.FixedIPs {
+		result.FixedIPs = append(result.FixedIPs, models.PortFixedIP{
+			SubnetID:  ip.SubnetID,
+			IPAddress: ip.IPAddress,
+		})
+	}
+	for _, pair := range port.AllowedAddressPairs {
+		result.AllowedAddressPairs = append(result.AllowedAddressPairs, models.PortAddressPair{
+			IPAddress:  pair.IPAddress,
+			MACAddress: pair.MACAddress,
+		})
+	}
+
+	return result
+}
+
+// interfaceFromNova converts a Nova interface attachment to our model
+func interfaceFromNova(iface *attachinterfaces.Interface) models.InstanceInterface {
+	result := models.InstanceInterface{
+		PortID:     iface.PortID,
+		NetworkID:  iface.NetID,
+		MACAddress: iface.MACAddr,
+		FixedIPs:   make([]models.PortFixedIP, 0, len(iface.FixedIPs)),
+		Status:     iface.PortState,
+	}
+	for _, ip := range iface.FixedIPs {
+		result.FixedIPs = append(result.FixedIPs, models.PortFixedIP{
+			SubnetID:  ip.SubnetID,
+			IPAddress: ip.IPAddress,
+		})
+	}
+
+	return result
+}
+
+// neutronFixedIPs converts requested fixed IPs to Neutron's, leaving them
+// unset when none are requested
+func neutronFixedIPs(fixedIPs []models.PortFixedIP) any {
+	if fixedIPs == nil {
+		return nil
+	}
+
+	result := make([]ports.IP, 0, len(fixedIPs))
+	for _, ip := range fixedIPs {
+		result = append(result, ports.IP{
+			SubnetID:  ip.SubnetID,
+			IPAddress: ip.IPAddress,
+		})
+	}
+	return result
+}
+
+// neutronAddressPairs converts requested allowed address pairs to Neutron's
+func neutronAddressPairs(pairs []models.PortAddressPair) []ports.AddressPair {
+	result := make([]ports.AddressPair, 0, len(pairs))
+	for _, pair := range pairs {
+		result = append(result, ports.AddressPair{
+			IPAddress:  pair.IPAddress,
+			MACAddress: pair.MACAddress,
+		})
+	}
+	return result
+}
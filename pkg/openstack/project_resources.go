@@ -0,0 +1,353 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/image/v2/images"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/subnets"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// serverDeletePollInterval is how often teardown checks whether deleted servers are gone
+const serverDeletePollInterval = 5 * time.Second
+
+// BootstrapProjectNetwork creates a private network, subnet and router in a project (admin only).
+// The router is connected to the external network when externalNetworkID is set.
+func BootstrapProjectNetwork(ctx context.Context, provider *gophercloud.ProviderClient, projectID, cidr, externalNetworkID string) (*models.ProjectNetwork, error) {
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	// Create the private network
+	adminStateUp := true
+	network, err := networks.Create(ctx, networkClient, networks.CreateOpts{
+		Name:         "default-network",
+		AdminStateUp: &adminStateUp,
+		ProjectID:    projectID,
+	}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network: %w", err)
+	}
+	result := &models.ProjectNetwork{NetworkID: network.ID}
+
+	// Create the subnet
+	enableDHCP := true
+	subnet, err := subnets.Create(ctx, networkClient, subnets.CreateOpts{
+		Name:           "default-subnet",
+		NetworkID:      network.ID,
+		CIDR:           cidr,
+		IPVersion:      gophercloud.IPv4,
+		EnableDHCP:     &enableDHCP,
+		DNSNameservers: []string{"8.8.8.8", "8.8.4.4"},
+		ProjectID:      projectID,
+	}).Extract()
+	if err != nil {
+		return result, fmt.Errorf("failed to create subnet: %w", err)
+	}
+	result.SubnetID = subnet.ID
+
+	// Create the router
+	routerOpts := routers.CreateOpts{
+		Name:         "default-router",
+		AdminStateUp: &adminStateUp,
+		ProjectID:    projectID,
+	}
+	if externalNetworkID != "" {
+		routerOpts.GatewayInfo = &routers.GatewayInfo{NetworkID: externalNetworkID}
+	}
+	router, err := routers.Create(ctx, networkClient, routerOpts).Extract()
+	if err != nil {
+		return result, fmt.Errorf("failed to create router: %w", err)
+	}
+	result.RouterID = router.ID
+
+	// Connect the subnet to the router
+	_, err = routers.AddInterface(ctx, networkClient, router.ID, routers.AddInterfaceOpts{SubnetID: subnet.ID}).Extract()
+	if err != nil {
+		return result, fmt.Errorf("failed to add router interface: %w", err)
+	}
+
+	return result, nil
+}
+
+// ListProjectResources lists the resources that remain in a project (admin only).
+// The project's default security group is not counted.
+func ListProjectResources(ctx context.Context, provider *gophercloud.ProviderClient, projectID string) (*models.ProjectResources, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	blockStorageClient, err := NewBlockStorageClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create block storage client: %w", err)
+	}
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network client: %w", err)
+	}
+
+	resources := &models.ProjectResources{
+		Instances:      []models.ProjectResource{},
+		Volumes:        []models.ProjectResource{},
+		FloatingIPs:    []models.ProjectResource{},
+		Routers:        []models.ProjectResource{},
+		Networks:       []models.ProjectResource{},
+		SecurityGroups: []models.ProjectResource{},
+		Snapshots:      []models.ProjectResource{},
+	}
+
+	// List instances
+	serverPages, err := servers.List(computeClient, servers.ListOpts{AllTenants: true, TenantID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	allServers, err := servers.ExtractServers(serverPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract instances: %w", err)
+	}
+	for _, s := range allServers {
+		resources.Instances = append(resources.Instances, models.ProjectResource{ID: s.ID, Name: s.Name})
+	}
+
+	// List volumes
+	volumePages, err := volumes.List(blockStorageClient, volumes.ListOpts{AllTenants: true, TenantID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	allVolumes, err := volumes.ExtractVolumes(volumePages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract volumes: %w", err)
+	}
+	for _, v := range allVolumes {
+		resources.Volumes = append(resources.Volumes, models.ProjectResource{ID: v.ID, Name: v.Name})
+	}
+
+	// List floating IPs
+	fipPages, err := floatingips.List(networkClient, floatingips.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list floating IPs: %w", err)
+	}
+	allFIPs, err := floatingips.ExtractFloatingIPs(fipPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract floating IPs: %w", err)
+	}
+	for _, f := range allFIPs {
+		resources.FloatingIPs = append(resources.FloatingIPs, models.ProjectResource{ID: f.ID, Name: f.FloatingIP})
+	}
+
+	// List routers
+	routerPages, err := routers.List(networkClient, routers.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routers: %w", err)
+	}
+	allRouters, err := routers.ExtractRouters(routerPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract routers: %w", err)
+	}
+	for _, r := range allRouters {
+		resources.Routers = append(resources.Routers, models.ProjectResource{ID: r.ID, Name: r.Name})
+	}
+
+	// List networks owned by the project
+	networkPages, err := networks.List(networkClient, networks.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	allNetworks, err := networks.ExtractNetworks(networkPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract networks: %w", err)
+	}
+	for _, n := range allNetworks {
+		resources.Networks = append(resources.Networks, models.ProjectResource{ID: n.ID, Name: n.Name})
+	}
+
+	// List security groups other than the default group
+	groupPages, err := groups.List(networkClient, groups.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list security groups: %w", err)
+	}
+	allGroups, err := groups.ExtractGroups(groupPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract security groups: %w", err)
+	}
+	for _, g := range allGroups {
+		if g.Name == "default" {
+			continue
+		}
+		resources.SecurityGroups = append(resources.SecurityGroups, models.ProjectResource{ID: g.ID, Name: g.Name})
+	}
+
+	// List instance snapshots, which outlive their instances
+	snapshots, err := ListSnapshots(ctx, provider, projectID, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		resources.Snapshots = append(resources.Snapshots, models.ProjectResource{ID: s.ID, Name: s.Name})
+	}
+
+	return resources, nil
+}
+
+// TeardownProjectResources deletes every resource in a project (admin only).
+// Instances are deleted first and awaited so their volumes and ports are released
+// before the remaining resources are removed. Deletion continues past individual
+// failures and all errors are returned together.
+func TeardownProjectResources(ctx context.Context, provider *gophercloud.ProviderClient, projectID string) error {
+	resources, err := ListProjectResources(ctx, provider, projectID)
+	if err != nil {
+		return err
+	}
+
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create compute client: %w", err)
+	}
+	blockStorageClient, err := NewBlockStorageClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create block storage client: %w", err)
+	}
+	networkClient, err := NewNetworkClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create network client: %w", err)
+	}
+	imageClient, err := NewImageClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create image client: %w", err)
+	}
+
+	var errs []error
+	ignoreNotFound := func(err error) error {
+		if err == nil || gophercloud.ResponseCodeIs(err, 404) {
+			return nil
+		}
+		return err
+	}
+
+	// Delete instances and wait for them to disappear
+	for _, s := range resources.Instances {
+		if err := ignoreNotFound(servers.Delete(ctx, computeClient, s.ID).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete instance %s: %w", s.ID, err))
+		}
+	}
+	for _, s := range resources.Instances {
+		if err := waitForServerDeleted(ctx, computeClient, s.ID); err != nil {
+			errs = append(errs, fmt.Errorf("instance %s was not deleted: %w", s.ID, err))
+		}
+	}
+
+	// Delete instance snapshots
+	for _, s := range resources.Snapshots {
+		if err := ignoreNotFound(images.Delete(ctx, imageClient, s.ID).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete snapshot %s: %w", s.ID, err))
+		}
+	}
+
+	// Release floating IPs
+	for _, f := range resources.FloatingIPs {
+		if err := ignoreNotFound(floatingips.Delete(ctx, networkClient, f.ID).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete floating IP %s: %w", f.ID, err))
+		}
+	}
+
+	// Delete volumes together with their snapshots
+	for _, v := range resources.Volumes {
+		if err := ignoreNotFound(volumes.Delete(ctx, blockStorageClient, v.ID, volumes.DeleteOpts{Cascade: true}).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete volume %s: %w", v.ID, err))
+		}
+	}
+
+	// Detach router interfaces, then delete routers
+	for _, r := range resources.Routers {
+		if err := removeRouterInterfaces(ctx, networkClient, r.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := ignoreNotFound(routers.Delete(ctx, networkClient, r.ID).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete router %s: %w", r.ID, err))
+		}
+	}
+
+	// Delete networks, which also removes their subnets
+	for _, n := range resources.Networks {
+		if err := ignoreNotFound(networks.Delete(ctx, networkClient, n.ID).ExtractErr()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete network %s: %w", n.ID, err))
+		}
+	}
+
+	// Delete all security groups, including the default group
+	groupPages, err := groups.List(networkClient, groups.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list security groups: %w", err))
+	} else if allGroups, err := groups.ExtractGroups(groupPages); err != nil {
+		errs = append(errs, fmt.Errorf("failed to extract security groups: %w", err))
+	} else {
+		for _, g := range allGroups {
+			if err := ignoreNotFound(groups.Delete(ctx, networkClient, g.ID).ExtractErr()); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete security group %s: %w", g.ID, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// waitForServerDeleted polls a server until it no longer exists or the context ends
+func waitForServerDeleted(ctx context.Context, computeClient *gophercloud.ServiceClient, serverID string) error {
+	ticker := time.NewTicker(serverDeletePollInterval)
+	defer ticker.Stop()
+
+	for {
+		server, err := servers.Get(ctx, computeClient, serverID).Extract()
+		if gophercloud.ResponseCodeIs(err, 404) {
+			return nil
+		}
+		if err == nil && server.Status == "ERROR" {
+			return fmt.Errorf("instance is in ERROR state")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeRouterInterfaces detaches every subnet interface from a router
+func removeRouterInterfaces(ctx context.Context, networkClient *gophercloud.ServiceClient, routerID string) error {
+	portPages, err := ports.List(networkClient, ports.ListOpts{DeviceID: routerID}).AllPages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list ports of router %s: %w", routerID, err)
+	}
+	allPorts, err := ports.ExtractPorts(portPages)
+	if err != nil {
+		return fmt.Errorf("failed to extract ports of router %s: %w", routerID, err)
+	}
+
+	for _, p := range allPorts {
+		// Gateway ports are removed together with the router
+		if !strings.HasPrefix(p.DeviceOwner, "network:router_interface") {
+			continue
+		}
+		_, err := routers.RemoveInterface(ctx, networkClient, routerID, routers.RemoveInterfaceOpts{PortID: p.ID}).Extract()
+		if err != nil && !gophercloud.ResponseCodeIs(err, 404) {
+			return fmt.Errorf("failed to remove interface %s from router %s: %w", p.ID, routerID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	blockquotas "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/quotasets"
+	computequotas "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/quotasets"
+	networkquotas "github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/quotas"
+)
+
+// ProjectQuotas holds the quota limits applied to new projects.
+// A zero value leaves the cloud's default for that resource in place.
+type ProjectQuotas struct {
+	Instances      int
+	Cores          int
+	RAM            int
+	Volumes        int
+	Gigabytes      int
+	Snapshots      int
+	FloatingIPs    int
+	Networks       int
+	Routers        int
+	SecurityGroups int
+}
+
+// quotaLimit returns a pointer to a quota limit, or nil when it is not set
+func quotaLimit(value int) *int {
+	if value <= 0 {
+		return nil
+	}
+	return &value
+}
+
+// ApplyProjectQuotas sets compute, block storage and network quotas on a project (admin only)
+func ApplyProjectQuotas(ctx context.Context, provider *gophercloud.ProviderClient, projectID string, quotas ProjectQuotas) error {
+	// Update compute quotas
+	if quotas.Instances > 0 || quotas.Cores > 0 || quotas.RAM > 0 {
+		computeClient, err := NewComputeClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create compute client: %w", err)
+		}
+
+		computeOpts := computequotas.UpdateOpts{
+			Instances: quotaLimit(quotas.Instances),
+			Cores:     quotaLimit(quotas.Cores),
+			RAM:       quotaLimit(quotas.RAM),
+		}
+		if _, err := computequotas.Update(ctx, computeClient, projectID, computeOpts).Extract(); err != nil {
+			return fmt.Errorf("failed to update compute quotas: %w", err)
+		}
+	}
+
+	// Update block storage quotas
+	if quotas.Volumes > 0 || quotas.Gigabytes > 0 || quotas.Snapshots > 0 {
+		blockStorageClient, err := NewBlockStorageClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create block storage client: %w", err)
+		}
+
+		blockOpts := blockquotas.UpdateOpts{
+			Volumes:   quotaLimit(quotas.Volumes),
+			Gigabytes: quotaLimit(quotas.Gigabytes),
+			Snapshots: quotaLimit(quotas.Snapshots),
+		}
+		if _, err := blockquotas.Update(ctx, blockStorageClient, projectID, blockOpts).Extract(); err != nil {
+			return fmt.Errorf("failed to update block storage quotas: %w", err)
+		}
+	}
+
+	// Update network quotas
+	if quotas.FloatingIPs > 0 || quotas.Networks > 0 || quotas.Routers > 0 || quotas.SecurityGroups > 0 {
+		networkClient, err := NewNetworkClient(provider)
+		if err != nil {
+			return fmt.Errorf("failed to create network client: %w", err)
+		}
+
+		networkOpts := networkquotas.UpdateOpts{
+			FloatingIP:    quotaLimit(quotas.FloatingIPs),
+			Network:       quotaLimit(quotas.Networks),
+			Router:        quotaLimit(quotas.Routers),
+			SecurityGroup: quotaLimit(quotas.SecurityGroups),
+		}
+		if _, err := networkquotas.Update(ctx, networkClient, projectID, networkOpts).Extract(); err != nil {
+			return fmt.Errorf("failed to update network quotas: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/roles"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// ProjectRoleMapping maps Lineserve project roles to Keystone roles.
+//
+// Owners, admins and members need write access to OpenStack, so they get the
+// Keystone member role. Billing and read-only users get the reader role, so
+// the OpenStack token embedded in their project token cannot modify resources.
+type ProjectRoleMapping struct {
+	MemberRoleID string
+	ReaderRoleID string
+}
+
+// KeystoneRoleID returns the Keystone role ID for a project role
+func (m ProjectRoleMapping) KeystoneRoleID(projectRole string) (string, error) {
+	switch projectRole {
+	case models.ProjectRoleOwner, models.ProjectRoleAdmin, models.ProjectRoleMember:
+		if m.MemberRoleID == "" {
+			return "", fmt.Errorf("keystone member role is not configured")
+		}
+		return m.MemberRoleID, nil
+	case models.ProjectRoleBilling, models.ProjectRoleReadOnly:
+		if m.ReaderRoleID == "" {
+			return "", fmt.Errorf("keystone reader role is not configured (set OPENSTACK_READER_ROLE_ID)")
+		}
+		return m.ReaderRoleID, nil
+	default:
+		return "", fmt.Errorf("unknown project role: %s", projectRole)
+	}
+}
+
+// UnassignRoleFromUserOnProject removes a role assignment from a user on a project (admin only)
+func UnassignRoleFromUserOnProject(ctx context.Context, provider *gophercloud.ProviderClient, userID, projectID, roleID string) error {
+	identityClient, err := NewIdentityClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create identity client: %w", err)
+	}
+
+	unassignOpts := roles.UnassignOpts{
+		UserID:    userID,
+		ProjectID: projectID,
+	}
+
+	result := roles.Unassign(ctx, identityClient, roleID, unassignOpts)
+	if result.Err != nil {
+		// The assignment is already gone, which is the desired state
+		if gophercloud.ResponseCodeIs(result.Err, 404) {
+			return nil
+		}
+		return fmt.Errorf("failed to unassign role: %w", result.Err)
+	}
+
+	return nil
+}
+
+// ChangeProjectRoleAssignment moves a user's Keystone assignment on a project
+// from the role backing oldRole to the role backing newRole
+func ChangeProjectRoleAssignment(ctx context.Context, provider *gophercloud.ProviderClient, mapping ProjectRoleMapping, userID, projectID, oldRole, newRole string) (string, error) {
+	newRoleID, err := mapping.KeystoneRoleID(newRole)
+	if err != nil {
+		return "", err
+	}
+
+	// Assign the new role before removing the old one so access is never interrupted
+	if err := AssignRoleToUserOnProject(ctx, provider, userID, projectID, newRoleID); err != nil {
+		return "", err
+	}
+
+	if oldRole == "" {
+		return newRoleID, nil
+	}
+
+	oldRoleID, err := mapping.KeystoneRoleID(oldRole)
+	if err != nil || oldRoleID == newRoleID {
+		return newRoleID, nil
+	}
+
+	if err := UnassignRoleFromUserOnProject(ctx, provider, userID, projectID, oldRoleID); err != nil {
+		return "", err
+	}
+
+	return newRoleID, nil
+}
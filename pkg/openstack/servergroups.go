@@ -0,0 +1,167 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	blockstorageazs "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/availabilityzones"
+	computeazs "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/availabilityzones"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// serverGroupMicroversion is the first compute microversion with the soft
+// affinity policies
+const serverGroupMicroversion = "2.15"
+
+// internalZone is Nova's zone for its own services, which hosts no instances
+const internalZone = "internal"
+
+// ListServerGroups lists the project's server groups
+func ListServerGroups(ctx context.Context, provider *gophercloud.ProviderClient) ([]models.ServerGroup, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	computeClient.Microversion = serverGroupMicroversion
+
+	allPages, err := servergroups.List(computeClient, servergroups.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list server groups: %w", err)
+	}
+	allGroups, err := servergroups.ExtractServerGroups(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract server groups: %w", err)
+	}
+
+	result := make([]models.ServerGroup, 0, len(allGroups))
+	for i := range allGroups {
+		result = append(result, serverGroupFromNova(&allGroups[i]))
+	}
+
+	return result, nil
+}
+
+// CreateServerGroup creates a server group with one placement policy
+func CreateServerGroup(ctx context.Context, provider *gophercloud.ProviderClient, name, policy string) (*models.ServerGroup, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	computeClient.Microversion = serverGroupMicroversion
+
+	group, err := servergroups.Create(ctx, computeClient, servergroups.CreateOpts{
+		Name:     name,
+		Policies: []string{policy},
+	}).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create server group: %w", err)
+	}
+
+	result := serverGroupFromNova(group)
+	return &result, nil
+}
+
+// GetServerGroup gets a server group with its members
+func GetServerGroup(ctx context.Context, provider *gophercloud.ProviderClient, id string) (*models.ServerGroup, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	computeClient.Microversion = serverGroupMicroversion
+
+	group, err := servergroups.Get(ctx, computeClient, id).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server group: %w", err)
+	}
+
+	result := serverGroupFromNova(group)
+	return &result, nil
+}
+
+// DeleteServerGroup deletes a server group; its instances keep running where
+// they are
+func DeleteServerGroup(ctx context.Context, provider *gophercloud.ProviderClient, id string) error {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	if err := servergroups.Delete(ctx, computeClient, id).ExtractErr(); err != nil {
+		return fmt.Errorf("failed to delete server group: %w", err)
+	}
+
+	return nil
+}
+
+// ListAvailabilityZones lists the compute and volume availability zones
+func ListAvailabilityZones(ctx context.Context, provider *gophercloud.ProviderClient) (*models.AvailabilityZones, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %w", err)
+	}
+	computePages, err := computeazs.List(computeClient).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compute availability zones: %w", err)
+	}
+	computeZones, err := computeazs.ExtractAvailabilityZones(computePages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract compute availability zones: %w", err)
+	}
+
+	volumeClient, err := NewBlockStorageClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create block storage client: %w", err)
+	}
+	volumePages, err := blockstorageazs.List(volumeClient).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volume availability zones: %w", err)
+	}
+	volumeZones, err := blockstorageazs.ExtractAvailabilityZones(volumePages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract volume availability zones: %w", err)
+	}
+
+	result := &models.AvailabilityZones{
+		Compute: make([]models.AvailabilityZone, 0, len(computeZones)),
+		Volume:  make([]models.AvailabilityZone, 0, len(volumeZones)),
+	}
+	for _, zone := range computeZones {
+		if zone.ZoneName == internalZone {
+			continue
+		}
+		result.Compute = append(result.Compute, models.AvailabilityZone{
+			Name:      zone.ZoneName,
+			Available: zone.ZoneState.Available,
+		})
+	}
+	for _, zone := range volumeZones {
+		result.Volume = append(result.Volume, models.AvailabilityZone{
+			Name:      zone.ZoneName,
+			Available: zone.ZoneState.Available,
+		})
+	}
+
+	return result, nil
+}
+
+// serverGroupFromNova converts a Nova server group to our model
+func serverGroupFromNova(group *servergroups.ServerGroup) models.ServerGroup {
+	result := models.ServerGroup{
+		ID:        group.ID,
+		Name:      group.Name,
+		Members:   group.Members,
+		ProjectID: group.ProjectID,
+	}
+	if result.Members == nil {
+		result.Members = []string{}
+	}
+	if group.Policy != nil {
+		result.Policy = *group.Policy
+	} else if len(group.Policies) > 0 {
+		result.Policy = group.Policies[0]
+	}
+
+	return result
+}
@@ -0,0 +1,132 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/image/v2/images"
+	"github.com/lineserve/lineserve-api/pkg/models"
+)
+
+// bytesPerGB converts image sizes to the gigabytes storage is billed in
+const bytesPerGB = 1024 * 1024 * 1024
+
+// ErrNotSnapshot is returned for an image that is not an instance snapshot
+var ErrNotSnapshot = errors.New("image is not an instance snapshot")
+
+// snapshotListOpts lists instance snapshots, which Nova marks with the
+// image_type and instance_uuid image properties
+type snapshotListOpts struct {
+	Owner        string `q:"owner"`
+	InstanceUUID string `q:"instance_uuid"`
+	ImageType    string `q:"image_type"`
+}
+
+// ToImageListQuery formats snapshotListOpts into a query string
+func (opts snapshotListOpts) ToImageListQuery() (string, error) {
+	q, err := gophercloud.BuildQueryString(opts)
+	if err != nil {
+		return "", err
+	}
+	return q.String(), nil
+}
+
+// CreateServerSnapshot snapshots a server into a new image and returns the
+// image's ID. The image is queued until Nova has uploaded the disk.
+func CreateServerSnapshot(ctx context.Context, provider *gophercloud.ProviderClient, serverID, name string, metadata map[string]string) (string, error) {
+	computeClient, err := NewComputeClient(provider)
+	if err != nil {
+		return "", fmt.Errorf("failed to create compute client: %w", err)
+	}
+
+	imageID, err := servers.CreateImage(ctx, computeClient, serverID, servers.CreateImageOpts{
+		Name:     name,
+		Metadata: metadata,
+	}).ExtractImageID()
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	return imageID, nil
+}
+
+// ListSnapshots lists the instance snapshots visible to the provider. A
+// project ID limits the list to the project's snapshots and a server ID to
+// the server's.
+func ListSnapshots(ctx context.Context, provider *gophercloud.ProviderClient, projectID, serverID string) ([]models.Snapshot, error) {
+	imageClient, err := NewImageClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image client: %w", err)
+	}
+
+	allPages, err := images.List(imageClient, snapshotListOpts{
+		Owner:        projectID,
+		InstanceUUID: serverID,
+		ImageType:    "snapshot",
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	allImages, err := images.ExtractImages(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract snapshots: %w", err)
+	}
+
+	snapshots := make([]models.Snapshot, 0, len(allImages))
+	for _, img := range allImages {
+		snapshots = append(snapshots, snapshotFromImage(&img))
+	}
+	return snapshots, nil
+}
+
+// GetSnapshot gets an instance snapshot by image ID
+func GetSnapshot(ctx context.Context, provider *gophercloud.ProviderClient, imageID string) (*models.Snapshot, error) {
+	imageClient, err := NewImageClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image client: %w", err)
+	}
+
+	img, err := images.Get(ctx, imageClient, imageID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot: %w", err)
+	}
+	if imageType, _ := img.Properties["image_type"].(string); imageType != "snapshot" {
+		return nil, ErrNotSnapshot
+	}
+
+	snapshot := snapshotFromImage(img)
+	return &snapshot, nil
+}
+
+// SummarizeSnapshotUsage totals the storage held by snapshots. Snapshots of
+// volume-backed servers are Cinder volume snapshots and hold no image data.
+func SummarizeSnapshotUsage(snapshots []models.Snapshot) models.SnapshotUsage {
+	usage := models.SnapshotUsage{Count: len(snapshots)}
+	for _, snapshot := range snapshots {
+		usage.SizeBytes += snapshot.Size
+	}
+	usage.SizeGB = float64(usage.SizeBytes) / bytesPerGB
+	return usage
+}
+
+// snapshotFromImage converts a Glance snapshot image to our model
+func snapshotFromImage(img *images.Image) models.Snapshot {
+	instanceID, _ := img.Properties["instance_uuid"].(string)
+	_, volumeBacked := img.Properties["block_device_mapping"]
+
+	return models.Snapshot{
+		ID:           img.ID,
+		Name:         img.Name,
+		InstanceID:   instanceID,
+		Status:       string(img.Status),
+		Size:         img.SizeBytes,
+		MinDisk:      img.MinDiskGigabytes,
+		VolumeBacked: volumeBacked,
+		ProjectID:    img.Owner,
+		CreatedAt:    img.CreatedAt,
+		UpdatedAt:    img.UpdatedAt,
+	}
+}
@@ -0,0 +1,262 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for signing
+const minRSAKeyBits = 2048
+
+// ErrNoKeys is returned when no signing key is configured
+var ErrNoKeys = errors.New("no JWT signing keys configured (set JWT_KEY_FILES or JWT_KEYS)")
+
+// Key is a private key used to sign and verify tokens
+type Key struct {
+	ID        string
+	Algorithm string
+	signer    crypto.Signer
+}
+
+// PublicKey returns the public half of the key
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.signer.Public()
+}
+
+// method returns the JWT signing method for the key
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == jwt.SigningMethodEdDSA.Alg() {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySet holds the keys used to sign and verify tokens. The first key signs
+// new tokens; every key verifies, so a new key can be put first while tokens
+// signed with the previous key stay valid until they expire.
+type KeySet struct {
+	keys []*Key
+	byID map[string]*Key
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet creates a key set from PEM-encoded private keys. Each PEM input may
+// contain several keys. Keys are identified by their RFC 7638 thumbprint.
+func NewKeySet(pemData ...[]byte) (*KeySet, error) {
+	ks := &KeySet{byID: map[string]*Key{}}
+
+	for _, data := range pemData {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+
+			key, err := parsePrivateKey(block)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := ks.byID[key.ID]; exists {
+				continue
+			}
+
+			ks.keys = append(ks.keys, key)
+			ks.byID[key.ID] = key
+		}
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return ks, nil
+}
+
+// LoadKeySet loads signing keys from PEM files and from inline PEM data. Keys
+// from files come first, so the first file holds the current signing key.
+// Inline data may use literal "\n" sequences instead of newlines.
+func LoadKeySet(files []string, inline string) (*KeySet, error) {
+	var pemData [][]byte
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key file %s: %v", file, err)
+		}
+		if !strings.Contains(string(data), "-----BEGIN") {
+			return nil, fmt.Errorf("JWT key file %s does not contain a PEM key", file)
+		}
+		pemData = append(pemData, data)
+	}
+
+	if inline = strings.TrimSpace(inline); inline != "" {
+		inline = strings.ReplaceAll(inline, `\n`, "\n")
+		if !strings.Contains(inline, "-----BEGIN") {
+			return nil, fmt.Errorf("JWT_KEYS does not contain a PEM key")
+		}
+		pemData = append(pemData, []byte(inline))
+	}
+
+	return NewKeySet(pemData...)
+}
+
+// parsePrivateKey parses a PKCS#8 or PKCS#1 private key block
+func parsePrivateKey(block *pem.Block) (*Key, error) {
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q; JWT keys must be RSA or Ed25519 private keys", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key: %v", err)
+	}
+
+	key := &Key{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA JWT keys must be at least %d bits", minRSAKeyBits)
+		}
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+		key.signer = k
+	case ed25519.PrivateKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+		key.signer = k
+	default:
+		return nil, fmt.Errorf("unsupported JWT key type %T; use RSA or Ed25519", parsed)
+	}
+
+	key.ID = thumbprint(key.jwk())
+	return key, nil
+}
+
+// jwk returns the public JWK of the key without its key ID
+func (k *Key) jwk() JWK {
+	switch pub := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint computes the RFC 7638 SHA-256 thumbprint of a JWK
+func thumbprint(jwk JWK) string {
+	var canonical string
+	if jwk.KeyType == "RSA" {
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Curve, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SigningKey returns the key that signs new tokens
+func (ks *KeySet) SigningKey() *Key {
+	return ks.keys[0]
+}
+
+// Sign signs claims with the current signing key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.SigningKey()
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.signer)
+}
+
+// Parse verifies a token against the key named by its kid header and returns
+// its claims. Expired tokens are rejected.
+func (ks *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.keyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+// keyfunc finds the verification key for a token
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The algorithm is bound to the key, never taken from the token alone
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.PublicKey(), nil
+}
+
+// JWKS returns the public keys of the set
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := key.jwk()
+		jwk.KeyID = key.ID
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}